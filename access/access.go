@@ -0,0 +1,212 @@
+package access
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Mode is what users can do in a channel.
+type Mode = string
+
+const (
+	// ModeDenied means Scholar can't be used in the channel.
+	ModeDenied Mode = "denied"
+	// ModeReadOnly means Scholar can answer questions, but nothing can be added to the library.
+	ModeReadOnly Mode = "read-only"
+	// ModeIngest means Scholar can be used fully, including adding content to the library.
+	ModeIngest Mode = "ingest"
+)
+
+// Action is an operation that requires authorization.
+type Action = string
+
+const (
+	// ActionAsk is asking Scholar a question, e.g. with a mention.
+	ActionAsk Action = "ask"
+	// ActionIngest is adding content to the library, e.g. with /upload or /summary.
+	ActionIngest Action = "ingest"
+	// ActionAdmin is a destructive or administrative operation, which is reserved for admins.
+	ActionAdmin Action = "admin"
+)
+
+// Policy is the access control configuration, loaded from a YAML file:
+//
+//	# Users allowed to use Scholar. If empty, everyone is allowed.
+//	users: [U012AB3CD]
+//	# Admins can use Scholar in any channel that isn't denied, and are the only ones allowed to run admin operations.
+//	admins: [U045EF6GH]
+//	# The mode of channels that aren't listed. Set to "denied" to only allow listed channels.
+//	default_mode: read-only
+//	channels:
+//	  C012AB3CD: ingest
+//	  C045EF6GH: denied
+type Policy struct {
+	Users       []string        `yaml:"users"`
+	Admins      []string        `yaml:"admins"`
+	DefaultMode Mode            `yaml:"default_mode"`
+	Channels    map[string]Mode `yaml:"channels"`
+}
+
+// DefaultPolicy allows everyone to use Scholar fully in every channel, with no admins.
+func DefaultPolicy() *Policy {
+	return &Policy{DefaultMode: ModeIngest}
+}
+
+// LoadPolicy reads and validates the policy at path.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read access policy")
+	}
+
+	policy := DefaultPolicy()
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, errors.Wrap(err, "failed to parse access policy")
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Validate checks that all modes are known.
+func (p *Policy) Validate() error {
+	if !validMode(p.DefaultMode) {
+		return fmt.Errorf("invalid default_mode %q", p.DefaultMode)
+	}
+
+	for channel, mode := range p.Channels {
+		if !validMode(mode) {
+			return fmt.Errorf("invalid mode %q for channel %s", mode, channel)
+		}
+	}
+
+	return nil
+}
+
+func validMode(mode Mode) bool {
+	return mode == ModeDenied || mode == ModeReadOnly || mode == ModeIngest
+}
+
+// ChannelMode returns the mode of the given channel.
+func (p *Policy) ChannelMode(channelID string) Mode {
+	if mode, ok := p.Channels[channelID]; ok {
+		return mode
+	}
+
+	return p.DefaultMode
+}
+
+// IsAdmin returns true if the user is an admin.
+func (p *Policy) IsAdmin(userID string) bool {
+	return slices.Contains(p.Admins, userID)
+}
+
+// DeniedError is returned when an action is not allowed. The message can be shown to the user.
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return e.Reason
+}
+
+const (
+	ReplyUserDenied     = "Sorry, you don't have access to Scholar. Ask an admin to add you."
+	ReplyChannelDenied  = "Scholar is not enabled in this channel."
+	ReplyChannelNoWrite = "This channel is read-only: Scholar can answer questions here, but nothing can be added to the library."
+	ReplyAdminOnly      = "Only admins can do this."
+)
+
+// Authorize returns a *DeniedError if the user isn't allowed to perform the action in the channel.
+// channelID can be empty for actions outside of a channel.
+func (p *Policy) Authorize(userID, channelID string, action Action) error {
+	admin := p.IsAdmin(userID)
+
+	if !admin && len(p.Users) > 0 && !slices.Contains(p.Users, userID) {
+		return &DeniedError{Reason: ReplyUserDenied}
+	}
+
+	if action == ActionAdmin {
+		if !admin {
+			return &DeniedError{Reason: ReplyAdminOnly}
+		}
+
+		return nil
+	}
+
+	if channelID == "" {
+		return nil
+	}
+
+	switch p.ChannelMode(channelID) {
+	case ModeDenied:
+		return &DeniedError{Reason: ReplyChannelDenied}
+	case ModeReadOnly:
+		if action == ActionIngest {
+			return &DeniedError{Reason: ReplyChannelNoWrite}
+		}
+	}
+
+	return nil
+}
+
+// Authorizer authorizes actions against a policy that can be reloaded at runtime.
+type Authorizer struct {
+	path string
+
+	mu     sync.RWMutex
+	policy *Policy
+}
+
+// NewAuthorizer creates an authorizer with the policy at path. If path is empty, everything is allowed.
+func NewAuthorizer(path string) (*Authorizer, error) {
+	a := &Authorizer{path: path, policy: DefaultPolicy()}
+	if path == "" {
+		return a, nil
+	}
+
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Reload reloads the policy from disk. If the new policy is invalid, the current policy is kept.
+func (a *Authorizer) Reload() error {
+	if a.path == "" {
+		return nil
+	}
+
+	policy, err := LoadPolicy(a.path)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.policy = policy
+	return nil
+}
+
+// Authorize returns a *DeniedError if the user isn't allowed to perform the action in the channel.
+func (a *Authorizer) Authorize(userID, channelID string, action Action) error {
+	return a.Policy().Authorize(userID, channelID, action)
+}
+
+// Policy returns the current policy.
+func (a *Authorizer) Policy() *Policy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.policy
+}
@@ -0,0 +1,113 @@
+package access
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPolicy = `
+users: [U1, U2]
+admins: [UADMIN]
+default_mode: denied
+channels:
+  CINGEST: ingest
+  CREAD: read-only
+`
+
+func writePolicy(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "access.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestAuthorize(t *testing.T) {
+	a, err := NewAuthorizer(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		channel string
+		action  Action
+		reason  string
+	}{
+		{name: "ingest allowed", user: "U1", channel: "CINGEST", action: ActionIngest},
+		{name: "ask in read-only", user: "U1", channel: "CREAD", action: ActionAsk},
+		{name: "ingest in read-only", user: "U1", channel: "CREAD", action: ActionIngest, reason: ReplyChannelNoWrite},
+		{name: "unlisted channel", user: "U1", channel: "COTHER", action: ActionAsk, reason: ReplyChannelDenied},
+		{name: "unlisted user", user: "U3", channel: "CINGEST", action: ActionAsk, reason: ReplyUserDenied},
+		{name: "admin action by user", user: "U1", channel: "CINGEST", action: ActionAdmin, reason: ReplyAdminOnly},
+		{name: "admin action by admin", user: "UADMIN", channel: "COTHER", action: ActionAdmin},
+		{name: "admin in unlisted channel", user: "UADMIN", channel: "COTHER", action: ActionAsk, reason: ReplyChannelDenied},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := a.Authorize(test.user, test.channel, test.action)
+			if test.reason == "" {
+				if err != nil {
+					t.Errorf("unexpected denial: %s", err)
+				}
+				return
+			}
+
+			var denied *DeniedError
+			if !errors.As(err, &denied) || denied.Reason != test.reason {
+				t.Errorf("expected denial %q, got %v", test.reason, err)
+			}
+		})
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	a, err := NewAuthorizer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Authorize("U1", "C1", ActionIngest); err != nil {
+		t.Errorf("expected everything to be allowed: %s", err)
+	}
+
+	if err := a.Authorize("U1", "C1", ActionAdmin); err == nil {
+		t.Errorf("expected admin actions to be denied without admins")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writePolicy(t, testPolicy)
+	a, err := NewAuthorizer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Authorize("U3", "CINGEST", ActionAsk); err == nil {
+		t.Fatalf("expected U3 to be denied")
+	}
+
+	os.WriteFile(path, []byte("users: [U3]\ndefault_mode: ingest\n"), 0644)
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Authorize("U3", "CINGEST", ActionAsk); err != nil {
+		t.Errorf("expected U3 to be allowed after reload: %s", err)
+	}
+
+	// An invalid policy is rejected and the current one is kept
+	os.WriteFile(path, []byte("default_mode: everything\n"), 0644)
+	if err := a.Reload(); err == nil {
+		t.Errorf("expected invalid policy to be rejected")
+	}
+
+	if err := a.Authorize("U3", "CINGEST", ActionAsk); err != nil {
+		t.Errorf("expected previous policy to be kept: %s", err)
+	}
+}
@@ -0,0 +1,126 @@
+package access
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// APIKey is a key that grants programmatic access to a library.
+type APIKey struct {
+	// Name identifies the key in logs.
+	Name string `yaml:"name"`
+	// Key is the secret sent by clients as a bearer token.
+	Key string `yaml:"key"`
+	// Team is the workspace or server whose library the key can access. Empty is the default workspace.
+	Team string `yaml:"team"`
+	// Scope is the library within the team, e.g. "channel-C012AB3CD". Empty is the public library.
+	Scope string `yaml:"scope"`
+	// Mode is "ingest" or "read-only" (the default).
+	Mode Mode `yaml:"mode"`
+	// Admin keys can delete documents.
+	Admin bool `yaml:"admin"`
+	// User optionally ties the key to a chat user, whose access policy then also applies.
+	User string `yaml:"user"`
+}
+
+const ReplyKeyReadOnly = "This API key is read-only."
+
+// Authorize returns a *DeniedError if the key doesn't allow the action.
+func (k *APIKey) Authorize(action Action) error {
+	switch action {
+	case ActionAdmin:
+		if !k.Admin {
+			return &DeniedError{Reason: ReplyAdminOnly}
+		}
+	case ActionIngest:
+		if k.Mode != ModeIngest && !k.Admin {
+			return &DeniedError{Reason: ReplyKeyReadOnly}
+		}
+	}
+
+	return nil
+}
+
+// APIKeys is the set of API keys, loaded from a YAML file:
+//
+//	keys:
+//	  - name: dashboard
+//	    key: 3b1f...   # A long random string
+//	  - name: bookmarklet
+//	    key: 9c2e...
+//	    mode: ingest
+//	    user: U012AB3CD
+type APIKeys struct {
+	Keys []*APIKey `yaml:"keys"`
+}
+
+// LoadAPIKeys reads and validates the API keys at path.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read API keys")
+	}
+
+	var keys APIKeys
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return nil, errors.Wrap(err, "failed to parse API keys")
+	}
+
+	if err := keys.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &keys, nil
+}
+
+// MIN_KEY_LENGTH is the minimum length of an API key.
+const MIN_KEY_LENGTH = 16
+
+// Validate checks that keys are long enough and unique, and that their modes are known.
+func (a *APIKeys) Validate() error {
+	seen := make(map[string]struct{}, len(a.Keys))
+	for i, key := range a.Keys {
+		if key.Name == "" {
+			key.Name = fmt.Sprintf("key-%d", i)
+		}
+
+		if len(key.Key) < MIN_KEY_LENGTH {
+			return fmt.Errorf("API key %s is shorter than %d characters", key.Name, MIN_KEY_LENGTH)
+		}
+
+		if _, ok := seen[key.Key]; ok {
+			return fmt.Errorf("API key %s is not unique", key.Name)
+		}
+		seen[key.Key] = struct{}{}
+
+		if key.Mode == "" {
+			key.Mode = ModeReadOnly
+		}
+
+		if key.Mode != ModeReadOnly && key.Mode != ModeIngest {
+			return fmt.Errorf("invalid mode %q for API key %s", key.Mode, key.Name)
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the key matching the secret, or nil. Secrets are compared in constant time.
+func (a *APIKeys) Lookup(secret string) *APIKey {
+	hash := sha256.Sum256([]byte(secret))
+
+	var found *APIKey
+	for _, key := range a.Keys {
+		keyHash := sha256.Sum256([]byte(key.Key))
+		if subtle.ConstantTimeCompare(hash[:], keyHash[:]) == 1 {
+			found = key
+		}
+	}
+
+	return found
+}
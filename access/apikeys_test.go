@@ -0,0 +1,65 @@
+package access
+
+import "testing"
+
+const testAPIKeys = `
+keys:
+  - name: dashboard
+    key: dashboard-0123456789
+  - name: bookmarklet
+    key: bookmarklet-0123456789
+    mode: ingest
+  - name: ops
+    key: ops-0123456789abcdef
+    admin: true
+`
+
+func TestAPIKeys(t *testing.T) {
+	keys, err := LoadAPIKeys(writePolicy(t, testAPIKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys.Lookup("wrong") != nil {
+		t.Error("expected unknown key to be rejected")
+	}
+
+	dashboard := keys.Lookup("dashboard-0123456789")
+	if dashboard == nil || dashboard.Name != "dashboard" || dashboard.Mode != ModeReadOnly {
+		t.Fatalf("unexpected key: %+v", dashboard)
+	}
+
+	tests := []struct {
+		key     string
+		action  Action
+		allowed bool
+	}{
+		{"dashboard-0123456789", ActionAsk, true},
+		{"dashboard-0123456789", ActionIngest, false},
+		{"bookmarklet-0123456789", ActionIngest, true},
+		{"bookmarklet-0123456789", ActionAdmin, false},
+		{"ops-0123456789abcdef", ActionAdmin, true},
+		{"ops-0123456789abcdef", ActionIngest, true},
+	}
+
+	for _, test := range tests {
+		err := keys.Lookup(test.key).Authorize(test.action)
+		if (err == nil) != test.allowed {
+			t.Errorf("%s %s: unexpected result %v", test.key, test.action, err)
+		}
+	}
+}
+
+func TestAPIKeysValidate(t *testing.T) {
+	invalid := []string{
+		"keys: [{name: short, key: abc}]",
+		"keys: [{key: dashboard-0123456789}, {key: dashboard-0123456789}]",
+		"keys: [{key: dashboard-0123456789, mode: denied}]",
+	}
+
+	for _, content := range invalid {
+		if _, err := LoadAPIKeys(writePolicy(t, content)); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+)
+
+// API_PREFIX is the path prefix of the REST API.
+const API_PREFIX = "/api/v1"
+
+// MAX_UPLOAD_SIZE limits the size of files uploaded to the API.
+const MAX_UPLOAD_SIZE = 20 << 20
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// apiServer exposes the library over an authenticated REST/JSON API. Clients authenticate with an API key
+// as a bearer token, and every key is tied to a single library.
+type apiServer struct {
+	log        zerolog.Logger
+	keys       *access.APIKeys
+	authorizer *access.Authorizer
+	tenants    *tenants
+}
+
+// apiError is the body of error responses.
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		// RequestID identifies the logs of an internal error.
+		RequestID string `json:"requestId,omitempty"`
+	} `json:"error"`
+}
+
+// Handler returns the HTTP handler of the API, to be served under API_PREFIX.
+func (s *apiServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET "+API_PREFIX+"/openapi.yaml", s.serveSpec)
+	mux.HandleFunc("GET "+API_PREFIX+"/documents", s.authenticated(access.ActionAsk, s.serveListDocuments))
+	mux.HandleFunc("POST "+API_PREFIX+"/documents", s.authenticated(access.ActionIngest, s.serveIngest))
+	mux.HandleFunc("GET "+API_PREFIX+"/documents/{file}", s.authenticated(access.ActionAsk, s.serveGetDocument))
+	mux.HandleFunc("DELETE "+API_PREFIX+"/documents/{file}", s.authenticated(access.ActionAdmin, s.serveDeleteDocument))
+	mux.HandleFunc("POST "+API_PREFIX+"/summaries", s.authenticated(access.ActionIngest, s.serveSummarize))
+	mux.HandleFunc("POST "+API_PREFIX+"/ask", s.authenticated(access.ActionAsk, s.serveAsk))
+
+	// Unknown routes get a JSON error as well.
+	mux.HandleFunc(API_PREFIX+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "no such endpoint")
+	})
+
+	return withCORS(mux)
+}
+
+// withCORS allows browsers on any origin (e.g. a bookmarklet) to call the API. Credentials are passed explicitly
+// in the Authorization header, not with cookies.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the caller of an API key authenticated request. If the request isn't authenticated, it
+// writes an error and returns nil.
+func (s *apiServer) authenticate(w http.ResponseWriter, r *http.Request) *caller {
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "missing API key")
+		return nil
+	}
+
+	key := s.keys.Lookup(secret)
+	if key == nil {
+		s.log.Warn().Str("path", r.URL.Path).Msg("Rejected request with invalid API key")
+		writeError(w, http.StatusUnauthorized, "unauthorized", "invalid API key")
+		return nil
+	}
+
+	c, err := newKeyCaller(key)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return nil
+	}
+
+	return c
+}
+
+// authenticated wraps a handler with API key authentication and authorization of the action.
+func (s *apiServer) authenticated(action access.Action, next func(w http.ResponseWriter, r *http.Request, req *caller)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := s.authenticate(w, r)
+		if req == nil {
+			return
+		}
+
+		if err := req.authorize(s.authorizer, action); err != nil {
+			s.log.Info().Str("key", req.name).Str("action", action).Msg("API request denied")
+			writeError(w, http.StatusForbidden, "forbidden", err.Error())
+			return
+		}
+
+		next(w, r, req)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	var body apiError
+	body.Error.Code = code
+	body.Error.Message = message
+
+	writeJSON(w, status, body)
+}
+
+// writeInternalError logs the error and reports it to the client. Spent budgets and failed runs are explained, but the
+// details of other errors (e.g. of OpenAI or the server's files) are only logged, under a request ID the client gets.
+func (s *apiServer) writeInternalError(w http.ResponseWriter, err error) {
+	var runErr *backend.RunError
+	switch {
+	case errors.Is(err, errBudgetExceeded):
+		writeError(w, http.StatusTooManyRequests, "budget_exceeded", err.Error())
+		return
+	case errors.As(err, &runErr):
+		s.log.Warn().Err(err).Msg("API request failed")
+		writeError(w, http.StatusBadGateway, "run_failed", runErr.UserMessage())
+		return
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	requestID := hex.EncodeToString(b)
+
+	s.log.Error().Err(err).Str("request_id", requestID).Msg("API request failed")
+
+	var body apiError
+	body.Error.Code = "internal"
+	body.Error.Message = "internal error"
+	body.Error.RequestID = requestID
+	writeJSON(w, http.StatusInternalServerError, body)
+}
+
+func (s *apiServer) serveSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPISpec)
+}
+
+func (s *apiServer) serveListDocuments(w http.ResponseWriter, r *http.Request, req *caller) {
+	fileStore := s.tenants.FileStore(req.team).Scoped(req.scope)
+
+	var docs []documentInfo
+	var err error
+	if query := r.URL.Query().Get("q"); query != "" {
+		docs, err = searchDocuments(fileStore, query)
+	} else {
+		docs, err = listDocuments(fileStore)
+	}
+
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"documents": docs})
+}
+
+func (s *apiServer) serveGetDocument(w http.ResponseWriter, r *http.Request, req *caller) {
+	name := r.PathValue("file")
+	fileStore := s.tenants.FileStore(req.team).Scoped(req.scope)
+
+	if !validDocumentName(name) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid document name")
+		return
+	}
+
+	if ok, err := fileStore.Contains(name); err != nil || !ok {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no such document: %s", name))
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/markdown") {
+		f, err := fileStore.Get(name)
+		if err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		io.Copy(w, f)
+		return
+	}
+
+	doc, err := readDocument(fileStore, name)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	response := map[string]any{
+		"file":     name,
+		"metadata": doc.Metadata,
+		"content":  string(doc.Content),
+	}
+
+	if summary, err := readStructuredSummary(fileStore, name); err == nil {
+		response["structuredSummary"] = summary
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *apiServer) serveDeleteDocument(w http.ResponseWriter, r *http.Request, req *caller) {
+	name := r.PathValue("file")
+	fileStore := s.tenants.FileStore(req.team).Scoped(req.scope)
+
+	if !validDocumentName(name) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid document name")
+		return
+	}
+
+	if ok, err := fileStore.Contains(name); err != nil || !ok {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no such document: %s", name))
+		return
+	}
+
+	tenant, err := s.tenants.Get(r.Context(), req.team)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	if err := tenant.backend.DeleteFile(r.Context(), req.scope, name); err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	if err := fileStore.Remove(name); err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	s.log.Info().Str("key", req.name).Str("file", name).Msg("Document deleted over the API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ingestRequest is the JSON body of ingest and summary requests. Either URL or URLs must be set.
+type ingestRequest struct {
+	URL  string   `json:"url"`
+	URLs []string `json:"urls"`
+}
+
+// upload is a file uploaded to the API.
+type upload struct {
+	name string
+	body []byte
+}
+
+// parseIngestRequest returns the URLs to ingest from a JSON body, or the file uploaded in a multipart form. Uploads
+// are kept in memory, so no server path ends up in the library.
+func parseIngestRequest(r *http.Request) ([]*url.URL, *upload, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(nil, r.Body, MAX_UPLOAD_SIZE)
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, nil, fmt.Errorf("missing file: %w", err)
+		}
+		defer file.Close()
+
+		name := filepath.Base(header.Filename)
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			return nil, nil, errors.New("the file has no name")
+		}
+
+		body, err := io.ReadAll(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read file: %w", err)
+		}
+
+		return nil, &upload{name: name, body: body}, nil
+	}
+
+	var body ingestRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, MAX_UPLOAD_SIZE)).Decode(&body); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	raw := body.URLs
+	if body.URL != "" {
+		raw = append([]string{body.URL}, raw...)
+	}
+
+	if len(raw) == 0 {
+		return nil, nil, errors.New("url or urls is required")
+	}
+
+	var urls []*url.URL
+	for _, u := range raw {
+		uri, err := validateWebURL(u)
+		if err != nil {
+			return nil, nil, err
+		}
+		urls = append(urls, uri)
+	}
+
+	return urls, nil, nil
+}
+
+// ingest ingests the URLs or the upload of the request into the key's library. Links and uploads that are already in
+// the library aren't added again.
+func (s *apiServer) ingest(w http.ResponseWriter, r *http.Request, req *caller) (*tenant, []ingestResult, bool) {
+	urls, upload, err := parseIngestRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return nil, nil, false
+	}
+
+	tenant, err := s.tenants.Get(r.Context(), req.team)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return nil, nil, false
+	}
+
+	opts := ingestOptions{Dedup: true, Scope: req.scope, UploadedBy: req.user}
+	if upload != nil {
+		return tenant, []ingestResult{tenant.ingester.IngestUpload(r.Context(), upload.name, upload.body, opts)}, true
+	}
+
+	return tenant, tenant.ingester.IngestAll(r.Context(), urls, opts, nil), true
+}
+
+func ingestInfos(results []ingestResult) []ingestInfo {
+	infos := make([]ingestInfo, len(results))
+	for i, res := range results {
+		infos[i] = newIngestInfo(res)
+	}
+
+	return infos
+}
+
+func (s *apiServer) serveIngest(w http.ResponseWriter, r *http.Request, req *caller) {
+	_, results, ok := s.ingest(w, r, req)
+	if !ok {
+		return
+	}
+
+	status := http.StatusOK
+	if len(succeeded(results)) > 0 {
+		status = http.StatusCreated
+	}
+
+	writeJSON(w, status, map[string]any{"documents": ingestInfos(results)})
+}
+
+func (s *apiServer) serveSummarize(w http.ResponseWriter, r *http.Request, req *caller) {
+	tenant, results, ok := s.ingest(w, r, req)
+	if !ok {
+		return
+	}
+
+	// Like in chat, links that are already in the library are summarized as well, with their stored summary.
+	docs := inLibrary(results)
+	if len(docs) == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"documents": ingestInfos(results)})
+		return
+	}
+
+	threadID, err := s.newThread(tenant, req)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	ctx, err := s.startThread(r.Context(), tenant, req, threadID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	var channelID string
+	if req.scope.Visibility == document.VisibilityChannel {
+		channelID = req.scope.ID
+	}
+
+	record := &summaryRecord{Documents: promptDocuments(docs), Scope: req.scope}
+	data := prompt.Data{
+		Date:      today(),
+		Persona:   tenant.personas.Get(channelID),
+		User:      prompt.User{ID: req.user},
+		ThreadID:  threadID,
+		Documents: record.Documents,
+	}
+
+	summary, _, cachedFrom, err := tenant.cachedSummary(ctx, s.log, threadID, data, record, nil, false)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	response := map[string]any{
+		"documents": ingestInfos(results),
+		"summary":   summary,
+		"threadId":  threadID,
+	}
+	if cachedFrom != "" {
+		response["cachedFrom"] = cachedFrom
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// askRequest is the JSON body of ask requests. ThreadID continues a previous conversation.
+type askRequest struct {
+	Question string `json:"question"`
+	ThreadID string `json:"threadId"`
+}
+
+func (s *apiServer) serveAsk(w http.ResponseWriter, r *http.Request, req *caller) {
+	var body askRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, MAX_UPLOAD_SIZE)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid JSON body: %s", err))
+		return
+	}
+
+	if strings.TrimSpace(body.Question) == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "question is required")
+		return
+	}
+
+	threadID := body.ThreadID
+	if threadID != "" && !strings.HasPrefix(threadID, "api-") {
+		// Chat threads are only accessible from their own conversation.
+		writeError(w, http.StatusBadRequest, "invalid_request", "threadId must be returned by a previous request")
+		return
+	}
+
+	tenant, err := s.tenants.Get(r.Context(), req.team)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	if threadID == "" {
+		if threadID, err = s.newThread(tenant, req); err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+	} else if !tenant.threads.Owns(threadID, req) {
+		// Threads of other keys are as good as missing.
+		writeError(w, http.StatusNotFound, "not_found", "no such thread")
+		return
+	}
+
+	instructions, err := tenant.assistantInstructions()
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	answer, err := s.prompt(r.Context(), tenant, req, threadID, backend.TaskQuestion, instructions, body.Question)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"answer": answer, "threadId": threadID})
+}
+
+// prompt prompts the assistant with the model of the task in the given thread, creating it with the key's library if
+// needed.
+func (s *apiServer) prompt(ctx context.Context, tenant *tenant, req *caller, threadID, task, instructions, text string) (string, error) {
+	ctx, err := s.startThread(ctx, tenant, req, threadID)
+	if err != nil {
+		return "", err
+	}
+
+	ctx = tenant.withModel(ctx, task, "")
+	return tenant.backend.Prompt(ctx, threadID, instructions, text)
+}
+
+// startThread creates the thread with the key's library if needed, and returns the context of the key's conversation
+// in it.
+func (s *apiServer) startThread(ctx context.Context, tenant *tenant, req *caller, threadID string) (context.Context, error) {
+	if err := tenant.backend.CreateThread(ctx, threadID, req.scope); err != nil {
+		return nil, err
+	}
+
+	return withConversation(ctx, &conversation{scope: req.scope, userID: req.user, command: "api"}), nil
+}
+
+// newThread returns the ID of a new conversation of the key, which only the key can follow up on.
+func (s *apiServer) newThread(tenant *tenant, req *caller) (string, error) {
+	threadID := newCallerThreadID("api")
+	if err := tenant.threads.Put(threadID, req); err != nil {
+		return "", fmt.Errorf("failed to record thread: %w", err)
+	}
+
+	return threadID, nil
+}
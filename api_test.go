@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/config"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/store"
+)
+
+const (
+	testReadKey   = "read-key-0123456789"
+	testIngestKey = "ingest-key-0123456789"
+)
+
+// newTestAPI returns an API server over a temporary library containing a single document.
+func newTestAPI(t *testing.T) (http.Handler, string) {
+	api, name := newTestAPIServer(t)
+	return api.Handler(), name
+}
+
+// newTestAPIServer is like newTestAPI, but returns the server, e.g. to add a tenant with addTestTenant.
+func newTestAPIServer(t *testing.T) (*apiServer, string) {
+	dataDir := t.TempDir()
+
+	doc := &document.Document{Content: []byte("# Bitcoin\n\nA peer-to-peer electronic cash system.\n"), Metadata: document.Metadata{Source: "https://bitcoin.org/bitcoin.pdf", Type: document.TypePDF}}
+	name, markdown, err := doc.ToMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.NewFileStore(dataDir).Store(name, strings.NewReader(string(markdown))); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := &access.APIKeys{Keys: []*access.APIKey{{Name: "read", Key: testReadKey}, {Name: "ingest", Key: testIngestKey, Mode: access.ModeIngest}}}
+	if err := keys.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer, err := access.NewAuthorizer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := &apiServer{
+		log:        zerolog.Nop(),
+		keys:       keys,
+		authorizer: authorizer,
+		tenants:    newTenants(zerolog.Nop(), "", dataDir, config.Default(), nil, nil, func() string { return "" }),
+	}
+
+	return api, name
+}
+
+// addTestTenant initializes the default workspace of the tenants with a fake backend and content handler.
+func addTestTenant(t *testing.T, tenants *tenants, fb *fakeBackend, fc *fakeContent) *tenant {
+	personas, err := newPersonaStore(filepath.Join(t.TempDir(), "personas.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { personas.Close() })
+
+	threads, err := newThreadOwnerStore(filepath.Join(t.TempDir(), "thread_owners.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { threads.Close() })
+
+	fs := tenants.FileStore("")
+	tn := &tenant{
+		fileStore:     fs,
+		backend:       fb,
+		templates:     tenants.config.Prompts.Templates,
+		personas:      personas,
+		threads:       threads,
+		summaryConfig: tenants.config.Summary,
+		ingester:      &ingester{log: zerolog.Nop(), contentHandler: fc, fileStore: fs, backend: fb, concurrency: 1},
+	}
+
+	done := make(chan struct{})
+	close(done)
+	tenants.tenants[""] = &tenantInit{done: done, tenant: tn}
+
+	return tn
+}
+
+func doRequest(handler http.Handler, method, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	handler, _ := newTestAPI(t)
+
+	tests := []struct {
+		method string
+		path   string
+		key    string
+		body   string
+		status int
+		code   string
+	}{
+		{"GET", API_PREFIX + "/documents", "", "", http.StatusUnauthorized, "unauthorized"},
+		{"GET", API_PREFIX + "/documents", "wrong-key-0123456789", "", http.StatusUnauthorized, "unauthorized"},
+		{"POST", API_PREFIX + "/documents", testReadKey, `{"url":"https://example.com"}`, http.StatusForbidden, "forbidden"},
+		{"DELETE", API_PREFIX + "/documents/x.md", testIngestKey, "", http.StatusForbidden, "forbidden"},
+		{"POST", API_PREFIX + "/summaries", testReadKey, `{"url":"https://example.com"}`, http.StatusForbidden, "forbidden"},
+		{"GET", API_PREFIX + "/nope", testReadKey, "", http.StatusNotFound, "not_found"},
+	}
+
+	for _, test := range tests {
+		rec := doRequest(handler, test.method, test.path, test.key, test.body)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.path, test.status, rec.Code)
+			continue
+		}
+
+		var body apiError
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Code != test.code {
+			t.Errorf("%s %s: expected error code %s, got %+v (%v)", test.method, test.path, test.code, body, err)
+		}
+	}
+}
+
+func TestAPIDocuments(t *testing.T) {
+	handler, name := newTestAPI(t)
+
+	var list struct {
+		Documents []documentInfo `json:"documents"`
+	}
+
+	rec := doRequest(handler, "GET", API_PREFIX+"/documents", testReadKey, "")
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("list failed with %d: %v", rec.Code, err)
+	}
+	if len(list.Documents) != 1 || list.Documents[0].File != name {
+		t.Errorf("unexpected documents: %+v", list.Documents)
+	}
+
+	rec = doRequest(handler, "GET", API_PREFIX+"/documents?q=electronic+cash", testReadKey, "")
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Documents) != 1 {
+		t.Errorf("search didn't match: %+v (%v)", list.Documents, err)
+	}
+
+	rec = doRequest(handler, "GET", API_PREFIX+"/documents?q=ethereum", testReadKey, "")
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Documents) != 0 {
+		t.Errorf("search matched: %+v (%v)", list.Documents, err)
+	}
+
+	var doc struct {
+		File     string            `json:"file"`
+		Metadata document.Metadata `json:"metadata"`
+		Content  string            `json:"content"`
+	}
+
+	rec = doRequest(handler, "GET", API_PREFIX+"/documents/"+name, testReadKey, "")
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("get failed with %d: %v", rec.Code, err)
+	}
+	if doc.Metadata.Source != "https://bitcoin.org/bitcoin.pdf" || !strings.Contains(doc.Content, "electronic cash") {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+
+	req := httptest.NewRequest("GET", API_PREFIX+"/documents/"+name, nil)
+	req.Header.Set("Authorization", "Bearer "+testReadKey)
+	req.Header.Set("Accept", "text/markdown")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !strings.HasPrefix(rec.Body.String(), "---\n") {
+		t.Errorf("expected markdown with front matter, got %q", rec.Body.String())
+	}
+
+	for _, path := range []string{"/documents/missing.md", "/documents/..%2Faccess.yaml", "/documents/notes.txt"} {
+		rec = doRequest(handler, "GET", API_PREFIX+path, testReadKey, "")
+		if rec.Code != http.StatusNotFound && rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected an error, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestAPIIngestValidation(t *testing.T) {
+	handler, _ := newTestAPI(t)
+
+	for _, body := range []string{`{}`, `{"url":"file:///etc/passwd"}`, `{"urls":["ftp://example.com"]}`, `not json`} {
+		rec := doRequest(handler, "POST", API_PREFIX+"/documents", testIngestKey, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, rec.Code)
+		}
+	}
+
+	rec := doRequest(handler, "POST", API_PREFIX+"/ask", testReadKey, `{"question":"What?","threadId":"1712345678.000100"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected chat thread to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestAPISummarize(t *testing.T) {
+	api, name := newTestAPIServer(t)
+	fb, fc := &fakeBackend{}, &fakeContent{}
+	addTestTenant(t, api.tenants, fb, fc)
+	handler := api.Handler()
+
+	var response struct {
+		Documents  []ingestInfo `json:"documents"`
+		Summary    string       `json:"summary"`
+		ThreadID   string       `json:"threadId"`
+		CachedFrom string       `json:"cachedFrom"`
+	}
+
+	// Links that are already in the library aren't fetched again, but are summarized.
+	rec := doRequest(handler, "POST", API_PREFIX+"/summaries", testIngestKey, `{"url":"https://bitcoin.org/bitcoin.pdf"}`)
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("summary failed with %d: %v", rec.Code, err)
+	}
+
+	if response.Summary == "" || response.CachedFrom != "" || len(fc.fetched) != 0 || fb.prompts != 1 {
+		t.Errorf("unexpected summary: %+v (fetched %v, %d prompts)", response, fc.fetched, fb.prompts)
+	}
+
+	// The summary is stored, and reused like in chat.
+	rec = doRequest(handler, "POST", API_PREFIX+"/summaries", testIngestKey, `{"url":"https://bitcoin.org/bitcoin.pdf"}`)
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("summary failed with %d: %v", rec.Code, err)
+	}
+
+	if response.Summary == "" || response.CachedFrom == "" || fb.prompts != 1 || fb.posts != 1 {
+		t.Errorf("expected the cached summary: %+v (%d prompts, %d posts)", response, fb.prompts, fb.posts)
+	}
+
+	if docs, err := listDocuments(api.tenants.FileStore("")); err != nil || len(docs) != 2 {
+		t.Errorf("expected %s and its summary, got %+v (%v)", name, docs, err)
+	}
+}
+
+func TestAPIUpload(t *testing.T) {
+	api, _ := newTestAPIServer(t)
+	fb := &fakeBackend{}
+	addTestTenant(t, api.tenants, fb, &fakeContent{})
+	handler := api.Handler()
+
+	upload := func(filename, content string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+		form.Close()
+
+		req := httptest.NewRequest("POST", API_PREFIX+"/documents", &body)
+		req.Header.Set("Authorization", "Bearer "+testIngestKey)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var response struct {
+		Documents []ingestInfo `json:"documents"`
+	}
+
+	rec := upload("notes.md", "# Lightning\n\nPayment channels.\n")
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("upload failed with %d: %v", rec.Code, err)
+	}
+
+	// Uploads are identified by their content, not by a path on the server.
+	info := response.Documents[0]
+	if !strings.HasPrefix(info.URL, "upload:sha256:") || info.Status != "ingested" {
+		t.Fatalf("unexpected document: %+v", info)
+	}
+
+	doc, err := readDocument(api.tenants.FileStore(""), info.File)
+	if err != nil || doc.Metadata.Source != info.URL {
+		t.Errorf("unexpected source %q (%v)", doc.Metadata.Source, err)
+	}
+
+	rec = upload("copy.md", "# Lightning\n\nPayment channels.\n")
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil || response.Documents[0].Status != "duplicate" || len(fb.uploaded) != 1 {
+		t.Errorf("expected the same file to be a duplicate, got %+v (%v)", response.Documents, err)
+	}
+
+	for _, filename := range []string{"", "."} {
+		if rec := upload(filename, "text"); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", filename, rec.Code)
+		}
+	}
+}
+
+func TestAPIThreads(t *testing.T) {
+	api, _ := newTestAPIServer(t)
+	addTestTenant(t, api.tenants, &fakeBackend{}, &fakeContent{})
+	handler := api.Handler()
+
+	ask := func(key, threadID string) (int, string) {
+		body, _ := json.Marshal(askRequest{Question: "What is Bitcoin?", ThreadID: threadID})
+		rec := doRequest(handler, "POST", API_PREFIX+"/ask", key, string(body))
+
+		var response struct {
+			ThreadID string `json:"threadId"`
+		}
+		json.NewDecoder(rec.Body).Decode(&response)
+		return rec.Code, response.ThreadID
+	}
+
+	status, threadID := ask(testReadKey, "")
+	if status != http.StatusOK || !regexp.MustCompile(`^api-[0-9a-f]{32}$`).MatchString(threadID) {
+		t.Fatalf("unexpected response %d with thread %q", status, threadID)
+	}
+
+	if status, _ := ask(testReadKey, threadID); status != http.StatusOK {
+		t.Errorf("expected the follow-up to be answered, got %d", status)
+	}
+
+	// Threads of other keys, and made-up threads, don't exist for the key.
+	for _, id := range []string{threadID, "api-1712345678000000000"} {
+		if status, _ := ask(testIngestKey, id); status != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", id, status)
+		}
+	}
+
+	if _, other := ask(testReadKey, ""); other == threadID {
+		t.Error("thread IDs were reused")
+	}
+
+	// Summaries start threads of their key as well.
+	rec := doRequest(handler, "POST", API_PREFIX+"/summaries", testIngestKey, `{"url":"https://bitcoin.org/bitcoin.pdf"}`)
+	var summary struct {
+		ThreadID string `json:"threadId"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("summary failed with %d: %v", rec.Code, err)
+	}
+
+	if status, _ := ask(testIngestKey, summary.ThreadID); status != http.StatusOK {
+		t.Errorf("expected the follow-up of the summary to be answered, got %d", status)
+	}
+
+	if status, _ := ask(testReadKey, summary.ThreadID); status != http.StatusNotFound {
+		t.Errorf("expected the summary of another key to be hidden, got %d", status)
+	}
+}
+
+func TestAPIInternalError(t *testing.T) {
+	api, _ := newTestAPIServer(t)
+	addTestTenant(t, api.tenants, &fakeBackend{threadErr: errors.New("open /var/lib/scholar/threads.db: permission denied")}, &fakeContent{})
+	handler := api.Handler()
+
+	rec := doRequest(handler, "POST", API_PREFIX+"/ask", testReadKey, `{"question":"What is Bitcoin?"}`)
+
+	var body apiError
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d (%v)", rec.Code, err)
+	}
+
+	// The details are only logged.
+	if body.Error.Code != "internal" || body.Error.Message != "internal error" || body.Error.RequestID == "" {
+		t.Errorf("unexpected error: %+v", body.Error)
+	}
+}
+
+func TestAPIPublicEndpoints(t *testing.T) {
+	handler, _ := newTestAPI(t)
+
+	rec := doRequest(handler, "OPTIONS", API_PREFIX+"/documents", "", "")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("unexpected preflight response: %d %v", rec.Code, rec.Header())
+	}
+
+	rec = doRequest(handler, "GET", API_PREFIX+"/openapi.yaml", "", "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "openapi:") {
+		t.Errorf("unexpected spec response: %d", rec.Code)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/store"
+)
+
+// app handles the commands and events of all chat frontends with a shared ingestion pipeline and backend.
+type app struct {
+	log        zerolog.Logger
+	authorizer *access.Authorizer
+	tenants    *tenants
+	// workers is the number of commands and events that are handled concurrently.
+	workers int
+	// threads serializes the events of each thread.
+	threads threadLocks
+}
+
+// frontendCommand is a command together with the frontend it came from.
+type frontendCommand struct {
+	frontend chat.Frontend
+	cmd      chat.Command
+}
+
+// frontendEvent is an event together with the frontend it came from.
+type frontendEvent struct {
+	frontend chat.Frontend
+	event    chat.Event
+}
+
+// Run handles the commands and events of the given frontends, with a.workers at a time, so a long research or summary
+// doesn't hold up the others. Events of the same thread are handled in order. It blocks until the context is done.
+func (a *app) Run(ctx context.Context, frontends []chat.Frontend) {
+	commands := make(chan frontendCommand)
+	events := make(chan frontendEvent)
+
+	for _, fe := range frontends {
+		go func() {
+			for cmd := range fe.SubscribeCommands() {
+				commands <- frontendCommand{fe, cmd}
+			}
+		}()
+
+		go func() {
+			for event := range fe.SubscribeEvents() {
+				events <- frontendEvent{fe, event}
+			}
+		}()
+	}
+
+	for range max(a.workers, 1) {
+		go func() {
+			for {
+				select {
+				case c := <-commands:
+					a.handleCommand(ctx, c.frontend, c.cmd)
+				case e := <-events:
+					a.handleThreadEvent(ctx, e.frontend, e.event)
+				}
+			}
+		}()
+	}
+
+	<-ctx.Done()
+}
+
+// handleThreadEvent handles an event after the previous events of its thread: a thread doesn't accept new messages
+// while it is answering one.
+func (a *app) handleThreadEvent(ctx context.Context, fe chat.Frontend, event chat.Event) {
+	if event.ThreadID != "" {
+		unlock := a.threads.Lock(fe.Name() + "/" + event.TeamID + "/" + event.ThreadID)
+		defer unlock()
+	}
+
+	a.handleEvent(ctx, fe, event)
+}
+
+// threadLocks are locks by thread. Locks are removed once nobody holds or waits for them.
+type threadLocks struct {
+	mu    sync.Mutex
+	locks map[string]*threadLock
+}
+
+type threadLock struct {
+	sync.Mutex
+	// refs is the number of holders and waiters.
+	refs int
+}
+
+// Lock locks the thread, and returns the function that unlocks it.
+func (l *threadLocks) Lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*threadLock)
+	}
+
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &threadLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (a *app) handleCommand(ctx context.Context, fe chat.Frontend, cmd chat.Command) {
+	switch cmd.CommandType {
+	case chat.PersonaCommand:
+		a.handlePersona(ctx, fe, cmd)
+		return
+	case chat.ResearchCommand:
+		a.handleResearch(ctx, fe, cmd)
+		return
+	case chat.UsageCommand:
+		a.handleUsage(ctx, fe, cmd)
+		return
+	}
+
+	log := a.log.With().Str("frontend", fe.Name()).Logger()
+	reporter := newCommandReporter(log, fe, cmd)
+
+	if err := a.authorizer.Authorize(cmd.UserID, cmd.ChannelID, access.ActionIngest); err != nil {
+		log.Info().Str("user_id", cmd.UserID).Str("channel_id", cmd.ChannelID).Str("command", cmd.CommandType).Msg("Command denied")
+		reporter.Done(err.Error())
+		return
+	}
+
+	tenant, err := a.tenants.Get(ctx, cmd.TeamID)
+	if err != nil {
+		log.Error().Err(err).Str("team_id", cmd.TeamID).Msg("Failed to load workspace")
+		reporter.Done(fmt.Sprintf("Failed to load workspace: %s", err))
+		return
+	}
+
+	if err := checkModel(tenant.backend.UserModels(), cmd.Model); err != nil {
+		reporter.Done(err.Error())
+		return
+	}
+
+	scope := commandScope(fe, cmd)
+	ctx = withConversation(ctx, commandConversation(fe, cmd, scope))
+
+	// Documents that are already in the library aren't ingested again, but they can be summarized and compared.
+	opts := ingestOptions{Dedup: true, Scope: scope, UploadedBy: cmd.UserID}
+	results := tenant.ingester.IngestAll(ctx, cmd.URLs, opts, reporter.Progress)
+	status := formatResults(results)
+
+	ok := succeeded(results)
+	if cmd.CommandType == chat.SummarizeCommand || cmd.CommandType == chat.CompareCommand {
+		ok = inLibrary(results)
+	}
+	if len(ok) == 0 {
+		reporter.Done(status)
+		return
+	}
+
+	docs := promptDocuments(ok)
+	if cmd.CommandType == chat.CompareCommand {
+		if docs = uniqueDocuments(docs); len(docs) < 2 {
+			reporter.Done(status + "Comparing needs at least two different documents.")
+			return
+		}
+	}
+
+	channelID, threadID, err := startCommandThread(ctx, fe, tenant, cmd, scope, strings.TrimSuffix(status, "\n"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start thread")
+		reporter.Done(err.Error())
+		return
+	}
+
+	switch cmd.CommandType {
+	case chat.SummarizeCommand:
+		reporter.Stage(stageSummarizing)
+
+		record := &summaryRecord{Documents: docs, Options: cmd.Summary, Scope: scope, Model: cmd.Model}
+		if err := a.summarize(ctx, fe, tenant, cmd.TeamID, channelID, threadID, cmd.UserID, record, reporter.Detail, false); err != nil {
+			log.Error().Err(err).Msg("Failed to summarize")
+			reporter.Done(errorMessage(err))
+			return
+		}
+
+	case chat.CompareCommand:
+		reporter.Stage(stageComparing)
+
+		ctx := tenant.withModel(ctx, backend.TaskCompare, cmd.Model)
+		if err := a.compare(ctx, fe, tenant, cmd.TeamID, channelID, threadID, cmd.UserID, docs); err != nil {
+			log.Error().Err(err).Msg("Failed to compare")
+			reporter.Done(errorMessage(err))
+			return
+		}
+	}
+
+	reporter.Done(status)
+}
+
+func (a *app) handleEvent(ctx context.Context, fe chat.Frontend, event chat.Event) {
+	log := a.log.With().Str("frontend", fe.Name()).Logger()
+
+	tenant, err := a.tenants.Get(ctx, event.TeamID)
+	if err != nil {
+		log.Error().Err(err).Str("team_id", event.TeamID).Msg("Failed to load workspace")
+		return
+	}
+
+	switch event.Type {
+	case chat.MessageEvent:
+		// TODO:
+		// Upload messages to the assistant context as JSON objects:
+		// {
+		//  "type": "message",
+		//  "messageId": "1635732824.000100", // To refer to messages later
+		// 	"channelId": "C01B2PZQX1Z",
+		// 	"threadId": "1635732824.000100",
+		//  "userId": "U01B2PZQX1Z", // To refer to users. Does this need username as well?
+		//  "text": "Hello, world!"
+		// }
+	case chat.MentionEvent:
+		if err := a.authorizer.Authorize(event.UserID, event.ChannelID, access.ActionAsk); err != nil {
+			log.Info().Str("user_id", event.UserID).Str("channel_id", event.ChannelID).Msg("Mention denied")
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, err.Error())
+			return
+		}
+
+		scope := conversationScope(fe, event.ChannelID, event.UserID)
+
+		// Tools the assistant calls act on behalf of the user, in the conversation's library, and usage is attributed to them.
+		ctx := withConversation(ctx, &conversation{
+			frontend:  fe,
+			teamID:    event.TeamID,
+			channelID: event.ChannelID,
+			userID:    event.UserID,
+			command:   event.Type,
+			scope:     scope,
+			canIngest: a.authorizer.Authorize(event.UserID, event.ChannelID, access.ActionIngest) == nil,
+		})
+
+		if err := tenant.backend.CreateThread(ctx, event.ThreadID, scope); err != nil {
+			log.Error().Err(err).Msg("Failed to create thread")
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, err.Error())
+			return
+		}
+
+		log.Info().Str("user_id", event.UserID).Str("channel_id", event.ChannelID).Str("thread_id", event.ThreadID).Msg("New mention")
+
+		// Links in a mention are added to the library first, so the assistant can use them in its reply.
+		urls := event.URLs
+		if err := a.authorizer.Authorize(event.UserID, event.ChannelID, access.ActionIngest); err != nil && len(urls) > 0 {
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, err.Error())
+			urls = nil
+		}
+
+		var ingested []ingestResult
+		// They're restricted to the conversation, so links shared in private channels and direct messages stay there.
+		results := tenant.ingester.IngestAll(ctx, urls, ingestOptions{Dedup: true, Scope: scope, UploadedBy: event.UserID}, nil)
+		for _, res := range results {
+			switch {
+			case res.Err == nil, errors.Is(res.Err, errDuplicate):
+				ingested = append(ingested, res)
+			default:
+				fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, fmt.Sprintf("Failed to ingest %s: %s", res.URL, res.Err))
+			}
+		}
+
+		data := promptData(fe, tenant, event.TeamID, event.ChannelID, event.UserID)
+		data.ThreadID = event.ThreadID
+		data.Message = event.Text
+		data.Documents = promptDocuments(ingested)
+
+		// Follow-ups to a summary keep its style.
+		if record, ok := tenant.summaries.Get(event.ThreadID); ok {
+			data.Summary = record.Options
+		}
+
+		ctx = tenant.withModel(ctx, backend.TaskQuestion, "")
+		reply, err := tenant.prompt(ctx, event.ThreadID, prompt.MentionInstructionsTemplate, prompt.MentionTemplate, data)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to prompt assistant")
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, errorMessage(err))
+			return
+		}
+
+		if err := fe.PostMessage(event.TeamID, event.ChannelID, &event.ThreadID, withModelFooter(ctx, reply)); err != nil {
+			log.Error().Err(err).Msg("Failed to post message")
+		}
+
+	case chat.RegenerateEvent:
+		if err := a.authorizer.Authorize(event.UserID, event.ChannelID, access.ActionAsk); err != nil {
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, err.Error())
+			return
+		}
+
+		record, ok := tenant.summaries.Get(event.ThreadID)
+		if !ok || !tenant.backend.ContainsThread(event.ThreadID) {
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, "This summary can't be regenerated anymore.")
+			return
+		}
+
+		log.Info().Str("user_id", event.UserID).Str("thread_id", event.ThreadID).Msg("Regenerating summary")
+
+		ctx := withConversation(ctx, &conversation{teamID: event.TeamID, channelID: event.ChannelID, userID: event.UserID, command: chat.SummarizeCommand, scope: record.Scope})
+
+		if err := a.summarize(ctx, fe, tenant, event.TeamID, event.ChannelID, event.ThreadID, event.UserID, record, nil, true); err != nil {
+			log.Error().Err(err).Msg("Failed to regenerate summary")
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, errorMessage(err))
+		}
+
+	case chat.ReactionEvent, chat.ShortcutEvent:
+		log.Info().Str("user_id", event.UserID).Str("channel_id", event.ChannelID).Int("urls", len(event.URLs)).Msg("Saving message links")
+
+		if err := a.authorizer.Authorize(event.UserID, event.ChannelID, access.ActionIngest); err != nil {
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, err.Error())
+			return
+		}
+
+		if len(event.URLs) == 0 {
+			fe.PostEphemeral(event.TeamID, event.ChannelID, event.UserID, chat.ReplyMissingURL)
+			return
+		}
+
+		// Like links in mentions, saved links are restricted to the conversation.
+		scope := conversationScope(fe, event.ChannelID, event.UserID)
+		ctx := withConversation(ctx, &conversation{teamID: event.TeamID, channelID: event.ChannelID, userID: event.UserID, command: event.Type, scope: scope})
+		results := tenant.ingester.IngestAll(ctx, event.URLs, ingestOptions{Dedup: true, Scope: scope, UploadedBy: event.UserID}, nil)
+		reply := fmt.Sprintf("Saved to Scholar by <@%s>:\n%s", event.UserID, formatResults(results))
+
+		if err := fe.PostMessage(event.TeamID, event.ChannelID, &event.ThreadID, reply); err != nil {
+			log.Error().Err(err).Msg("Failed to post message")
+		}
+	}
+}
+
+// startCommandThread starts the thread of a command with the given text, and creates its assistant thread, which can
+// search the documents of the scope. It returns the channel of the thread, which is a direct message for private
+// commands, and the thread ID.
+func startCommandThread(ctx context.Context, fe chat.Frontend, tenant *tenant, cmd chat.Command, scope store.Scope, text string) (string, string, error) {
+	// Private commands are answered in a direct message instead of the channel.
+	channelID := cmd.ChannelID
+	if scope.Visibility == document.VisibilityPrivate && !fe.IsDM(channelID) {
+		var err error
+		if channelID, err = fe.OpenDM(cmd.TeamID, cmd.UserID); err != nil {
+			return "", "", fmt.Errorf("failed to open direct message: %w", err)
+		}
+	}
+
+	threadID, err := fe.StartUploadThread(cmd.TeamID, channelID, cmd.UserID, text)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to start upload thread: %w", err)
+	}
+
+	if err := tenant.backend.CreateThread(ctx, threadID, scope); err != nil {
+		return "", "", fmt.Errorf("failed to create thread: %w", err)
+	}
+
+	return channelID, threadID, nil
+}
+
+// commandScope returns the library a command's documents are added to.
+func commandScope(fe chat.Frontend, cmd chat.Command) store.Scope {
+	switch cmd.Visibility {
+	case document.VisibilityChannel:
+		if fe.IsDM(cmd.ChannelID) {
+			return store.PrivateScope(cmd.UserID)
+		}
+		return store.ChannelScope(cmd.ChannelID)
+	case document.VisibilityPrivate:
+		return store.PrivateScope(cmd.UserID)
+	default:
+		return store.PublicScope
+	}
+}
+
+// conversationScope returns the restricted library that can be searched in a conversation, besides the public one:
+// the user's private documents in direct messages, and the channel's documents elsewhere.
+func conversationScope(fe chat.Frontend, channelID, userID string) store.Scope {
+	if fe.IsDM(channelID) {
+		return store.PrivateScope(userID)
+	}
+
+	return store.ChannelScope(channelID)
+}
+
+// errorMessage returns the message of an error for users. Runs of the assistant that didn't complete are explained
+// with what users can do about it.
+func errorMessage(err error) string {
+	var runErr *backend.RunError
+	if errors.As(err, &runErr) {
+		return runErr.UserMessage()
+	}
+
+	return err.Error()
+}
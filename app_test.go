@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+
+	"github.com/mempirate/scholar/backend"
+)
+
+func TestThreadLocks(t *testing.T) {
+	var locks threadLocks
+
+	// Events of a thread are handled one at a time, and other threads don't wait for them.
+	var running, overlaps atomic.Int32
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := locks.Lock("slack/T1/1736000000.000100")
+			defer unlock()
+
+			if running.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+
+	unlock := locks.Lock("slack/T1/1736000000.000200")
+	unlock()
+
+	wg.Wait()
+	if overlaps.Load() != 0 {
+		t.Errorf("events of a thread overlapped %d times", overlaps.Load())
+	}
+
+	// Locks are removed once released.
+	if len(locks.locks) != 0 {
+		t.Errorf("expected no locks, got %d", len(locks.locks))
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	timeout := fmt.Errorf("failed to answer: %w", &backend.RunError{Status: openai.RunStatusCancelled, Timeout: time.Minute})
+	if msg := errorMessage(timeout); msg != "The answer took longer than 1m0s and was cancelled. Please try again." {
+		t.Errorf("unexpected message: %s", msg)
+	}
+
+	if msg := errorMessage(errors.New("failed to create thread")); msg != "failed to create thread" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+}
@@ -2,22 +2,25 @@ package backend
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"path"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
-	"github.com/openai/openai-go/packages/pagination"
+	"github.com/openai/openai-go/shared"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/mempirate/scholar/cache"
 	"github.com/mempirate/scholar/log"
-	"github.com/mempirate/scholar/prompt"
 	"github.com/mempirate/scholar/store"
 	"github.com/mempirate/scholar/util"
 )
@@ -25,58 +28,231 @@ import (
 const ASSISTANT_NAME = "Scholar"
 const VECTOR_STORE_NAME = "ScholarVectorStore"
 
-// ScholarBackend is an interface for the LLM backend used by applications.
+// Config are the settings of the assistant and its runs.
+type Config struct {
+	// Model is the model of the assistant, and of prompts whose task isn't in Models.
+	Model string `yaml:"model"`
+	// Models routes tasks to their own model, e.g. cheap summaries of tweets and strong ones of long papers. Keys
+	// are the tasks (see Tasks); tasks without a model use Model.
+	Models map[string]string `yaml:"models"`
+	// UserModels are the models users can select for their prompts with --model. Empty disables the option.
+	UserModels []string `yaml:"user_models"`
+	// AssistantName and VectorStoreName are the names of the assistant and vector store. Existing ones are
+	// looked up by name, so changing these creates new ones.
+	AssistantName   string `yaml:"assistant_name"`
+	VectorStoreName string `yaml:"vector_store_name"`
+	// Instructions are the instructions of the assistant, rendered from the assistant prompt template.
+	Instructions string  `yaml:"-"`
+	Temperature  float64 `yaml:"temperature"`
+	// MaxNumResults is the maximum number of chunks returned by file search, at most 50.
+	// Ref. <https://platform.openai.com/docs/assistants/tools/file-search#customizing-file-search-settings>
+	MaxNumResults int `yaml:"max_num_results"`
+	// MaxPromptTokens and MaxCompletionTokens bound the tokens used by a run. With file search, prompts should be large.
+	// Ref: <https://platform.openai.com/docs/assistants/deep-dive#max-completion-and-max-prompt-tokens>
+	MaxPromptTokens     int `yaml:"max_prompt_tokens"`
+	MaxCompletionTokens int `yaml:"max_completion_tokens"`
+	// PollInterval is how often runs are polled until they complete.
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// RequestTimeout bounds every attempt of a request to the API.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// MaxRetries is how many times requests that failed with a connection error, a timeout, a rate limit or a server
+	// error are retried. Retries start after RetryDelay, which doubles (with jitter) up to MaxRetryDelay. Rate limits
+	// that ask to wait longer than MaxRetryDelay aren't retried.
+	MaxRetries    int           `yaml:"max_retries"`
+	RetryDelay    time.Duration `yaml:"retry_delay"`
+	MaxRetryDelay time.Duration `yaml:"max_retry_delay"`
+	// RunTimeout bounds a run of the assistant, including its tool calls. Runs that take longer are cancelled.
+	RunTimeout time.Duration `yaml:"run_timeout"`
+	// UploadTimeout bounds the upload of a file to a vector store, until it is processed.
+	UploadTimeout time.Duration `yaml:"upload_timeout"`
+	// UploadConcurrency is the number of files uploaded concurrently when syncing the vector store.
+	UploadConcurrency int `yaml:"upload_concurrency"`
+	// UnverifiedQuotes is what happens to quotes of a response that aren't found in the cited files: they're
+	// flagged (QuotesFlag) or removed (QuotesRemove).
+	UnverifiedQuotes string `yaml:"unverified_quotes"`
+}
+
+// DefaultConfig returns the default settings.
+func DefaultConfig() Config {
+	return Config{
+		Model: openai.ChatModelGPT4oMini,
+		Models: map[string]string{
+			TaskTweetSummary: openai.ChatModelGPT4oMini,
+			TaskLongSummary:  openai.ChatModelGPT4o,
+			TaskCompare:      openai.ChatModelGPT4o,
+			TaskResearch:     openai.ChatModelGPT4o,
+		},
+		UserModels:          []string{openai.ChatModelGPT4oMini, openai.ChatModelGPT4o},
+		AssistantName:       ASSISTANT_NAME,
+		VectorStoreName:     VECTOR_STORE_NAME,
+		Temperature:         1,
+		MaxNumResults:       50,
+		MaxPromptTokens:     100_000,
+		MaxCompletionTokens: 30_000,
+		PollInterval:        100 * time.Millisecond,
+		RequestTimeout:      time.Minute,
+		MaxRetries:          4,
+		RetryDelay:          500 * time.Millisecond,
+		MaxRetryDelay:       30 * time.Second,
+		RunTimeout:          5 * time.Minute,
+		UploadTimeout:       5 * time.Minute,
+		UploadConcurrency:   4,
+		UnverifiedQuotes:    QuotesFlag,
+	}
+}
+
+// Validate checks that the settings are within the limits of the API.
+func (c *Config) Validate() error {
+	switch {
+	case c.Model == "":
+		return errors.New("model is required")
+	case c.AssistantName == "" || c.VectorStoreName == "":
+		return errors.New("assistant_name and vector_store_name are required")
+	case c.Temperature < 0 || c.Temperature > 2:
+		return errors.New("temperature must be between 0 and 2")
+	case c.MaxNumResults < 1 || c.MaxNumResults > 50:
+		return errors.New("max_num_results must be between 1 and 50")
+	case c.MaxPromptTokens < 256 || c.MaxCompletionTokens < 256:
+		return errors.New("max_prompt_tokens and max_completion_tokens must be at least 256")
+	case c.PollInterval <= 0:
+		return errors.New("poll_interval must be positive")
+	case c.RequestTimeout <= 0 || c.RunTimeout <= 0 || c.UploadTimeout <= 0:
+		return errors.New("request_timeout, run_timeout and upload_timeout must be positive")
+	case c.MaxRetries < 0:
+		return errors.New("max_retries must not be negative")
+	case c.RetryDelay <= 0 || c.MaxRetryDelay < c.RetryDelay:
+		return errors.New("retry_delay must be positive, and at most max_retry_delay")
+	case c.UploadConcurrency < 1:
+		return errors.New("upload_concurrency must be at least 1")
+	case c.UnverifiedQuotes != QuotesFlag && c.UnverifiedQuotes != QuotesRemove:
+		return fmt.Errorf("unverified_quotes must be %q or %q", QuotesFlag, QuotesRemove)
+	}
+
+	for task := range c.Models {
+		if !slices.Contains(Tasks, task) {
+			return fmt.Errorf("models: unknown task %q (available: %s)", task, strings.Join(Tasks, ", "))
+		}
+	}
+
+	return nil
+}
+
+// ScholarBackend is the LLM backend as used by applications, once it is initialized. *Backend implements it.
+//
+// Runs and completions use the model requested with WithModel, and report the model that answered to UsedModel.
+// Runs that don't complete within the run timeout are cancelled, and runs that end without an answer return a
+// *RunError.
 type ScholarBackend interface {
-	// CreateThread creates a new thread.
-	CreateThread(ctx context.Context, threadID string) error
-	// UploadFile uploads a file to the vector store. threadID is only used to associate prompts with the
-	// file upload. The file is uploaded to the vector store associated with the assistant.
-	UploadFile(ctx context.Context, threadID, path string) error
+	// CreateThread creates a new thread that can search the public documents and the documents of the given scope.
+	CreateThread(ctx context.Context, threadID string, scope store.Scope) error
+	// ContainsThread returns true if the thread exists.
+	ContainsThread(threadID string) bool
 	// Post adds a message to the thread with no response (adds more context).
 	Post(ctx context.Context, threadID, text string) error
-	// Prompt prompts the assistant with a message and returns the response.
-	Prompt(ctx context.Context, threadID, text string) (string, error)
+	// Prompt prompts the assistant in the thread, and returns its response with the cited files listed at the end.
+	Prompt(ctx context.Context, threadID, instructions, text string) (string, error)
+	// PromptJSON is like Prompt, but the response is a JSON object that follows the schema of the format.
+	PromptJSON(ctx context.Context, threadID, instructions, text string, format ResponseFormat) (string, error)
+	// Complete prompts the model outside of any thread and without file search.
+	Complete(ctx context.Context, instructions, text string) (string, error)
+	// UploadFile uploads a document to the vector store of the scope.
+	UploadFile(ctx context.Context, scope store.Scope, name string, content io.Reader) error
+	// DeleteFile removes a document from the vector store of the scope, and deletes the uploaded file.
+	DeleteFile(ctx context.Context, scope store.Scope, name string) error
+	// TaskModel returns the model of a task.
+	TaskModel(task string) string
+	// UserModels returns the models users can select instead of the model of a task.
+	UserModels() []string
+	// Close closes the thread cache.
+	Close() error
 }
 
+var _ ScholarBackend = (*Backend)(nil)
+
 // Backend manages interactions with the OpenAI API and is responsible for
 // managing the assistant, vector store, and document uploads.
 type Backend struct {
 	log zerolog.Logger
 
 	client *openai.Client
-	model  openai.ChatModel
+	config Config
+
+	// assistantName and vectorStoreName are suffixed with the tenant, to isolate workspaces.
+	assistantName   string
+	vectorStoreName string
 
 	assistant *openai.Assistant
-	store     *openai.VectorStore
+	// store is the vector store of public documents, which is attached to the assistant.
+	store *openai.VectorStore
+
+	// scopedStores maps scope keys to the vector stores of channel and private documents. These are
+	// attached to threads instead, so they're only searched in the right conversations.
+	scopedMu     sync.Mutex
+	scopedStores map[string]*openai.VectorStore
 
 	localStore store.LocalStore
 
 	// threadCache is a cache that maps local IDs to openAI thread IDs.
 	threadCache *cache.BoltCache
+
+	// registry are the function tools of the assistant.
+	registry *Tools
+
+	// meter records and limits the usage of the backend. Nil if usage isn't accounted for.
+	meter Meter
+
+	// quotes counts the quotes of all responses that were verified, for the accuracy metric.
+	quotesMu sync.Mutex
+	quotes   quoteStats
 }
 
-func NewBackend(apiKey string, model openai.ChatModel, localStore store.LocalStore) *Backend {
+// NewBackend creates a new backend. tenant isolates the assistant and vector store of a workspace from
+// other workspaces, and can be left empty if there's only one. opts are applied to every request, e.g. to use
+// another endpoint.
+func NewBackend(apiKey string, config Config, localStore store.LocalStore, tenant string, opts ...option.RequestOption) (*Backend, error) {
 	log := log.NewLogger("scholar")
 
+	assistantName, vectorStoreName := config.AssistantName, config.VectorStoreName
+	if tenant != "" {
+		assistantName += "-" + tenant
+		vectorStoreName += "-" + tenant
+	}
+
 	log.Info().Msg("Initializing OpenAI client")
-	client := openai.NewClient(
+	// Failed requests are retried by the retrier instead of the client.
+	client := openai.NewClient(append([]option.RequestOption{
 		option.WithAPIKey(apiKey),
 		option.WithHeader("OpenAI-Beta", "assistants=v2"),
-	)
+		option.WithMaxRetries(0),
+		option.WithMiddleware(newRetrier(log, config).Middleware),
+	}, opts...)...)
 
 	cache, err := cache.NewBoltCache(path.Join(localStore.Path(), "threads.db"))
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create thread cache")
-		return nil
+		return nil, errors.Wrap(err, "failed to create thread cache")
 	}
 
 	return &Backend{
-		log:         log,
-		client:      client,
-		model:       model,
-		threadCache: cache,
-		localStore:  localStore,
-	}
+		log:             log,
+		client:          client,
+		config:          config,
+		assistantName:   assistantName,
+		vectorStoreName: vectorStoreName,
+		threadCache:     cache,
+		localStore:      localStore,
+		scopedStores:    make(map[string]*openai.VectorStore),
+		registry:        NewTools(),
+	}, nil
+}
+
+// Close closes the thread cache. The backend can't be used anymore.
+func (b *Backend) Close() error {
+	return b.threadCache.Close()
+}
+
+// Tools returns the registry of the assistant's function tools. Tools must be registered before Init.
+func (b *Backend) Tools() *Tools {
+	return b.registry
 }
 
 // Init initializes the backend by getting or creating the assistant and vector store.
@@ -88,15 +264,20 @@ func (b *Backend) Init(ctx context.Context) error {
 
 	b.assistant = assistant
 
-	vectorStore, err := b.GetOrCreateVectorStore(ctx, VECTOR_STORE_NAME)
+	vectorStore, err := b.GetOrCreateVectorStore(ctx, b.vectorStoreName)
 	if err != nil {
 		return err
 	}
 
 	b.store = vectorStore
 
-	b.log.Debug().Str("assistant_id", b.assistant.ID).Str("store_id", b.store.ID).Msg("Updating assistant with vector store")
+	// Settings are applied on every start, so configuration changes reach existing assistants.
+	b.log.Debug().Str("assistant_id", b.assistant.ID).Str("store_id", b.store.ID).Str("model", b.config.Model).Msg("Updating assistant settings and vector store")
 	_, err = b.client.Beta.Assistants.Update(ctx, b.assistant.ID, openai.BetaAssistantUpdateParams{
+		Model:        openai.String(b.config.Model),
+		Instructions: openai.String(b.config.Instructions),
+		Temperature:  openai.Float(b.config.Temperature),
+		Tools:        openai.F(b.tools()),
 		ToolResources: openai.F(openai.BetaAssistantUpdateParamsToolResources{
 			FileSearch: openai.F(openai.BetaAssistantUpdateParamsToolResourcesFileSearch{
 				VectorStoreIDs: openai.F([]string{b.store.ID}),
@@ -108,33 +289,70 @@ func (b *Backend) Init(ctx context.Context) error {
 		return err
 	}
 
-	if err := b.syncFiles(ctx); err != nil {
+	remoteFiles, err := b.remoteFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := b.syncFiles(ctx, b.localStore, b.store, remoteFiles); err != nil {
 		return err
 	}
 
+	scopes, err := b.localStore.Scopes()
+	if err != nil {
+		return errors.Wrap(err, "failed to list scopes")
+	}
+
+	for _, scope := range scopes {
+		vectorStore, err := b.vectorStore(ctx, scope)
+		if err != nil {
+			return err
+		}
+
+		if err := b.syncFiles(ctx, b.localStore.Scoped(scope), vectorStore, remoteFiles); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// SyncFiles syncs the local store with the remote store.
-func (b *Backend) syncFiles(ctx context.Context) error {
-	fileNames, err := b.localStore.List()
-	if err != nil {
-		return err
+// vectorStore returns the vector store of the given scope, creating it if needed.
+func (b *Backend) vectorStore(ctx context.Context, scope store.Scope) (*openai.VectorStore, error) {
+	if scope.IsPublic() {
+		return b.store, nil
 	}
 
-	remoteFiles, err := b.client.Files.List(ctx, openai.FileListParams{})
+	b.scopedMu.Lock()
+	defer b.scopedMu.Unlock()
+
+	if vectorStore, ok := b.scopedStores[scope.Key()]; ok {
+		return vectorStore, nil
+	}
+
+	vectorStore, err := b.GetOrCreateVectorStore(ctx, b.vectorStoreName+"-"+scope.Key())
 	if err != nil {
-		return errors.Wrap(err, "failed to list remote files")
+		return nil, err
 	}
 
-	remoteNames := make(map[string]struct{})
+	b.scopedStores[scope.Key()] = vectorStore
+	return vectorStore, nil
+}
 
-	for _, file := range remoteFiles.Data {
-		remoteNames[file.Filename] = struct{}{}
+// SyncFiles syncs a local store with a remote vector store. remoteFiles maps file IDs to file names.
+func (b *Backend) syncFiles(ctx context.Context, localStore store.LocalStore, vectorStore *openai.VectorStore, remoteFiles map[string]string) error {
+	fileNames, err := localStore.List()
+	if err != nil {
+		return err
+	}
+
+	remoteNames, err := b.remoteFileNames(ctx, vectorStore.ID, remoteFiles)
+	if err != nil {
+		return err
 	}
 
 	eg := errgroup.Group{}
-	eg.SetLimit(4)
+	eg.SetLimit(b.config.UploadConcurrency)
 
 	// Sync local files to remote store
 	for _, fileName := range fileNames {
@@ -145,7 +363,7 @@ func (b *Backend) syncFiles(ctx context.Context) error {
 		func(fileName string) {
 			eg.Go(func() error {
 				b.log.Debug().Str("filename", fileName).Msg("Uploading local file")
-				f, err := b.localStore.Get(fileName)
+				f, err := localStore.Get(fileName)
 				if err != nil {
 					return err
 
@@ -153,57 +371,88 @@ func (b *Backend) syncFiles(ctx context.Context) error {
 
 				defer f.Close()
 
-				vsFile, err := b.client.Beta.VectorStores.Files.UploadAndPoll(ctx, b.store.ID, openai.FileNewParams{
-					File: openai.F[io.Reader](f),
-					// Purpose of the file.
-					Purpose: openai.F(openai.FilePurposeAssistants),
-				}, 100)
-
+				vsFile, err := b.upload(ctx, vectorStore.ID, f)
 				if err != nil {
-					return errors.Wrap(err, "failed to upload document to vector store")
+					return err
 				}
 
 				b.log.Info().Str("file", fileName).Str("size", util.FormatBytes(vsFile.UsageBytes)).Str("status", string(vsFile.Status)).Msg("Document uploaded")
+				b.record(ctx, Usage{StorageBytes: vsFile.UsageBytes})
 
 				return nil
 			})
 		}(fileName)
 	}
 
-	return nil
+	return eg.Wait()
 }
 
-func (b *Backend) GetOrCreateAssistant(ctx context.Context) (*openai.Assistant, error) {
-	b.log.Info().Str("name", ASSISTANT_NAME).Msg("Getting or creating assistant")
-	assistants, err := b.client.Beta.Assistants.List(ctx, openai.BetaAssistantListParams{})
-	if err != nil {
-		return nil, err
+// remoteFiles returns the names of all uploaded files by ID.
+func (b *Backend) remoteFiles(ctx context.Context) (map[string]string, error) {
+	remoteFiles := make(map[string]string)
+
+	fileIter := b.client.Files.ListAutoPaging(ctx, openai.FileListParams{})
+	for fileIter.Next() {
+		file := fileIter.Current()
+		remoteFiles[file.ID] = file.Filename
 	}
 
-	for _, assistant := range assistants.Data {
-		if assistant.Name == ASSISTANT_NAME && len(assistant.Tools) > 0 && assistant.Tools[0].Type == openai.AssistantToolTypeFileSearch {
+	if err := fileIter.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to list remote files")
+	}
+
+	return remoteFiles, nil
+}
+
+// remoteFileNames returns the names of the files in the given vector store. Files in other vector stores
+// (other scopes or tenants) are not included, even though they share the same file storage.
+func (b *Backend) remoteFileNames(ctx context.Context, vectorStoreID string, remoteFiles map[string]string) (map[string]struct{}, error) {
+	remoteNames := make(map[string]struct{})
+
+	vsIter := b.client.Beta.VectorStores.Files.ListAutoPaging(ctx, vectorStoreID, openai.BetaVectorStoreFileListParams{
+		Limit: openai.Int(100),
+	})
+	for vsIter.Next() {
+		if name, ok := remoteFiles[vsIter.Current().ID]; ok {
+			remoteNames[name] = struct{}{}
+		}
+	}
+
+	if err := vsIter.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to list vector store files")
+	}
+
+	return remoteNames, nil
+}
+
+func (b *Backend) GetOrCreateAssistant(ctx context.Context) (*openai.Assistant, error) {
+	b.log.Info().Str("name", b.assistantName).Msg("Getting or creating assistant")
+	assistants := b.client.Beta.Assistants.ListAutoPaging(ctx, openai.BetaAssistantListParams{
+		Limit: openai.Int(100),
+	})
+
+	for assistants.Next() {
+		assistant := assistants.Current()
+		if assistant.Name == b.assistantName && len(assistant.Tools) > 0 && assistant.Tools[0].Type == openai.AssistantToolTypeFileSearch {
 			b.log.Debug().Msg("Existing assistant found with file_search tool")
 			return &assistant, nil
 		}
 	}
 
+	if err := assistants.Err(); err != nil {
+		return nil, err
+	}
+
 	b.log.Debug().Msg("Creating new assistant with file_search tool")
 
 	assistant, err := b.client.Beta.Assistants.New(ctx, openai.BetaAssistantNewParams{
-		Name:         openai.String(ASSISTANT_NAME),
-		Instructions: openai.String(prompt.ASSISTANT_PROMPT_INSTRUCTIONS),
-		Model:        openai.String(b.model),
+		Name:         openai.String(b.assistantName),
+		Instructions: openai.String(b.config.Instructions),
+		Model:        openai.String(b.config.Model),
 		// Description:   param.Field{},
 		// Metadata:      param.Field{},
-		// Temperature:   param.Field{},
-		Temperature: openai.Float(1),
-		Tools: openai.F([]openai.AssistantToolUnionParam{
-			openai.FileSearchToolParam{Type: openai.F(openai.FileSearchToolTypeFileSearch), FileSearch: openai.F(openai.FileSearchToolFileSearchParam{
-				// NOTE: set max num results to 50 for now (maximum)
-				// Ref. <https://platform.openai.com/docs/assistants/tools/file-search#customizing-file-search-settings>
-				MaxNumResults: openai.Int(50),
-			})},
-		}),
+		Temperature: openai.Float(b.config.Temperature),
+		Tools:       openai.F(b.tools()),
 		// TopP:          param.Field{},
 	})
 
@@ -216,23 +465,38 @@ func (b *Backend) GetOrCreateAssistant(ctx context.Context) (*openai.Assistant,
 	return assistant, nil
 }
 
+// tools returns the tools of the assistant: file search first, followed by the registered function tools.
+func (b *Backend) tools() []openai.AssistantToolUnionParam {
+	tools := []openai.AssistantToolUnionParam{
+		openai.FileSearchToolParam{Type: openai.F(openai.FileSearchToolTypeFileSearch), FileSearch: openai.F(openai.FileSearchToolFileSearchParam{
+			MaxNumResults: openai.Int(int64(b.config.MaxNumResults)),
+		})},
+	}
+
+	return append(tools, b.registry.params()...)
+}
+
 // GetOrCreateVectorStore gets or creates a vector store for the assistant.
 // The vector store is used to store document embeddings for the file search tool of the assistant.
 // It will expire after 30 days of inactivity.
 // https://github.com/openai/openai-go/blob/main/examples/beta/vectorstorefilebatch/main.go
 func (b *Backend) GetOrCreateVectorStore(ctx context.Context, name string) (*openai.VectorStore, error) {
-	stores, err := b.client.Beta.VectorStores.List(ctx, openai.BetaVectorStoreListParams{})
-	if err != nil {
-		return nil, err
-	}
+	stores := b.client.Beta.VectorStores.ListAutoPaging(ctx, openai.BetaVectorStoreListParams{
+		Limit: openai.Int(100),
+	})
 
-	for _, store := range stores.Data {
+	for stores.Next() {
+		store := stores.Current()
 		if store.Name == name {
 			b.log.Debug().Msg("Existing vector store found")
 			return &store, nil
 		}
 	}
 
+	if err := stores.Err(); err != nil {
+		return nil, err
+	}
+
 	vectorStore, err := b.client.Beta.VectorStores.New(
 		ctx,
 		openai.BetaVectorStoreNewParams{
@@ -254,20 +518,94 @@ func (b *Backend) GetOrCreateVectorStore(ctx context.Context, name string) (*ope
 	return vectorStore, nil
 }
 
-func (b *Backend) UploadFile(ctx context.Context, name string, content io.Reader) error {
-	b.log.Debug().Str("name", name).Msg("Uploading document")
+// UploadFile uploads a document to the vector store of the given scope.
+func (b *Backend) UploadFile(ctx context.Context, scope store.Scope, name string, content io.Reader) error {
+	b.log.Debug().Str("name", name).Str("scope", scope.Key()).Msg("Uploading document")
+
+	vectorStore, err := b.vectorStore(ctx, scope)
+	if err != nil {
+		return err
+	}
+
+	vsFile, err := b.upload(ctx, vectorStore.ID, content)
+	if err != nil {
+		return err
+	}
+
+	b.log.Info().Str("name", name).Str("size", util.FormatBytes(vsFile.UsageBytes)).Str("status", string(vsFile.Status)).Msg("Document uploaded")
+	b.record(ctx, Usage{StorageBytes: vsFile.UsageBytes})
+
+	return nil
+}
+
+// upload uploads a file to a vector store, and waits until it is processed.
+func (b *Backend) upload(ctx context.Context, vectorStoreID string, content io.Reader) (*openai.VectorStoreFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.config.UploadTimeout)
+	defer cancel()
 
-	vsFile, err := b.client.Beta.VectorStores.Files.UploadAndPoll(ctx, b.store.ID, openai.FileNewParams{
+	vsFile, err := b.client.Beta.VectorStores.Files.UploadAndPoll(ctx, vectorStoreID, openai.FileNewParams{
 		File: openai.F(content),
 		// Purpose of the file.
 		Purpose: openai.F(openai.FilePurposeAssistants),
 	}, 100)
 
 	if err != nil {
-		return errors.Wrap(err, "failed to upload document to vector store")
+		return nil, errors.Wrap(err, "failed to upload document to vector store")
 	}
 
-	b.log.Info().Str("name", name).Str("size", util.FormatBytes(vsFile.UsageBytes)).Str("status", string(vsFile.Status)).Msg("Document uploaded")
+	// Files that can't be processed can't be searched. They're removed, so the next sync uploads them again.
+	if vsFile.Status == openai.VectorStoreFileStatusFailed {
+		if _, err := b.client.Beta.VectorStores.Files.Delete(ctx, vectorStoreID, vsFile.ID); err != nil {
+			b.log.Warn().Err(err).Str("file_id", vsFile.ID).Msg("Failed to remove unprocessed file from vector store")
+		}
+		if _, err := b.client.Files.Delete(ctx, vsFile.ID); err != nil {
+			b.log.Warn().Err(err).Str("file_id", vsFile.ID).Msg("Failed to delete unprocessed file")
+		}
+
+		return nil, errors.Errorf("failed to process document in vector store: %s", vsFile.LastError.Message)
+	}
+
+	return vsFile, nil
+}
+
+// DeleteFile removes a document from the vector store of the given scope, and deletes the uploaded file.
+func (b *Backend) DeleteFile(ctx context.Context, scope store.Scope, name string) error {
+	vectorStore, err := b.vectorStore(ctx, scope)
+	if err != nil {
+		return err
+	}
+
+	remoteFiles, err := b.remoteFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+
+	vsIter := b.client.Beta.VectorStores.Files.ListAutoPaging(ctx, vectorStore.ID, openai.BetaVectorStoreFileListParams{
+		Limit: openai.Int(100),
+	})
+	for vsIter.Next() {
+		if id := vsIter.Current().ID; remoteFiles[id] == name {
+			ids = append(ids, id)
+		}
+	}
+
+	if err := vsIter.Err(); err != nil {
+		return errors.Wrap(err, "failed to list vector store files")
+	}
+
+	for _, id := range ids {
+		if _, err := b.client.Beta.VectorStores.Files.Delete(ctx, vectorStore.ID, id); err != nil {
+			return errors.Wrapf(err, "failed to remove %s from vector store", name)
+		}
+
+		if _, err := b.client.Files.Delete(ctx, id); err != nil {
+			return errors.Wrapf(err, "failed to delete file %s", name)
+		}
+	}
+
+	b.log.Info().Str("name", name).Str("scope", scope.Key()).Int("files", len(ids)).Msg("Document deleted")
 
 	return nil
 }
@@ -281,13 +619,28 @@ func (b *Backend) getFileName(ctx context.Context, id string) (string, error) {
 	return f.Filename, nil
 }
 
-// CreateThread creates a new thread with the given ID if it doesn't exist yet.
-func (b *Backend) CreateThread(ctx context.Context, threadID string) error {
+// CreateThread creates a new thread with the given ID if it doesn't exist yet. Besides the public documents,
+// the thread can search the documents of the given scope (i.e. where the conversation takes place).
+func (b *Backend) CreateThread(ctx context.Context, threadID string, scope store.Scope) error {
 	if b.ContainsThread(threadID) {
 		return nil
 	}
 
-	thread, err := b.client.Beta.Threads.New(ctx, openai.BetaThreadNewParams{})
+	params := openai.BetaThreadNewParams{}
+	if !scope.IsPublic() {
+		vectorStore, err := b.vectorStore(ctx, scope)
+		if err != nil {
+			return err
+		}
+
+		params.ToolResources = openai.F(openai.BetaThreadNewParamsToolResources{
+			FileSearch: openai.F(openai.BetaThreadNewParamsToolResourcesFileSearch{
+				VectorStoreIDs: openai.F([]string{vectorStore.ID}),
+			}),
+		})
+	}
+
+	thread, err := b.client.Beta.Threads.New(ctx, params)
 	if err != nil {
 		return errors.Wrap(err, "failed to create thread")
 	}
@@ -295,27 +648,43 @@ func (b *Backend) CreateThread(ctx context.Context, threadID string) error {
 	b.log.Debug().Str("thread_id", threadID).Str("openai_id", thread.ID).Msg("Thread created")
 
 	b.threadCache.Put(threadID, thread.ID)
+	if !scope.IsPublic() {
+		b.threadCache.Put(threadScopeKey(threadID), scope.Key())
+	}
+
 	return nil
 }
 
-func (b *Backend) ContainsThread(threadID string) bool {
-	return b.threadCache.Contains(threadID)
+// threadScopeKey is the key of the scope of a thread in the thread cache.
+func threadScopeKey(threadID string) string {
+	return "scope:" + threadID
 }
 
-func (b *Backend) Post(ctx context.Context, threadID, text string) error {
-	// TODO
-	return nil
+// threadScope returns the scope the thread was created with. Threads of public conversations, and threads created
+// before scopes were recorded, only search public documents.
+func (b *Backend) threadScope(threadID string) store.Scope {
+	key, ok := b.threadCache.Get(threadScopeKey(threadID))
+	if !ok {
+		return store.PublicScope
+	}
+
+	scope, err := store.ParseScope(key)
+	if err != nil {
+		b.log.Warn().Err(err).Str("thread_id", threadID).Msg("Invalid thread scope")
+		return store.PublicScope
+	}
+
+	return scope
 }
 
-func (b *Backend) Prompt(ctx context.Context, threadID, instructions, text string) (string, error) {
-	start := time.Now()
-	defer func() {
-		b.log.Debug().Dur("duration", time.Since(start)).Msg("Message posted")
-	}()
+func (b *Backend) ContainsThread(threadID string) bool {
+	return b.threadCache.Contains(threadID)
+}
 
+func (b *Backend) Post(ctx context.Context, threadID, text string) error {
 	thread, ok := b.threadCache.Get(threadID)
 	if !ok {
-		return "", errors.New("local thread not found")
+		return errors.New("local thread not found")
 	}
 
 	_, err := b.client.Beta.Threads.Messages.New(ctx, thread, openai.BetaThreadMessageNewParams{
@@ -328,94 +697,263 @@ func (b *Backend) Prompt(ctx context.Context, threadID, instructions, text strin
 		}),
 	})
 
+	return errors.Wrap(err, "failed to create new message")
+}
+
+// Prompt prompts the assistant in the thread, and returns its response with the cited files listed at the end.
+// Citations of files that aren't in the vector store are dropped, and quotes are verified against the cited files.
+func (b *Backend) Prompt(ctx context.Context, threadID, instructions, text string) (string, error) {
+	content, err := b.run(ctx, threadID, instructions, text, nil)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create new message")
+		return "", err
 	}
 
-	run, err := b.client.Beta.Threads.Runs.NewAndPoll(ctx, thread, openai.BetaThreadRunNewParams{
-		AssistantID: openai.String(b.assistant.ID),
-		// TODO: add in config
-		Instructions: openai.String(instructions),
-		// NOTE: with file search, we should increase the max prompt tokens for better responses.
-		// Ref: <https://platform.openai.com/docs/assistants/deep-dive#max-completion-and-max-prompt-tokens>
-		MaxPromptTokens:     openai.Int(100_000),
-		MaxCompletionTokens: openai.Int(30_000),
-		Include:             openai.F([]openai.RunStepInclude{openai.RunStepIncludeStepDetailsToolCallsFileSearchResultsContent}),
-	}, 100)
+	fileCache := make(map[string]string)
 
-	if err != nil {
-		return "", errors.Wrap(err, "failed to create new run")
+	// Citations of the same file share a number.
+	numbers := make(map[string]int)
+	citations := make(map[int]string)
+
+	for _, annotation := range content.Annotations {
+		// Other annotations, e.g. of file paths, aren't citations.
+		citation, ok := annotation.FileCitation.(openai.FileCitationAnnotationFileCitation)
+		if !ok {
+			b.log.Warn().Str("type", string(annotation.Type)).Msg("Invalid file citation, dropping annotation")
+			content.Value = strings.Replace(content.Value, annotation.Text, "", 1)
+			continue
+		}
+
+		file, ok := fileCache[citation.FileID]
+		if !ok {
+			file, err = b.getFileName(ctx, citation.FileID)
+			if err != nil {
+				b.log.Warn().Err(err).Str("file_id", citation.FileID).Msg("Invalid file citation, file doesn't exist in vector store")
+				content.Value = strings.Replace(content.Value, annotation.Text, "", 1)
+				continue
+			}
+
+			fileCache[citation.FileID] = file
+		}
+
+		index, ok := numbers[file]
+		if !ok {
+			index = len(numbers) + 1
+			numbers[file] = index
+			citations[index] = file
+		}
+
+		content.Value = strings.Replace(content.Value, annotation.Text, fmt.Sprintf(" [%d]", index), 1)
 	}
 
-	fileCache := make(map[string]string)
+	// Quotes are only checked against the files the thread can search.
+	scope := b.threadScope(threadID)
+	read := func(file string) (string, error) { return b.readLocal(scope, file) }
+
+	value, stats := verifyQuotes(content.Value, citations, read, b.config.UnverifiedQuotes)
+	if stats.total > 0 {
+		b.quotesMu.Lock()
+		b.quotes.verified += stats.verified
+		b.quotes.total += stats.total
+		total := b.quotes
+		b.quotesMu.Unlock()
+
+		b.log.Info().Str("thread_id", threadID).Int("verified", stats.verified).Int("quotes", stats.total).
+			Float64("accuracy", stats.Accuracy()).Float64("total_accuracy", total.Accuracy()).Msg("Quotes verified")
+	}
 
 	response := strings.Builder{}
+	response.WriteString(value)
+	if len(citations) > 0 {
+		response.WriteString("\n\n---")
+		for index := 1; index <= len(citations); index++ {
+			response.WriteString(fmt.Sprintf("\n[%d] %s", index, citations[index]))
+		}
+	}
 
-	if run.Status == openai.RunStatusCompleted {
-		eg := errgroup.Group{}
+	return response.String(), nil
+}
 
-		var messages *pagination.CursorPage[openai.Message]
+// Complete prompts the model with instructions and a text, outside of any thread and without file search. It is
+// meant for self-contained tasks, like summarizing a section of a document.
+func (b *Backend) Complete(ctx context.Context, instructions, text string) (string, error) {
+	model, err := b.model(ctx)
+	if err != nil {
+		return "", err
+	}
 
-		eg.Go(func() error {
-			messages, err = b.client.Beta.Threads.Messages.List(ctx, thread, openai.BetaThreadMessageListParams{})
-			if err != nil {
-				return err
-			}
+	completion, err := b.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Model: openai.F(model),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(instructions),
+			openai.UserMessage(text),
+		}),
+		Temperature:         openai.Float(b.config.Temperature),
+		MaxCompletionTokens: openai.Int(int64(b.config.MaxCompletionTokens)),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create completion")
+	}
 
-			// TODO: include steps and files consulted
-			// steps, err = b.client.Beta.Threads.Runs.Steps.List(ctx, thread, run.ID, openai.BetaThreadRunStepListParams{
-			// 	Include: openai.F([]openai.RunStepInclude{openai.RunStepIncludeStepDetailsToolCallsFileSearchResultsContent}),
-			// })
-			if err != nil {
-				return err
-			}
+	b.record(ctx, Usage{Model: model, PromptTokens: completion.Usage.PromptTokens, CompletionTokens: completion.Usage.CompletionTokens})
 
-			return nil
-		})
+	if len(completion.Choices) == 0 {
+		return "", errors.New("completion without choices")
+	}
+
+	return completion.Choices[0].Message.Content, nil
+}
 
-		if err := eg.Wait(); err != nil {
-			return "", err
+// ResponseFormat is the JSON schema of the response of PromptJSON.
+type ResponseFormat struct {
+	// Name identifies the schema, e.g. "structured_summary".
+	Name        string
+	Description string
+	Schema      map[string]any
+}
+
+// PromptJSON is like Prompt, but the response is a JSON object that follows the schema of the format. Citations are
+// removed from the response, since their markers would make it invalid.
+//
+// The schema is enforced with structured outputs. Since not every model and tool supports them, the schema is also
+// part of the instructions, and runs that are rejected with structured outputs are retried without.
+func (b *Backend) PromptJSON(ctx context.Context, threadID, instructions, text string, format ResponseFormat) (string, error) {
+	schema, err := json.Marshal(format.Schema)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid response schema")
+	}
+
+	instructions = fmt.Sprintf("%s\n\nRespond only with a JSON object (without markdown code fences) that follows this JSON schema:\n%s", instructions, schema)
+
+	content, err := b.run(ctx, threadID, instructions, text, &format)
+	if err != nil {
+		return "", err
+	}
+
+	for _, annotation := range content.Annotations {
+		content.Value = strings.Replace(content.Value, annotation.Text, "", 1)
+	}
+
+	// Without structured outputs, the object may be wrapped in a code block.
+	response := content.Value
+	if start, end := strings.Index(response, "{"), strings.LastIndex(response, "}"); start >= 0 && end > start {
+		response = response[start : end+1]
+	}
+
+	return response, nil
+}
+
+// MAX_TOOL_ROUNDS is the maximum number of times a run can call tools before it is cancelled.
+const MAX_TOOL_ROUNDS = 10
+
+// callTools calls the tools of a run that requires action, and returns their outputs.
+func (b *Backend) callTools(ctx context.Context, calls []openai.RequiredActionFunctionToolCall) []openai.BetaThreadRunSubmitToolOutputsParamsToolOutput {
+	outputs := make([]openai.BetaThreadRunSubmitToolOutputsParamsToolOutput, len(calls))
+	for i, call := range calls {
+		start := time.Now()
+		output := b.registry.Call(ctx, call.Function.Name, call.Function.Arguments)
+		b.log.Debug().Str("tool", call.Function.Name).Str("arguments", call.Function.Arguments).Dur("duration", time.Since(start)).Msg("Tool called")
+
+		outputs[i] = openai.BetaThreadRunSubmitToolOutputsParamsToolOutput{
+			ToolCallID: openai.String(call.ID),
+			Output:     openai.String(output),
 		}
+	}
+
+	return outputs
+}
+
+// run posts the text in the thread, runs the assistant with the given instructions and returns its response.
+// If format is set, the response is requested in that format.
+func (b *Backend) run(ctx context.Context, threadID, instructions, text string, format *ResponseFormat) (*openai.Text, error) {
+	start := time.Now()
+	defer func() {
+		b.log.Debug().Dur("duration", time.Since(start)).Msg("Message posted")
+	}()
 
-		// The first message is the response
-		message := messages.Data[0]
+	// The model is checked first, so refused prompts aren't added to the thread.
+	model, err := b.model(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		content := message.Content[0]
+	// Runs are bounded, so a run that hangs doesn't hold up the conversation.
+	ctx, cancel := context.WithTimeout(ctx, b.config.RunTimeout)
+	defer cancel()
 
-		citations := make([]string, len(content.Text.Annotations))
+	if err := b.Post(ctx, threadID, text); err != nil {
+		return nil, err
+	}
 
-		for i, annotation := range content.Text.Annotations {
-			index := i + 1
-			content.Text.Value = strings.Replace(content.Text.Value, annotation.Text, fmt.Sprintf(" [%d]", index), 1)
-			citation := annotation.FileCitation.(openai.FileCitationAnnotationFileCitation)
-			if file, ok := fileCache[citation.FileID]; ok {
-				citations[i] = fmt.Sprintf("[%d] %s", index, file)
-				continue
-			}
+	thread, _ := b.threadCache.Get(threadID)
 
-			file, err := b.getFileName(ctx, citation.FileID)
-			if err != nil {
-				b.log.Err(err).Int("index", index).Msg("Invalid file citation, file doesn't exist in vector store")
-				continue
-			}
+	params := openai.BetaThreadRunNewParams{
+		AssistantID:         openai.String(b.assistant.ID),
+		Model:               openai.F(model),
+		Instructions:        openai.String(instructions),
+		MaxPromptTokens:     openai.Int(int64(b.config.MaxPromptTokens)),
+		MaxCompletionTokens: openai.Int(int64(b.config.MaxCompletionTokens)),
+		Include:             openai.F([]openai.RunStepInclude{openai.RunStepIncludeStepDetailsToolCallsFileSearchResultsContent}),
+	}
 
-			fileCache[citation.FileID] = file
-			citations[i] = fmt.Sprintf("[%d] %s", index, file)
+	var opts []option.RequestOption
+	if format != nil {
+		opts = append(opts, option.WithJSONSet("response_format", shared.ResponseFormatJSONSchemaParam{
+			Type: openai.F(shared.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:        openai.String(format.Name),
+				Description: openai.String(format.Description),
+				Schema:      openai.F[any](format.Schema),
+				Strict:      openai.Bool(true),
+			}),
+		}))
+	}
+
+	run, err := b.client.Beta.Threads.Runs.New(ctx, thread, params, opts...)
+
+	var apiErr *openai.Error
+	if format != nil && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+		b.log.Warn().Str("message", apiErr.Message).Msg("Structured outputs rejected, retrying without")
+		run, err = b.client.Beta.Threads.Runs.New(ctx, thread, params)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create new run")
+	}
+
+	runID := run.ID
+	if run, err = b.awaitRun(ctx, thread, run); err != nil {
+		// The abandoned run used tokens until it was cancelled.
+		if cancelled := b.cancelRun(thread, runID); cancelled != nil {
+			b.record(ctx, Usage{Model: model, PromptTokens: cancelled.Usage.PromptTokens, CompletionTokens: cancelled.Usage.CompletionTokens})
 		}
 
-		response.WriteString(content.Text.Value)
-		if len(citations) > 0 {
-			response.WriteByte('\n')
-			response.WriteByte('\n')
-			response.WriteString("---")
-			response.WriteByte('\n')
-			response.WriteString(strings.Join(citations, "\n"))
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, &RunError{Status: openai.RunStatusCancelled, Timeout: b.config.RunTimeout}
 		}
+		return nil, err
+	}
 
-		return response.String(), nil
-	} else {
-		err = errors.New("run not completed")
+	b.record(ctx, Usage{Model: model, PromptTokens: run.Usage.PromptTokens, CompletionTokens: run.Usage.CompletionTokens})
+
+	if run.Status != openai.RunStatusCompleted {
 		b.log.Error().Str("status", string(run.Status)).Str("data", run.JSON.RawJSON()).Msg("Run not completed")
-		return "", err
+		return nil, newRunError(run)
+	}
+
+	// TODO: include steps and files consulted
+	// steps, err = b.client.Beta.Threads.Runs.Steps.List(ctx, thread, run.ID, openai.BetaThreadRunStepListParams{
+	// 	Include: openai.F([]openai.RunStepInclude{openai.RunStepIncludeStepDetailsToolCallsFileSearchResultsContent}),
+	// })
+	messages, err := b.client.Beta.Threads.Messages.List(ctx, thread, openai.BetaThreadMessageListParams{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list messages")
+	}
+
+	// The first message is the response
+	if len(messages.Data) == 0 || len(messages.Data[0].Content) == 0 {
+		return nil, errors.New("run completed without a response")
 	}
+
+	content := messages.Data[0].Content[0].Text
+	return &content, nil
 }
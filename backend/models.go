@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"context"
+	"sync"
+)
+
+// Tasks are the kinds of prompts that can be routed to their own model with Config.Models. Prompts without a task
+// use Config.Model.
+const (
+	TaskQuestion     = "question"
+	TaskSummary      = "summary"
+	TaskTweetSummary = "tweet_summary"
+	TaskLongSummary  = "long_summary"
+	TaskCompare      = "compare"
+	TaskResearch     = "research"
+)
+
+// Tasks lists the tasks that can be routed.
+var Tasks = []string{TaskQuestion, TaskSummary, TaskTweetSummary, TaskLongSummary, TaskCompare, TaskResearch}
+
+// modelChoice is the model requested for the runs and completions of a context, and the one they used.
+type modelChoice struct {
+	model string
+
+	mu   sync.Mutex
+	used string
+}
+
+type modelKey struct{}
+
+// WithModel returns a context whose runs and completions use the given model instead of the default one. Meters can
+// still replace it, e.g. once a budget is spent.
+func WithModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelKey{}, &modelChoice{model: model})
+}
+
+// UsedModel returns the model the last run or completion of a context returned by WithModel used, or an empty
+// string if there was none.
+func UsedModel(ctx context.Context) string {
+	choice, ok := ctx.Value(modelKey{}).(*modelChoice)
+	if !ok {
+		return ""
+	}
+
+	choice.mu.Lock()
+	defer choice.mu.Unlock()
+	return choice.used
+}
+
+// TaskModel returns the model of a task.
+func (c *Config) TaskModel(task string) string {
+	if model, ok := c.Models[task]; ok && model != "" {
+		return model
+	}
+
+	return c.Model
+}
+
+// TaskModel returns the model of a task.
+func (b *Backend) TaskModel(task string) string {
+	return b.config.TaskModel(task)
+}
+
+// UserModels returns the models users can select instead of the model of a task.
+func (b *Backend) UserModels() []string {
+	return b.config.UserModels
+}
+
+// model returns the model of a run or completion on behalf of the context: the model requested with WithModel or the
+// default one, unless the meter replaces it.
+func (b *Backend) model(ctx context.Context) (string, error) {
+	choice, _ := ctx.Value(modelKey{}).(*modelChoice)
+
+	model := b.config.Model
+	if choice != nil && choice.model != "" {
+		model = choice.model
+	}
+
+	if b.meter != nil {
+		var err error
+		if model, err = b.meter.Model(ctx, model); err != nil {
+			return "", err
+		}
+	}
+
+	if choice != nil {
+		choice.mu.Lock()
+		choice.used = model
+		choice.mu.Unlock()
+	}
+
+	return model, nil
+}
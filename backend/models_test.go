@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type brokeKey struct{}
+
+// downgradeMeter replaces every model with a cheap one, and refuses runs of users without a budget.
+type downgradeMeter struct{}
+
+func (downgradeMeter) Model(ctx context.Context, model string) (string, error) {
+	if ctx.Value(brokeKey{}) != nil {
+		return "", errors.New("budget exceeded")
+	}
+
+	return "gpt-4o-mini", nil
+}
+
+func (downgradeMeter) Record(ctx context.Context, usage Usage) {}
+
+func TestModel(t *testing.T) {
+	b := &Backend{config: DefaultConfig()}
+
+	if model := b.TaskModel(TaskLongSummary); model != "gpt-4o" {
+		t.Errorf("unexpected model of long summaries: %s", model)
+	}
+	if model := b.TaskModel(TaskQuestion); model != b.config.Model {
+		t.Errorf("tasks without a model should use the default one, got %s", model)
+	}
+
+	if model, _ := b.model(context.Background()); model != b.config.Model {
+		t.Errorf("expected the default model, got %s", model)
+	}
+
+	ctx := WithModel(context.Background(), "gpt-4o")
+	if UsedModel(ctx) != "" {
+		t.Error("no model was used yet")
+	}
+	if model, _ := b.model(ctx); model != "gpt-4o" || UsedModel(ctx) != "gpt-4o" {
+		t.Errorf("expected the requested model, got %s (used %s)", model, UsedModel(ctx))
+	}
+
+	// Meters have the last word, and the footer shows the model that was actually used.
+	b.SetMeter(downgradeMeter{})
+	if model, _ := b.model(ctx); model != "gpt-4o-mini" || UsedModel(ctx) != "gpt-4o-mini" {
+		t.Errorf("expected the meter's model, got %s (used %s)", model, UsedModel(ctx))
+	}
+
+	if _, err := b.model(context.WithValue(ctx, brokeKey{}, true)); err == nil {
+		t.Error("expected the meter's error")
+	}
+}
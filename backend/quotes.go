@@ -0,0 +1,255 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mempirate/scholar/store"
+)
+
+const (
+	// QuotesFlag marks unverified quotes in responses.
+	QuotesFlag = "flag"
+	// QuotesRemove removes unverified quotes from responses.
+	QuotesRemove = "remove"
+)
+
+const (
+	// QUOTE_MATCH_THRESHOLD is the fraction of a quote's word trigrams that must appear in the cited document
+	// for the quote to be verified. It tolerates small differences, like changed punctuation or an elided word.
+	QUOTE_MATCH_THRESHOLD = 0.8
+	// QUOTE_NGRAM is the number of words quotes are matched by.
+	QUOTE_NGRAM = 3
+
+	// QUOTE_VERIFIED and QUOTE_UNVERIFIED are appended to quotes that were and weren't found in the cited documents.
+	QUOTE_VERIFIED   = " ✓"
+	QUOTE_UNVERIFIED = " _(not found in the cited document)_"
+)
+
+// citationMarkerRegex matches the citation markers of a response, e.g. " [1]".
+var citationMarkerRegex = regexp.MustCompile(` ?\[(\d+)\]`)
+
+// quote is a block quote of a response.
+type quote struct {
+	// start and end are the indexes of the first and last line of the block.
+	start, end int
+	// text is the quoted text, without the ">" prefixes and citation markers.
+	text string
+	// citations are the numbers of the citations in the block, or in the line introducing it.
+	citations []int
+}
+
+// quoteStats counts the verified quotes of responses.
+type quoteStats struct {
+	verified, total int
+}
+
+// Accuracy returns the fraction of verified quotes, or 1 if there were none.
+func (s quoteStats) Accuracy() float64 {
+	if s.total == 0 {
+		return 1
+	}
+
+	return float64(s.verified) / float64(s.total)
+}
+
+// extractQuotes returns the block quotes of a response, with the citations that refer to them. citations maps the
+// numbers of the response's citations to their files; other numbers in brackets are part of the quoted text.
+func extractQuotes(lines []string, citations map[int]string) []quote {
+	var quotes []quote
+	for i := 0; i < len(lines); i++ {
+		if !isQuoteLine(lines[i]) {
+			continue
+		}
+
+		q := quote{start: i}
+		var text []string
+		for ; i < len(lines) && isQuoteLine(lines[i]); i++ {
+			line := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+			line, numbers := stripCitations(line, citations)
+			text = append(text, strings.TrimSpace(line))
+			q.citations = append(q.citations, numbers...)
+		}
+		q.end = i - 1
+		q.text = strings.Join(text, " ")
+
+		// Quotes are usually introduced by the sentence that cites them.
+		if len(q.citations) == 0 && q.start > 0 {
+			_, q.citations = stripCitations(lines[q.start-1], citations)
+		}
+
+		quotes = append(quotes, q)
+	}
+
+	return quotes
+}
+
+func isQuoteLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), ">")
+}
+
+// stripCitations removes the citation markers from a line, and returns the numbers of the citations.
+func stripCitations(line string, citations map[int]string) (string, []int) {
+	var numbers []int
+	line = citationMarkerRegex.ReplaceAllStringFunc(line, func(marker string) string {
+		n, _ := strconv.Atoi(strings.Trim(marker, " []"))
+		if _, ok := citations[n]; !ok {
+			return marker
+		}
+
+		numbers = append(numbers, n)
+		return ""
+	})
+
+	return line, numbers
+}
+
+// quoteWords returns the lowercase words of a text, ignoring punctuation and markdown formatting.
+func quoteWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// ngrams returns the word n-grams of a text. Texts shorter than n words have a single n-gram.
+func ngrams(words []string, n int) []string {
+	if len(words) <= n {
+		return []string{strings.Join(words, " ")}
+	}
+
+	grams := make([]string, 0, len(words)-n+1)
+	for i := 0; i+n <= len(words); i++ {
+		grams = append(grams, strings.Join(words[i:i+n], " "))
+	}
+
+	return grams
+}
+
+// matchQuote returns the fraction of the quote's word n-grams that appear in the document.
+func matchQuote(quote string, document string) float64 {
+	words := quoteWords(quote)
+	if len(words) == 0 {
+		return 1
+	}
+
+	// Padding with spaces makes n-grams match whole words only.
+	haystack := " " + strings.Join(quoteWords(document), " ") + " "
+
+	grams := ngrams(words, QUOTE_NGRAM)
+	found := 0
+	for _, gram := range grams {
+		if strings.Contains(haystack, " "+gram+" ") {
+			found++
+		}
+	}
+
+	return float64(found) / float64(len(grams))
+}
+
+// verifyQuotes checks the block quotes of a response against the markdown of the files it cites. Verified quotes are
+// marked, and unverified quotes are flagged or removed, depending on mode. Quotes that can't be attributed to a
+// file (the response cites nothing) are left as they are, and not counted. read returns the markdown of a file.
+func verifyQuotes(text string, citations map[int]string, read func(file string) (string, error), mode string) (string, quoteStats) {
+	var stats quoteStats
+
+	lines := strings.Split(text, "\n")
+	quotes := extractQuotes(lines, citations)
+	if len(quotes) == 0 {
+		return text, stats
+	}
+
+	documents := make(map[string]string)
+	document := func(file string) string {
+		if markdown, ok := documents[file]; ok {
+			return markdown
+		}
+
+		markdown, _ := read(file)
+		documents[file] = markdown
+		return markdown
+	}
+
+	// Quotes without a citation of their own are checked against every cited file.
+	var all []int
+	for n := range citations {
+		all = append(all, n)
+	}
+
+	removed := make(map[int]bool)
+	for _, q := range quotes {
+		candidates := q.citations
+		if len(candidates) == 0 {
+			candidates = all
+		}
+
+		if len(candidates) == 0 {
+			continue
+		}
+
+		verified := false
+		for _, n := range candidates {
+			if matchQuote(q.text, document(citations[n])) >= QUOTE_MATCH_THRESHOLD {
+				verified = true
+				break
+			}
+		}
+
+		stats.total++
+		switch {
+		case verified:
+			stats.verified++
+			lines[q.end] += QUOTE_VERIFIED
+		case mode == QuotesRemove:
+			for i := q.start; i <= q.end; i++ {
+				removed[i] = true
+			}
+		default:
+			lines[q.end] += QUOTE_UNVERIFIED
+		}
+	}
+
+	kept := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if !removed[i] {
+			kept = append(kept, line)
+		}
+	}
+
+	return strings.Join(kept, "\n"), stats
+}
+
+// readLocal returns the markdown of a file of the local store. Files are uploaded with their local name, and a
+// thread can cite the public documents and the documents of its scope.
+func (b *Backend) readLocal(scope store.Scope, file string) (string, error) {
+	stores := []store.LocalStore{b.localStore}
+	if !scope.IsPublic() {
+		stores = append(stores, b.localStore.Scoped(scope))
+	}
+
+	var markdown strings.Builder
+	for _, s := range stores {
+		f, err := s.Get(file)
+		if err != nil {
+			continue
+		}
+
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+
+		markdown.Write(content)
+		markdown.WriteByte('\n')
+	}
+
+	if markdown.Len() == 0 {
+		return "", fmt.Errorf("file %s not found in the local store", file)
+	}
+
+	return markdown.String(), nil
+}
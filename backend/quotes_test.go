@@ -0,0 +1,152 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+
+	"github.com/mempirate/scholar/store"
+)
+
+const fastPay = `---
+title: FastPay
+---
+# FastPay: High-Performance Byzantine Fault Tolerant Settlement
+
+FastPay allows a set of distributed authorities, some of which are Byzantine, to maintain a high-integrity
+and availability settlement system for pre-funded retail payments.
+`
+
+func readFiles(files map[string]string) func(string) (string, error) {
+	return func(file string) (string, error) {
+		if markdown, ok := files[file]; ok {
+			return markdown, nil
+		}
+
+		return "", errors.New("not found")
+	}
+}
+
+func TestMatchQuote(t *testing.T) {
+	tests := []struct {
+		quote    string
+		expected bool
+	}{
+		{"FastPay allows a set of distributed authorities, some of which are Byzantine", true},
+		// Case, punctuation, line breaks and markdown don't matter.
+		{"*some of which are byzantine,* to maintain a high-integrity and availability settlement system", true},
+		// A few words that differ still match.
+		{"to maintain a high-integrity and availability settlement system for pre-funded retail payments and transfers", true},
+		{"FastPay allows a set of authorities to settle payments instantly", false},
+		{"Byzantine", true},
+		{"Byzant", false},
+	}
+
+	for _, test := range tests {
+		if score := matchQuote(test.quote, fastPay); (score >= QUOTE_MATCH_THRESHOLD) != test.expected {
+			t.Errorf("%q: unexpected score %.2f", test.quote, score)
+		}
+	}
+}
+
+func TestVerifyQuotes(t *testing.T) {
+	read := readFiles(map[string]string{"FastPay.md": fastPay, "Bitcoin.md": "# Bitcoin\n\nA purely peer-to-peer version of electronic cash.\n"})
+	citations := map[int]string{1: "FastPay.md", 2: "Bitcoin.md"}
+
+	response := strings.Join([]string{
+		"FastPay is a settlement system [1]:",
+		"> FastPay allows a set of distributed authorities,",
+		"> some of which are Byzantine",
+		"",
+		"> Bitcoin settles payments in a second [2]",
+		"",
+		"> A purely peer-to-peer version of electronic cash [2]",
+	}, "\n")
+
+	flagged, stats := verifyQuotes(response, citations, read, QuotesFlag)
+	if stats.verified != 2 || stats.total != 3 {
+		t.Errorf("expected 2 of 3 quotes verified, got %+v", stats)
+	}
+
+	for _, want := range []string{
+		"> some of which are Byzantine" + QUOTE_VERIFIED,
+		"> Bitcoin settles payments in a second [2]" + QUOTE_UNVERIFIED,
+		"> A purely peer-to-peer version of electronic cash [2]" + QUOTE_VERIFIED,
+	} {
+		if !strings.Contains(flagged, want) {
+			t.Errorf("expected %q in:\n%s", want, flagged)
+		}
+	}
+
+	removed, _ := verifyQuotes(response, citations, read, QuotesRemove)
+	if strings.Contains(removed, "Bitcoin settles") || !strings.Contains(removed, "electronic cash") {
+		t.Errorf("expected the unverified quote to be removed:\n%s", removed)
+	}
+
+	// Without citations, quotes can't be verified and are left alone.
+	if text, stats := verifyQuotes("> Some quote [3]", nil, read, QuotesRemove); text != "> Some quote [3]" || stats.total != 0 {
+		t.Errorf("expected the quote to be left alone, got %q (%+v)", text, stats)
+	}
+}
+
+func TestQuoteAccuracy(t *testing.T) {
+	if accuracy := (quoteStats{}).Accuracy(); accuracy != 1 {
+		t.Errorf("expected an accuracy of 1 without quotes, got %f", accuracy)
+	}
+
+	if accuracy := (quoteStats{verified: 3, total: 4}).Accuracy(); accuracy != 0.75 {
+		t.Errorf("expected an accuracy of 0.75, got %f", accuracy)
+	}
+}
+
+func TestPromptQuoteScope(t *testing.T) {
+	routes := runRoutes(respond(`{"id":"run_1","status":"completed"}`))
+	routes["POST /threads"] = respond(`{"id":"thread_2","object":"thread"}`)
+	routes["GET /files/{file}"] = respond(`{"id":"file_1","object":"file","filename":"Notes.md"}`)
+	routes["GET /threads/{thread}/messages"] = respond(`{"object":"list","data":[{"id":"msg_2","object":"thread.message","role":"assistant",` +
+		`"content":[{"type":"text","text":{"value":"> The launch is on Friday【4:0†source】","annotations":[` +
+		`{"type":"file_citation","text":"【4:0†source】","file_citation":{"file_id":"file_1"},"start_index":25,"end_index":37}]}}]}]}`)
+	b := newTestBackend(t, testConfig(), newFakeOpenAI(t, routes))
+
+	// Files of other conversations can have the same name as the cited file.
+	for scope, content := range map[store.Scope]string{
+		store.ChannelScope("C1"): "# Notes\n\nThe launch is on Friday.\n",
+		store.ChannelScope("C2"): "# Notes\n\nThe launch was postponed.\n",
+	} {
+		if err := b.localStore.Scoped(scope).Store("Notes.md", strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.scopedStores[store.ChannelScope("C2").Key()] = &openai.VectorStore{ID: "vs_2"}
+	if err := b.CreateThread(context.Background(), "t2", store.ChannelScope("C2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The quote is only in a file of another channel, which the thread can't search.
+	response, err := b.Prompt(context.Background(), "t2", "Be brief.", "When is the launch?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "> The launch is on Friday [1]" + QUOTE_UNVERIFIED + "\n\n---\n[1] Notes.md"; response != want {
+		t.Errorf("expected %q, got %q", want, response)
+	}
+
+	b.scopedStores[store.ChannelScope("C1").Key()] = &openai.VectorStore{ID: "vs_1"}
+	if err := b.CreateThread(context.Background(), "t3", store.ChannelScope("C1")); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err = b.Prompt(context.Background(), "t3", "Be brief.", "When is the launch?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "> The launch is on Friday [1]" + QUOTE_VERIFIED + "\n\n---\n[1] Notes.md"; response != want {
+		t.Errorf("expected %q, got %q", want, response)
+	}
+}
@@ -0,0 +1,211 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// retrier retries failed requests to the API with jittered exponential backoff, and bounds every attempt with a
+// timeout. It replaces the client's own retries, which sleep without watching the context and ignore Retry-After
+// delays of a minute or more.
+type retrier struct {
+	log zerolog.Logger
+	// maxRetries is the number of times a request is retried.
+	maxRetries int
+	// timeout bounds every attempt of a request.
+	timeout time.Duration
+	// delay is the delay before the first retry. It doubles with every retry, up to maxDelay.
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+func newRetrier(log zerolog.Logger, config Config) *retrier {
+	return &retrier{
+		log:        log,
+		maxRetries: config.MaxRetries,
+		timeout:    config.RequestTimeout,
+		delay:      config.RetryDelay,
+		maxDelay:   config.MaxRetryDelay,
+	}
+}
+
+// Middleware is the retrier as a client middleware.
+func (r *retrier) Middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		res, err := r.attempt(req, next)
+		if ctx.Err() != nil {
+			if res != nil {
+				res.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+
+		delay, retry := r.retryDelay(res, err, attempt)
+		// Requests with a body that can't be read again (e.g. streamed uploads) can't be retried.
+		if !retry || (req.Body != nil && req.GetBody == nil) {
+			return res, err
+		}
+
+		event := r.log.Warn().Str("method", req.Method).Str("path", req.URL.Path).Int("attempt", attempt+1).Dur("delay", delay)
+		if res != nil {
+			event = event.Int("status", res.StatusCode)
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		} else {
+			event = event.Err(err)
+		}
+		event.Msg("OpenAI request failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// attempt sends the request once, bounded by the attempt timeout. The timeout also covers reading the response body.
+func (r *retrier) attempt(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if r.timeout <= 0 {
+		return next(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), r.timeout)
+	res, err := next(req.WithContext(ctx))
+	if err != nil || res == nil {
+		cancel()
+		return res, err
+	}
+
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// retryDelay returns how long to wait before retrying a request that failed with the response or error, and false
+// if it shouldn't be retried. Connection errors, timeouts, conflicts, rate limits and server errors are retried.
+func (r *retrier) retryDelay(res *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= r.maxRetries {
+		return 0, false
+	}
+
+	if err == nil {
+		switch res.Header.Get("x-should-retry") {
+		case "true":
+		case "false":
+			return 0, false
+		default:
+			if res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusConflict &&
+				res.StatusCode != http.StatusTooManyRequests && res.StatusCode < http.StatusInternalServerError {
+				return 0, false
+			}
+		}
+
+		// Running out of quota is also reported as a rate limit, but waiting doesn't help.
+		if res.StatusCode == http.StatusTooManyRequests && errorCode(res) == "insufficient_quota" {
+			return 0, false
+		}
+
+		// The API's own delay is honored, unless it's longer than waiting is worth.
+		if delay, ok := retryAfter(res); ok {
+			return delay, delay <= r.maxDelay
+		}
+	}
+
+	delay := min(r.delay<<attempt, r.maxDelay)
+	// Jitter spreads the retries of concurrent requests, e.g. uploads, so they don't hit the rate limit together.
+	return delay/2 + rand.N(delay/2+1), true
+}
+
+// retryAfter returns the delay the response asks for with the Retry-After-Ms or Retry-After headers.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(res.Header.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+
+	value := res.Header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+
+	return 0, false
+}
+
+// errorCode returns the code of an error response, e.g. "insufficient_quota". The body is kept for the client.
+func errorCode(res *http.Response) string {
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	return apiErrorCode(body)
+}
+
+// apiErrorCode returns the code of an error response body. The client doesn't parse it.
+func apiErrorCode(body []byte) string {
+	var response struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return ""
+	}
+
+	return response.Error.Code
+}
+
+// cancelBody cancels the context of an attempt once its response is read.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// IsTransient returns true if an error of the backend may go away when retried later, e.g. timeouts, rate limits and
+// server errors, as opposed to invalid requests or API keys.
+func IsTransient(err error) bool {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusRequestTimeout || apiErr.StatusCode == http.StatusConflict ||
+			(apiErr.StatusCode == http.StatusTooManyRequests && apiErrorCode([]byte(apiErr.JSON.RawJSON())) != "insufficient_quota") ||
+			apiErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	// Without a response, the API couldn't be reached in time.
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const modelRoute = "GET /models/{model}"
+
+// failTimes returns a handler that fails the first n requests with the handler, and responds with a model afterwards.
+func failTimes(n int, failure http.HandlerFunc) http.HandlerFunc {
+	var requests int
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requests++; requests <= n {
+			failure(w, r)
+			return
+		}
+		w.Write([]byte(`{"id":"gpt-4o","object":"model"}`))
+	}
+}
+
+func TestRetry(t *testing.T) {
+	rateLimited := func(retryAfter string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", retryAfter)
+			fail(http.StatusTooManyRequests, "rate_limit_exceeded")(w, r)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		calls   int
+		// delay is the minimum time the request takes.
+		delay     time.Duration
+		err       bool
+		transient bool
+	}{
+		{name: "rate limit", handler: failTimes(1, rateLimited("0.05")), calls: 2, delay: 50 * time.Millisecond},
+		{name: "server error", handler: failTimes(2, fail(http.StatusInternalServerError, "server_error")), calls: 3},
+		{
+			name: "timeout",
+			handler: failTimes(1, func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			}),
+			calls: 2,
+			delay: 50 * time.Millisecond,
+		},
+		{
+			name:    "quota",
+			handler: fail(http.StatusTooManyRequests, "insufficient_quota"),
+			calls:   1,
+			err:     true,
+		},
+		{name: "long retry after", handler: rateLimited("60"), calls: 1, err: true, transient: true},
+		{name: "bad request", handler: fail(http.StatusBadRequest, "invalid_request"), calls: 1, err: true},
+		{
+			name:      "retries exhausted",
+			handler:   fail(http.StatusServiceUnavailable, "server_error"),
+			calls:     3,
+			err:       true,
+			transient: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeOpenAI(t, map[string]http.HandlerFunc{modelRoute: test.handler})
+
+			config := testConfig()
+			config.MaxRetries = 2
+			config.RequestTimeout = 50 * time.Millisecond
+			b := newTestBackend(t, config, fake)
+
+			start := time.Now()
+			_, err := b.client.Models.Get(context.Background(), "gpt-4o")
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err != nil && IsTransient(err) != test.transient {
+				t.Errorf("expected transient %t for %v", test.transient, err)
+			}
+
+			if calls := fake.Calls(modelRoute); calls != test.calls {
+				t.Errorf("expected %d requests, got %d", test.calls, calls)
+			}
+
+			if elapsed := time.Since(start); elapsed < test.delay {
+				t.Errorf("expected the request to take at least %s, took %s", test.delay, elapsed)
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	fake := newFakeOpenAI(t, map[string]http.HandlerFunc{modelRoute: fail(http.StatusInternalServerError, "server_error")})
+
+	config := testConfig()
+	config.RetryDelay = time.Minute
+	config.MaxRetryDelay = time.Minute
+	b := newTestBackend(t, config, fake)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Backoff stops as soon as the request is abandoned.
+	start := time.Now()
+	if _, err := b.client.Models.Get(ctx, "gpt-4o"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the request took %s to be abandoned", elapsed)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	r := &retrier{log: zerolog.Nop(), maxRetries: 10, delay: 100 * time.Millisecond, maxDelay: time.Second}
+
+	for attempt := range 10 {
+		delay, retry := r.retryDelay(nil, errors.New("connection reset"), attempt)
+		if !retry {
+			t.Fatalf("attempt %d wasn't retried", attempt)
+		}
+
+		// Delays double up to the maximum, and are jittered down to half.
+		expected := min(r.delay<<attempt, r.maxDelay)
+		if delay < expected/2 || delay > expected {
+			t.Errorf("attempt %d: delay %s outside [%s, %s]", attempt, delay, expected/2, expected)
+		}
+	}
+
+	if _, retry := r.retryDelay(nil, errors.New("connection reset"), 10); retry {
+		t.Error("retried after the last attempt")
+	}
+}
@@ -0,0 +1,157 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/pkg/errors"
+)
+
+// CANCEL_TIMEOUT bounds the cancellation of an abandoned run.
+const CANCEL_TIMEOUT = 10 * time.Second
+
+// RunError is the error of a run that ended without completing, or that was cancelled because it took longer than
+// the run timeout. UserMessage describes it for users.
+type RunError struct {
+	Status openai.RunStatus
+	// Reason is why an incomplete run stopped, or the code of the error of a failed run.
+	Reason string
+	// Message describes the error of a failed run.
+	Message string
+	// Timeout is set if the run was cancelled because it took longer.
+	Timeout time.Duration
+}
+
+func newRunError(run *openai.Run) *RunError {
+	switch run.Status {
+	case openai.RunStatusIncomplete:
+		return &RunError{Status: run.Status, Reason: string(run.IncompleteDetails.Reason)}
+	case openai.RunStatusFailed:
+		return &RunError{Status: run.Status, Reason: string(run.LastError.Code), Message: run.LastError.Message}
+	default:
+		return &RunError{Status: run.Status}
+	}
+}
+
+func (e *RunError) Error() string {
+	switch {
+	case e.Timeout > 0:
+		return fmt.Sprintf("run cancelled after %s", e.Timeout)
+	case e.Message != "":
+		return fmt.Sprintf("run %s: %s: %s", e.Status, e.Reason, e.Message)
+	case e.Reason != "":
+		return fmt.Sprintf("run %s: %s", e.Status, e.Reason)
+	}
+
+	return fmt.Sprintf("run %s", e.Status)
+}
+
+// UserMessage describes the error for users, with what they can do about it.
+func (e *RunError) UserMessage() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("The answer took longer than %s and was cancelled. Please try again.", e.Timeout)
+	}
+
+	switch e.Status {
+	case openai.RunStatusIncomplete:
+		switch openai.RunIncompleteDetailsReason(e.Reason) {
+		case openai.RunIncompleteDetailsReasonMaxCompletionTokens:
+			return "The answer was cut off because it got too long. Try asking for less, e.g. a shorter summary."
+		case openai.RunIncompleteDetailsReasonMaxPromptTokens:
+			return "The question needs more context than Scholar can read at once. Try a narrower question or fewer documents."
+		}
+		return "The answer is incomplete. Please try again."
+	case openai.RunStatusFailed:
+		switch openai.RunLastErrorCode(e.Reason) {
+		case openai.RunLastErrorCodeRateLimitExceeded:
+			return "OpenAI is rate limiting Scholar right now. Please try again in a minute."
+		case openai.RunLastErrorCodeServerError:
+			return "OpenAI failed to answer. Please try again."
+		case openai.RunLastErrorCodeInvalidPrompt:
+			return fmt.Sprintf("OpenAI rejected the prompt: %s", e.Message)
+		}
+		return fmt.Sprintf("The answer failed: %s", e.Message)
+	case openai.RunStatusExpired:
+		return "The answer took too long and expired. Please try again."
+	case openai.RunStatusCancelled:
+		return "The answer was cancelled."
+	}
+
+	return fmt.Sprintf("The answer ended unexpectedly (%s).", e.Status)
+}
+
+// awaitRun polls a run until it ends, and calls the tools it requires on the way. It returns the ended run.
+func (b *Backend) awaitRun(ctx context.Context, threadID string, run *openai.Run) (*openai.Run, error) {
+	run, err := b.pollRun(ctx, threadID, run.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to poll run")
+	}
+
+	// Runs that call functions wait for their outputs, and continue with them.
+	for round := 0; run.Status == openai.RunStatusRequiresAction; round++ {
+		if round == MAX_TOOL_ROUNDS {
+			return nil, errors.Errorf("run called tools more than %d times", MAX_TOOL_ROUNDS)
+		}
+
+		outputs := b.callTools(ctx, run.RequiredAction.SubmitToolOutputs.ToolCalls)
+		if _, err := b.client.Beta.Threads.Runs.SubmitToolOutputs(ctx, threadID, run.ID, openai.BetaThreadRunSubmitToolOutputsParams{
+			ToolOutputs: openai.F(outputs),
+		}); err != nil {
+			return nil, errors.Wrap(err, "failed to submit tool outputs")
+		}
+
+		if run, err = b.pollRun(ctx, threadID, run.ID); err != nil {
+			return nil, errors.Wrap(err, "failed to poll run")
+		}
+	}
+
+	return run, nil
+}
+
+// pollRun polls a run until it ends or requires action. Unlike the client's polling, it stops as soon as the context
+// is done, and waits for runs that are being cancelled.
+func (b *Backend) pollRun(ctx context.Context, threadID, runID string) (*openai.Run, error) {
+	for {
+		run, err := b.client.Beta.Threads.Runs.Get(ctx, threadID, runID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch run.Status {
+		case openai.RunStatusQueued, openai.RunStatusInProgress, openai.RunStatusCancelling:
+		default:
+			return run, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(b.config.PollInterval):
+		}
+	}
+}
+
+// cancelRun cancels a run that was abandoned, e.g. because it timed out. Otherwise it would keep using tokens, and
+// the thread would refuse new messages until the run expires. It returns the ended run, with the tokens it used until
+// then, or nil if it couldn't be retrieved.
+func (b *Backend) cancelRun(threadID, runID string) *openai.Run {
+	// The context of the run may be done already.
+	ctx, cancel := context.WithTimeout(context.Background(), CANCEL_TIMEOUT)
+	defer cancel()
+
+	if _, err := b.client.Beta.Threads.Runs.Cancel(ctx, threadID, runID); err != nil {
+		b.log.Warn().Err(err).Str("run_id", runID).Msg("Failed to cancel run")
+	}
+
+	// Runs that ended before they were cancelled can't be cancelled anymore, but still used tokens.
+	run, err := b.pollRun(ctx, threadID, runID)
+	if err != nil {
+		b.log.Warn().Err(err).Str("run_id", runID).Msg("Failed to retrieve cancelled run")
+		return nil
+	}
+
+	b.log.Info().Str("run_id", runID).Str("status", string(run.Status)).Msg("Run cancelled")
+	return run
+}
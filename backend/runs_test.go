@@ -0,0 +1,282 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+
+	"github.com/mempirate/scholar/store"
+)
+
+// fakeOpenAI is a fake of the OpenAI API. Routes are http.ServeMux patterns, e.g. "GET /threads/{thread}/runs/{run}",
+// and the requests of every route are counted.
+type fakeOpenAI struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newFakeOpenAI(t *testing.T, routes map[string]http.HandlerFunc) *fakeOpenAI {
+	f := &fakeOpenAI{calls: make(map[string]int)}
+
+	mux := http.NewServeMux()
+	for pattern, handler := range routes {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			f.mu.Lock()
+			f.calls[pattern]++
+			f.mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			handler(w, r)
+		})
+	}
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Calls returns the number of requests of a route.
+func (f *fakeOpenAI) Calls(pattern string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[pattern]
+}
+
+// respond returns a handler that responds with the JSON body.
+func respond(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}
+}
+
+// fail returns a handler that responds with an API error.
+func fail(status int, code string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":{"message":"failed","type":"error","code":"` + code + `"}}`))
+	}
+}
+
+// testConfig are settings that keep tests fast.
+func testConfig() Config {
+	config := DefaultConfig()
+	config.PollInterval = time.Millisecond
+	config.RequestTimeout = 500 * time.Millisecond
+	config.RetryDelay = time.Millisecond
+	config.MaxRetryDelay = 100 * time.Millisecond
+	config.RunTimeout = time.Second
+	return config
+}
+
+// newTestBackend returns a backend of the fake API, with an initialized assistant and vector store, and a thread "t1".
+func newTestBackend(t *testing.T, config Config, fake *fakeOpenAI) *Backend {
+	b, err := NewBackend("sk-test", config, store.NewFileStore(t.TempDir()), "", option.WithBaseURL(fake.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	b.assistant = &openai.Assistant{ID: "asst_1"}
+	b.store = &openai.VectorStore{ID: "vs_1"}
+	if err := b.threadCache.Put("t1", "thread_1"); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// runRoutes are the routes of a run whose status is polled from status. Once the run is cancelled, it reports the
+// tokens it used.
+func runRoutes(status http.HandlerFunc) map[string]http.HandlerFunc {
+	var cancelled atomic.Bool
+
+	return map[string]http.HandlerFunc{
+		"POST /threads/{thread}/messages": respond(`{"id":"msg_1","object":"thread.message"}`),
+		"POST /threads/{thread}/runs":     respond(`{"id":"run_1","object":"thread.run","status":"queued"}`),
+		"GET /threads/{thread}/runs/{run}": func(w http.ResponseWriter, r *http.Request) {
+			if cancelled.Load() {
+				w.Write([]byte(`{"id":"run_1","status":"cancelled","usage":{"prompt_tokens":100,"completion_tokens":10,"total_tokens":110}}`))
+				return
+			}
+			status(w, r)
+		},
+		"POST /threads/{thread}/runs/{run}/cancel": func(w http.ResponseWriter, r *http.Request) {
+			cancelled.Store(true)
+			w.Write([]byte(`{"id":"run_1","object":"thread.run","status":"cancelling"}`))
+		},
+		"GET /threads/{thread}/messages": respond(`{"object":"list","data":[{"id":"msg_2","object":"thread.message","role":"assistant",` +
+			`"content":[{"type":"text","text":{"value":"Hello","annotations":[]}}]}]}`),
+	}
+}
+
+func TestRunStatuses(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    string
+		reason string
+		err    string
+	}{
+		{name: "completed", run: `{"id":"run_1","status":"completed"}`},
+		{
+			name:   "incomplete",
+			run:    `{"id":"run_1","status":"incomplete","incomplete_details":{"reason":"max_completion_tokens"}}`,
+			reason: "max_completion_tokens",
+			err:    "cut off",
+		},
+		{
+			name:   "failed",
+			run:    `{"id":"run_1","status":"failed","last_error":{"code":"rate_limit_exceeded","message":"Rate limit reached"}}`,
+			reason: "rate_limit_exceeded",
+			err:    "rate limiting",
+		},
+		{name: "expired", run: `{"id":"run_1","status":"expired"}`, err: "expired"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Runs are polled until they end.
+			var polls int
+			fake := newFakeOpenAI(t, runRoutes(func(w http.ResponseWriter, r *http.Request) {
+				if polls++; polls < 3 {
+					w.Write([]byte(`{"id":"run_1","status":"in_progress"}`))
+					return
+				}
+				w.Write([]byte(test.run))
+			}))
+			b := newTestBackend(t, testConfig(), fake)
+
+			response, err := b.Prompt(context.Background(), "t1", "Be brief.", "Hi")
+			if test.err == "" {
+				if err != nil || response != "Hello" {
+					t.Fatalf("unexpected response %q (%v)", response, err)
+				}
+				return
+			}
+
+			var runErr *RunError
+			if !errors.As(err, &runErr) || runErr.Reason != test.reason || !strings.Contains(runErr.UserMessage(), test.err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if polls != 3 {
+				t.Errorf("expected 3 polls, got %d", polls)
+			}
+
+			// Runs that ended aren't cancelled.
+			if calls := fake.Calls("POST /threads/{thread}/runs/{run}/cancel"); calls != 0 {
+				t.Errorf("ended run was cancelled %d times", calls)
+			}
+		})
+	}
+}
+
+func TestPromptAnnotations(t *testing.T) {
+	routes := runRoutes(respond(`{"id":"run_1","status":"completed"}`))
+	routes["GET /threads/{thread}/messages"] = respond(`{"object":"list","data":[{"id":"msg_2","object":"thread.message","role":"assistant",` +
+		`"content":[{"type":"text","text":{"value":"Hello【4:0†source】","annotations":[` +
+		`{"type":"file_path","text":"【4:0†source】","file_path":{"file_id":"file_1"},"start_index":5,"end_index":17}]}}]}]}`)
+	b := newTestBackend(t, testConfig(), newFakeOpenAI(t, routes))
+
+	// Annotations that aren't file citations are dropped.
+	response, err := b.Prompt(context.Background(), "t1", "Be brief.", "Hi")
+	if err != nil || response != "Hello" {
+		t.Fatalf("unexpected response %q (%v)", response, err)
+	}
+}
+
+// recordingMeter records the usage of the backend.
+type recordingMeter struct {
+	mu    sync.Mutex
+	usage []Usage
+}
+
+func (m *recordingMeter) Model(ctx context.Context, model string) (string, error) {
+	return model, nil
+}
+
+func (m *recordingMeter) Record(ctx context.Context, usage Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage = append(m.usage, usage)
+}
+
+func TestRunTimeout(t *testing.T) {
+	fake := newFakeOpenAI(t, runRoutes(respond(`{"id":"run_1","status":"in_progress"}`)))
+
+	config := testConfig()
+	config.RunTimeout = 50 * time.Millisecond
+	b := newTestBackend(t, config, fake)
+
+	meter := &recordingMeter{}
+	b.SetMeter(meter)
+
+	start := time.Now()
+	_, err := b.Prompt(context.Background(), "t1", "Be brief.", "Hi")
+
+	var runErr *RunError
+	if !errors.As(err, &runErr) || runErr.Timeout != config.RunTimeout || !strings.Contains(runErr.UserMessage(), "took longer") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the run took %s to time out", elapsed)
+	}
+
+	// The abandoned run is cancelled, so the thread accepts new messages.
+	if calls := fake.Calls("POST /threads/{thread}/runs/{run}/cancel"); calls != 1 {
+		t.Errorf("expected the run to be cancelled once, got %d", calls)
+	}
+
+	// The tokens it used until then are still accounted for.
+	if len(meter.usage) != 1 || meter.usage[0].PromptTokens != 100 || meter.usage[0].CompletionTokens != 10 {
+		t.Errorf("expected the usage of the cancelled run to be recorded, got %+v", meter.usage)
+	}
+}
+
+func TestRunAbandoned(t *testing.T) {
+	fake := newFakeOpenAI(t, runRoutes(respond(`{"id":"run_1","status":"queued"}`)))
+	b := newTestBackend(t, testConfig(), fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	if _, err := b.Prompt(ctx, "t1", "Be brief.", "Hi"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the prompt to be cancelled, got %v", err)
+	}
+
+	if calls := fake.Calls("POST /threads/{thread}/runs/{run}/cancel"); calls != 1 {
+		t.Errorf("expected the run to be cancelled once, got %d", calls)
+	}
+}
+
+func TestUploadFailed(t *testing.T) {
+	fake := newFakeOpenAI(t, map[string]http.HandlerFunc{
+		"POST /files":                                respond(`{"id":"file_1","object":"file","filename":"empty.md"}`),
+		"POST /vector_stores/{store}/files":          respond(`{"id":"file_1","object":"vector_store.file","status":"in_progress"}`),
+		"GET /vector_stores/{store}/files/{file}":    respond(`{"id":"file_1","object":"vector_store.file","status":"failed","last_error":{"code":"invalid_file","message":"The file is empty."}}`),
+		"DELETE /vector_stores/{store}/files/{file}": respond(`{"id":"file_1","deleted":true}`),
+		"DELETE /files/{file}":                       respond(`{"id":"file_1","deleted":true}`),
+	})
+	b := newTestBackend(t, testConfig(), fake)
+
+	err := b.UploadFile(context.Background(), store.PublicScope, "empty.md", strings.NewReader(""))
+	if err == nil || !strings.Contains(err.Error(), "The file is empty.") {
+		t.Fatalf("expected the processing error, got %v", err)
+	}
+
+	// Unprocessed files are removed, so they're uploaded again on the next sync.
+	if fake.Calls("DELETE /vector_stores/{store}/files/{file}") != 1 || fake.Calls("DELETE /files/{file}") != 1 {
+		t.Error("the unprocessed file wasn't removed")
+	}
+}
@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+	"github.com/pkg/errors"
+)
+
+// toolNameRegex matches the names the API accepts for functions.
+var toolNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ToolFunc runs a tool with the JSON arguments of a call, and returns the output for the assistant.
+type ToolFunc func(ctx context.Context, arguments json.RawMessage) (any, error)
+
+// Tool is a function the assistant can call in its runs, besides file search.
+type Tool struct {
+	// Name identifies the tool, e.g. "list_documents".
+	Name string
+	// Description tells the assistant what the tool does, and when to use it.
+	Description string
+	// Parameters is the JSON schema of the arguments. It is an empty object schema if nil.
+	Parameters map[string]any
+	Call       ToolFunc
+}
+
+// Tools is a registry of the tools of an assistant. Tools must be registered before the backend is initialized,
+// since they're part of the assistant's settings.
+type Tools struct {
+	mu    sync.RWMutex
+	tools []Tool
+}
+
+// NewTools creates an empty registry.
+func NewTools() *Tools {
+	return &Tools{}
+}
+
+// Register adds a tool to the registry.
+func (t *Tools) Register(tool Tool) error {
+	if !toolNameRegex.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q", tool.Name)
+	}
+
+	if tool.Call == nil {
+		return fmt.Errorf("tool %s has no function", tool.Name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, existing := range t.tools {
+		if existing.Name == tool.Name {
+			return fmt.Errorf("tool %s is already registered", tool.Name)
+		}
+	}
+
+	t.tools = append(t.tools, tool)
+	return nil
+}
+
+// Get returns the tool with the given name.
+func (t *Tools) Get(name string) (Tool, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, tool := range t.tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+
+	return Tool{}, false
+}
+
+// List returns the registered tools, in the order they were registered.
+func (t *Tools) List() []Tool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return append([]Tool(nil), t.tools...)
+}
+
+// toolError is the output of a tool call that failed. The assistant gets the error instead of the run failing,
+// so it can tell the user or try something else.
+type toolError struct {
+	Error string `json:"error"`
+}
+
+// Call calls the named tool with the JSON arguments, and returns its output as JSON. Errors are returned as an
+// output with an "error" field.
+func (t *Tools) Call(ctx context.Context, name, arguments string) string {
+	var output any
+
+	tool, ok := t.Get(name)
+	if !ok {
+		output = toolError{Error: fmt.Sprintf("unknown tool %s", name)}
+	} else {
+		if arguments == "" {
+			arguments = "{}"
+		}
+
+		result, err := tool.Call(ctx, json.RawMessage(arguments))
+		if err != nil {
+			output = toolError{Error: err.Error()}
+		} else {
+			output = result
+		}
+	}
+
+	encoded, err := json.Marshal(output)
+	if err != nil {
+		encoded, _ = json.Marshal(toolError{Error: errors.Wrap(err, "invalid output").Error()})
+	}
+
+	return string(encoded)
+}
+
+// params returns the function tools of the assistant.
+func (t *Tools) params() []openai.AssistantToolUnionParam {
+	tools := t.List()
+	params := make([]openai.AssistantToolUnionParam, 0, len(tools))
+	for _, tool := range tools {
+		parameters := tool.Parameters
+		if parameters == nil {
+			parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+
+		params = append(params, openai.FunctionToolParam{
+			Type: openai.F(openai.FunctionToolTypeFunction),
+			Function: openai.F(shared.FunctionDefinitionParam{
+				Name:        openai.String(tool.Name),
+				Description: openai.String(tool.Description),
+				Parameters:  openai.F(shared.FunctionParameters(parameters)),
+			}),
+		})
+	}
+
+	return params
+}
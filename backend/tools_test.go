@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestToolsRegister(t *testing.T) {
+	tools := NewTools()
+	call := func(ctx context.Context, args json.RawMessage) (any, error) { return nil, nil }
+
+	if err := tools.Register(Tool{Name: "list_documents", Call: call}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tool := range []Tool{
+		{Name: "list_documents", Call: call},
+		{Name: "list documents", Call: call},
+		{Name: "", Call: call},
+		{Name: "get_current_date"},
+	} {
+		if err := tools.Register(tool); err == nil {
+			t.Errorf("expected an error registering %+v", tool)
+		}
+	}
+
+	if params := tools.params(); len(params) != 1 {
+		t.Errorf("expected 1 function tool, got %d", len(params))
+	}
+}
+
+func TestToolsCall(t *testing.T) {
+	tools := NewTools()
+	tools.Register(Tool{Name: "echo", Call: func(ctx context.Context, args json.RawMessage) (any, error) {
+		var p struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(args, &p); err != nil {
+			return nil, err
+		}
+
+		return map[string]string{"text": p.Text}, nil
+	}})
+	tools.Register(Tool{Name: "fail", Call: func(ctx context.Context, args json.RawMessage) (any, error) {
+		return nil, errors.New("no such document")
+	}})
+
+	tests := []struct {
+		name      string
+		arguments string
+		expected  string
+	}{
+		{"echo", `{"text": "hello"}`, `{"text":"hello"}`},
+		{"echo", "", `{"text":""}`},
+		{"echo", "not json", `{"error":"invalid character 'o' in literal null (expecting 'u')"}`},
+		{"fail", "{}", `{"error":"no such document"}`},
+		{"missing", "{}", `{"error":"unknown tool missing"}`},
+	}
+
+	for _, test := range tests {
+		if output := tools.Call(context.Background(), test.name, test.arguments); output != test.expected {
+			t.Errorf("%s(%s): expected %s, got %s", test.name, test.arguments, test.expected, output)
+		}
+	}
+}
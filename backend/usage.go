@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"context"
+)
+
+// Usage is what a request to the API used.
+type Usage struct {
+	// Model is the model of runs and completions. Empty for uploads.
+	Model            string
+	PromptTokens     int64
+	CompletionTokens int64
+	// StorageBytes is the size of an uploaded file in the vector store.
+	StorageBytes int64
+}
+
+// Meter accounts for the usage of the backend, and can limit it. Requests are made on behalf of their context,
+// e.g. the user and channel of a conversation.
+type Meter interface {
+	// Model returns the model a run or completion on behalf of the context should use instead of model, or an
+	// error if it isn't allowed at all.
+	Model(ctx context.Context, model string) (string, error)
+	// Record records what a request on behalf of the context used.
+	Record(ctx context.Context, usage Usage)
+}
+
+// SetMeter sets the meter of the backend's usage. Without a meter, usage isn't recorded or limited.
+func (b *Backend) SetMeter(meter Meter) {
+	b.meter = meter
+}
+
+// record records the usage of a request on behalf of the context.
+func (b *Backend) record(ctx context.Context, usage Usage) {
+	if b.meter == nil || usage == (Usage{}) {
+		return
+	}
+
+	b.meter.Record(ctx, usage)
+}
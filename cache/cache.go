@@ -84,3 +84,12 @@ func (c *BoltCache) Len() int {
 func (c *BoltCache) Close() error {
 	return c.db.Close()
 }
+
+// ForEach calls fn for every key-value pair in the cache, in key order. If fn returns an error, iteration stops.
+func (c *BoltCache) ForEach(fn func(key, value string) error) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BUCKET_NAME)).ForEach(func(k, v []byte) error {
+			return fn(string(k), string(v))
+		})
+	})
+}
@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	SEEN_BUCKET_NAME  = "seen"
+	ORDER_BUCKET_NAME = "order"
+)
+
+// TTLCache is a persistent, bounded set of keys. Keys expire after the TTL, and the oldest keys
+// are evicted when the cache holds more than maxEntries keys.
+type TTLCache struct {
+	db *bolt.DB
+
+	ttl        time.Duration
+	maxEntries int
+
+	now func() time.Time
+}
+
+// NewTTLCache creates a new TTLCache instance with the given path.
+// It is up to the caller to close the database when it is no longer needed.
+func NewTTLCache(path string, ttl time.Duration, maxEntries int) (*TTLCache, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	// "seen" maps keys to their insertion time, "order" indexes keys by insertion time for eviction.
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(SEEN_BUCKET_NAME)); err != nil {
+			return err
+		}
+
+		_, err := tx.CreateBucketIfNotExists([]byte(ORDER_BUCKET_NAME))
+		return err
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create buckets")
+	}
+
+	return &TTLCache{
+		db:         db,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}, nil
+}
+
+// Contains returns true if the key was added less than TTL ago.
+func (c *TTLCache) Contains(key string) bool {
+	var exists bool
+	c.db.View(func(tx *bolt.Tx) error {
+		exists = c.contains(tx.Bucket([]byte(SEEN_BUCKET_NAME)), key)
+		return nil
+	})
+
+	return exists
+}
+
+// Put adds the key to the cache, and evicts expired keys and keys over the size limit.
+func (c *TTLCache) Put(key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		seen := tx.Bucket([]byte(SEEN_BUCKET_NAME))
+		order := tx.Bucket([]byte(ORDER_BUCKET_NAME))
+
+		if err := c.put(seen, order, key); err != nil {
+			return err
+		}
+
+		return c.evict(seen, order)
+	})
+}
+
+// Add adds the keys to the cache unless any of them is contained already, in a single transaction, so concurrent
+// callers can't both add the same key. It returns false if a key was contained, in which case nothing is added.
+func (c *TTLCache) Add(keys ...string) (bool, error) {
+	var added bool
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		seen := tx.Bucket([]byte(SEEN_BUCKET_NAME))
+		order := tx.Bucket([]byte(ORDER_BUCKET_NAME))
+
+		for _, key := range keys {
+			if c.contains(seen, key) {
+				return nil
+			}
+		}
+
+		for _, key := range keys {
+			if err := c.put(seen, order, key); err != nil {
+				return err
+			}
+		}
+
+		added = true
+		return c.evict(seen, order)
+	})
+
+	return added, err
+}
+
+// Remove removes the keys from the cache, e.g. to handle a key again after failing to handle it.
+func (c *TTLCache) Remove(keys ...string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		seen := tx.Bucket([]byte(SEEN_BUCKET_NAME))
+		order := tx.Bucket([]byte(ORDER_BUCKET_NAME))
+
+		count := seen.Sequence()
+		for _, key := range keys {
+			prev := seen.Get([]byte(key))
+			if prev == nil {
+				continue
+			}
+
+			if err := order.Delete(orderKey(prev, key)); err != nil {
+				return err
+			}
+
+			if err := seen.Delete([]byte(key)); err != nil {
+				return err
+			}
+
+			count--
+		}
+
+		return seen.SetSequence(count)
+	})
+}
+
+// contains returns true if the key was added less than TTL ago.
+func (c *TTLCache) contains(seen *bolt.Bucket, key string) bool {
+	val := seen.Get([]byte(key))
+	if len(val) != 8 {
+		return false
+	}
+
+	added := time.Unix(0, int64(binary.BigEndian.Uint64(val)))
+	return c.now().Sub(added) < c.ttl
+}
+
+// put adds or refreshes the key.
+func (c *TTLCache) put(seen, order *bolt.Bucket, key string) error {
+	// Remove the previous index entry if the key is refreshed. The bucket sequence is used as the key count.
+	if prev := seen.Get([]byte(key)); prev != nil {
+		if err := order.Delete(orderKey(prev, key)); err != nil {
+			return err
+		}
+	} else if err := seen.SetSequence(seen.Sequence() + 1); err != nil {
+		return err
+	}
+
+	now := make([]byte, 8)
+	binary.BigEndian.PutUint64(now, uint64(c.now().UnixNano()))
+
+	if err := seen.Put([]byte(key), now); err != nil {
+		return err
+	}
+
+	return order.Put(orderKey(now, key), nil)
+}
+
+// evict removes the oldest keys while they are expired or the cache is over the size limit.
+func (c *TTLCache) evict(seen, order *bolt.Bucket) error {
+	count := int(seen.Sequence())
+	cutoff := uint64(c.now().Add(-c.ttl).UnixNano())
+
+	cursor := order.Cursor()
+	for k, _ := cursor.First(); k != nil; k, _ = cursor.First() {
+		expired := binary.BigEndian.Uint64(k[:8]) < cutoff
+		if !expired && count <= c.maxEntries {
+			break
+		}
+
+		if err := seen.Delete(k[8:]); err != nil {
+			return err
+		}
+
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+
+		count--
+	}
+
+	return seen.SetSequence(uint64(count))
+}
+
+func (c *TTLCache) Len() int {
+	var count int
+	c.db.View(func(tx *bolt.Tx) error {
+		count = int(tx.Bucket([]byte(SEEN_BUCKET_NAME)).Sequence())
+		return nil
+	})
+
+	return count
+}
+
+// Close closes the database.
+func (c *TTLCache) Close() error {
+	return c.db.Close()
+}
+
+// orderKey is the key in the order bucket: the 8-byte big-endian insertion time followed by the key.
+func orderKey(ts []byte, key string) []byte {
+	return append(append(make([]byte, 0, len(ts)+len(key)), ts...), key...)
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	c, err := NewTTLCache(filepath.Join(t.TempDir(), "seen.db"), time.Hour, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if err := c.Put(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+	}
+
+	if !c.Contains("0") || c.Len() != 3 {
+		t.Fatalf("expected 3 keys, got %d", c.Len())
+	}
+
+	// Over the size limit: the oldest key is evicted
+	c.Put("3")
+	if c.Contains("0") || !c.Contains("3") || c.Len() != 3 {
+		t.Errorf("expected oldest key to be evicted")
+	}
+
+	// Refreshing a key moves it to the back of the eviction order
+	c.Put("1")
+	c.Put("4")
+	if !c.Contains("1") || c.Contains("2") {
+		t.Errorf("expected refreshed key to be kept")
+	}
+
+	// Expired keys are not contained, and are evicted on the next put
+	now = now.Add(2 * time.Hour)
+	if c.Contains("1") {
+		t.Errorf("expected key to be expired")
+	}
+
+	c.Put("5")
+	if c.Len() != 1 {
+		t.Errorf("expected expired keys to be evicted, got %d keys", c.Len())
+	}
+}
+
+func TestTTLCachePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.db")
+	c, err := NewTTLCache(path, time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put("event")
+	c.Close()
+
+	c, err = NewTTLCache(path, time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if !c.Contains("event") {
+		t.Errorf("expected key to survive a restart")
+	}
+}
+
+func TestTTLCacheAdd(t *testing.T) {
+	c, err := NewTTLCache(filepath.Join(t.TempDir(), "seen.db"), time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if added, err := c.Add("event", "message"); !added || err != nil {
+		t.Fatalf("expected keys to be added (%v)", err)
+	}
+
+	// Nothing is added if any key is contained.
+	if added, _ := c.Add("other", "message"); added || c.Contains("other") {
+		t.Errorf("expected keys not to be added")
+	}
+
+	// Removed keys can be added again.
+	if err := c.Remove("event", "message"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Len() != 0 {
+		t.Errorf("expected no keys, got %d", c.Len())
+	}
+
+	if added, _ := c.Add("message"); !added {
+		t.Errorf("expected removed key to be added")
+	}
+}
+
+func TestTTLCacheAddConcurrent(t *testing.T) {
+	c, err := NewTTLCache(filepath.Join(t.TempDir(), "seen.db"), time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Exactly one of concurrent callers adds a key.
+	var wg sync.WaitGroup
+	var added atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := c.Add("event"); ok {
+				added.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if added.Load() != 1 {
+		t.Errorf("expected the key to be added once, got %d", added.Load())
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/cache"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/store"
+)
+
+const ReplyPrivateLibrary = "This library is private."
+
+// caller is a client of the API or the MCP server, and the library it can access.
+type caller struct {
+	// name identifies the caller in logs.
+	name string
+	// key is the API key the caller authenticated with. Nil for local MCP clients.
+	key   *access.APIKey
+	team  string
+	scope store.Scope
+	// user optionally ties the caller to a chat user, whose access policy then also applies.
+	user string
+}
+
+// newKeyCaller returns the caller authenticated with the given API key.
+func newKeyCaller(key *access.APIKey) (*caller, error) {
+	scope, err := store.ParseScope(key.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &caller{name: key.Name, key: key, team: key.Team, scope: scope, user: key.User}, nil
+}
+
+// authorize returns a *access.DeniedError if the caller isn't allowed to perform the action. Like in chat, the
+// user's access policy applies in the channel of a channel library, and private libraries are only accessible
+// to their owner.
+func (c *caller) authorize(authorizer *access.Authorizer, action access.Action) error {
+	if c.key != nil {
+		if err := c.key.Authorize(action); err != nil {
+			return err
+		}
+	}
+
+	if c.user == "" {
+		return nil
+	}
+
+	var channelID string
+	switch c.scope.Visibility {
+	case document.VisibilityChannel:
+		channelID = c.scope.ID
+	case document.VisibilityPrivate:
+		if c.scope.ID != c.user {
+			return &access.DeniedError{Reason: ReplyPrivateLibrary}
+		}
+	}
+
+	return authorizer.Authorize(c.user, channelID, action)
+}
+
+// owner identifies the caller as the owner of the conversations it starts. API keys are identified by a hash of their
+// secret, since names can change, and local MCP clients by their name.
+func (c *caller) owner() string {
+	if c.key == nil {
+		return "local:" + c.name
+	}
+
+	sum := sha256.Sum256([]byte(c.key.Key))
+	return "key:" + hex.EncodeToString(sum[:])
+}
+
+// newCallerThreadID returns an unguessable ID for a conversation of a caller, e.g. "api-" followed by 32 hex characters.
+func newCallerThreadID(prefix string) string {
+	b := make([]byte, 16)
+	// Read never fails, it crashes the program instead.
+	rand.Read(b)
+	return prefix + "-" + hex.EncodeToString(b)
+}
+
+// threadOwner is the caller that started a conversation, and the library it was started with.
+type threadOwner struct {
+	Owner string      `json:"owner"`
+	Scope store.Scope `json:"scope"`
+}
+
+// threadOwnerStore records the owner of each conversation started by a caller, so that only the caller can follow up.
+type threadOwnerStore struct {
+	cache *cache.BoltCache
+}
+
+func newThreadOwnerStore(path string) (*threadOwnerStore, error) {
+	cache, err := cache.NewBoltCache(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &threadOwnerStore{cache: cache}, nil
+}
+
+// Put records the caller as the owner of a new thread.
+func (s *threadOwnerStore) Put(threadID string, c *caller) error {
+	value, err := json.Marshal(threadOwner{Owner: c.owner(), Scope: c.scope})
+	if err != nil {
+		return err
+	}
+
+	return s.cache.Put(threadID, string(value))
+}
+
+// Owns returns true if the caller started the thread with its current library.
+func (s *threadOwnerStore) Owns(threadID string, c *caller) bool {
+	value, ok := s.cache.Get(threadID)
+	if !ok {
+		return false
+	}
+
+	var owner threadOwner
+	if err := json.Unmarshal([]byte(value), &owner); err != nil {
+		return false
+	}
+
+	return owner.Owner == c.owner() && owner.Scope == c.scope
+}
+
+// Close closes the database.
+func (s *threadOwnerStore) Close() error {
+	return s.cache.Close()
+}
@@ -0,0 +1,313 @@
+// Package chat defines the interface between Scholar and the chat platforms it runs on.
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+)
+
+// https://stackoverflow.com/a/3809435 + Claude
+const URL_REGEX = `https?:\/\/(www\.)?[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}([-a-zA-Z0-9()@:%_\+.~#?&//=]*)`
+
+var urlRegex = regexp.MustCompile(URL_REGEX)
+
+const (
+	ReplyMissingURL     = "There doesn't seem to be a URL in your message."
+	ReplyInvalidURL     = "The URL you provided is invalid. Please provide a valid URL."
+	ReplyWorking        = "Working on it..."
+	ReplyBusy           = "Scholar is busy right now. Please try again in a minute."
+	ReplyUnknownCommand = "Unknown command."
+	// ReplyRegenerating acknowledges a click on a summary's "Regenerate" button.
+	ReplyRegenerating = "Regenerating the summary..."
+	// ReplyCompareURLs rejects a /compare with less than two links.
+	ReplyCompareURLs = "Please provide at least two links to compare."
+	// ReplyMissingQuestion rejects a /research without a question.
+	ReplyMissingQuestion = "Please provide a question to research."
+)
+
+// ExtractURLs returns all valid URLs found in the text, in order of appearance and without duplicates.
+func ExtractURLs(text string) []*url.URL {
+	matches := urlRegex.FindAllString(text, -1)
+
+	seen := make(map[string]struct{}, len(matches))
+	urls := make([]*url.URL, 0, len(matches))
+	for _, match := range matches {
+		if _, ok := seen[match]; ok {
+			continue
+		}
+
+		seen[match] = struct{}{}
+
+		uri, err := url.Parse(match)
+		if err != nil {
+			continue
+		}
+
+		urls = append(urls, uri)
+	}
+
+	return urls
+}
+
+// SplitArgs splits a command's text into arguments at whitespace, except within double quotes, which are removed.
+// Curly quotes count as double quotes, since chat clients may substitute them.
+func SplitArgs(text string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			quoted = !quoted
+			inArg = true
+		case unicode.IsSpace(r) && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args
+}
+
+// SummaryFlags lists the options of /summary, for help messages.
+const SummaryFlags = "--tldr, --detailed, --eli5, --technical, --bullets, --structured, --lang=<code> and --focus=\"<topic>\""
+
+// ParseSummaryOption applies a /summary option (e.g. --tldr or --lang=de) to opts. It returns false if the argument
+// is not a summary option.
+func ParseSummaryOption(arg string, opts *prompt.SummaryOptions) (bool, error) {
+	name, value, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+
+	switch name {
+	case prompt.StyleTLDR, prompt.StyleDetailed, prompt.StyleELI5, prompt.StyleTechnical:
+		if opts.Style != prompt.StyleDefault && opts.Style != name {
+			return true, fmt.Errorf("--%s and --%s can't be combined", opts.Style, name)
+		}
+		opts.Style = name
+	case "bullets":
+		opts.Bullets = true
+	case "structured":
+		opts.Structured = true
+	case "lang":
+		if value == "" {
+			return true, errors.New("--lang requires a language code, e.g. --lang=de")
+		}
+		opts.Language = value
+	case "focus":
+		if strings.TrimSpace(value) == "" {
+			return true, errors.New(`--focus requires a topic, e.g. --focus="security"`)
+		}
+		opts.Focus = strings.TrimSpace(value)
+	default:
+		return false, nil
+	}
+
+	return true, opts.Validate()
+}
+
+// ParseModelOption parses a --model=<name> option. It returns false if the argument is not a model option.
+func ParseModelOption(arg string, model *string) (bool, error) {
+	name, value, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+	if name != "model" {
+		return false, nil
+	}
+
+	if value = strings.TrimSpace(value); value == "" {
+		return true, errors.New("--model requires a model name, e.g. --model=gpt-4o")
+	}
+
+	*model = value
+	return true, nil
+}
+
+// SplitMessage splits the text into chunks of at most n characters, preferring to split between lines.
+func SplitMessage(text string, n int) []string {
+	var chunks []string
+
+	runes := []rune(text)
+	for len(runes) > n {
+		cut := n
+		for i := n; i > n/2; i-- {
+			if runes[i] == '\n' {
+				cut = i
+				break
+			}
+		}
+
+		chunks = append(chunks, string(runes[:cut]))
+		runes = runes[cut:]
+		if len(runes) > 0 && runes[0] == '\n' {
+			runes = runes[1:]
+		}
+	}
+
+	return append(chunks, string(runes))
+}
+
+// SEARCH_HISTORY_LIMIT is the number of recent messages of a channel SearchMessages looks through.
+const SEARCH_HISTORY_LIMIT = 100
+
+// MatchMessages returns the first limit messages that contain every word of the query, ignoring case.
+func MatchMessages(messages []Message, query string, limit int) []Message {
+	words := strings.Fields(strings.ToLower(query))
+
+	var matches []Message
+	for _, msg := range messages {
+		if len(matches) == limit {
+			break
+		}
+
+		text := strings.ToLower(msg.Text)
+		match := true
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			matches = append(matches, msg)
+		}
+	}
+
+	return matches
+}
+
+type CommandType = string
+
+const (
+	UploadCommand    CommandType = "/upload"
+	SummarizeCommand CommandType = "/summary"
+	// PersonaCommand shows the persona of the channel, or switches it to the persona named in the command's text.
+	PersonaCommand CommandType = "/persona"
+	// CompareCommand adds two or more documents to the library and compares them in a new thread.
+	CompareCommand CommandType = "/compare"
+	// ResearchCommand researches the question in the command's text with the library and the web, in a new thread.
+	ResearchCommand CommandType = "/research"
+	// UsageCommand reports the token usage and costs of the workspace, and the budgets of the user and the channel.
+	UsageCommand CommandType = "/usage"
+)
+
+// Command represents a processed command from a chat frontend.
+type Command struct {
+	CommandType CommandType
+	// TeamID is the workspace (Slack) or server (Discord) the command was invoked in.
+	TeamID    string
+	UserID    string
+	ChannelID string
+	URLs      []*url.URL
+	// Text is the argument of commands that don't take URLs, e.g. the persona name of /persona or the question
+	// of /research.
+	Text string
+	// Visibility of the uploaded documents, set with the --private or --channel options. Defaults to public.
+	Visibility document.Visibility
+	// Summary are the options of /summary, e.g. --tldr or --lang=de.
+	Summary prompt.SummaryOptions
+	// Model is the model selected with --model for the commands that prompt the assistant. Empty for the model of
+	// the task.
+	Model string
+	// ResponseURL can be used to post ephemeral progress updates and results for the command. On Slack this
+	// is the response URL, which can be used 5 times within 30 minutes. On Discord it is the interaction token.
+	ResponseURL string
+}
+
+type EventType = string
+
+const (
+	MessageEvent EventType = "message"
+	MentionEvent EventType = "mention"
+	// ReactionEvent is emitted when a user reacts to a message with the ingest reaction.
+	ReactionEvent EventType = "reaction"
+	// ShortcutEvent is emitted when a user invokes the "Save to Scholar" message shortcut.
+	ShortcutEvent EventType = "shortcut"
+	// RegenerateEvent is emitted when a user clicks the "Regenerate" button of the summary in a thread.
+	RegenerateEvent EventType = "regenerate"
+)
+
+// Event represents a processed event from a chat frontend.
+type Event struct {
+	Type      EventType
+	TeamID    string
+	UserID    string
+	ChannelID string
+	ThreadID  string
+	Text      string
+	// URLs contains the links found in the (target) message. Set for mention, reaction and shortcut events.
+	URLs []*url.URL
+}
+
+// Frontend is a chat platform Scholar receives commands and events from, and replies on.
+// Replies to a conversation go in a thread, identified by the ThreadID of its event.
+type Frontend interface {
+	// Name identifies the frontend in logs, e.g. "slack".
+	Name() string
+	// Start connects to the platform and starts receiving commands and events. It may block.
+	Start()
+
+	// SubscribeCommands returns a channel that yields incoming commands.
+	SubscribeCommands() chan Command
+	// SubscribeEvents returns a channel that yields incoming events.
+	SubscribeEvents() chan Event
+
+	// Respond posts a response to a command that is only visible to the user that invoked it, replacing
+	// the previous response.
+	Respond(cmd Command, text string) error
+	// MaxResponses is the number of times a command can be responded to, or 0 if there is no limit.
+	MaxResponses() int
+	// PostEphemeral posts a message only the given user can see.
+	PostEphemeral(teamID, channelID, userID, text string) error
+	// PostMessage posts a message to a channel, in the given thread if threadID is set.
+	PostMessage(teamID, channelID string, threadID *string, text string) error
+	// PostSummary posts a summary in a thread, with a footer and a "Regenerate" button that emits a RegenerateEvent.
+	PostSummary(teamID, channelID, threadID, text, footer string) error
+	// StartUploadThread starts a new thread in the given channel with the given text, and returns the thread ID.
+	StartUploadThread(teamID, channelID, userID, text string) (string, error)
+
+	// OpenDM returns the ID of the direct message channel between Scholar and the given user.
+	OpenDM(teamID, userID string) (string, error)
+	// IsDM returns true if the channel is a direct message with Scholar.
+	IsDM(channelID string) bool
+
+	// ChannelName returns the name of a channel, or an empty string if it can't be resolved (e.g. direct messages).
+	ChannelName(teamID, channelID string) string
+	// UserProfile returns the profile of a user. Only the ID is set if the profile can't be fetched.
+	UserProfile(teamID, userID string) User
+	// SearchMessages returns up to limit of the recent messages of a channel that contain every word of the
+	// query, newest first.
+	SearchMessages(teamID, channelID, query string, limit int) ([]Message, error)
+}
+
+// Message is a message posted in a channel.
+type Message struct {
+	ID     string
+	UserID string
+	Text   string
+	Time   time.Time
+}
+
+// User is the profile of a chat user.
+type User struct {
+	ID   string
+	Name string
+	// Title is the user's job title, if the platform has one.
+	Title string
+}
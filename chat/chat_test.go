@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mempirate/scholar/prompt"
+)
+
+func TestSplitMessage(t *testing.T) {
+	text := strings.Repeat("a", 30) + "\n" + strings.Repeat("b", 30) + "\n" + strings.Repeat("c", 50)
+
+	chunks := SplitMessage(text, 40)
+	if len(chunks) != 4 || chunks[0] != strings.Repeat("a", 30) || chunks[1] != strings.Repeat("b", 30) {
+		t.Errorf("unexpected chunks: %q", chunks)
+	}
+
+	for _, chunk := range chunks {
+		if len(chunk) > 40 {
+			t.Errorf("chunk too long: %d", len(chunk))
+		}
+	}
+
+	if chunks := SplitMessage("short", 40); len(chunks) != 1 || chunks[0] != "short" {
+		t.Errorf("unexpected chunks: %q", chunks)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	args := SplitArgs(`--focus="fee market" --lang=de  https://example.com --focus=“MEV”`)
+	expected := []string{"--focus=fee market", "--lang=de", "https://example.com", "--focus=MEV"}
+
+	if strings.Join(args, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected args: %q", args)
+	}
+}
+
+func TestParseSummaryOption(t *testing.T) {
+	var opts prompt.SummaryOptions
+	for _, arg := range []string{"--technical", "--bullets", "--lang=es", `--focus=proof of stake`} {
+		if ok, err := ParseSummaryOption(arg, &opts); !ok || err != nil {
+			t.Errorf("%s: %v, %v", arg, ok, err)
+		}
+	}
+
+	if opts.String() != "technical, bullets, lang=es, focus: proof of stake" {
+		t.Errorf("unexpected options: %s", opts)
+	}
+
+	if ok, _ := ParseSummaryOption("--private", &opts); ok {
+		t.Error("--private isn't a summary option")
+	}
+
+	for _, arg := range []string{"--tldr", "--lang=", "--lang=123", "--focus= ", "--structured"} {
+		if _, err := ParseSummaryOption(arg, &opts); err == nil {
+			t.Errorf("%s: expected an error", arg)
+		}
+	}
+}
+
+func TestMatchMessages(t *testing.T) {
+	messages := []Message{
+		{ID: "3", Text: "The FastPay paper is out"},
+		{ID: "2", Text: "Has anyone read the fastpay benchmarks?"},
+		{ID: "1", Text: "Lunch at noon"},
+		{ID: "0", Text: "FastPay benchmarks are in section 6"},
+	}
+
+	matches := MatchMessages(messages, "fastpay BENCHMARKS", 1)
+	if len(matches) != 1 || matches[0].ID != "2" {
+		t.Errorf("expected message 2, got %+v", matches)
+	}
+
+	if matches := MatchMessages(messages, "fastpay", 10); len(matches) != 3 {
+		t.Errorf("expected 3 messages, got %+v", matches)
+	}
+
+	if matches := MatchMessages(messages, "", 10); len(matches) != 4 {
+		t.Errorf("expected an empty query to match every message, got %+v", matches)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/content"
+	"github.com/mempirate/scholar/log"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/scrape"
+	"github.com/mempirate/scholar/store"
+)
+
+var (
+	jsonOutput = flag.Bool("json", false, "Print JSON instead of markdown (CLI commands).")
+	scopeKey   = flag.String("scope", "", "Library to use, e.g. \"channel-C012AB3CD\" or \"private-U012AB3CD\". Empty is the public library (CLI commands).")
+	teamID     = flag.String("team", "", "Workspace or server whose library to use. Empty is the default workspace (CLI commands).")
+	mcpUser    = flag.String("user", "", "Chat user whose access policy applies to MCP clients, e.g. \"U012AB3CD\" (mcp command).")
+)
+
+// errUsage is returned by commands that are called with the wrong arguments.
+var errUsage = errors.New("usage")
+
+// cliCommand is a scholar subcommand. run gets the positional arguments and writes its output to the CLI's output.
+type cliCommand struct {
+	usage string
+	help  string
+	run   func(c *cli, ctx context.Context, args []string) error
+}
+
+var cliCommands = map[string]cliCommand{
+	"ingest":    {usage: "ingest <url|file>...", help: "Add documents to the library. Local markdown and text files are supported.", run: (*cli).ingest},
+	"summarize": {usage: "summarize <url>...", help: "Add documents to the library and summarize them.", run: (*cli).summarize},
+	"ask":       {usage: "ask \"<question>\"", help: "Ask a question about the library.", run: (*cli).ask},
+	"ls":        {usage: "ls", help: "List the documents in the library.", run: (*cli).ls},
+	"rm":        {usage: "rm <file>...", help: "Remove documents from the library.", run: (*cli).rm},
+	"sync":      {usage: "sync", help: "Upload local documents that are missing from the vector store.", run: (*cli).sync},
+	"mcp":       {usage: "mcp", help: "Serve the library to MCP clients over stdio.", run: (*cli).mcp},
+}
+
+// usage prints the subcommands and flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: scholar [serve] [flags]\n       scholar <command> [flags] [args]\n\nCommands:\n")
+	fmt.Fprintf(out, "  %-24s %s\n", "serve", "Run the chat bots (default).")
+	for _, name := range []string{"ingest", "summarize", "ask", "ls", "rm", "sync", "mcp"} {
+		fmt.Fprintf(out, "  %-24s %s\n", cliCommands[name].usage, cliCommands[name].help)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// parseArgs parses the flags in args and returns the positional arguments. Unlike flag.Parse, flags
+// may follow positional arguments, e.g. "scholar ls --json".
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// cli runs the scripting commands against the local library, without any chat frontend.
+type cli struct {
+	log zerolog.Logger
+	out io.Writer
+
+	dataDir string
+	scope   store.Scope
+	json    bool
+
+	tenants *tenants
+}
+
+// runCLI runs the subcommand with the given arguments.
+func runCLI(ctx context.Context, name string, args []string) error {
+	command := cliCommands[name]
+
+	// Standard output is reserved for the command's output.
+	log.SetOutput(os.Stderr)
+
+	positional, err := parseArgs(flag.CommandLine, args)
+	if err != nil {
+		return err
+	}
+
+	scope, err := store.ParseScope(*scopeKey)
+	if err != nil {
+		return err
+	}
+
+	c := &cli{
+		log:     log.NewLogger("cli"),
+		out:     os.Stdout,
+		dataDir: os.ExpandEnv(*dataDir),
+		scope:   scope,
+		json:    *jsonOutput,
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	var contentHandler urlHandler
+	if fcKey := os.Getenv("FIRECRAWL_API_KEY"); fcKey != "" {
+		fc, err := scrape.NewFirecrawlScraper(fcKey, cfg.Firecrawl)
+		if err != nil {
+			return errors.Wrap(err, "failed to create Firecrawl scraper")
+		}
+		contentHandler = content.NewContentHandler(fc)
+	}
+
+	c.tenants = newTenants(c.log, os.Getenv("OPENAI_API_KEY"), c.dataDir, cfg, contentHandler, nil, func() string { return "" })
+
+	err = command.run(c, ctx, positional)
+	if errors.Is(err, errUsage) {
+		return errors.New("usage: scholar " + command.usage)
+	}
+
+	return err
+}
+
+// tenant initializes the library selected with --team. This syncs it with the vector store.
+func (c *cli) tenant(ctx context.Context) (*tenant, error) {
+	if c.tenants.apiKey == "" {
+		return nil, errors.New("OPENAI_API_KEY is not set")
+	}
+
+	return c.tenants.Get(ctx, *teamID)
+}
+
+// ingester returns the ingester of the selected library, which requires a content handler.
+func (c *cli) ingester(ctx context.Context) (*tenant, error) {
+	if c.tenants.contentHandler == nil {
+		return nil, errors.New("FIRECRAWL_API_KEY is not set")
+	}
+
+	return c.tenant(ctx)
+}
+
+// fileStore returns the local store of the selected library, without connecting to the backend.
+func (c *cli) fileStore() store.LocalStore {
+	return c.tenants.FileStore(*teamID).Scoped(c.scope)
+}
+
+// print writes v as JSON, or the markdown otherwise.
+func (c *cli) print(v any, markdown string) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	_, err := fmt.Fprint(c.out, markdown)
+	return err
+}
+
+// parseSources parses URLs and local file paths into URLs. Files become file:// URLs.
+func parseSources(args []string) ([]*url.URL, error) {
+	urls := make([]*url.URL, 0, len(args))
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+			uri, err := url.Parse(arg)
+			if err != nil || uri.Host == "" {
+				return nil, fmt.Errorf("invalid URL: %s", arg)
+			}
+			urls = append(urls, uri)
+			continue
+		}
+
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("not a URL or file: %s", arg)
+		}
+
+		urls = append(urls, &url.URL{Scheme: "file", Path: path})
+	}
+
+	return urls, nil
+}
+
+// ingestInfo is the outcome of ingesting a URL in JSON output.
+type ingestInfo struct {
+	URL    string `json:"url"`
+	File   string `json:"file,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newIngestInfo(res ingestResult) ingestInfo {
+	info := ingestInfo{URL: res.URL.String(), File: res.FileName, Status: "ingested"}
+	if res.Doc != nil {
+		info.Title = res.Doc.FindTitle()
+	}
+
+	switch {
+	case res.Err == nil:
+	case errors.Is(res.Err, errDuplicate):
+		info.Status = "duplicate"
+	case errors.Is(res.Err, errUnsupported):
+		info.Status = "unsupported"
+	default:
+		info.Status = "failed"
+		info.Error = res.Err.Error()
+	}
+
+	return info
+}
+
+// markdownResults renders ingestion results as a markdown list.
+func markdownResults(results []ingestResult) string {
+	return strings.ReplaceAll(formatResults(results), "• ", "- ")
+}
+
+func (c *cli) ingest(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	urls, err := parseSources(args)
+	if err != nil {
+		return err
+	}
+
+	tenant, err := c.ingester(ctx)
+	if err != nil {
+		return err
+	}
+
+	results := tenant.ingester.IngestAll(ctx, urls, ingestOptions{Dedup: true, Scope: c.scope}, nil)
+
+	infos := make([]ingestInfo, len(results))
+	for i, res := range results {
+		infos[i] = newIngestInfo(res)
+	}
+
+	if err := c.print(infos, markdownResults(results)); err != nil {
+		return err
+	}
+
+	if len(succeeded(results)) == 0 {
+		return errors.New("nothing was ingested")
+	}
+
+	return nil
+}
+
+// newThreadID returns a unique ID for a CLI conversation.
+func newThreadID() string {
+	return fmt.Sprintf("cli-%d", time.Now().UnixNano())
+}
+
+func (c *cli) summarize(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	urls, err := parseSources(args)
+	if err != nil {
+		return err
+	}
+
+	tenant, err := c.ingester(ctx)
+	if err != nil {
+		return err
+	}
+
+	results := tenant.ingester.IngestAll(ctx, urls, ingestOptions{Scope: c.scope}, nil)
+	ok := succeeded(results)
+	if len(ok) == 0 {
+		fmt.Fprint(os.Stderr, markdownResults(results))
+		return errors.New("nothing was ingested")
+	}
+
+	threadID := newThreadID()
+	if err := tenant.backend.CreateThread(ctx, threadID, c.scope); err != nil {
+		return err
+	}
+
+	docs := promptDocuments(ok)
+	ctx = tenant.withModel(ctx, summaryTask(docs, false), "")
+	summary, err := tenant.prompt(ctx, threadID, prompt.SummaryInstructionsTemplate, prompt.SummaryTemplate, prompt.Data{Date: today(), ThreadID: threadID, Documents: docs})
+	if err != nil {
+		return err
+	}
+
+	infos := make([]ingestInfo, len(results))
+	for i, res := range results {
+		infos[i] = newIngestInfo(res)
+	}
+
+	return c.print(struct {
+		Documents []ingestInfo `json:"documents"`
+		Summary   string       `json:"summary"`
+	}{infos, summary}, summary+"\n")
+}
+
+func (c *cli) ask(ctx context.Context, args []string) error {
+	question := strings.Join(args, " ")
+	if strings.TrimSpace(question) == "" {
+		return errUsage
+	}
+
+	tenant, err := c.tenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	threadID := newThreadID()
+	if err := tenant.backend.CreateThread(ctx, threadID, c.scope); err != nil {
+		return err
+	}
+
+	instructions, err := tenant.assistantInstructions()
+	if err != nil {
+		return err
+	}
+
+	answer, err := tenant.backend.Prompt(tenant.withModel(ctx, backend.TaskQuestion, ""), threadID, instructions, question)
+	if err != nil {
+		return err
+	}
+
+	return c.print(struct {
+		Question string `json:"question"`
+		Answer   string `json:"answer"`
+	}{question, answer}, answer+"\n")
+}
+
+func (c *cli) ls(ctx context.Context, args []string) error {
+	docs, err := listDocuments(c.fileStore())
+	if err != nil {
+		return err
+	}
+
+	sb := strings.Builder{}
+	for _, doc := range docs {
+		title := doc.Title
+		if title == "" {
+			title = doc.File
+		}
+
+		fmt.Fprintf(&sb, "- **%s** (`%s`)", title, doc.File)
+		if doc.Source != "" {
+			fmt.Fprintf(&sb, " %s", doc.Source)
+		}
+		sb.WriteString("\n")
+	}
+
+	return c.print(docs, sb.String())
+}
+
+func (c *cli) rm(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, name := range args {
+		if !validDocumentName(name) {
+			return fmt.Errorf("invalid document name: %s", name)
+		}
+	}
+
+	fileStore := c.fileStore()
+	for _, name := range args {
+		if ok, err := fileStore.Contains(name); err != nil || !ok {
+			return fmt.Errorf("no such document: %s", name)
+		}
+	}
+
+	tenant, err := c.tenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	sb := strings.Builder{}
+	for _, name := range args {
+		if err := tenant.backend.DeleteFile(ctx, c.scope, name); err != nil {
+			return err
+		}
+
+		if err := fileStore.Remove(name); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", name)
+		}
+
+		fmt.Fprintf(&sb, "- %s: removed\n", name)
+	}
+
+	return c.print(struct {
+		Removed []string `json:"removed"`
+	}{args}, sb.String())
+}
+
+func (c *cli) sync(ctx context.Context, args []string) error {
+	// Initializing the tenant syncs all of its libraries.
+	if _, err := c.tenant(ctx); err != nil {
+		return err
+	}
+
+	docs, err := c.fileStore().List()
+	if err != nil {
+		return err
+	}
+
+	return c.print(struct {
+		Documents int `json:"documents"`
+	}{len(docs)}, fmt.Sprintf("Library synced: %d documents\n", len(docs)))
+}
+
+// mcp serves the library to a local MCP client, e.g. a coding agent, until it closes standard input.
+func (c *cli) mcp(ctx context.Context, args []string) error {
+	authorizer, err := access.NewAuthorizer(*accessConfig)
+	if err != nil {
+		return err
+	}
+
+	caller := &caller{name: "stdio", team: *teamID, scope: c.scope, user: *mcpUser}
+	return newMCPServer(authorizer, c.tenants, caller).ServeStdio(ctx, os.Stdin, c.out)
+}
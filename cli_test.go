@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/store"
+)
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "")
+	scope := fs.String("scope", "", "")
+
+	args, err := parseArgs(fs, []string{"https://example.com", "--json", "notes.md", "-scope", "private-U1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(args) != 2 || args[0] != "https://example.com" || args[1] != "notes.md" {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+
+	if !*jsonOutput || *scope != "private-U1" {
+		t.Errorf("flags not parsed: json=%v scope=%q", *jsonOutput, *scope)
+	}
+}
+
+func TestParseSources(t *testing.T) {
+	notes := filepath.Join(t.TempDir(), "notes.md")
+	if err := os.WriteFile(notes, []byte("# Notes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	urls, err := parseSources([]string{"https://example.com/paper.pdf", notes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if urls[0].Host != "example.com" || urls[1].Scheme != "file" || urls[1].Path != notes {
+		t.Errorf("unexpected URLs: %v", urls)
+	}
+
+	if _, err := parseSources([]string{"missing.md"}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestIngestInfo(t *testing.T) {
+	uri, _ := url.Parse("https://example.com")
+
+	tests := []struct {
+		err    error
+		status string
+	}{
+		{nil, "ingested"},
+		{errDuplicate, "duplicate"},
+		{errUnsupported, "unsupported"},
+		{errors.New("boom"), "failed"},
+	}
+
+	for _, test := range tests {
+		info := newIngestInfo(ingestResult{URL: uri, Err: test.err})
+		if info.Status != test.status {
+			t.Errorf("%v: expected %s, got %s", test.err, test.status, info.Status)
+		}
+	}
+}
+
+func TestListDocuments(t *testing.T) {
+	fs := store.NewFileStore(t.TempDir())
+
+	doc := &document.Document{Content: []byte("# Bitcoin\n"), Metadata: document.Metadata{Source: "https://bitcoin.org/bitcoin.pdf", Type: document.TypePDF}}
+	name, markdown, err := doc.ToMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Store(name, strings.NewReader(string(markdown))); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := listDocuments(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(docs) != 1 || docs[0].File != name || docs[0].Title != "Bitcoin" || docs[0].Type != document.TypePDF {
+		t.Errorf("unexpected documents: %+v", docs)
+	}
+}
+
+func TestRemoveInvalidName(t *testing.T) {
+	c := &cli{}
+
+	// Names are checked before the library is opened, so paths out of it are never looked up.
+	for _, name := range []string{"../config.yaml", "notes/../../config.example.yaml", "notes.txt"} {
+		err := c.rm(context.Background(), []string{"bitcoin.md", name})
+		if err == nil || !strings.Contains(err.Error(), "invalid document name") {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+}
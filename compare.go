@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/prompt"
+)
+
+// compare compares the documents along the dimensions of prompt.Comparison, and posts the comparison in the thread.
+func (a *app) compare(ctx context.Context, fe chat.Frontend, tenant *tenant, teamID, channelID, threadID, userID string, docs []prompt.Document) error {
+	data := promptData(fe, tenant, teamID, channelID, userID)
+	data.ThreadID = threadID
+	data.Documents = docs
+
+	format := backend.ResponseFormat{
+		Name:        "comparison",
+		Description: "A comparison of the files along fixed dimensions.",
+		Schema:      prompt.ComparisonSchema(),
+	}
+
+	var comparison *prompt.Comparison
+	err := tenant.promptJSON(ctx, threadID, prompt.SummaryInstructionsTemplate, prompt.CompareTemplate, data, format, func(response string) (err error) {
+		comparison, err = prompt.ParseComparison(response, documentFiles(docs))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prompt for comparison: %w", err)
+	}
+
+	if err := fe.PostMessage(teamID, channelID, &threadID, withModelFooter(ctx, comparison.Markdown(docs))); err != nil {
+		return fmt.Errorf("failed to post comparison: %w", err)
+	}
+
+	return nil
+}
+
+// uniqueDocuments removes documents that appear more than once, e.g. when two links lead to the same document.
+func uniqueDocuments(docs []prompt.Document) []prompt.Document {
+	seen := make(map[string]bool, len(docs))
+	unique := make([]prompt.Document, 0, len(docs))
+	for _, doc := range docs {
+		if !seen[doc.File] {
+			seen[doc.File] = true
+			unique = append(unique, doc)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,341 @@
+// Package config loads Scholar's settings from a YAML file, with environment variable overrides.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/scrape"
+	"github.com/mempirate/scholar/search"
+	"github.com/mempirate/scholar/slack"
+)
+
+// ENV_PREFIX is the prefix of environment variables that override settings, e.g. SCHOLAR_OPENAI_MODEL
+// overrides openai.model.
+const ENV_PREFIX = "SCHOLAR"
+
+// Config are Scholar's settings. Settings that are missing from the file keep their defaults:
+//
+//	openai:
+//	  model: gpt-4o
+//	  temperature: 0.5
+//	firecrawl:
+//	  timeout: 2m
+//	prompts:
+//	  dir: ./prompts
+//	  channels:
+//	    C012AB3CD: protocol-engineering
+type Config struct {
+	OpenAI    backend.Config         `yaml:"openai"`
+	Firecrawl scrape.FirecrawlConfig `yaml:"firecrawl"`
+	Slack     slack.Config           `yaml:"slack"`
+	Chat      ChatConfig             `yaml:"chat"`
+	Ingest    IngestConfig           `yaml:"ingest"`
+	Summary   SummaryConfig          `yaml:"summary"`
+	Research  ResearchConfig         `yaml:"research"`
+	Usage     UsageConfig            `yaml:"usage"`
+	Prompts   PromptsConfig          `yaml:"prompts"`
+}
+
+// ChatConfig are the settings of the handling of chat commands and events.
+type ChatConfig struct {
+	// Workers is the number of commands and events that are handled concurrently, across frontends.
+	Workers int `yaml:"workers"`
+}
+
+// IngestConfig are the settings of the ingestion pipeline.
+type IngestConfig struct {
+	// Concurrency is the number of URLs of a command that are ingested concurrently.
+	Concurrency int `yaml:"concurrency"`
+}
+
+// SummaryConfig are the settings of summaries of long documents. Retrieval only samples a document's chunks, so long
+// documents are summarized section by section instead, and the section summaries are combined into one.
+type SummaryConfig struct {
+	// LongDocumentLength is the length (in bytes of markdown) from which a document is summarized section by section.
+	// 0 disables section summaries.
+	LongDocumentLength int `yaml:"long_document_length"`
+	// SectionLength is the maximum length of a section.
+	SectionLength int `yaml:"section_length"`
+	// Concurrency is the number of sections summarized concurrently.
+	Concurrency int `yaml:"concurrency"`
+}
+
+// ResearchConfig are the settings of /research, which plans sub-questions, answers them from the library, and
+// searches the web for sources where the library falls short. The limits bound the cost of a single research.
+type ResearchConfig struct {
+	// Search is the web search provider: "firecrawl", or "none" to only research the library.
+	Search string `yaml:"search"`
+	// MaxQuestions is the maximum number of sub-questions a question is broken into.
+	MaxQuestions int `yaml:"max_questions"`
+	// MaxRounds is the maximum number of times a sub-question is searched on the web before it is answered as is.
+	MaxRounds int `yaml:"max_rounds"`
+	// MaxSearches is the maximum number of web searches of a research.
+	MaxSearches int `yaml:"max_searches"`
+	// ResultsPerSearch is the number of results considered for each web search.
+	ResultsPerSearch int `yaml:"results_per_search"`
+	// MaxSources is the maximum number of new sources a research adds to the library.
+	MaxSources int `yaml:"max_sources"`
+}
+
+const (
+	// BudgetRefuse refuses prompts once a budget is spent.
+	BudgetRefuse = "refuse"
+	// BudgetDowngrade switches to a cheaper model once a budget is spent.
+	BudgetDowngrade = "downgrade"
+)
+
+// UsageConfig are the settings of usage accounting: the prices costs are estimated with, and the daily budgets of
+// users and channels. Days start at midnight UTC.
+type UsageConfig struct {
+	// Prices are the prices of models, in dollars per million tokens.
+	Prices map[string]Price `yaml:"prices"`
+	// UserBudget and ChannelBudget are the number of tokens a user or a channel can use per day. 0 disables the budget.
+	UserBudget    int `yaml:"user_budget"`
+	ChannelBudget int `yaml:"channel_budget"`
+	// Exceeded is what happens once a budget is spent: "refuse" or "downgrade".
+	Exceeded string `yaml:"exceeded"`
+	// DowngradeModel is the model used once a budget is spent, if Exceeded is "downgrade".
+	DowngradeModel string `yaml:"downgrade_model"`
+}
+
+// Price is the price of a model, in dollars per million tokens.
+type Price struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// PromptsConfig selects the prompt templates and the persona of each channel.
+type PromptsConfig struct {
+	// Dir is a directory of templates that override the built-in ones (see the prompt package).
+	Dir string `yaml:"dir"`
+	// Channels maps channel IDs to the persona they start with. Other channels use the default persona.
+	Channels map[string]string `yaml:"channels"`
+	// Templates are the templates loaded from Dir.
+	Templates *prompt.Templates `yaml:"-"`
+}
+
+// Default returns the default settings.
+func Default() *Config {
+	config := &Config{
+		OpenAI:    backend.DefaultConfig(),
+		Firecrawl: scrape.DefaultFirecrawlConfig(),
+		Slack:     slack.DefaultConfig(),
+		Chat:      ChatConfig{Workers: 8},
+		Ingest:    IngestConfig{Concurrency: 4},
+		Summary:   SummaryConfig{LongDocumentLength: 60_000, SectionLength: 24_000, Concurrency: 4},
+		Research:  ResearchConfig{Search: search.ProviderFirecrawl, MaxQuestions: 4, MaxRounds: 2, MaxSearches: 6, ResultsPerSearch: 3, MaxSources: 6},
+		Usage: UsageConfig{
+			Prices: map[string]Price{
+				"gpt-4o-mini": {Prompt: 0.15, Completion: 0.60},
+				"gpt-4o":      {Prompt: 2.50, Completion: 10.00},
+			},
+			Exceeded:       BudgetRefuse,
+			DowngradeModel: "gpt-4o-mini",
+		},
+		Prompts: PromptsConfig{Templates: prompt.MustLoad("")},
+	}
+
+	config.OpenAI.Instructions = assistantInstructions(config.Prompts.Templates)
+	return config
+}
+
+// assistantInstructions renders the instructions the assistant is created with. They are rendered without a date,
+// since they outlive the day; runs override them with instructions rendered for their conversation.
+func assistantInstructions(templates *prompt.Templates) string {
+	// The templates were validated when they were loaded.
+	instructions, _ := templates.Render(prompt.AssistantTemplate, prompt.Data{})
+	return instructions
+}
+
+// Load reads the settings at path over the defaults, applies the environment overrides and validates the result.
+// If path is empty, only the defaults and the environment are used.
+func Load(path string) (*Config, error) {
+	config := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read config")
+		}
+
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, errors.Wrap(err, "failed to parse config")
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(config).Elem(), ENV_PREFIX, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if config.Prompts.Dir != "" {
+		templates, err := prompt.Load(config.Prompts.Dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load prompt templates")
+		}
+
+		config.Prompts.Templates = templates
+		config.OpenAI.Instructions = assistantInstructions(templates)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	return config, nil
+}
+
+// Validate checks every section of the settings.
+func (c *Config) Validate() error {
+	if err := c.OpenAI.Validate(); err != nil {
+		return errors.Wrap(err, "openai")
+	}
+
+	if err := c.Firecrawl.Validate(); err != nil {
+		return errors.Wrap(err, "firecrawl")
+	}
+
+	if err := c.Slack.Validate(); err != nil {
+		return errors.Wrap(err, "slack")
+	}
+
+	if c.Chat.Workers < 1 {
+		return errors.New("chat: workers must be at least 1")
+	}
+
+	if c.Ingest.Concurrency < 1 {
+		return errors.New("ingest: concurrency must be at least 1")
+	}
+
+	switch {
+	case c.Summary.LongDocumentLength < 0:
+		return errors.New("summary: long_document_length must not be negative")
+	case c.Summary.SectionLength < 1000:
+		return errors.New("summary: section_length must be at least 1000")
+	case c.Summary.Concurrency < 1:
+		return errors.New("summary: concurrency must be at least 1")
+	}
+
+	switch {
+	case c.Research.Search != search.ProviderFirecrawl && c.Research.Search != search.ProviderNone:
+		return fmt.Errorf("research: unknown search provider %q (available: %s, %s)", c.Research.Search, search.ProviderFirecrawl, search.ProviderNone)
+	case c.Research.MaxQuestions < 1 || c.Research.MaxRounds < 0:
+		return errors.New("research: max_questions must be at least 1, and max_rounds must not be negative")
+	case c.Research.MaxSearches < 0 || c.Research.MaxSources < 0:
+		return errors.New("research: max_searches and max_sources must not be negative")
+	case c.Research.ResultsPerSearch < 1:
+		return errors.New("research: results_per_search must be at least 1")
+	}
+
+	switch {
+	case c.Usage.UserBudget < 0 || c.Usage.ChannelBudget < 0:
+		return errors.New("usage: user_budget and channel_budget must not be negative")
+	case c.Usage.Exceeded != BudgetRefuse && c.Usage.Exceeded != BudgetDowngrade:
+		return fmt.Errorf("usage: exceeded must be %q or %q", BudgetRefuse, BudgetDowngrade)
+	case c.Usage.Exceeded == BudgetDowngrade && c.Usage.DowngradeModel == "":
+		return errors.New("usage: downgrade_model is required to downgrade")
+	}
+
+	for channelID, persona := range c.Prompts.Channels {
+		if !c.Prompts.Templates.HasPersona(persona) {
+			return fmt.Errorf("prompts: unknown persona %q for channel %s (available: %s)", persona, channelID, strings.Join(c.Prompts.Templates.Personas(), ", "))
+		}
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv sets the fields of v from the environment. The variable of a field is its YAML path in upper case,
+// joined with underscores. Lists are comma-separated.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(tag)
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct {
+			if err := applyEnv(value, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		env, ok := lookup(name)
+		if !ok {
+			continue
+		}
+
+		if err := setValue(value, env); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setValue(value reflect.Value, env string) error {
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(env)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(env)
+	case reflect.Int:
+		n, err := strconv.Atoi(env)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(env, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(env)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(env, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+
+	return nil
+}
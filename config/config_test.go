@@ -0,0 +1,163 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mempirate/scholar/backend"
+)
+
+// withoutTemplates drops the parsed templates, which can't be compared, from a config.
+func withoutTemplates(config *Config) *Config {
+	c := *config
+	c.Prompts.Templates = nil
+	return &c
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv("SCHOLAR_OPENAI_MAX_NUM_RESULTS", "20")
+	t.Setenv("SCHOLAR_FIRECRAWL_FORMATS", "markdown, html")
+
+	promptsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(promptsDir, "assistant.tmpl"), []byte("Be brief."), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(writeConfig(t, `
+openai:
+  model: gpt-4o
+  temperature: 0.2
+firecrawl:
+  timeout: 2m
+prompts:
+  dir: `+promptsDir+`
+  channels:
+    C1: reading-group
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.OpenAI.Model != "gpt-4o" || config.OpenAI.Temperature != 0.2 || config.OpenAI.MaxNumResults != 20 {
+		t.Errorf("unexpected openai config: %+v", config.OpenAI)
+	}
+
+	if config.Firecrawl.Timeout != 2*time.Minute || !reflect.DeepEqual(config.Firecrawl.Formats, []string{"markdown", "html"}) {
+		t.Errorf("unexpected firecrawl config: %+v", config.Firecrawl)
+	}
+
+	// Missing settings keep their defaults.
+	if config.OpenAI.MaxPromptTokens != 100_000 || !config.Prompts.Templates.HasPersona("protocol-engineering") {
+		t.Errorf("defaults were not kept: %+v", config)
+	}
+
+	if config.OpenAI.Instructions != "Be brief." {
+		t.Errorf("assistant instructions were not applied: %q", config.OpenAI.Instructions)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	config, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(withoutTemplates(config), withoutTemplates(Default())) {
+		t.Errorf("expected defaults, got %+v", config)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+	}{
+		{name: "unknown field", config: "openai:\n  modle: gpt-4o\n"},
+		{name: "temperature", config: "openai:\n  temperature: 3\n"},
+		{name: "max num results", config: "openai:\n  max_num_results: 100\n"},
+		{name: "formats", config: "firecrawl:\n  formats: [html]\n"},
+		{name: "prompts dir", config: "prompts:\n  dir: /nonexistent\n"},
+		{name: "persona", config: "prompts:\n  channels:\n    C1: pirate\n"},
+		{name: "concurrency", config: "ingest:\n  concurrency: 0\n"},
+		{name: "workers", config: "chat:\n  workers: 0\n"},
+		{name: "budget", config: "usage:\n  user_budget: -1\n"},
+		{name: "exceeded", config: "usage:\n  exceeded: warn\n"},
+		{name: "task", config: "openai:\n  models:\n    poetry: gpt-4o\n"},
+		{name: "retry delay", config: "openai:\n  retry_delay: 1m\n"},
+		{name: "env", env: map[string]string{"SCHOLAR_OPENAI_MAX_PROMPT_TOKENS": "lots"}},
+	}
+
+	for _, test := range tests {
+		for key, value := range test.env {
+			t.Setenv(key, value)
+		}
+
+		if _, err := Load(writeConfig(t, test.config)); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+
+		for key := range test.env {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	config, err := Load("../config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(withoutTemplates(config), withoutTemplates(Default())) {
+		t.Errorf("the example config doesn't match the defaults: %+v", config)
+	}
+}
+
+// TestExampleTasks checks that the example config names exactly the tasks of backend.Tasks, so the list can't drift.
+func TestExampleTasks(t *testing.T) {
+	example, err := os.ReadFile("../config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var comment []string
+	for _, line := range strings.Split(string(example), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "# Models of tasks:") {
+			comment = append(comment, strings.TrimPrefix(line, "# Models of tasks:"))
+		} else if len(comment) > 0 && strings.HasPrefix(line, "#") {
+			comment = append(comment, strings.TrimPrefix(line, "#"))
+		} else if len(comment) > 0 {
+			break
+		}
+	}
+	if len(comment) == 0 {
+		t.Fatal("the example config doesn't list the tasks")
+	}
+
+	// Drop the explanations in parentheses, then split the list on commas and its final "and".
+	list := regexp.MustCompile(`\([^)]*\)`).ReplaceAllString(strings.Join(comment, " "), "")
+	var tasks []string
+	for _, task := range regexp.MustCompile(`,| and `).Split(strings.TrimSuffix(strings.TrimSpace(list), "."), -1) {
+		tasks = append(tasks, strings.TrimSpace(task))
+	}
+
+	if !reflect.DeepEqual(tasks, backend.Tasks) {
+		t.Errorf("the example config lists the tasks %q, want %q", tasks, backend.Tasks)
+	}
+}
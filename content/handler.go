@@ -3,6 +3,8 @@ package content
 import (
 	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -30,8 +32,12 @@ func NewContentHandler(scraper *scrape.FirecrawlScraper) *ContentHandler {
 	}
 }
 
-// HandleURL downloads the content from the given URL and returns it.
+// HandleURL downloads the content from the given URL and returns it. file:// URLs are read from disk.
 func (h *ContentHandler) HandleURL(uri *url.URL) (*document.Document, error) {
+	if uri.Scheme == "file" {
+		return h.HandleFile(uri.Path)
+	}
+
 	if h.twitterRegex.MatchString(uri.String()) {
 		// Tweet
 		id, err := h.extractTweetID(uri.String())
@@ -47,6 +53,55 @@ func (h *ContentHandler) HandleURL(uri *url.URL) (*document.Document, error) {
 	}
 }
 
+// HandleFile reads a local markdown or text file and returns it. Other file types are not supported,
+// in which case nil is returned.
+func (h *ContentHandler) HandleFile(path string) (*document.Document, error) {
+	if !isTextFile(path) {
+		return nil, nil
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+
+	return NewFileDocument(filepath.Base(path), body, (&url.URL{Scheme: "file", Path: path}).String()), nil
+}
+
+// NewFileDocument returns the document of a markdown or text file with the given name and source, e.g. of an
+// upload. Other file types are not supported, in which case nil is returned.
+func NewFileDocument(name string, body []byte, source string) *document.Document {
+	if !isTextFile(name) {
+		return nil
+	}
+
+	doc := &document.Document{
+		Content: body,
+		Metadata: document.Metadata{
+			Source:        source,
+			Type:          document.TypeArticle,
+			ProcessedTime: time.Now().Format(time.RFC3339),
+		},
+	}
+
+	// Fall back to the file name for documents without a heading.
+	if doc.FindTitle() == "" {
+		doc.Metadata.Title = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	return doc
+}
+
+// isTextFile returns true if the file is a markdown or text file.
+func isTextFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown", ".txt":
+		return true
+	default:
+		return false
+	}
+}
+
 func getRawGithubURL(url *url.URL) (*url.URL, error) {
 	// Replace the domain and remove the "/blob/" segment
 	rawURL := strings.Replace(url.String(), "github.com", "raw.githubusercontent.com", 1)
@@ -11,7 +11,7 @@ import (
 )
 
 func TestRegexID(t *testing.T) {
-	fc, err := scrape.NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"))
+	fc, err := scrape.NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"), scrape.DefaultFirecrawlConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,7 +36,7 @@ func TestRegexID(t *testing.T) {
 }
 
 func TestHandleWebPage(t *testing.T) {
-	fc, err := scrape.NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"))
+	fc, err := scrape.NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"), scrape.DefaultFirecrawlConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -65,7 +65,7 @@ func TestHandleWebPage(t *testing.T) {
 }
 
 func TestGithubMarkdown(t *testing.T) {
-	fc, err := scrape.NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"))
+	fc, err := scrape.NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"), scrape.DefaultFirecrawlConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -103,3 +103,35 @@ func TestGithubMarkdown(t *testing.T) {
 		os.WriteFile(name, content, 0644)
 	}
 }
+
+func TestHandleFile(t *testing.T) {
+	h := NewContentHandler(nil)
+	dir := t.TempDir()
+
+	notes := dir + "/notes.md"
+	if err := os.WriteFile(notes, []byte("# Reading list\n\n- Bitcoin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := h.HandleURL(&url.URL{Scheme: "file", Path: notes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Metadata.Title != "Reading list" || doc.Metadata.Source != "file://"+notes {
+		t.Errorf("unexpected metadata: %+v", doc.Metadata)
+	}
+
+	plain := dir + "/plain.txt"
+	if err := os.WriteFile(plain, []byte("no heading"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc, err := h.HandleFile(plain); err != nil || doc.Metadata.Title != "plain" {
+		t.Errorf("unexpected document: %+v (%v)", doc, err)
+	}
+
+	if doc, err := h.HandleFile(dir + "/paper.pdf"); err != nil || doc != nil {
+		t.Errorf("expected unsupported file, got %+v (%v)", doc, err)
+	}
+}
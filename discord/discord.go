@@ -0,0 +1,815 @@
+// Package discord implements the Discord chat frontend: slash commands, mentions and message threads.
+package discord
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/log"
+	"github.com/mempirate/scholar/prompt"
+)
+
+// DefaultIngestReaction is the emoji that triggers ingestion of the links in a message.
+const DefaultIngestReaction = "📚"
+
+// RegenerateButtonPrefix prefixes the custom ID of the "Regenerate" button of summaries, followed by the thread ID.
+const RegenerateButtonPrefix = "regenerate_summary:"
+
+// SaveShortcutName is the name of the "Save to Scholar" message context menu command.
+const SaveShortcutName = "Save to Scholar"
+
+// DirectMessageTeamID is the team ID of commands and events in direct messages, which don't belong to a server.
+const DirectMessageTeamID = "discord"
+
+// MAX_MESSAGE_LENGTH is the maximum number of characters in a Discord message.
+const MAX_MESSAGE_LENGTH = 2000
+
+// THREAD_ARCHIVE_MINUTES is how long a thread started by Scholar stays active without messages.
+const THREAD_ARCHIVE_MINUTES = 24 * 60
+
+// gateway is the subset of the Discord session used by the handler. It is implemented by *discordgo.Session,
+// and replaced in tests.
+type gateway interface {
+	Open() error
+	AddHandler(handler interface{}) func()
+
+	ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+
+	Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendReply(channelID string, content string, reference *discordgo.MessageReference, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	User(userID string, options ...discordgo.RequestOption) (*discordgo.User, error)
+}
+
+var visibilityChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "public", Value: document.VisibilityPublic},
+	{Name: "channel", Value: document.VisibilityChannel},
+	{Name: "private", Value: document.VisibilityPrivate},
+}
+
+var commandOptions = []*discordgo.ApplicationCommandOption{
+	{Type: discordgo.ApplicationCommandOptionString, Name: "urls", Description: "Links to the documents", Required: true},
+	{Type: discordgo.ApplicationCommandOptionString, Name: "visibility", Description: "Who can retrieve the documents", Choices: visibilityChoices},
+}
+
+// modelOption selects the model of the commands that prompt the assistant.
+var modelOption = &discordgo.ApplicationCommandOption{Type: discordgo.ApplicationCommandOptionString, Name: "model", Description: "The model to use instead of the default one, e.g. gpt-4o"}
+
+var compareOptions = append(slices.Clone(commandOptions), modelOption)
+
+var styleChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "TL;DR", Value: prompt.StyleTLDR},
+	{Name: "detailed", Value: prompt.StyleDetailed},
+	{Name: "explain like I'm five", Value: prompt.StyleELI5},
+	{Name: "technical", Value: prompt.StyleTechnical},
+}
+
+var summaryOptions = append(slices.Clone(commandOptions),
+	&discordgo.ApplicationCommandOption{Type: discordgo.ApplicationCommandOptionString, Name: "style", Description: "The style of the summary", Choices: styleChoices},
+	&discordgo.ApplicationCommandOption{Type: discordgo.ApplicationCommandOptionBoolean, Name: "bullets", Description: "Format the summary as a list"},
+	&discordgo.ApplicationCommandOption{Type: discordgo.ApplicationCommandOptionBoolean, Name: "structured", Description: "Summarize each document with the same sections"},
+	&discordgo.ApplicationCommandOption{Type: discordgo.ApplicationCommandOptionString, Name: "lang", Description: "The code of the language to write the summary in, e.g. de"},
+	&discordgo.ApplicationCommandOption{Type: discordgo.ApplicationCommandOptionString, Name: "focus", Description: "A topic to focus on"},
+	modelOption,
+)
+
+// commands are registered globally when the bot connects.
+var commands = []*discordgo.ApplicationCommand{
+	{Name: strings.TrimPrefix(chat.UploadCommand, "/"), Description: "Add documents to the Scholar library", Options: commandOptions},
+	{Name: strings.TrimPrefix(chat.SummarizeCommand, "/"), Description: "Add documents to the Scholar library and summarize them", Options: summaryOptions},
+	{Name: strings.TrimPrefix(chat.CompareCommand, "/"), Description: "Add documents to the Scholar library and compare them", Options: compareOptions},
+	{Name: strings.TrimPrefix(chat.ResearchCommand, "/"), Description: "Research a question with the Scholar library and the web", Options: []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionString, Name: "question", Description: "The question to research", Required: true},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "visibility", Description: "Who can retrieve the report and the new sources", Choices: visibilityChoices},
+		modelOption,
+	}},
+	{Name: strings.TrimPrefix(chat.PersonaCommand, "/"), Description: "Show or switch the persona Scholar uses in this channel", Options: []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "The persona to switch to"},
+	}},
+	{Name: strings.TrimPrefix(chat.UsageCommand, "/"), Description: "Show the token usage and costs of Scholar, and your daily budget"},
+	{Name: SaveShortcutName, Type: discordgo.MessageApplicationCommand},
+}
+
+// DiscordHandler is the Discord chat frontend.
+//
+// Discord threads are channels whose ID is the ID of the message they were started from, so Scholar uses
+// message IDs as thread IDs and starts the thread when it first replies. Direct messages can't have threads,
+// so replies there reference the message instead.
+type DiscordHandler struct {
+	log     zerolog.Logger
+	session gateway
+
+	commandCh chan chat.Command
+	eventCh   chan chat.Event
+
+	// ingestReaction is the emoji that triggers ingestion of a message's links.
+	ingestReaction string
+
+	mu sync.Mutex
+	// appID is the application ID, used to register commands and respond to interactions.
+	appID string
+	// botUserID is the user ID of the bot itself, used to detect mentions and ignore its own messages.
+	botUserID string
+	// parents maps channel IDs to the ID of their parent channel, which is empty if the channel is not a thread.
+	parents map[string]string
+	// dms contains the IDs of direct message channels.
+	dms map[string]struct{}
+}
+
+// NewDiscordHandler creates a Discord frontend that connects with the given bot token.
+func NewDiscordHandler(token, ingestReaction string) (*DiscordHandler, error) {
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading mentions requires the privileged message content intent.
+	session.Identify.Intents = discordgo.IntentsGuilds |
+		discordgo.IntentsGuildMessages |
+		discordgo.IntentsGuildMessageReactions |
+		discordgo.IntentsDirectMessages |
+		discordgo.IntentsDirectMessageReactions |
+		discordgo.IntentsMessageContent
+
+	return newDiscordHandler(session, ingestReaction), nil
+}
+
+func newDiscordHandler(session gateway, ingestReaction string) *DiscordHandler {
+	return &DiscordHandler{
+		log:            log.NewLogger("discord"),
+		session:        session,
+		commandCh:      make(chan chat.Command, 32),
+		eventCh:        make(chan chat.Event, 32),
+		ingestReaction: ingestReaction,
+		parents:        make(map[string]string),
+		dms:            make(map[string]struct{}),
+	}
+}
+
+var _ chat.Frontend = (*DiscordHandler)(nil)
+
+// Name implements chat.Frontend.
+func (d *DiscordHandler) Name() string {
+	return "discord"
+}
+
+// Start connects to the Discord gateway and starts receiving events. It blocks.
+func (d *DiscordHandler) Start() {
+	d.session.AddHandler(func(_ *discordgo.Session, r *discordgo.Ready) { d.onReady(r) })
+	d.session.AddHandler(func(_ *discordgo.Session, i *discordgo.InteractionCreate) { d.onInteraction(i) })
+	d.session.AddHandler(func(_ *discordgo.Session, m *discordgo.MessageCreate) { d.onMessage(m) })
+	d.session.AddHandler(func(_ *discordgo.Session, r *discordgo.MessageReactionAdd) { d.onReactionAdded(r) })
+
+	if err := d.session.Open(); err != nil {
+		d.log.Fatal().Err(err).Msg("Failed to connect to Discord")
+	}
+
+	select {}
+}
+
+// SubscribeCommands returns a channel that yields incoming commands.
+func (d *DiscordHandler) SubscribeCommands() chan chat.Command {
+	return d.commandCh
+}
+
+// SubscribeEvents returns a channel that yields incoming events.
+func (d *DiscordHandler) SubscribeEvents() chan chat.Event {
+	return d.eventCh
+}
+
+// MaxResponses is unlimited: the response of an interaction can be edited as long as its token is valid.
+func (d *DiscordHandler) MaxResponses() int {
+	return 0
+}
+
+// Respond edits the ephemeral acknowledgement of a command. Interaction tokens are valid for 15 minutes.
+// Commands without a token get a direct message instead.
+func (d *DiscordHandler) Respond(cmd chat.Command, text string) error {
+	if cmd.ResponseURL == "" {
+		return d.PostEphemeral(cmd.TeamID, cmd.ChannelID, cmd.UserID, text)
+	}
+
+	d.mu.Lock()
+	appID := d.appID
+	d.mu.Unlock()
+
+	text = truncate(text, MAX_MESSAGE_LENGTH)
+	_, err := d.session.InteractionResponseEdit(&discordgo.Interaction{AppID: appID, Token: cmd.ResponseURL}, &discordgo.WebhookEdit{Content: &text})
+	return err
+}
+
+// PostEphemeral sends the user a direct message. Discord only supports ephemeral messages in response to interactions.
+func (d *DiscordHandler) PostEphemeral(teamID, channelID, userID, text string) error {
+	dmID, err := d.OpenDM(teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	return d.PostMessage(teamID, dmID, nil, text)
+}
+
+// PostMessage posts a message to a channel, in the given thread if threadID is set. Long messages are split.
+func (d *DiscordHandler) PostMessage(teamID, channelID string, threadID *string, text string) error {
+	target := channelID
+	var reference *discordgo.MessageReference
+
+	switch {
+	case threadID == nil:
+	case d.IsDM(channelID):
+		reference = &discordgo.MessageReference{MessageID: *threadID, ChannelID: channelID}
+	default:
+		if err := d.ensureThread(channelID, *threadID); err != nil {
+			return err
+		}
+		target = *threadID
+	}
+
+	for _, chunk := range chat.SplitMessage(text, MAX_MESSAGE_LENGTH) {
+		var err error
+		if reference != nil {
+			_, err = d.session.ChannelMessageSendReply(target, chunk, reference)
+		} else {
+			_, err = d.session.ChannelMessageSend(target, chunk)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostSummary posts a summary in a thread. The footer and a "Regenerate" button are attached to its last chunk.
+func (d *DiscordHandler) PostSummary(teamID, channelID, threadID, text, footer string) error {
+	if footer != "" {
+		text += "\n-# " + footer
+	}
+
+	chunks := chat.SplitMessage(text, MAX_MESSAGE_LENGTH)
+	if len(chunks) > 1 {
+		if err := d.PostMessage(teamID, channelID, &threadID, strings.Join(chunks[:len(chunks)-1], "\n")); err != nil {
+			return err
+		}
+	}
+
+	msg := &discordgo.MessageSend{
+		Content: chunks[len(chunks)-1],
+		Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Regenerate", Style: discordgo.SecondaryButton, CustomID: RegenerateButtonPrefix + threadID},
+		}}},
+	}
+
+	target := channelID
+	if d.IsDM(channelID) {
+		msg.Reference = &discordgo.MessageReference{MessageID: threadID, ChannelID: channelID}
+	} else {
+		if err := d.ensureThread(channelID, threadID); err != nil {
+			return err
+		}
+		target = threadID
+	}
+
+	_, err := d.session.ChannelMessageSendComplex(target, msg)
+	return err
+}
+
+// StartUploadThread posts the text in the given channel and starts a thread on it. The thread ID is returned.
+// Multi-line text (e.g. a list of uploads) gets the uploader on its own line.
+func (d *DiscordHandler) StartUploadThread(teamID, channelID, userID, text string) (string, error) {
+	response := fmt.Sprintf("%s (uploaded by <@%s>)", text, userID)
+	if strings.Contains(text, "\n") {
+		response = fmt.Sprintf("Uploaded by <@%s>:\n%s", userID, text)
+	}
+
+	msg, err := d.session.ChannelMessageSend(channelID, truncate(response, MAX_MESSAGE_LENGTH))
+	if err != nil {
+		return "", err
+	}
+
+	if d.IsDM(channelID) {
+		return msg.ID, nil
+	}
+
+	if err := d.startThread(channelID, msg.ID, threadName(text)); err != nil {
+		return "", err
+	}
+
+	return msg.ID, nil
+}
+
+// OpenDM returns the ID of the direct message channel between Scholar and the given user.
+func (d *DiscordHandler) OpenDM(teamID, userID string) (string, error) {
+	channel, err := d.session.UserChannelCreate(userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to open direct message with %s: %w", userID, err)
+	}
+
+	d.markDM(channel.ID)
+	return channel.ID, nil
+}
+
+// IsDM returns true if the channel is a direct message with Scholar.
+func (d *DiscordHandler) IsDM(channelID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.dms[channelID]
+	return ok
+}
+
+func (d *DiscordHandler) markDM(channelID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dms[channelID] = struct{}{}
+}
+
+// ChannelName returns the name of a channel. Direct messages don't have one.
+func (d *DiscordHandler) ChannelName(teamID, channelID string) string {
+	if d.IsDM(channelID) {
+		return ""
+	}
+
+	channel, err := d.session.Channel(channelID)
+	if err != nil {
+		d.log.Warn().Err(err).Str("channel", channelID).Msg("Failed to fetch channel")
+		return ""
+	}
+
+	return channel.Name
+}
+
+// UserProfile returns the display name of a user. Discord users don't have a title.
+func (d *DiscordHandler) UserProfile(teamID, userID string) chat.User {
+	profile := chat.User{ID: userID}
+
+	user, err := d.session.User(userID)
+	if err != nil {
+		d.log.Warn().Err(err).Str("user", userID).Msg("Failed to fetch user")
+		return profile
+	}
+
+	profile.Name = user.GlobalName
+	if profile.Name == "" {
+		profile.Name = user.Username
+	}
+
+	return profile
+}
+
+// SearchMessages searches the recent messages of a channel. Bots can't use Discord's search, so the messages are
+// fetched and matched locally.
+func (d *DiscordHandler) SearchMessages(teamID, channelID, query string, limit int) ([]chat.Message, error) {
+	msgs, err := d.session.ChannelMessages(channelID, chat.SEARCH_HISTORY_LIMIT, "", "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages of channel %s: %w", channelID, err)
+	}
+
+	messages := make([]chat.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Author == nil || msg.Author.Bot {
+			continue
+		}
+
+		messages = append(messages, chat.Message{ID: msg.ID, UserID: msg.Author.ID, Text: msg.Content, Time: msg.Timestamp})
+	}
+
+	return chat.MatchMessages(messages, query, limit), nil
+}
+
+// ensureThread starts a thread on the message, unless the message already is a thread.
+func (d *DiscordHandler) ensureThread(channelID, threadID string) error {
+	d.mu.Lock()
+	_, ok := d.parents[threadID]
+	d.mu.Unlock()
+
+	if ok || threadID == channelID {
+		return nil
+	}
+
+	// After a restart, the thread may already exist. Then starting it fails, but the thread can be used.
+	if err := d.startThread(channelID, threadID, "Scholar"); err != nil {
+		if _, err := d.session.Channel(threadID); err != nil {
+			return fmt.Errorf("failed to start thread on message %s: %w", threadID, err)
+		}
+	}
+
+	d.setParent(threadID, channelID)
+	return nil
+}
+
+func (d *DiscordHandler) startThread(channelID, messageID, name string) error {
+	if _, err := d.session.MessageThreadStart(channelID, messageID, name, THREAD_ARCHIVE_MINUTES); err != nil {
+		return err
+	}
+
+	d.setParent(messageID, channelID)
+	return nil
+}
+
+func (d *DiscordHandler) setParent(channelID, parentID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.parents[channelID] = parentID
+}
+
+// resolveChannel returns the channel and thread a reply to a message in the given channel should go in.
+// Messages in threads are attributed to the parent channel, so channel policies and libraries apply.
+func (d *DiscordHandler) resolveChannel(channelID, messageID string) (string, string) {
+	d.mu.Lock()
+	parentID, ok := d.parents[channelID]
+	d.mu.Unlock()
+
+	if !ok {
+		channel, err := d.session.Channel(channelID)
+		if err != nil {
+			d.log.Error().Err(err).Str("channel", channelID).Msg("Failed to fetch channel")
+			return channelID, messageID
+		}
+
+		if channel.IsThread() {
+			parentID = channel.ParentID
+		}
+		d.setParent(channelID, parentID)
+	}
+
+	if parentID != "" {
+		return parentID, channelID
+	}
+
+	return channelID, messageID
+}
+
+func (d *DiscordHandler) onReady(r *discordgo.Ready) {
+	d.mu.Lock()
+	d.botUserID = r.User.ID
+	d.appID = r.User.ID
+	if r.Application != nil && r.Application.ID != "" {
+		d.appID = r.Application.ID
+	}
+	appID := d.appID
+	d.mu.Unlock()
+
+	d.log.Info().Str("user", r.User.Username).Int("guilds", len(r.Guilds)).Msg("Connected to Discord")
+
+	if _, err := d.session.ApplicationCommandBulkOverwrite(appID, "", commands); err != nil {
+		d.log.Error().Err(err).Msg("Failed to register commands")
+	}
+}
+
+// onInteraction handles slash commands and the "Save to Scholar" message command.
+func (d *DiscordHandler) onInteraction(i *discordgo.InteractionCreate) {
+	if i.Type == discordgo.InteractionMessageComponent {
+		d.onComponent(i)
+		return
+	}
+
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	if i.GuildID == "" {
+		d.markDM(i.ChannelID)
+	}
+
+	data := i.ApplicationCommandData()
+	reply := chat.ReplyUnknownCommand
+
+	switch {
+	case data.CommandType == discordgo.MessageApplicationCommand && data.Name == SaveShortcutName:
+		reply = d.onSaveShortcut(i, data)
+	case data.CommandType == discordgo.ChatApplicationCommand:
+		reply = d.onCommand(i, data)
+	default:
+		d.log.Debug().Str("name", data.Name).Msg("Ignoring unknown command")
+	}
+
+	err := d.session.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: reply, Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		d.log.Error().Err(err).Msg("Failed to respond to interaction")
+	}
+}
+
+// onComponent handles clicks on the "Regenerate" button of summaries.
+func (d *DiscordHandler) onComponent(i *discordgo.InteractionCreate) {
+	threadID, ok := strings.CutPrefix(i.MessageComponentData().CustomID, RegenerateButtonPrefix)
+	if !ok {
+		d.log.Debug().Str("custom_id", i.MessageComponentData().CustomID).Msg("Ignoring unknown component")
+		return
+	}
+
+	if i.GuildID == "" {
+		d.markDM(i.ChannelID)
+	}
+
+	channelID := i.ChannelID
+	if i.GuildID != "" {
+		channelID, _ = d.resolveChannel(i.ChannelID, "")
+	}
+
+	d.log.Info().Str("user", userOf(i.Interaction)).Str("thread_id", threadID).Msg("Received regenerate button")
+
+	err := d.session.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: chat.ReplyRegenerating, Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		d.log.Error().Err(err).Msg("Failed to respond to interaction")
+	}
+
+	d.eventCh <- chat.Event{
+		Type:      chat.RegenerateEvent,
+		TeamID:    teamOf(i.GuildID),
+		UserID:    userOf(i.Interaction),
+		ChannelID: channelID,
+		ThreadID:  threadID,
+	}
+}
+
+// onCommand validates a slash command and queues it for processing. It returns the text
+// to acknowledge the command with.
+func (d *DiscordHandler) onCommand(i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) string {
+	commandType := "/" + data.Name
+
+	channelID := i.ChannelID
+	if i.GuildID != "" {
+		channelID, _ = d.resolveChannel(i.ChannelID, "")
+	}
+
+	command := chat.Command{
+		CommandType: commandType,
+		TeamID:      teamOf(i.GuildID),
+		UserID:      userOf(i.Interaction),
+		ChannelID:   channelID,
+		ResponseURL: i.Token,
+	}
+
+	switch commandType {
+	case chat.UploadCommand, chat.SummarizeCommand, chat.CompareCommand:
+		var text string
+		if opt := data.GetOption("urls"); opt != nil {
+			text = opt.StringValue()
+		}
+
+		urls := chat.ExtractURLs(text)
+		if len(urls) == 0 {
+			if strings.TrimSpace(text) == "" {
+				return chat.ReplyMissingURL
+			}
+			return chat.ReplyInvalidURL
+		}
+
+		if commandType == chat.CompareCommand && len(urls) < 2 {
+			return chat.ReplyCompareURLs
+		}
+
+		command.URLs = urls
+		command.Visibility = document.VisibilityPublic
+		if opt := data.GetOption("visibility"); opt != nil {
+			command.Visibility = opt.StringValue()
+		}
+
+		if commandType == chat.SummarizeCommand {
+			summary, err := parseSummaryOptions(data)
+			if err != nil {
+				return err.Error()
+			}
+			command.Summary = summary
+		}
+
+		if opt := data.GetOption("model"); opt != nil {
+			command.Model = strings.TrimSpace(opt.StringValue())
+		}
+
+	case chat.PersonaCommand:
+		if opt := data.GetOption("name"); opt != nil {
+			command.Text = strings.TrimSpace(opt.StringValue())
+		}
+
+	case chat.UsageCommand:
+
+	case chat.ResearchCommand:
+		if opt := data.GetOption("question"); opt != nil {
+			command.Text = strings.TrimSpace(opt.StringValue())
+		}
+
+		if command.Text == "" {
+			return chat.ReplyMissingQuestion
+		}
+
+		command.Visibility = document.VisibilityPublic
+		if opt := data.GetOption("visibility"); opt != nil {
+			command.Visibility = opt.StringValue()
+		}
+
+		if opt := data.GetOption("model"); opt != nil {
+			command.Model = strings.TrimSpace(opt.StringValue())
+		}
+
+	default:
+		return chat.ReplyUnknownCommand
+	}
+
+	select {
+	case d.commandCh <- command:
+		return chat.ReplyWorking
+	default:
+		d.log.Warn().Str("command", commandType).Msg("Command queue full, rejecting command")
+		return chat.ReplyBusy
+	}
+}
+
+// parseSummaryOptions returns the summary options of a /summary command.
+func parseSummaryOptions(data discordgo.ApplicationCommandInteractionData) (prompt.SummaryOptions, error) {
+	var summary prompt.SummaryOptions
+	if opt := data.GetOption("style"); opt != nil {
+		summary.Style = opt.StringValue()
+	}
+	if opt := data.GetOption("bullets"); opt != nil {
+		summary.Bullets = opt.BoolValue()
+	}
+	if opt := data.GetOption("structured"); opt != nil {
+		summary.Structured = opt.BoolValue()
+	}
+	if opt := data.GetOption("lang"); opt != nil {
+		summary.Language = strings.TrimSpace(opt.StringValue())
+	}
+	if opt := data.GetOption("focus"); opt != nil {
+		summary.Focus = strings.TrimSpace(opt.StringValue())
+	}
+
+	return summary, summary.Validate()
+}
+
+func (d *DiscordHandler) onSaveShortcut(i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) string {
+	if data.Resolved == nil || data.Resolved.Messages[data.TargetID] == nil {
+		return chat.ReplyMissingURL
+	}
+
+	msg := data.Resolved.Messages[data.TargetID]
+	channelID, threadID := i.ChannelID, msg.ID
+	if i.GuildID != "" {
+		channelID, threadID = d.resolveChannel(i.ChannelID, msg.ID)
+	}
+
+	d.log.Info().Str("user", userOf(i.Interaction)).Str("message", msg.ID).Msg("Received save shortcut")
+
+	d.eventCh <- chat.Event{
+		Type:      chat.ShortcutEvent,
+		TeamID:    teamOf(i.GuildID),
+		UserID:    userOf(i.Interaction),
+		ChannelID: channelID,
+		ThreadID:  threadID,
+		Text:      msg.Content,
+		URLs:      chat.ExtractURLs(msg.Content),
+	}
+
+	return chat.ReplyWorking
+}
+
+// onMessage handles new messages. Mentions of Scholar and direct messages are emitted as mention events.
+func (d *DiscordHandler) onMessage(m *discordgo.MessageCreate) {
+	d.mu.Lock()
+	botUserID := d.botUserID
+	d.mu.Unlock()
+
+	if m.Author == nil || m.Author.Bot || m.Author.ID == botUserID {
+		return
+	}
+
+	isDM := m.GuildID == ""
+	if isDM {
+		d.markDM(m.ChannelID)
+	}
+
+	mentioned := false
+	for _, user := range m.Mentions {
+		if user.ID == botUserID {
+			mentioned = true
+		}
+	}
+
+	channelID, threadID := m.ChannelID, m.ID
+	if !isDM {
+		channelID, threadID = d.resolveChannel(m.ChannelID, m.ID)
+	}
+
+	event := chat.Event{
+		Type:      chat.MessageEvent,
+		TeamID:    teamOf(m.GuildID),
+		UserID:    m.Author.ID,
+		ChannelID: channelID,
+		ThreadID:  threadID,
+		Text:      m.Content,
+	}
+
+	if isDM || mentioned {
+		d.log.Info().Str("thread_id", threadID).Str("user", m.Author.ID).Msg("Received mention")
+
+		event.Type = chat.MentionEvent
+		event.Text = stripMention(m.Content, botUserID)
+		event.URLs = chat.ExtractURLs(event.Text)
+	}
+
+	d.eventCh <- event
+}
+
+func (d *DiscordHandler) onReactionAdded(r *discordgo.MessageReactionAdd) {
+	if r.Emoji.Name != d.ingestReaction {
+		return
+	}
+
+	d.log.Info().Str("reaction", r.Emoji.Name).Str("user", r.UserID).Str("message", r.MessageID).Msg("Received ingest reaction")
+
+	msg, err := d.session.ChannelMessage(r.ChannelID, r.MessageID)
+	if err != nil {
+		d.log.Error().Err(err).Str("message", r.MessageID).Msg("Failed to fetch message")
+		return
+	}
+
+	channelID, threadID := r.ChannelID, msg.ID
+	if r.GuildID == "" {
+		d.markDM(r.ChannelID)
+	} else {
+		channelID, threadID = d.resolveChannel(r.ChannelID, msg.ID)
+	}
+
+	d.eventCh <- chat.Event{
+		Type:      chat.ReactionEvent,
+		TeamID:    teamOf(r.GuildID),
+		UserID:    r.UserID,
+		ChannelID: channelID,
+		ThreadID:  threadID,
+		Text:      msg.Content,
+		URLs:      chat.ExtractURLs(msg.Content),
+	}
+}
+
+// teamOf returns the team ID for a server. Direct messages don't belong to a server.
+func teamOf(guildID string) string {
+	if guildID == "" {
+		return DirectMessageTeamID
+	}
+
+	return guildID
+}
+
+// userOf returns the user that triggered an interaction. In servers, only the member is set.
+func userOf(i *discordgo.Interaction) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+
+	if i.User != nil {
+		return i.User.ID
+	}
+
+	return ""
+}
+
+// stripMention removes mentions of the bot from the text.
+func stripMention(text, botUserID string) string {
+	text = strings.ReplaceAll(text, "<@"+botUserID+">", "")
+	text = strings.ReplaceAll(text, "<@!"+botUserID+">", "")
+	return strings.TrimSpace(text)
+}
+
+// threadName returns a thread name (at most 100 characters) for an upload thread.
+func threadName(text string) string {
+	name, _, _ := strings.Cut(text, "\n")
+	name = strings.TrimSpace(strings.TrimPrefix(name, "•"))
+	if name == "" {
+		name = "Scholar"
+	}
+
+	return truncate(name, 100)
+}
+
+// truncate shortens the text to at most n characters.
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,375 @@
+package discord
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+)
+
+type sentMessage struct {
+	channelID  string
+	content    string
+	reference  *discordgo.MessageReference
+	components []discordgo.MessageComponent
+}
+
+// fakeGateway records the requests the handler makes instead of sending them to Discord.
+type fakeGateway struct {
+	mu        sync.Mutex
+	nextID    int
+	channels  map[string]*discordgo.Channel
+	messages  map[string]*discordgo.Message
+	sent      []sentMessage
+	threads   []string
+	responses []*discordgo.InteractionResponse
+	edits     []string
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{
+		channels: map[string]*discordgo.Channel{
+			"C1":      {ID: "C1", Type: discordgo.ChannelTypeGuildText},
+			"THREAD1": {ID: "THREAD1", Type: discordgo.ChannelTypeGuildPublicThread, ParentID: "C1"},
+		},
+		messages: make(map[string]*discordgo.Message),
+	}
+}
+
+func (g *fakeGateway) Open() error                           { return nil }
+func (g *fakeGateway) AddHandler(handler interface{}) func() { return func() {} }
+
+func (g *fakeGateway) ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	return commands, nil
+}
+
+func (g *fakeGateway) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.responses = append(g.responses, resp)
+	return nil
+}
+
+func (g *fakeGateway) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.edits = append(g.edits, interaction.Token+":"+*newresp.Content)
+	return &discordgo.Message{}, nil
+}
+
+func (g *fakeGateway) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if channel, ok := g.channels[channelID]; ok {
+		return channel, nil
+	}
+
+	return nil, fmt.Errorf("unknown channel %s", channelID)
+}
+
+func (g *fakeGateway) ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if msg, ok := g.messages[messageID]; ok {
+		return msg, nil
+	}
+
+	return nil, fmt.Errorf("unknown message %s", messageID)
+}
+
+func (g *fakeGateway) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var msgs []*discordgo.Message
+	for _, msg := range g.messages {
+		if msg.ChannelID == channelID {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	// Newest first, like Discord.
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Timestamp.After(msgs[j].Timestamp) })
+	return msgs, nil
+}
+
+func (g *fakeGateway) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return g.ChannelMessageSendReply(channelID, content, nil)
+}
+
+func (g *fakeGateway) ChannelMessageSendReply(channelID string, content string, reference *discordgo.MessageReference, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return g.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{Content: content, Reference: reference})
+}
+
+func (g *fakeGateway) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.nextID++
+	msg := &discordgo.Message{ID: fmt.Sprintf("M%d", g.nextID), ChannelID: channelID, Content: data.Content}
+	g.messages[msg.ID] = msg
+	g.sent = append(g.sent, sentMessage{channelID: channelID, content: data.Content, reference: data.Reference, components: data.Components})
+	return msg, nil
+}
+
+func (g *fakeGateway) MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	thread := &discordgo.Channel{ID: messageID, Name: name, Type: discordgo.ChannelTypeGuildPublicThread, ParentID: channelID}
+	g.channels[messageID] = thread
+	g.threads = append(g.threads, messageID)
+	return thread, nil
+}
+
+func (g *fakeGateway) UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: "DM-" + recipientID, Type: discordgo.ChannelTypeDM}, nil
+}
+
+func (g *fakeGateway) User(userID string, options ...discordgo.RequestOption) (*discordgo.User, error) {
+	return &discordgo.User{ID: userID, Username: strings.ToLower(userID)}, nil
+}
+
+func newTestHandler() (*DiscordHandler, *fakeGateway) {
+	g := newFakeGateway()
+	d := newDiscordHandler(g, DefaultIngestReaction)
+	d.onReady(&discordgo.Ready{User: &discordgo.User{ID: "BOT", Username: "scholar"}, Application: &discordgo.Application{ID: "APP"}})
+	return d, g
+}
+
+func slashCommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   "G1",
+		ChannelID: "C1",
+		Token:     "TOKEN",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "U1"}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name:        name,
+			CommandType: discordgo.ChatApplicationCommand,
+			Options:     options,
+		},
+	}}
+}
+
+func stringOption(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+func TestSlashCommand(t *testing.T) {
+	d, g := newTestHandler()
+
+	d.onInteraction(slashCommand("upload", stringOption("urls", "https://example.com https://test.com"), stringOption("visibility", document.VisibilityPrivate)))
+	d.onInteraction(slashCommand("upload", stringOption("urls", "example dot com")))
+
+	if len(g.responses) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(g.responses))
+	}
+
+	if resp := g.responses[0]; resp.Data.Content != chat.ReplyWorking || resp.Data.Flags != discordgo.MessageFlagsEphemeral {
+		t.Errorf("unexpected acknowledgement: %+v", resp.Data)
+	}
+
+	if content := g.responses[1].Data.Content; content != chat.ReplyInvalidURL {
+		t.Errorf("unexpected reply to invalid command: %s", content)
+	}
+
+	cmd := <-d.SubscribeCommands()
+	if cmd.CommandType != chat.UploadCommand || cmd.TeamID != "G1" || cmd.UserID != "U1" || len(cmd.URLs) != 2 || cmd.Visibility != document.VisibilityPrivate {
+		t.Errorf("unexpected command: %+v", cmd)
+	}
+
+	if err := d.Respond(cmd, "Done."); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.edits) != 1 || g.edits[0] != "TOKEN:Done." {
+		t.Errorf("unexpected edits: %v", g.edits)
+	}
+
+	d.onInteraction(slashCommand("summary", stringOption("urls", "https://example.com"), stringOption("style", prompt.StyleELI5), stringOption("lang", "fr"), stringOption("model", "gpt-4o")))
+	if cmd := <-d.SubscribeCommands(); cmd.Summary != (prompt.SummaryOptions{Style: prompt.StyleELI5, Language: "fr"}) || cmd.Model != "gpt-4o" {
+		t.Errorf("unexpected summary options: %+v, model %q", cmd.Summary, cmd.Model)
+	}
+
+	d.onInteraction(slashCommand("persona", stringOption("name", "reading-group")))
+	if cmd := <-d.SubscribeCommands(); cmd.CommandType != chat.PersonaCommand || cmd.Text != "reading-group" {
+		t.Errorf("unexpected persona command: %+v", cmd)
+	}
+}
+
+func TestRegenerateSummary(t *testing.T) {
+	d, g := newTestHandler()
+
+	if err := d.PostSummary("G1", "C1", "M0", "A summary.", "Style: tldr"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.sent) != 1 || g.sent[0].channelID != "M0" || !strings.HasSuffix(g.sent[0].content, "-# Style: tldr") || len(g.sent[0].components) != 1 {
+		t.Fatalf("unexpected messages: %+v", g.sent)
+	}
+
+	button := g.sent[0].components[0].(discordgo.ActionsRow).Components[0].(discordgo.Button)
+	d.onInteraction(&discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:      discordgo.InteractionMessageComponent,
+		GuildID:   "G1",
+		ChannelID: "M0",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "U1"}},
+		Data:      discordgo.MessageComponentInteractionData{CustomID: button.CustomID},
+	}})
+
+	event := <-d.SubscribeEvents()
+	if event.Type != chat.RegenerateEvent || event.ThreadID != "M0" || event.ChannelID != "C1" || event.UserID != "U1" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestMentionStartsThread(t *testing.T) {
+	d, g := newTestHandler()
+
+	d.onMessage(&discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "M100",
+		GuildID:   "G1",
+		ChannelID: "C1",
+		Author:    &discordgo.User{ID: "U1"},
+		Mentions:  []*discordgo.User{{ID: "BOT"}},
+		Content:   "<@BOT> what is https://example.com about?",
+	}})
+
+	ev := <-d.SubscribeEvents()
+	if ev.Type != chat.MentionEvent || ev.ChannelID != "C1" || ev.ThreadID != "M100" || ev.Text != "what is https://example.com about?" || len(ev.URLs) != 1 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	if err := d.PostMessage(ev.TeamID, ev.ChannelID, &ev.ThreadID, "reply"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.threads) != 1 || g.threads[0] != "M100" {
+		t.Errorf("expected a thread on the mention, got %v", g.threads)
+	}
+
+	if len(g.sent) != 1 || g.sent[0].channelID != "M100" {
+		t.Errorf("expected the reply in the thread, got %+v", g.sent)
+	}
+}
+
+func TestMentionInThread(t *testing.T) {
+	d, g := newTestHandler()
+
+	d.onMessage(&discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "M100",
+		GuildID:   "G1",
+		ChannelID: "THREAD1",
+		Author:    &discordgo.User{ID: "U1"},
+		Mentions:  []*discordgo.User{{ID: "BOT"}},
+		Content:   "<@BOT> and now?",
+	}})
+
+	// Messages in threads belong to the parent channel.
+	ev := <-d.SubscribeEvents()
+	if ev.ChannelID != "C1" || ev.ThreadID != "THREAD1" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	if err := d.PostMessage(ev.TeamID, ev.ChannelID, &ev.ThreadID, "reply"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.threads) != 0 || len(g.sent) != 1 || g.sent[0].channelID != "THREAD1" {
+		t.Errorf("unexpected requests: threads %v, sent %+v", g.threads, g.sent)
+	}
+}
+
+func TestDirectMessage(t *testing.T) {
+	d, g := newTestHandler()
+
+	d.onMessage(&discordgo.MessageCreate{Message: &discordgo.Message{ID: "M1", ChannelID: "D1", Author: &discordgo.User{ID: "U1"}, Content: "hello"}})
+	d.onMessage(&discordgo.MessageCreate{Message: &discordgo.Message{ID: "M2", ChannelID: "D1", Author: &discordgo.User{ID: "BOT"}, Content: "own message"}})
+
+	ev := <-d.SubscribeEvents()
+	if ev.Type != chat.MentionEvent || ev.TeamID != DirectMessageTeamID || !d.IsDM("D1") {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	if len(d.SubscribeEvents()) != 0 {
+		t.Error("expected own message to be ignored")
+	}
+
+	// Direct messages have no threads, so replies reference the message.
+	if err := d.PostMessage(ev.TeamID, ev.ChannelID, &ev.ThreadID, "reply"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.sent) != 1 || g.sent[0].reference == nil || g.sent[0].reference.MessageID != "M1" {
+		t.Errorf("unexpected messages: %+v", g.sent)
+	}
+}
+
+func TestStartUploadThread(t *testing.T) {
+	d, g := newTestHandler()
+
+	threadID, err := d.StartUploadThread("G1", "C1", "U1", "• Bitcoin [https://bitcoin.org/bitcoin.pdf]\n• Ethereum [https://ethereum.org]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.threads) != 1 || g.threads[0] != threadID || g.channels[threadID].Name != "Bitcoin [https://bitcoin.org/bitcoin.pdf]" {
+		t.Errorf("unexpected threads: %v", g.threads)
+	}
+
+	if !strings.HasPrefix(g.sent[0].content, "Uploaded by <@U1>:\n") {
+		t.Errorf("unexpected message: %s", g.sent[0].content)
+	}
+}
+
+func TestIngestReaction(t *testing.T) {
+	d, g := newTestHandler()
+	g.messages["M5"] = &discordgo.Message{ID: "M5", ChannelID: "C1", Content: "see https://example.com"}
+
+	d.onReactionAdded(&discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{UserID: "U2", MessageID: "M5", ChannelID: "C1", GuildID: "G1", Emoji: discordgo.Emoji{Name: "👍"}}})
+	d.onReactionAdded(&discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{UserID: "U2", MessageID: "M5", ChannelID: "C1", GuildID: "G1", Emoji: discordgo.Emoji{Name: DefaultIngestReaction}}})
+
+	if len(d.SubscribeEvents()) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(d.SubscribeEvents()))
+	}
+
+	if ev := <-d.SubscribeEvents(); ev.Type != chat.ReactionEvent || ev.UserID != "U2" || ev.ThreadID != "M5" || len(ev.URLs) != 1 {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	d, g := newTestHandler()
+
+	now := time.Now()
+	for i, msg := range []*discordgo.Message{
+		{ID: "M1", ChannelID: "C1", Content: "FastPay paper", Author: &discordgo.User{ID: "U1"}, Timestamp: now.Add(-time.Hour)},
+		{ID: "M2", ChannelID: "C1", Content: "fastpay benchmarks", Author: &discordgo.User{ID: "U2"}, Timestamp: now},
+		{ID: "M3", ChannelID: "C1", Content: "Saved FastPay", Author: &discordgo.User{ID: "BOT", Bot: true}, Timestamp: now},
+		{ID: "M4", ChannelID: "C2", Content: "FastPay", Author: &discordgo.User{ID: "U1"}, Timestamp: now},
+	} {
+		g.messages[fmt.Sprint(i)] = msg
+	}
+
+	messages, err := d.SearchMessages("G1", "C1", "fastpay", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || messages[0].ID != "M2" || messages[1].ID != "M1" {
+		t.Errorf("expected M2 and M1, got %+v", messages)
+	}
+}
@@ -19,24 +19,48 @@ const (
 	TypePDF     Type = "pdf"
 	TypeTweet   Type = "tweet"
 	TypeArticle Type = "article"
+	// TypeSummary documents are summaries generated by Scholar, derived from other documents.
+	TypeSummary Type = "summary"
+	// TypeReport documents are research reports generated by Scholar with /research.
+	TypeReport Type = "report"
+)
+
+// Visibility determines who can retrieve a document.
+type Visibility = string
+
+const (
+	// VisibilityPublic documents are visible to the whole workspace. This is the default.
+	VisibilityPublic Visibility = "public"
+	// VisibilityChannel documents are only visible in the channel they were uploaded in.
+	VisibilityChannel Visibility = "channel"
+	// VisibilityPrivate documents are only visible to the user that uploaded them.
+	VisibilityPrivate Visibility = "private"
 )
 
 // TODO: turn into YAML front matter
 type Metadata struct {
-	Title string `yaml:"title"`
-	ID    string `yaml:"id,omitempty"`
+	Title string `yaml:"title" json:"title"`
+	ID    string `yaml:"id,omitempty" json:"id,omitempty"`
 	// Description: either Description or OGDescription
-	Description *string  `yaml:"description,omitempty"`
-	Keywords    []string `yaml:"keywords,omitempty"`
-	Authors     []string `yaml:"authors,omitempty"`
-	Source      string   `yaml:"source"`
-	Type        Type     `yaml:"type"`
+	Description *string  `yaml:"description,omitempty" json:"description,omitempty"`
+	Keywords    []string `yaml:"keywords,omitempty" json:"keywords,omitempty"`
+	Authors     []string `yaml:"authors,omitempty" json:"authors,omitempty"`
+	Source      string   `yaml:"source" json:"source"`
+	Type        Type     `yaml:"type" json:"type"`
 	// OGSiteName
-	SiteName      *string  `yaml:"siteName,omitempty"`
-	PublishedTime *string  `yaml:"publishedTime,omitempty"`
-	ModifiedTime  *string  `yaml:"modifiedTime,omitempty"`
-	ProcessedTime string   `yaml:"processedTime"`
-	Links         []string `yaml:"links,omitempty"`
+	SiteName      *string  `yaml:"siteName,omitempty" json:"siteName,omitempty"`
+	PublishedTime *string  `yaml:"publishedTime,omitempty" json:"publishedTime,omitempty"`
+	ModifiedTime  *string  `yaml:"modifiedTime,omitempty" json:"modifiedTime,omitempty"`
+	ProcessedTime string   `yaml:"processedTime" json:"processedTime"`
+	Links         []string `yaml:"links,omitempty" json:"links,omitempty"`
+	// Visibility of the document. Empty means public.
+	Visibility Visibility `yaml:"visibility,omitempty" json:"visibility,omitempty"`
+	// Channel is the channel the document was uploaded in. Set for channel documents.
+	Channel string `yaml:"channel,omitempty" json:"channel,omitempty"`
+	// UploadedBy is the user that uploaded the document.
+	UploadedBy string `yaml:"uploadedBy,omitempty" json:"uploadedBy,omitempty"`
+	// DerivedFrom are the file names of the documents a derived document (e.g. a summary) was generated from.
+	DerivedFrom []string `yaml:"derivedFrom,omitempty" json:"derivedFrom,omitempty"`
 }
 
 type Document struct {
@@ -116,6 +140,28 @@ func (d *Document) ToMarkdown() (string, []byte, error) {
 	return d.FileName(), builder.Bytes(), nil
 }
 
+// Parse parses a markdown document created by ToMarkdown. Documents without front matter are returned
+// with empty metadata.
+func Parse(markdown []byte) (*Document, error) {
+	rest, ok := bytes.CutPrefix(markdown, []byte("---\n"))
+	if !ok {
+		return &Document{Content: markdown}, nil
+	}
+
+	frontMatter, content, ok := bytes.Cut(rest, []byte("\n---\n"))
+	if !ok {
+		return nil, errors.New("unterminated front matter")
+	}
+
+	var doc Document
+	if err := yaml.Unmarshal(frontMatter, &doc.Metadata); err != nil {
+		return nil, errors.Wrap(err, "failed to parse front matter")
+	}
+
+	doc.Content = content
+	return &doc, nil
+}
+
 func sanitizeFileName(name string) string {
 	re := regexp.MustCompile(`[\/\\:\*\?"<>\|\p{C}]`)
 
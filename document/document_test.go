@@ -48,3 +48,33 @@ func TestFindTitle(t *testing.T) {
 		})
 	}
 }
+
+func TestParse(t *testing.T) {
+	source := "https://bitcoin.org/bitcoin.pdf"
+	doc := &Document{
+		Content:  []byte("# Bitcoin\n\nA peer-to-peer electronic cash system.\n"),
+		Metadata: Metadata{Title: "Bitcoin", Source: source, Type: TypePDF, Visibility: VisibilityPrivate},
+	}
+
+	_, markdown, err := doc.ToMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(markdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Metadata.Title != "Bitcoin" || parsed.Metadata.Source != source || parsed.Metadata.Visibility != VisibilityPrivate {
+		t.Errorf("unexpected metadata: %+v", parsed.Metadata)
+	}
+
+	if string(parsed.Content) != string(doc.Content) {
+		t.Errorf("unexpected content: %q", parsed.Content)
+	}
+
+	if plain, err := Parse([]byte("# Notes\n")); err != nil || plain.FindTitle() != "Notes" {
+		t.Errorf("unexpected plain document: %+v (%v)", plain, err)
+	}
+}
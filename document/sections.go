@@ -0,0 +1,179 @@
+package document
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Section is a part of a document's content. Long documents are split into sections, to be summarized one at a time.
+type Section struct {
+	// Heading names the section, e.g. "3 Protocol", "Page 4" or "Part 2".
+	Heading string
+	Text    string
+}
+
+// headingRegex matches ATX headings, e.g. "## 3 Protocol".
+var headingRegex = regexp.MustCompile(`^#{1,6}[ \t]+(.+?)[ \t#]*$`)
+
+// PAGE_BREAK separates the pages of some converted PDFs.
+const PAGE_BREAK = "\f"
+
+// MAX_MERGED_HEADINGS is the number of headings listed in the heading of merged sections. Sections with more show
+// the first and last heading, e.g. "2 Model – 5 Evaluation".
+const MAX_MERGED_HEADINGS = 3
+
+// Sections splits the content into sections of at most maxLength bytes. The content is split at its headings, or at
+// page breaks if it has no headings. Short consecutive sections are merged, and sections that are too long are
+// split between paragraphs.
+func (d *Document) Sections(maxLength int) []Section {
+	sections := splitHeadings(string(d.Content))
+	if len(sections) == 1 && strings.Contains(sections[0].Text, PAGE_BREAK) {
+		sections = splitPages(sections[0].Text)
+	}
+
+	var result []Section
+	for _, section := range mergeSections(sections, maxLength) {
+		result = append(result, splitSection(section, maxLength)...)
+	}
+
+	return result
+}
+
+// splitHeadings splits markdown at its headings, ignoring lines in code blocks. The text before the first heading
+// is a section without heading.
+func splitHeadings(markdown string) []Section {
+	var sections []Section
+	current := Section{}
+	var text strings.Builder
+	fenced := false
+
+	flush := func() {
+		current.Text = strings.TrimSpace(text.String())
+		if current.Text != "" || current.Heading != "" {
+			sections = append(sections, current)
+		}
+		text.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		}
+
+		if match := headingRegex.FindStringSubmatch(strings.TrimRight(line, "\r\n")); match != nil && !fenced {
+			flush()
+			current = Section{Heading: match[1]}
+		}
+
+		text.WriteString(line)
+	}
+	flush()
+
+	return sections
+}
+
+// splitPages splits text at its page breaks.
+func splitPages(text string) []Section {
+	var sections []Section
+	for i, page := range strings.Split(text, PAGE_BREAK) {
+		if page = strings.TrimSpace(page); page != "" {
+			sections = append(sections, Section{Heading: fmt.Sprintf("Page %d", i+1), Text: page})
+		}
+	}
+
+	return sections
+}
+
+// mergeSections merges consecutive sections as long as they fit in maxLength.
+func mergeSections(sections []Section, maxLength int) []Section {
+	var merged []Section
+	var headings []string
+
+	for _, section := range sections {
+		last := len(merged) - 1
+		if last >= 0 && len(merged[last].Text)+len(section.Text)+2 <= maxLength {
+			merged[last].Text += "\n\n" + section.Text
+			if section.Heading != "" {
+				headings = append(headings, section.Heading)
+			}
+			merged[last].Heading = mergedHeading(headings)
+			continue
+		}
+
+		headings = nil
+		if section.Heading != "" {
+			headings = append(headings, section.Heading)
+		}
+		merged = append(merged, section)
+	}
+
+	return merged
+}
+
+func mergedHeading(headings []string) string {
+	if len(headings) > MAX_MERGED_HEADINGS {
+		return headings[0] + " – " + headings[len(headings)-1]
+	}
+
+	return strings.Join(headings, ", ")
+}
+
+// splitSection splits a section that is longer than maxLength between paragraphs, or between lines and words
+// if a paragraph is too long. The parts are numbered, e.g. "3 Protocol (2/3)".
+func splitSection(section Section, maxLength int) []Section {
+	if len(section.Text) <= maxLength {
+		return []Section{section}
+	}
+
+	var parts []string
+	var part strings.Builder
+	for _, paragraph := range strings.Split(section.Text, "\n\n") {
+		if part.Len() > 0 && part.Len()+len(paragraph)+2 > maxLength {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+
+		for len(paragraph) > maxLength {
+			cut := cutIndex(paragraph, maxLength)
+			parts = append(parts, strings.TrimSpace(paragraph[:cut]))
+			paragraph = strings.TrimSpace(paragraph[cut:])
+		}
+
+		if part.Len() > 0 {
+			part.WriteString("\n\n")
+		}
+		part.WriteString(paragraph)
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+
+	heading := section.Heading
+	if heading == "" {
+		heading = "Part"
+	}
+
+	sections := make([]Section, len(parts))
+	for i, text := range parts {
+		sections[i] = Section{Heading: fmt.Sprintf("%s (%d/%d)", heading, i+1, len(parts)), Text: text}
+	}
+
+	return sections
+}
+
+// cutIndex returns where to cut text to at most n bytes: after the last line break or space in the second half,
+// and otherwise at the last character boundary.
+func cutIndex(text string, n int) int {
+	if i := strings.LastIndexAny(text[:n], "\n "); i > n/2 {
+		return i + 1
+	}
+
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+
+	return n
+}
@@ -0,0 +1,80 @@
+package document
+
+import (
+	"strings"
+	"testing"
+)
+
+func headings(sections []Section) string {
+	names := make([]string, len(sections))
+	for i, section := range sections {
+		names[i] = section.Heading
+	}
+
+	return strings.Join(names, " | ")
+}
+
+func TestSections(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+
+	tests := []struct {
+		name      string
+		content   string
+		maxLength int
+		expected  string
+	}{
+		{
+			name:      "headings",
+			content:   "# FastPay\n\n" + long + "\n\n## 1 Introduction\n\n" + long + "\n\n## 2 Model\n\n" + long,
+			maxLength: 200,
+			expected:  "FastPay | 1 Introduction | 2 Model",
+		},
+		{
+			name:      "merged",
+			content:   "# A\n\nshort\n\n# B\n\nshort\n\n# C\n\nshort\n\n# D\n\nshort",
+			maxLength: 200,
+			expected:  "A – D",
+		},
+		{
+			name:      "code block",
+			content:   "# A\n\n```\n# not a heading\n" + long + "\n```\n\n# B\n\n" + long,
+			maxLength: 200,
+			expected:  "A | B",
+		},
+		{
+			name:      "pages",
+			content:   long + "\f" + long + "\f\f" + long,
+			maxLength: 200,
+			expected:  "Page 1 | Page 2 | Page 4",
+		},
+		{
+			name:      "paragraphs",
+			content:   "# A\n\n" + long + "\n\n" + long,
+			maxLength: 200,
+			expected:  "A (1/2) | A (2/2)",
+		},
+		{
+			name:      "no structure",
+			content:   strings.Repeat(long, 3),
+			maxLength: 200,
+			expected:  "Part (1/3) | Part (2/3) | Part (3/3)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := &Document{Content: []byte(test.content)}
+
+			sections := doc.Sections(test.maxLength)
+			if got := headings(sections); got != test.expected {
+				t.Errorf("unexpected sections: %s", got)
+			}
+
+			for _, section := range sections {
+				if len(section.Text) > test.maxLength {
+					t.Errorf("section %s is %d bytes long", section.Heading, len(section.Text))
+				}
+			}
+		})
+	}
+}
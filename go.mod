@@ -3,6 +3,7 @@ module github.com/mempirate/scholar
 go 1.22.7
 
 require (
+	github.com/bwmarrin/discordgo v0.29.0
 	github.com/mendableai/firecrawl-go v1.0.0
 	github.com/openai/openai-go v0.1.0-alpha.41
 	github.com/pkg/errors v0.9.1
@@ -22,5 +23,6 @@ require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
+	golang.org/x/crypto v0.25.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 )
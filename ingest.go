@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/content"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/store"
+)
+
+var (
+	// errDuplicate is returned when a document that already exists is ingested with de-duplication enabled.
+	errDuplicate = errors.New("file already exists")
+	// errUnsupported is returned when the content at a URL can't be handled.
+	errUnsupported = errors.New("content type not supported")
+)
+
+// validateWebURL parses a link given by a user, API client or the assistant. Only web URLs are accepted, so they
+// can't make the server read its own files.
+func validateWebURL(raw string) (*url.URL, error) {
+	uri, err := url.Parse(raw)
+	if err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || uri.Host == "" {
+		return nil, fmt.Errorf("invalid URL: %s", raw)
+	}
+
+	return uri, nil
+}
+
+// urlHandler fetches the content at a URL as a document. *content.ContentHandler handles web pages, PDFs, tweets and
+// local files.
+type urlHandler interface {
+	HandleURL(uri *url.URL) (*document.Document, error)
+}
+
+// ingester runs the ingestion pipeline: download, convert to markdown, store locally and upload to the vector store.
+type ingester struct {
+	log zerolog.Logger
+
+	contentHandler urlHandler
+	fileStore      *store.FileStore
+	backend        backend.ScholarBackend
+
+	// concurrency is the number of URLs ingested concurrently.
+	concurrency int
+}
+
+// ingestOptions control where an ingested document ends up.
+type ingestOptions struct {
+	// Dedup makes ingestion fail with errDuplicate if the document is already in the scope's library.
+	Dedup bool
+	// Scope is the library the document is added to.
+	Scope store.Scope
+	// UploadedBy is the user that added the document.
+	UploadedBy string
+}
+
+// Ingest ingests the content at the given URL and returns the document with its file name. If opts.Dedup is set,
+// errDuplicate is returned when the document is already in the store. progress is optional.
+func (i *ingester) Ingest(ctx context.Context, uri *url.URL, opts ingestOptions, progress progressFunc) (*document.Document, string, error) {
+	if progress == nil {
+		progress = func(*url.URL, stage) {}
+	}
+
+	return i.ingest(ctx, uri, func() (*document.Document, error) { return i.contentHandler.HandleURL(uri) }, opts, progress)
+}
+
+// IngestUpload ingests an uploaded markdown or text file. Its source is the hash of its content, so the same file
+// isn't added twice, and the result has it as its URL.
+func (i *ingester) IngestUpload(ctx context.Context, name string, body []byte, opts ingestOptions) ingestResult {
+	sum := sha256.Sum256(body)
+	uri := &url.URL{Scheme: "upload", Opaque: "sha256:" + hex.EncodeToString(sum[:])}
+
+	doc, fileName, err := i.ingest(ctx, uri, func() (*document.Document, error) {
+		return content.NewFileDocument(name, body, uri.String()), nil
+	}, opts, func(*url.URL, stage) {})
+	if err != nil && !errors.Is(err, errDuplicate) {
+		i.log.Error().Err(err).Str("name", name).Msg("Failed to ingest upload")
+	}
+
+	return ingestResult{URL: uri, Doc: doc, FileName: fileName, Err: err}
+}
+
+// ingest runs the pipeline for the document of the source uri, which fetch returns.
+func (i *ingester) ingest(ctx context.Context, uri *url.URL, fetch func() (*document.Document, error), opts ingestOptions, progress progressFunc) (*document.Document, string, error) {
+	fileStore := i.fileStore.Scoped(opts.Scope)
+
+	// Known links aren't fetched again.
+	if opts.Dedup {
+		if doc, name := findSource(fileStore, uri.String()); doc != nil {
+			i.log.Info().Str("name", name).Str("url", uri.String()).Msg("Link already in the library, skipping")
+			return doc, name, errDuplicate
+		}
+	}
+
+	progress(uri, stageFetching)
+	doc, err := fetch()
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to handle URL %s", uri)
+	}
+
+	if doc == nil {
+		i.log.Info().Str("url", uri.String()).Msg("Nil content")
+		return nil, "", errUnsupported
+	}
+
+	if opts.Dedup {
+		contains, err := fileStore.Contains(doc.FileName())
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to check if content exists")
+		}
+
+		if contains {
+			i.log.Info().Str("name", doc.FileName()).Msg("File already exists, skipping")
+			return doc, doc.FileName(), errDuplicate
+		}
+	}
+
+	doc.Metadata.UploadedBy = opts.UploadedBy
+	setScope(&doc.Metadata, opts.Scope)
+
+	progress(uri, stageConverting)
+	fileName, file, err := doc.ToMarkdown()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to convert content to markdown")
+	}
+
+	if err := fileStore.Store(fileName, bytes.NewReader(file)); err != nil {
+		return nil, "", errors.Wrap(err, "failed to store file locally")
+	}
+
+	localFile, err := fileStore.Get(fileName)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to get local file")
+	}
+
+	defer localFile.Close()
+
+	progress(uri, stageUploading)
+	if err := i.backend.UploadFile(ctx, opts.Scope, fileName, localFile); err != nil {
+		return nil, "", err
+	}
+
+	return doc, fileName, nil
+}
+
+// setScope sets the visibility of a document of the given scope.
+func setScope(metadata *document.Metadata, scope store.Scope) {
+	if scope.IsPublic() {
+		return
+	}
+
+	metadata.Visibility = scope.Visibility
+	if scope.Visibility == document.VisibilityChannel {
+		metadata.Channel = scope.ID
+	}
+}
+
+// ingestResult is the outcome of ingesting a single URL.
+type ingestResult struct {
+	URL      *url.URL
+	Doc      *document.Document
+	FileName string
+	Err      error
+}
+
+// IngestAll ingests the given URLs concurrently. Results are returned in the same order as the URLs.
+// progress is optional, and is called with stageDone once a URL has been processed.
+func (i *ingester) IngestAll(ctx context.Context, urls []*url.URL, opts ingestOptions, progress progressFunc) []ingestResult {
+	results := make([]ingestResult, len(urls))
+
+	eg := errgroup.Group{}
+	eg.SetLimit(i.concurrency)
+
+	for idx, uri := range urls {
+		eg.Go(func() error {
+			doc, fileName, err := i.Ingest(ctx, uri, opts, progress)
+			if err != nil && !errors.Is(err, errDuplicate) {
+				i.log.Error().Err(err).Str("url", uri.String()).Msg("Failed to ingest URL")
+			}
+
+			if progress != nil {
+				progress(uri, stageDone)
+			}
+
+			results[idx] = ingestResult{URL: uri, Doc: doc, FileName: fileName, Err: err}
+			return nil
+		})
+	}
+
+	eg.Wait()
+
+	return results
+}
+
+// formatResults renders ingestion results as a Slack list, one line per URL.
+func formatResults(results []ingestResult) string {
+	sb := strings.Builder{}
+	for _, res := range results {
+		switch {
+		case res.Err == nil:
+			fmt.Fprintf(&sb, "• %s [%s]\n", res.Doc.FindTitle(), res.Doc.Metadata.Source)
+		case errors.Is(res.Err, errDuplicate):
+			fmt.Fprintf(&sb, "• %s: already in the library\n", res.URL)
+		case errors.Is(res.Err, errUnsupported):
+			fmt.Fprintf(&sb, "• %s: content type not supported\n", res.URL)
+		default:
+			fmt.Fprintf(&sb, "• %s: failed (%s)\n", res.URL, res.Err)
+		}
+	}
+
+	return sb.String()
+}
+
+// findSource returns the document of the library that was ingested from the given source URL, with its file name.
+func findSource(fileStore store.LocalStore, source string) (*document.Document, string) {
+	names, err := fileStore.List()
+	if err != nil {
+		return nil, ""
+	}
+
+	for _, name := range names {
+		doc, err := readDocument(fileStore, name)
+		if err == nil && doc.Metadata.Source == source && doc.Metadata.Type != document.TypeSummary {
+			return doc, name
+		}
+	}
+
+	return nil, ""
+}
+
+// inLibrary returns the results whose document is in the library: the ingested ones, and the duplicates.
+func inLibrary(results []ingestResult) []ingestResult {
+	docs := make([]ingestResult, 0, len(results))
+	for _, res := range results {
+		if res.Err == nil || errors.Is(res.Err, errDuplicate) {
+			docs = append(docs, res)
+		}
+	}
+
+	return docs
+}
+
+// succeeded returns the results that were ingested without error.
+func succeeded(results []ingestResult) []ingestResult {
+	ok := make([]ingestResult, 0, len(results))
+	for _, res := range results {
+		if res.Err == nil {
+			ok = append(ok, res)
+		}
+	}
+
+	return ok
+}
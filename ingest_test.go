@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/store"
+)
+
+func TestFindSource(t *testing.T) {
+	fs := store.NewFileStore(t.TempDir())
+
+	for _, doc := range []*document.Document{
+		{Content: []byte("# Summary\n"), Metadata: document.Metadata{Title: "Summary of Bitcoin", Source: "https://bitcoin.org/bitcoin.pdf", Type: document.TypeSummary}},
+		{Content: []byte("# Bitcoin\n"), Metadata: document.Metadata{Source: "https://bitcoin.org/bitcoin.pdf", Type: document.TypePDF}},
+	} {
+		name, markdown, err := doc.ToMarkdown()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fs.Store(name, strings.NewReader(string(markdown))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Summaries derived from a link aren't the link's document.
+	if doc, name := findSource(fs, "https://bitcoin.org/bitcoin.pdf"); doc == nil || name != "Bitcoin.md" {
+		t.Errorf("unexpected document %s", name)
+	}
+
+	if doc, _ := findSource(fs, "https://ethereum.org/whitepaper.pdf"); doc != nil {
+		t.Error("unexpected document for an unknown link")
+	}
+}
+
+func TestValidateWebURL(t *testing.T) {
+	for _, raw := range []string{"https://arxiv.org/abs/2003.11506", "http://example.com/paper.pdf"} {
+		if _, err := validateWebURL(raw); err != nil {
+			t.Errorf("%s: %v", raw, err)
+		}
+	}
+
+	for _, raw := range []string{"file:///etc/passwd", "ftp://example.com/paper.pdf", "https://", "/etc/passwd", "%zz"} {
+		if _, err := validateWebURL(raw); err == nil {
+			t.Errorf("%s: expected an error", raw)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/store"
+)
+
+// documentInfo describes a document in JSON output.
+type documentInfo struct {
+	File       string              `json:"file"`
+	Title      string              `json:"title,omitempty"`
+	Source     string              `json:"source,omitempty"`
+	Type       string              `json:"type,omitempty"`
+	Visibility document.Visibility `json:"visibility,omitempty"`
+	Processed  string              `json:"processed,omitempty"`
+	// DerivedFrom are the source documents of derived documents, like summaries.
+	DerivedFrom []string `json:"derivedFrom,omitempty"`
+	// Snippet is the text around the first match, for search results.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+func newDocumentInfo(name string, doc *document.Document) documentInfo {
+	return documentInfo{
+		File:        name,
+		Title:       doc.FindTitle(),
+		Source:      doc.Metadata.Source,
+		Type:        doc.Metadata.Type,
+		Visibility:  doc.Metadata.Visibility,
+		Processed:   doc.Metadata.ProcessedTime,
+		DerivedFrom: doc.Metadata.DerivedFrom,
+	}
+}
+
+// listDocuments returns the documents in the local store with their metadata.
+func listDocuments(fileStore store.LocalStore) ([]documentInfo, error) {
+	names, err := fileStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]documentInfo, 0, len(names))
+	for _, name := range names {
+		doc, err := readDocument(fileStore, name)
+		if err != nil {
+			docs = append(docs, documentInfo{File: name})
+			continue
+		}
+
+		docs = append(docs, newDocumentInfo(name, doc))
+	}
+
+	return docs, nil
+}
+
+// readDocument reads and parses a document from the store.
+func readDocument(fileStore store.LocalStore, name string) (*document.Document, error) {
+	f, err := fileStore.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	markdown, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return document.Parse(markdown)
+}
+
+// readStructuredSummary reads the structured summary stored next to a document.
+func readStructuredSummary(fileStore store.LocalStore, name string) (*prompt.StructuredSummary, error) {
+	f, err := fileStore.Get(store.SidecarName(name, store.SidecarSummary))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var summary prompt.StructuredSummary
+	if err := json.NewDecoder(f).Decode(&summary); err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+// storeStructuredSummary stores a structured summary next to its document, replacing the previous one.
+func storeStructuredSummary(fileStore store.LocalStore, summary *prompt.StructuredSummary) error {
+	data, err := summary.MarshalIndent()
+	if err != nil {
+		return err
+	}
+
+	return fileStore.Store(store.SidecarName(summary.File, store.SidecarSummary), bytes.NewReader(data))
+}
+
+// SNIPPET_LENGTH is the number of characters shown around a search match.
+const SNIPPET_LENGTH = 200
+
+// searchDocuments returns the documents whose title, source, content or structured summary contain all words of
+// the query, ignoring case. This is a plain text search of the local store, unlike the semantic search of the assistant.
+func searchDocuments(fileStore store.LocalStore, query string) ([]documentInfo, error) {
+	words := strings.Fields(strings.ToLower(query))
+
+	names, err := fileStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]documentInfo, 0)
+	for _, name := range names {
+		doc, err := readDocument(fileStore, name)
+		if err != nil {
+			continue
+		}
+
+		content := string(doc.Content)
+		haystack := strings.ToLower(doc.FindTitle() + "\n" + doc.Metadata.Source + "\n" + content)
+		if summary, err := readStructuredSummary(fileStore, name); err == nil {
+			haystack += "\n" + strings.ToLower(summary.Text())
+		}
+
+		matches := true
+		for _, word := range words {
+			if !strings.Contains(haystack, word) {
+				matches = false
+				break
+			}
+		}
+
+		if !matches {
+			continue
+		}
+
+		info := newDocumentInfo(name, doc)
+		if len(words) > 0 {
+			info.Snippet = snippet(content, words[0])
+		}
+
+		docs = append(docs, info)
+	}
+
+	return docs, nil
+}
+
+// snippet returns the text around the first occurrence of word in content, on a single line.
+func snippet(content, word string) string {
+	// Lowercasing can change the length of some characters, so the index is only approximate.
+	idx := strings.Index(strings.ToLower(content), word)
+	if idx < 0 || idx >= len(content) {
+		return ""
+	}
+
+	start := max(0, idx-SNIPPET_LENGTH/2)
+	end := min(len(content), idx+len(word)+SNIPPET_LENGTH/2)
+
+	// Don't cut multi-byte characters in half.
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	return strings.Join(strings.Fields(content[start:end]), " ")
+}
+
+// validDocumentName returns true if name refers to a document in a store, and not to a path outside of it.
+func validDocumentName(name string) bool {
+	return name != "" && name == filepath.Base(name) && filepath.Ext(name) == ".md"
+}
@@ -2,6 +2,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -10,9 +11,20 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func NewLogger(module string) zerolog.Logger {
+// out is where loggers write to.
+var out io.Writer = os.Stdout
+
+// SetOutput changes where loggers created afterwards write to, e.g. stderr when stdout is used for command output.
+func SetOutput(w io.Writer) {
+	out = w
+}
+
+func init() {
 	zerolog.TimeFieldFormat = time.RFC3339Nano
-	output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: "15:04:05.000"}
+}
+
+func NewLogger(module string) zerolog.Logger {
+	output := zerolog.ConsoleWriter{Out: out, TimeFormat: "15:04:05.000"}
 	output.FormatMessage = func(i interface{}) string {
 		return fmt.Sprintf("%-45s", fmt.Sprintf("[%s] %s", strings.ToUpper(module), i))
 	}
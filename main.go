@@ -1,42 +1,117 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/access"
 	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/config"
 	"github.com/mempirate/scholar/content"
+	"github.com/mempirate/scholar/discord"
 	"github.com/mempirate/scholar/log"
-	"github.com/mempirate/scholar/prompt"
 	"github.com/mempirate/scholar/scrape"
 	"github.com/mempirate/scholar/slack"
 	"github.com/mempirate/scholar/store"
-	"github.com/openai/openai-go"
 )
 
-const OPENAI_MODEL = openai.ChatModelGPT4oMini
-
 var (
-	dataDir = flag.String("data-dir", defaultDataDir(), "Directory to store learned file data. This directory will mirror what's in the vector store.")
+	configPath      = flag.String("config", os.Getenv("SCHOLAR_CONFIG"), "Path to the configuration file (YAML). Settings can be overridden with SCHOLAR_* environment variables.")
+	dataDir         = flag.String("data-dir", defaultDataDir(), "Directory to store learned file data. This directory will mirror what's in the vector store.")
+	ingestReaction  = flag.String("ingest-reaction", "", "Emoji (without colons) that saves the links in a message to Scholar when used as a reaction. Overrides slack.ingest_reaction in the configuration (default \""+slack.DefaultIngestReaction+"\").")
+	slackMode       = flag.String("slack-mode", "socket", "How to receive Slack events: \"socket\" (Socket Mode) or \"http\" (Events API, requires SLACK_SIGNING_SECRET).")
+	httpAddr        = flag.String("http-addr", ":3000", "Address to listen on for Slack requests in HTTP mode, the OAuth install flow, the REST API and MCP.")
+	accessConfig    = flag.String("access-config", "", "Path to the access control policy (YAML). Reloaded on SIGHUP. If empty, everyone can use Scholar everywhere.")
+	oauthRedirect   = flag.String("oauth-redirect-url", "", "Public URL of the OAuth callback (https://<host>/slack/oauth/callback). Required if SLACK_CLIENT_ID is set.")
+	apiKeysConfig   = flag.String("api-keys", "", "Path to the API keys (YAML). If empty, the REST API and the MCP endpoint are disabled.")
+	discordReaction = flag.String("discord-ingest-reaction", discord.DefaultIngestReaction, "Emoji that saves the links in a message to Scholar when used as a reaction on Discord.")
 )
 
 func main() {
-	flag.Parse()
+	flag.Usage = usage
+
+	// The first argument selects the command. Without one, Scholar runs the chat bots.
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	if command == "help" {
+		usage()
+		return
+	}
+
+	if command == "serve" {
+		if _, err := parseArgs(flag.CommandLine, args); err != nil {
+			os.Exit(2)
+		}
+		serve()
+		return
+	}
 
+	if _, ok := cliCommands[command]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+
+	if err := runCLI(context.Background(), command, args); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the chat frontends until the process is stopped.
+func serve() {
 	key, appToken, botToken, fcKey := os.Getenv("OPENAI_API_KEY"), os.Getenv("SLACK_APP_TOKEN"), os.Getenv("SLACK_BOT_TOKEN"), os.Getenv("FIRECRAWL_API_KEY")
-	if key == "" || appToken == "" || botToken == "" || fcKey == "" {
-		panic("OPENAI_API_KEY || SLACK_APP_TOKEN || SLACK_BOT_TOKEN | FIRECRAWL_API_KEY is not set")
+	// With OAuth, workspaces bring their own bot token and SLACK_BOT_TOKEN is optional.
+	clientID, clientSecret := os.Getenv("SLACK_CLIENT_ID"), os.Getenv("SLACK_CLIENT_SECRET")
+	discordToken := os.Getenv("DISCORD_BOT_TOKEN")
+	if key == "" || (botToken == "" && clientID == "" && discordToken == "") || fcKey == "" {
+		panic("OPENAI_API_KEY || SLACK_BOT_TOKEN (or SLACK_CLIENT_ID or DISCORD_BOT_TOKEN) | FIRECRAWL_API_KEY is not set")
+	}
+
+	if clientID != "" && (clientSecret == "" || *oauthRedirect == "") {
+		panic("SLACK_CLIENT_SECRET and -oauth-redirect-url are required with SLACK_CLIENT_ID")
+	}
+
+	slackEnabled := botToken != "" || clientID != ""
+
+	signingSecret := os.Getenv("SLACK_SIGNING_SECRET")
+	switch *slackMode {
+	case "socket":
+		if slackEnabled && appToken == "" {
+			panic("SLACK_APP_TOKEN is not set")
+		}
+	case "http":
+		if slackEnabled && signingSecret == "" {
+			panic("SLACK_SIGNING_SECRET is not set")
+		}
+	default:
+		panic(fmt.Sprintf("unknown Slack mode: %s", *slackMode))
 	}
 
 	log := log.NewLogger("main")
 
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
 	// Expand environment variables in datadir
 	dataDir := os.ExpandEnv(*dataDir)
-	fileStore := store.NewFileStore(dataDir)
 
 	log.Info().Str("dataDir", dataDir).Msg("Using data directory")
 
@@ -45,156 +120,151 @@ func main() {
 		panic(err)
 	}
 
-	backend := backend.NewBackend(key, OPENAI_MODEL, fileStore)
-
 	ctx := context.Background()
 
-	if err := backend.Init(ctx); err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize backend")
+	authorizer, err := access.NewAuthorizer(*accessConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load access policy")
+	}
+
+	go reloadOnSignal(log, authorizer)
+
+	var frontends []chat.Frontend
+
+	// Slack and the REST API share a single HTTP server.
+	mux := http.NewServeMux()
+	serveHTTP := false
+
+	// The default workspace is the Slack workspace SLACK_BOT_TOKEN belongs to.
+	defaultTeamID := func() string { return "" }
+
+	if slackEnabled {
+		var slackHandler *slack.SlackHandler
+		if *slackMode == "http" {
+			slackHandler = slack.NewHTTPSlackHandler(botToken, signingSecret, dataDir, cfg.Slack)
+		} else {
+			slackHandler = slack.NewSlackHandler(appToken, botToken, dataDir, cfg.Slack)
+		}
+
+		if clientID != "" {
+			if err := slackHandler.EnableOAuth(slack.OAuthConfig{ClientID: clientID, ClientSecret: clientSecret, RedirectURL: *oauthRedirect}, dataDir); err != nil {
+				log.Fatal().Err(err).Msg("Failed to enable OAuth")
+			}
+		}
+
+		if slackHandler.ServesHTTP() {
+			mux.Handle("/slack/", slackHandler.Handler())
+			serveHTTP = true
+		}
+
+		defaultTeamID = slackHandler.DefaultTeamID
+		frontends = append(frontends, slackHandler)
 	}
-	log.Info().Msg("Backend initialized")
 
-	slackHandler := slack.NewSlackHandler(appToken, botToken)
-	commands := slackHandler.SubscribeCommands()
-	events := slackHandler.SubscribeEvents()
+	if discordToken != "" {
+		discordHandler, err := discord.NewDiscordHandler(discordToken, *discordReaction)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create Discord handler")
+		}
 
-	go slackHandler.Start()
+		frontends = append(frontends, discordHandler)
+	}
+
+	for _, fe := range frontends {
+		go fe.Start()
+	}
 
-	fc, err := scrape.NewFirecrawlScraper(fcKey)
+	fc, err := scrape.NewFirecrawlScraper(fcKey, cfg.Firecrawl)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create Firecrawl scraper")
 	}
 
 	contentHandler := content.NewContentHandler(fc)
 
-	for {
-		select {
-		case cmd := <-commands:
-			// Handle content extraction
-			content, err := contentHandler.HandleURL(cmd.URL)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to handle URL")
-				slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, fmt.Sprintf("Failed to handle URL: %s (%s)", cmd.URL, err))
-				continue
-			}
+	tenants := newTenants(log, key, dataDir, cfg, contentHandler, newSearchProvider(cfg.Research, fcKey), defaultTeamID)
+
+	// The default workspace is initialized up front, other workspaces on their first event.
+	if botToken != "" {
+		if _, err := tenants.Get(ctx, ""); err == nil {
+			log.Info().Msg("Backend initialized")
+		} else if backend.IsTransient(err) {
+			// Workspaces that failed to initialize are initialized again on their next event.
+			log.Error().Err(err).Msg("Failed to initialize backend, retrying on the next event")
+		} else {
+			log.Fatal().Err(err).Msg("Failed to initialize backend")
+		}
+	}
 
-			if content == nil {
-				log.Info().Str("url", cmd.URL.String()).Msg("Nil content")
-				slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, "Content type not supported")
-				continue
-			}
+	if *apiKeysConfig != "" {
+		keys, err := loadAPIKeys(*apiKeysConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load API keys")
+		}
 
-			// We only de-duplicate uploads. If someone wants to summarize a file that already exists, we'll allow it, but we won't upload it again.
-			if cmd.CommandType == slack.UploadCommand {
-				// Handle content de-duplication
-				contains, err := fileStore.Contains(content.FileName())
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to check if content exists")
-					continue
-				}
-
-				if contains {
-					log.Info().Str("name", content.FileName()).Msg("File already exists, skipping")
-					slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, "This file already exists.")
-					continue
-				}
-			}
+		api := &apiServer{log: log.With().Str("component", "api").Logger(), keys: keys, authorizer: authorizer, tenants: tenants}
+		mux.Handle(API_PREFIX+"/", api.Handler())
+		mux.Handle(MCP_PATH, api.MCPHandler())
+		serveHTTP = true
+	}
 
-			fileName, file, err := content.ToMarkdown()
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to convert content to markdown")
-				slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, fmt.Sprintf("Failed to convert content to markdown: %s", err))
-				continue
-			}
+	if serveHTTP {
+		go func() {
+			log.Info().Str("addr", *httpAddr).Msg("Listening for HTTP requests")
 
-			local := bytes.NewReader(file)
-			if err := fileStore.Store(fileName, local); err != nil {
-				log.Error().Err(err).Msg("Failed to store file locally")
-				slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, err.Error())
-				continue
+			server := &http.Server{Addr: *httpAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+			if err := server.ListenAndServe(); err != nil {
+				log.Fatal().Err(err).Msg("HTTP server failed")
 			}
+		}()
+	}
 
-			localFile, err := fileStore.Get(fileName)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to get local file")
-				slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, err.Error())
-				continue
-			}
+	app := &app{log: log, authorizer: authorizer, tenants: tenants, workers: cfg.Chat.Workers}
+	app.Run(ctx, frontends)
+}
 
-			if err := backend.UploadFile(ctx, fileName, localFile); err != nil {
-				log.Error().Err(err).Msg("Failed to upload file")
-				slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, err.Error())
-				continue
-			}
+// reloadOnSignal reloads the access policy whenever the process receives SIGHUP.
+func reloadOnSignal(log zerolog.Logger, authorizer *access.Authorizer) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
 
-			localFile.Close()
+	for range sighup {
+		if err := authorizer.Reload(); err != nil {
+			log.Error().Err(err).Msg("Failed to reload access policy, keeping the current one")
+			continue
+		}
 
-			threadID, err := slackHandler.StartUploadThread(cmd.ChannelID, cmd.UserID, fmt.Sprintf("%s [%s]", content.FindTitle(), content.Metadata.Source))
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to start upload thread")
-				slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, fmt.Sprintf("Failed to start upload thread: %s", err))
-				continue
-			}
+		log.Info().Msg("Access policy reloaded")
+	}
+}
 
-			if err := backend.CreateThread(ctx, threadID); err != nil {
-				log.Error().Err(err).Msg("Failed to create thread")
-				slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, fmt.Sprintf("Failed to create thread: %s", err))
-				continue
-			}
+// loadConfig loads the configuration file, and applies the flags that override it.
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return nil, err
+	}
 
-			if cmd.CommandType == slack.SummarizeCommand {
-				summary, err := backend.Prompt(ctx, threadID, prompt.SUMMARY_PROMPT_INSTRUCTIONS, prompt.CreateSummaryPrompt(fileName))
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to prompt for summary")
-					slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, fmt.Sprintf("Failed to prompt for summary: %s", err))
-					continue
-				}
-
-				if err := slackHandler.PostMessage(cmd.ChannelID, &threadID, summary); err != nil {
-					log.Error().Err(err).Msg("Failed to post summary")
-					slackHandler.PostEphemeral(cmd.ChannelID, cmd.UserID, fmt.Sprintf("Failed to post summary: %s", err))
-					continue
-				}
-			}
-		case event := <-events:
-			switch event.Type {
-			case slack.MessageEvent:
-				// TODO:
-				// Upload messages to the assistant context as JSON objects:
-				// {
-				//  "type": "message",
-				//  "messageId": "1635732824.000100", // To refer to messages later
-				// 	"channelId": "C01B2PZQX1Z",
-				// 	"threadId": "1635732824.000100",
-				//  "userId": "U01B2PZQX1Z", // To refer to users. Does this need username as well?
-				//  "text": "Hello, world!"
-				// }
-			case slack.MentionEvent:
-				err := backend.CreateThread(ctx, event.ThreadID)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to create thread")
-					slackHandler.PostEphemeral(event.ChannelID, event.UserID, err.Error())
-					continue
-				}
-
-				log.Info().Str("user_id", event.UserID).Str("channel_id", event.ChannelID).Str("thread_id", event.ThreadID).Msg("New mention")
-
-				reply, err := backend.Prompt(ctx, event.ThreadID, prompt.MENTION_PROMPT_INSTRUCTIONS, prompt.CreateMentionPrompt(event.Text, event.ChannelID, event.ThreadID, event.UserID))
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to prompt assistant")
-					slackHandler.PostEphemeral(event.ChannelID, event.UserID, err.Error())
-					continue
-				}
-
-				if err := slackHandler.PostMessage(event.ChannelID, &event.ThreadID, reply); err != nil {
-					log.Error().Err(err).Msg("Failed to post message")
-					continue
-				}
+	if *ingestReaction != "" {
+		cfg.Slack.IngestReaction = *ingestReaction
+	}
 
-			}
+	return cfg, nil
+}
+
+// loadAPIKeys loads the API keys and checks that their libraries are valid.
+func loadAPIKeys(path string) (*access.APIKeys, error) {
+	keys, err := access.LoadAPIKeys(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys.Keys {
+		if _, err := store.ParseScope(key.Scope); err != nil {
+			return nil, fmt.Errorf("API key %s: %w", key.Name, err)
 		}
 	}
 
+	return keys, nil
 }
 
 func defaultDataDir() string {
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/mcp"
+)
+
+const (
+	// MCP_PATH is the path of the MCP endpoint.
+	MCP_PATH = "/mcp"
+	// MCP_SERVER_VERSION is the version the MCP server reports to clients.
+	MCP_SERVER_VERSION = "1.0.0"
+	// DOCUMENT_URI_PREFIX is the prefix of document resource URIs.
+	DOCUMENT_URI_PREFIX = "scholar://documents/"
+	// MAX_SEARCH_RESULTS is the default number of documents returned by search_library.
+	MAX_SEARCH_RESULTS = 20
+)
+
+const mcpInstructions = `Scholar is a shared research library of papers, articles and posts. Use search_library to find documents,
+get_document or the document resources to read them, and ask_scholar for answers that draw on the whole library.`
+
+// mcpLibrary exposes a caller's library to MCP clients. Tools are authorized like the equivalent chat commands.
+type mcpLibrary struct {
+	authorizer *access.Authorizer
+	tenants    *tenants
+	caller     *caller
+}
+
+// newMCPServer returns an MCP server for the caller's library.
+func newMCPServer(authorizer *access.Authorizer, tenants *tenants, c *caller) *mcp.Server {
+	lib := &mcpLibrary{authorizer: authorizer, tenants: tenants, caller: c}
+
+	server := mcp.NewServer("scholar", MCP_SERVER_VERSION, mcpInstructions)
+	server.AddTool(mcp.Tool{
+		Name:        "search_library",
+		Description: "Search the library. Returns the documents whose title, source or content contain every word of the query, with their file name. Without a query, lists all documents.",
+		InputSchema: objectSchema(map[string]any{
+			"query": stringSchema("Words to search for."),
+			"limit": map[string]any{"type": "integer", "description": fmt.Sprintf("Maximum number of documents to return. Defaults to %d.", MAX_SEARCH_RESULTS)},
+		}),
+		Handler: lib.searchLibrary,
+	})
+	server.AddTool(mcp.Tool{
+		Name:        "get_document",
+		Description: "Get a document's markdown, including its front matter (title, source, authors...).",
+		InputSchema: objectSchema(map[string]any{"file": stringSchema("The file name of the document, as returned by search_library.")}, "file"),
+		Handler:     lib.getDocument,
+	})
+	server.AddTool(mcp.Tool{
+		Name:        "ingest_url",
+		Description: "Add a web page, PDF or tweet to the library.",
+		InputSchema: objectSchema(map[string]any{"url": stringSchema("The http or https URL to add.")}, "url"),
+		Handler:     lib.ingestURL,
+	})
+	server.AddTool(mcp.Tool{
+		Name:        "ask_scholar",
+		Description: "Ask Scholar's assistant a question. It answers with citations from the library. Pass the returned threadId to ask follow-up questions.",
+		InputSchema: objectSchema(map[string]any{
+			"question": stringSchema("The question."),
+			"threadId": stringSchema("Continue a previous conversation."),
+		}, "question"),
+		Handler: lib.askScholar,
+	})
+	server.SetResources(lib)
+
+	return server
+}
+
+// MCPHandler returns the HTTP handler of the MCP server. Clients authenticate with an API key, like for the REST API.
+func (s *apiServer) MCPHandler() http.Handler {
+	return mcp.HTTPHandler(func(w http.ResponseWriter, r *http.Request) *mcp.Server {
+		c := s.authenticate(w, r)
+		if c == nil {
+			return nil
+		}
+
+		return newMCPServer(s.authorizer, s.tenants, c)
+	})
+}
+
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func stringSchema(description string) map[string]any {
+	return map[string]any{"type": "string", "description": description}
+}
+
+func toJSON(v any) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	return string(data), err
+}
+
+// readMarkdown returns the stored markdown of a document in the caller's library.
+func (l *mcpLibrary) readMarkdown(name string) (string, error) {
+	fileStore := l.tenants.FileStore(l.caller.team).Scoped(l.caller.scope)
+
+	if !validDocumentName(name) {
+		return "", fmt.Errorf("invalid document name: %s", name)
+	}
+
+	if ok, err := fileStore.Contains(name); err != nil || !ok {
+		return "", fmt.Errorf("no such document: %s", name)
+	}
+
+	f, err := fileStore.Get(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	markdown, err := io.ReadAll(f)
+	return string(markdown), err
+}
+
+func (l *mcpLibrary) searchLibrary(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", err
+	}
+
+	if err := l.caller.authorize(l.authorizer, access.ActionAsk); err != nil {
+		return "", err
+	}
+
+	fileStore := l.tenants.FileStore(l.caller.team).Scoped(l.caller.scope)
+
+	var docs []documentInfo
+	var err error
+	if strings.TrimSpace(p.Query) == "" {
+		docs, err = listDocuments(fileStore)
+	} else {
+		docs, err = searchDocuments(fileStore, p.Query)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if p.Limit <= 0 {
+		p.Limit = MAX_SEARCH_RESULTS
+	}
+
+	return toJSON(map[string]any{"total": len(docs), "documents": docs[:min(len(docs), p.Limit)]})
+}
+
+func (l *mcpLibrary) getDocument(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		File string `json:"file"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", err
+	}
+
+	if err := l.caller.authorize(l.authorizer, access.ActionAsk); err != nil {
+		return "", err
+	}
+
+	return l.readMarkdown(p.File)
+}
+
+func (l *mcpLibrary) ingestURL(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", err
+	}
+
+	if err := l.caller.authorize(l.authorizer, access.ActionIngest); err != nil {
+		return "", err
+	}
+
+	uri, err := validateWebURL(p.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if l.tenants.contentHandler == nil {
+		return "", errors.New("ingestion is not configured")
+	}
+
+	tenant, err := l.tenants.Get(ctx, l.caller.team)
+	if err != nil {
+		return "", err
+	}
+
+	opts := ingestOptions{Dedup: true, Scope: l.caller.scope, UploadedBy: l.caller.user}
+	results := tenant.ingester.IngestAll(ctx, []*url.URL{uri}, opts, nil)
+
+	return toJSON(newIngestInfo(results[0]))
+}
+
+func (l *mcpLibrary) askScholar(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Question string `json:"question"`
+		ThreadID string `json:"threadId"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(p.Question) == "" {
+		return "", errors.New("question is required")
+	}
+
+	if err := l.caller.authorize(l.authorizer, access.ActionAsk); err != nil {
+		return "", err
+	}
+
+	threadID := p.ThreadID
+	if threadID != "" && !strings.HasPrefix(threadID, "mcp-") {
+		// Chat threads are only accessible from their own conversation.
+		return "", errors.New("threadId must be returned by a previous ask_scholar call")
+	}
+
+	tenant, err := l.tenants.Get(ctx, l.caller.team)
+	if err != nil {
+		return "", err
+	}
+
+	// Like over the API, only the client that started a thread can follow up on it.
+	if threadID == "" {
+		threadID = newCallerThreadID("mcp")
+		if err := tenant.threads.Put(threadID, l.caller); err != nil {
+			return "", fmt.Errorf("failed to record thread: %w", err)
+		}
+	} else if !tenant.threads.Owns(threadID, l.caller) {
+		return "", fmt.Errorf("no such thread: %s", threadID)
+	}
+
+	if err := tenant.backend.CreateThread(ctx, threadID, l.caller.scope); err != nil {
+		return "", err
+	}
+
+	instructions, err := tenant.assistantInstructions()
+	if err != nil {
+		return "", err
+	}
+
+	ctx = withConversation(ctx, &conversation{scope: l.caller.scope, userID: l.caller.user, command: "mcp"})
+	ctx = tenant.withModel(ctx, backend.TaskQuestion, "")
+	answer, err := tenant.backend.Prompt(ctx, threadID, instructions, p.Question)
+	if err != nil {
+		return "", err
+	}
+
+	return toJSON(map[string]string{"answer": answer, "threadId": threadID})
+}
+
+// List lists the documents of the caller's library as resources.
+func (l *mcpLibrary) List(ctx context.Context) ([]mcp.Resource, error) {
+	if err := l.caller.authorize(l.authorizer, access.ActionAsk); err != nil {
+		return nil, &mcp.Error{Code: mcp.CodeInvalidRequest, Message: err.Error()}
+	}
+
+	docs, err := listDocuments(l.tenants.FileStore(l.caller.team).Scoped(l.caller.scope))
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]mcp.Resource, len(docs))
+	for i, doc := range docs {
+		resources[i] = mcp.Resource{
+			URI:         DOCUMENT_URI_PREFIX + doc.File,
+			Name:        doc.File,
+			Title:       doc.Title,
+			Description: doc.Source,
+			MIMEType:    "text/markdown",
+		}
+	}
+
+	return resources, nil
+}
+
+// Read returns the markdown of a document resource.
+func (l *mcpLibrary) Read(ctx context.Context, uri string) (*mcp.ResourceContents, error) {
+	if err := l.caller.authorize(l.authorizer, access.ActionAsk); err != nil {
+		return nil, &mcp.Error{Code: mcp.CodeInvalidRequest, Message: err.Error()}
+	}
+
+	name, ok := strings.CutPrefix(uri, DOCUMENT_URI_PREFIX)
+	if !ok {
+		return nil, mcp.ErrResourceNotFound
+	}
+
+	markdown, err := l.readMarkdown(name)
+	if err != nil {
+		return nil, mcp.ErrResourceNotFound
+	}
+
+	return &mcp.ResourceContents{URI: uri, MIMEType: "text/markdown", Text: markdown}, nil
+}
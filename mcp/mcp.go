@@ -0,0 +1,288 @@
+// Package mcp implements a Model Context Protocol server, so that agents can use Scholar's tools and read its documents.
+//
+// Only the server side of the protocol is implemented: tools and resources, over stdio and HTTP. See
+// https://modelcontextprotocol.io/specification.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/log"
+)
+
+// LATEST_PROTOCOL_VERSION is the protocol version offered to clients that ask for an unsupported one.
+const LATEST_PROTOCOL_VERSION = "2025-06-18"
+
+// supportedVersions are the protocol versions the server can speak. The differences between them don't affect
+// the features used here.
+var supportedVersions = []string{"2024-11-05", "2025-03-26", LATEST_PROTOCOL_VERSION}
+
+// JSON-RPC error codes.
+const (
+	CodeParseError       = -32700
+	CodeInvalidRequest   = -32600
+	CodeMethodNotFound   = -32601
+	CodeInvalidParams    = -32602
+	CodeInternalError    = -32603
+	CodeResourceNotFound = -32002
+)
+
+// Error is a JSON-RPC error.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// ErrResourceNotFound is returned by Resources.Read for unknown URIs.
+var ErrResourceNotFound = &Error{Code: CodeResourceNotFound, Message: "resource not found"}
+
+// request is a JSON-RPC request, or a notification if it has no ID.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC response.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// ToolHandler runs a tool with the arguments sent by the client, and returns its text output. Errors are reported
+// to the model as the tool's output, so it can correct itself.
+type ToolHandler func(ctx context.Context, args json.RawMessage) (string, error)
+
+// Tool is a function that clients can call.
+type Tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// InputSchema is the JSON schema of the arguments.
+	InputSchema map[string]any `json:"inputSchema"`
+	Handler     ToolHandler    `json:"-"`
+}
+
+// Resource describes a document that clients can read.
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	MIMEType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceContents is the content of a resource.
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MIMEType string `json:"mimeType,omitempty"`
+	Text     string `json:"text"`
+}
+
+// Resources lists and reads the resources of a server.
+type Resources interface {
+	List(ctx context.Context) ([]Resource, error)
+	// Read returns ErrResourceNotFound if there is no resource with the given URI.
+	Read(ctx context.Context, uri string) (*ResourceContents, error)
+}
+
+// Server handles the messages of a single client session.
+type Server struct {
+	log zerolog.Logger
+
+	name         string
+	version      string
+	instructions string
+
+	tools     []Tool
+	resources Resources
+}
+
+// NewServer creates a server that introduces itself with the given name and version.
+func NewServer(name, version, instructions string) *Server {
+	return &Server{
+		log:          log.NewLogger("mcp"),
+		name:         name,
+		version:      version,
+		instructions: instructions,
+	}
+}
+
+// AddTool adds a tool to the server.
+func (s *Server) AddTool(tool Tool) {
+	s.tools = append(s.tools, tool)
+}
+
+// SetResources sets the resources of the server.
+func (s *Server) SetResources(resources Resources) {
+	s.resources = resources
+}
+
+// Handle handles a single JSON-RPC message and returns the response, or nil for notifications.
+func (s *Server) Handle(ctx context.Context, msg []byte) []byte {
+	var req request
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return s.encode(response{ID: json.RawMessage("null"), Error: &Error{Code: CodeParseError, Message: err.Error()}})
+	}
+
+	// Notifications (like notifications/initialized) and responses to server requests need no response.
+	if len(req.ID) == 0 {
+		return nil
+	}
+
+	res := response{ID: req.ID}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		res.Error = &Error{Code: CodeInvalidRequest, Message: "invalid JSON-RPC request"}
+		return s.encode(res)
+	}
+
+	result, err := s.dispatch(ctx, req)
+	if err != nil {
+		rpcErr, ok := err.(*Error)
+		if !ok {
+			s.log.Error().Err(err).Str("method", req.Method).Msg("Request failed")
+			rpcErr = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		res.Error = rpcErr
+	} else {
+		res.Result = result
+	}
+
+	return s.encode(res)
+}
+
+func (s *Server) encode(res response) []byte {
+	res.JSONRPC = "2.0"
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		data, _ = json.Marshal(response{JSONRPC: "2.0", ID: res.ID, Error: &Error{Code: CodeInternalError, Message: err.Error()}})
+	}
+
+	return data
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (any, error) {
+	switch req.Method {
+	case "initialize":
+		return s.initialize(req.Params)
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return map[string]any{"tools": s.tools}, nil
+	case "tools/call":
+		return s.callTool(ctx, req.Params)
+	case "resources/list":
+		if s.resources == nil {
+			return map[string]any{"resources": []Resource{}}, nil
+		}
+
+		resources, err := s.resources.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"resources": resources}, nil
+	case "resources/read":
+		return s.readResource(ctx, req.Params)
+	default:
+		return nil, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+}
+
+func (s *Server) initialize(params json.RawMessage) (any, error) {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ClientInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+
+	version := p.ProtocolVersion
+	if !slices.Contains(supportedVersions, version) {
+		version = LATEST_PROTOCOL_VERSION
+	}
+
+	s.log.Info().Str("client", p.ClientInfo.Name).Str("client_version", p.ClientInfo.Version).Str("protocol", version).Msg("Client connected")
+
+	capabilities := map[string]any{"tools": map[string]any{}}
+	if s.resources != nil {
+		capabilities["resources"] = map[string]any{}
+	}
+
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities":    capabilities,
+		"serverInfo":      map[string]string{"name": s.name, "version": s.version},
+		"instructions":    s.instructions,
+	}, nil
+}
+
+// textContent is a text content block of a tool result.
+type textContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (s *Server) callTool(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+
+	i := slices.IndexFunc(s.tools, func(t Tool) bool { return t.Name == p.Name })
+	if i < 0 {
+		return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("unknown tool: %s", p.Name)}
+	}
+
+	if len(p.Arguments) == 0 {
+		p.Arguments = json.RawMessage("{}")
+	}
+
+	s.log.Info().Str("tool", p.Name).Msg("Calling tool")
+
+	text, err := s.tools[i].Handler(ctx, p.Arguments)
+	if err != nil {
+		s.log.Info().Err(err).Str("tool", p.Name).Msg("Tool failed")
+		return map[string]any{"content": []textContent{{Type: "text", Text: err.Error()}}, "isError": true}, nil
+	}
+
+	return map[string]any{"content": []textContent{{Type: "text", Text: text}}}, nil
+}
+
+func (s *Server) readResource(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.URI == "" {
+		return nil, &Error{Code: CodeInvalidParams, Message: "uri is required"}
+	}
+
+	if s.resources == nil {
+		return nil, ErrResourceNotFound
+	}
+
+	contents, err := s.resources.Read(ctx, p.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"contents": []*ResourceContents{contents}}, nil
+}
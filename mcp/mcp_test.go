@@ -0,0 +1,189 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testResources struct{}
+
+func (testResources) List(ctx context.Context) ([]Resource, error) {
+	return []Resource{{URI: "scholar://documents/a.md", Name: "a.md"}}, nil
+}
+
+func (testResources) Read(ctx context.Context, uri string) (*ResourceContents, error) {
+	if uri != "scholar://documents/a.md" {
+		return nil, ErrResourceNotFound
+	}
+
+	return &ResourceContents{URI: uri, MIMEType: "text/markdown", Text: "# A\n"}, nil
+}
+
+func newTestServer() *Server {
+	s := NewServer("scholar", "test", "")
+	s.AddTool(Tool{
+		Name:        "echo",
+		InputSchema: map[string]any{"type": "object"},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var p struct {
+				Text string `json:"text"`
+			}
+			json.Unmarshal(args, &p)
+			if p.Text == "" {
+				return "", errors.New("text is required")
+			}
+			return p.Text, nil
+		},
+	})
+	s.SetResources(testResources{})
+
+	return s
+}
+
+// call sends a request and decodes the response.
+func call(t *testing.T, s *Server, msg string) response {
+	t.Helper()
+
+	var res struct {
+		response
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(s.Handle(context.Background(), []byte(msg)), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	res.response.Result = res.Result
+	return res.response
+}
+
+func TestInitialize(t *testing.T) {
+	s := newTestServer()
+
+	res := call(t, s, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	var result struct {
+		ProtocolVersion string         `json:"protocolVersion"`
+		Capabilities    map[string]any `json:"capabilities"`
+	}
+	json.Unmarshal(res.Result.(json.RawMessage), &result)
+
+	if result.ProtocolVersion != "2025-03-26" || result.Capabilities["tools"] == nil || result.Capabilities["resources"] == nil {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	res = call(t, s, `{"jsonrpc":"2.0","id":2,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`)
+	json.Unmarshal(res.Result.(json.RawMessage), &result)
+	if result.ProtocolVersion != LATEST_PROTOCOL_VERSION {
+		t.Errorf("expected latest version, got %s", result.ProtocolVersion)
+	}
+
+	if res := s.Handle(context.Background(), []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)); res != nil {
+		t.Errorf("expected no response to a notification, got %s", res)
+	}
+}
+
+func TestCallTool(t *testing.T) {
+	s := newTestServer()
+
+	tests := []struct {
+		msg     string
+		code    int
+		text    string
+		isError bool
+	}{
+		{msg: `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`, text: "hi"},
+		{msg: `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo"}}`, text: "text is required", isError: true},
+		{msg: `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"nope"}}`, code: CodeInvalidParams},
+		{msg: `{"jsonrpc":"2.0","id":4,"method":"nope"}`, code: CodeMethodNotFound},
+		{msg: `{"id":5,"method":"ping"}`, code: CodeInvalidRequest},
+		{msg: `{"jsonrpc":"2.0","id":6,"method":"resources/read","params":{"uri":"scholar://documents/b.md"}}`, code: CodeResourceNotFound},
+		{msg: `not json`, code: CodeParseError},
+	}
+
+	for _, test := range tests {
+		res := call(t, s, test.msg)
+		if test.code != 0 {
+			if res.Error == nil || res.Error.Code != test.code {
+				t.Errorf("%s: expected error %d, got %+v", test.msg, test.code, res.Error)
+			}
+			continue
+		}
+
+		var result struct {
+			Content []textContent `json:"content"`
+			IsError bool          `json:"isError"`
+		}
+		json.Unmarshal(res.Result.(json.RawMessage), &result)
+
+		if len(result.Content) != 1 || result.Content[0].Text != test.text || result.IsError != test.isError {
+			t.Errorf("%s: unexpected result %+v", test.msg, result)
+		}
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	s := newTestServer()
+
+	in := strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}
+{"jsonrpc":"2.0","method":"notifications/initialized"}
+
+{"jsonrpc":"2.0","id":2,"method":"resources/read","params":{"uri":"scholar://documents/a.md"}}`)
+	var out bytes.Buffer
+
+	if err := s.ServeStdio(context.Background(), in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 responses, got %q", out.String())
+	}
+
+	if !strings.Contains(lines[1], `"text":"# A\n"`) {
+		t.Errorf("unexpected resource response: %s", lines[1])
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	handler := HTTPHandler(func(w http.ResponseWriter, r *http.Request) *Server {
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return nil
+		}
+		return newTestServer()
+	})
+
+	tests := []struct {
+		method string
+		auth   bool
+		body   string
+		status int
+	}{
+		{"POST", true, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusOK},
+		{"POST", true, `{"jsonrpc":"2.0","method":"notifications/initialized"}`, http.StatusAccepted},
+		{"POST", false, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusUnauthorized},
+		{"GET", true, "", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/mcp", strings.NewReader(test.body))
+		if test.auth {
+			req.Header.Set("Authorization", "Bearer key")
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.body, test.status, rec.Code)
+		}
+	}
+}
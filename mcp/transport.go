@@ -0,0 +1,70 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// MAX_MESSAGE_SIZE limits the size of messages received over HTTP.
+const MAX_MESSAGE_SIZE = 4 << 20
+
+// ServeStdio handles newline-delimited messages from in and writes the responses to out, until in is closed.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if res := s.Handle(ctx, line); res != nil {
+				if _, err := out.Write(append(res, '\n')); err != nil {
+					return errors.Wrap(err, "failed to write response")
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "failed to read message")
+		}
+	}
+}
+
+// HTTPHandler serves the streamable HTTP transport, without server-sent events: every request gets a single JSON
+// response. newServer returns the server for a request, or nil if it already responded, e.g. because the request
+// isn't authenticated.
+func HTTPHandler(newServer func(w http.ResponseWriter, r *http.Request) *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		server := newServer(w, r)
+		if server == nil {
+			return
+		}
+
+		msg, err := io.ReadAll(io.LimitReader(r.Body, MAX_MESSAGE_SIZE))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res := server.Handle(r.Context(), msg)
+		if res == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(res)
+	})
+}
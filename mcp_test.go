@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/config"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/store"
+)
+
+const testMCPPolicy = `
+users: [U1, U2]
+default_mode: read-only
+channels:
+  C1: ingest
+`
+
+// newTestMCP returns an MCP server for the caller, over a temporary library containing a single document.
+func newTestMCP(t *testing.T, c *caller) (func(msg string) map[string]any, string) {
+	dataDir := t.TempDir()
+
+	doc := &document.Document{Content: []byte("# Bitcoin\n\nA peer-to-peer electronic cash system.\n"), Metadata: document.Metadata{Source: "https://bitcoin.org/bitcoin.pdf"}}
+	name, markdown, err := doc.ToMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.NewFileStore(dataDir).Scoped(c.scope).Store(name, strings.NewReader(string(markdown))); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := filepath.Join(t.TempDir(), "access.yaml")
+	if err := os.WriteFile(policy, []byte(testMCPPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer, err := access.NewAuthorizer(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newMCPServer(authorizer, newTenants(zerolog.Nop(), "", dataDir, config.Default(), nil, nil, func() string { return "" }), c)
+
+	return func(msg string) map[string]any {
+		var res map[string]any
+		if err := json.Unmarshal(server.Handle(context.Background(), []byte(msg)), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}, name
+}
+
+// toolText returns the text of a tool result, and whether it is an error.
+func toolText(res map[string]any) (string, bool) {
+	result, _ := res["result"].(map[string]any)
+	content, _ := result["content"].([]any)
+	if len(content) != 1 {
+		return "", true
+	}
+
+	isError, _ := result["isError"].(bool)
+	return content[0].(map[string]any)["text"].(string), isError
+}
+
+func TestMCPTools(t *testing.T) {
+	call, name := newTestMCP(t, &caller{name: "test", user: "U1", scope: store.ChannelScope("C2")})
+
+	text, isError := toolText(call(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search_library","arguments":{"query":"electronic cash"}}}`))
+	if isError || !strings.Contains(text, name) {
+		t.Errorf("search didn't find the document: %s", text)
+	}
+
+	text, isError = toolText(call(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"get_document","arguments":{"file":"` + name + `"}}}`))
+	if isError || !strings.Contains(text, "source: https://bitcoin.org/bitcoin.pdf") {
+		t.Errorf("unexpected document: %s", text)
+	}
+
+	text, isError = toolText(call(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"get_document","arguments":{"file":"../access.yaml"}}}`))
+	if !isError {
+		t.Errorf("expected an error for an invalid name, got %s", text)
+	}
+
+	// C2 is read-only.
+	text, isError = toolText(call(`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"ingest_url","arguments":{"url":"https://example.com"}}}`))
+	if !isError || text != access.ReplyChannelNoWrite {
+		t.Errorf("expected ingestion to be denied, got %s", text)
+	}
+
+	res := call(`{"jsonrpc":"2.0","id":5,"method":"resources/read","params":{"uri":"` + DOCUMENT_URI_PREFIX + name + `"}}`)
+	contents := res["result"].(map[string]any)["contents"].([]any)
+	if !strings.Contains(contents[0].(map[string]any)["text"].(string), "electronic cash") {
+		t.Errorf("unexpected resource: %v", res)
+	}
+}
+
+func TestMCPThreads(t *testing.T) {
+	tenants := newTenants(zerolog.Nop(), "", t.TempDir(), config.Default(), nil, nil, func() string { return "" })
+	addTestTenant(t, tenants, &fakeBackend{}, &fakeContent{})
+
+	authorizer, err := access.NewAuthorizer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ask := func(c *caller, threadID string) (string, bool) {
+		server := newMCPServer(authorizer, tenants, c)
+		args, _ := json.Marshal(map[string]string{"question": "What is Bitcoin?", "threadId": threadID})
+
+		var res map[string]any
+		if err := json.Unmarshal(server.Handle(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"ask_scholar","arguments":`+string(args)+`}}`)), &res); err != nil {
+			t.Fatal(err)
+		}
+
+		return toolText(res)
+	}
+
+	alice := &caller{name: "alice", key: &access.APIKey{Name: "alice", Key: "alice-key-0123456789"}}
+	bob := &caller{name: "bob", key: &access.APIKey{Name: "bob", Key: "bob-key-0123456789"}}
+
+	text, isError := ask(alice, "")
+	var answer struct {
+		ThreadID string `json:"threadId"`
+	}
+	if err := json.Unmarshal([]byte(text), &answer); isError || err != nil || !strings.HasPrefix(answer.ThreadID, "mcp-") {
+		t.Fatalf("unexpected answer: %s", text)
+	}
+
+	if text, isError := ask(alice, answer.ThreadID); isError {
+		t.Errorf("expected the follow-up to be answered, got %s", text)
+	}
+
+	if text, isError := ask(bob, answer.ThreadID); !isError || !strings.Contains(text, "no such thread") {
+		t.Errorf("expected the thread of another client to be hidden, got %s", text)
+	}
+}
+
+func TestMCPAccess(t *testing.T) {
+	tests := []struct {
+		caller  *caller
+		allowed bool
+	}{
+		{&caller{user: "U1"}, true},
+		{&caller{user: "U3"}, false},
+		{&caller{user: "U1", scope: store.PrivateScope("U1")}, true},
+		{&caller{user: "U2", scope: store.PrivateScope("U1")}, false},
+		{&caller{key: &access.APIKey{Mode: access.ModeReadOnly}}, true},
+	}
+
+	for _, test := range tests {
+		call, _ := newTestMCP(t, test.caller)
+
+		res := call(`{"jsonrpc":"2.0","id":1,"method":"resources/list"}`)
+		if _, denied := res["error"]; denied == test.allowed {
+			t.Errorf("%+v: expected allowed=%v, got %v", test.caller, test.allowed, res)
+		}
+	}
+}
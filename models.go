@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+)
+
+// checkModel returns an error if users can't select the model of a command. An empty model selects the model of the
+// command's task.
+func checkModel(models []string, model string) error {
+	switch {
+	case model == "" || slices.Contains(models, model):
+		return nil
+	case len(models) == 0:
+		return errors.New("models can't be selected")
+	default:
+		return fmt.Errorf("unknown model %s (available: %s)", model, strings.Join(models, ", "))
+	}
+}
+
+// withModel returns a context whose prompts use the model the user selected, or the model of the task. Models that
+// users can't select (anymore) are ignored.
+func (t *tenant) withModel(ctx context.Context, task, model string) context.Context {
+	if checkModel(t.backend.UserModels(), model) != nil || model == "" {
+		model = t.backend.TaskModel(task)
+	}
+
+	return backend.WithModel(ctx, model)
+}
+
+// summaryTask returns the task of a summary of the documents: summaries of tweets are cheap, and long documents that
+// are summarized section by section need a strong model.
+func summaryTask(docs []prompt.Document, long bool) string {
+	if long {
+		return backend.TaskLongSummary
+	}
+
+	tweets := len(docs) > 0
+	for _, doc := range docs {
+		tweets = tweets && doc.Type == document.TypeTweet
+	}
+
+	if tweets {
+		return backend.TaskTweetSummary
+	}
+
+	return backend.TaskSummary
+}
+
+// modelFooter returns the footer of a response, with the model that wrote it. Without a model, e.g. for cached
+// responses, it is empty.
+func modelFooter(ctx context.Context) string {
+	if model := backend.UsedModel(ctx); model != "" {
+		return "Model: " + model
+	}
+
+	return ""
+}
+
+// withModelFooter appends the footer of the model that wrote a response to it.
+func withModelFooter(ctx context.Context, text string) string {
+	if footer := modelFooter(ctx); footer != "" {
+		return fmt.Sprintf("%s\n\n_%s_", text, footer)
+	}
+
+	return text
+}
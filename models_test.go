@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+)
+
+func TestCheckModel(t *testing.T) {
+	models := []string{"gpt-4o-mini", "gpt-4o"}
+	if err := checkModel(models, ""); err != nil {
+		t.Errorf("the model of the task should always be allowed: %v", err)
+	}
+	if err := checkModel(models, "gpt-4o"); err != nil {
+		t.Error(err)
+	}
+	if err := checkModel(models, "o1"); err == nil {
+		t.Error("expected an error for a model users can't select")
+	}
+	if err := checkModel(nil, "gpt-4o"); err == nil {
+		t.Error("expected an error when models can't be selected")
+	}
+}
+
+func TestSummaryTask(t *testing.T) {
+	tweet := prompt.Document{File: "tweet.md", Metadata: document.Metadata{Type: document.TypeTweet}}
+	pdf := prompt.Document{File: "paper.md", Metadata: document.Metadata{Type: document.TypePDF}}
+
+	tests := []struct {
+		docs []prompt.Document
+		long bool
+		task string
+	}{
+		{docs: []prompt.Document{tweet, tweet}, task: backend.TaskTweetSummary},
+		{docs: []prompt.Document{tweet, pdf}, task: backend.TaskSummary},
+		{docs: []prompt.Document{pdf}, long: true, task: backend.TaskLongSummary},
+		{task: backend.TaskSummary},
+	}
+
+	for _, test := range tests {
+		if task := summaryTask(test.docs, test.long); task != test.task {
+			t.Errorf("%+v: expected %s, got %s", test.docs, test.task, task)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/cache"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/prompt"
+)
+
+// personaStore stores the persona of each channel. Channels that never switched with /persona use the persona
+// they're configured with, or the default persona.
+type personaStore struct {
+	cache    *cache.BoltCache
+	defaults map[string]string
+}
+
+func newPersonaStore(path string, defaults map[string]string) (*personaStore, error) {
+	cache, err := cache.NewBoltCache(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &personaStore{cache: cache, defaults: defaults}, nil
+}
+
+// Get returns the persona of a channel.
+func (p *personaStore) Get(channelID string) string {
+	if persona, ok := p.cache.Get(channelID); ok {
+		return persona
+	}
+
+	if persona, ok := p.defaults[channelID]; ok {
+		return persona
+	}
+
+	return prompt.DefaultPersona
+}
+
+// Set switches the persona of a channel.
+func (p *personaStore) Set(channelID, persona string) error {
+	return p.cache.Put(channelID, persona)
+}
+
+// Close closes the database.
+func (p *personaStore) Close() error {
+	return p.cache.Close()
+}
+
+// today returns the current date, as shown to the assistant.
+func today() string {
+	return time.Now().Format(time.DateOnly)
+}
+
+// promptData returns the template variables of a conversation with a user, with the persona of its channel.
+func promptData(fe chat.Frontend, tenant *tenant, teamID, channelID, userID string) prompt.Data {
+	user := fe.UserProfile(teamID, userID)
+	name := fe.Name()
+
+	return prompt.Data{
+		Date:     today(),
+		Platform: strings.ToUpper(name[:1]) + name[1:],
+		Persona:  tenant.personas.Get(channelID),
+		Channel:  prompt.Channel{ID: channelID, Name: fe.ChannelName(teamID, channelID)},
+		User:     prompt.User{ID: user.ID, Name: user.Name, Title: user.Title},
+	}
+}
+
+// renderPrompt renders the instructions and message templates of a prompt.
+func (t *tenant) renderPrompt(instructionsTemplate, messageTemplate string, data prompt.Data) (string, string, error) {
+	instructions, err := t.templates.Render(instructionsTemplate, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render %s prompt: %w", instructionsTemplate, err)
+	}
+
+	message, err := t.templates.Render(messageTemplate, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render %s prompt: %w", messageTemplate, err)
+	}
+
+	return instructions, message, nil
+}
+
+// prompt renders the instructions and message templates, and prompts the assistant with them in the given thread.
+func (t *tenant) prompt(ctx context.Context, threadID, instructionsTemplate, messageTemplate string, data prompt.Data) (string, error) {
+	instructions, message, err := t.renderPrompt(instructionsTemplate, messageTemplate, data)
+	if err != nil {
+		return "", err
+	}
+
+	return t.backend.Prompt(ctx, threadID, instructions, message)
+}
+
+// JSON_PROMPT_ATTEMPTS is how many times a JSON response is requested before giving up on invalid responses.
+const JSON_PROMPT_ATTEMPTS = 2
+
+// promptJSON renders the instructions and message templates, and prompts the assistant for a JSON response in the
+// given format. The response is parsed (and validated) by parse. Invalid responses are retried with the error, so the
+// assistant can correct them.
+func (t *tenant) promptJSON(ctx context.Context, threadID, instructionsTemplate, messageTemplate string, data prompt.Data, format backend.ResponseFormat, parse func(response string) error) error {
+	instructions, message, err := t.renderPrompt(instructionsTemplate, messageTemplate, data)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, err := t.backend.PromptJSON(ctx, threadID, instructions, message, format)
+		if err != nil {
+			return err
+		}
+
+		err = parse(response)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == JSON_PROMPT_ATTEMPTS {
+			return fmt.Errorf("invalid %s: %w", format.Name, err)
+		}
+
+		message = fmt.Sprintf("Your response was invalid (%s). Please respond again with a valid JSON object.", err)
+		if len(data.Documents) > 0 {
+			message = fmt.Sprintf("Your response was invalid (%s). Please respond again with a valid JSON object for the files %s.", err, strings.Join(documentFiles(data.Documents), ", "))
+		}
+	}
+}
+
+// assistantInstructions renders the assistant's instructions for questions asked outside of chat, with the default persona.
+func (t *tenant) assistantInstructions() (string, error) {
+	return t.templates.Render(prompt.AssistantTemplate, prompt.Data{Date: today()})
+}
+
+// documentFiles returns the file names of documents.
+func documentFiles(docs []prompt.Document) []string {
+	files := make([]string, len(docs))
+	for i, doc := range docs {
+		files[i] = doc.File
+	}
+
+	return files
+}
+
+// promptDocuments returns the template variables of ingested documents.
+func promptDocuments(results []ingestResult) []prompt.Document {
+	docs := make([]prompt.Document, len(results))
+	for i, res := range results {
+		docs[i].File = res.FileName
+		if res.Doc != nil {
+			docs[i].Metadata = res.Doc.Metadata
+		}
+	}
+
+	return docs
+}
+
+// handlePersona shows the persona of the command's channel, or switches it to the persona named in the command.
+// Anyone who can ask Scholar questions can see the persona, but switching it requires ingest permission.
+func (a *app) handlePersona(ctx context.Context, fe chat.Frontend, cmd chat.Command) {
+	log := a.log.With().Str("frontend", fe.Name()).Logger()
+
+	action := access.ActionAsk
+	if cmd.Text != "" {
+		action = access.ActionIngest
+	}
+
+	if err := a.authorizer.Authorize(cmd.UserID, cmd.ChannelID, action); err != nil {
+		log.Info().Str("user_id", cmd.UserID).Str("channel_id", cmd.ChannelID).Str("command", cmd.CommandType).Msg("Command denied")
+		fe.Respond(cmd, err.Error())
+		return
+	}
+
+	tenant, err := a.tenants.Get(ctx, cmd.TeamID)
+	if err != nil {
+		log.Error().Err(err).Str("team_id", cmd.TeamID).Msg("Failed to load workspace")
+		fe.Respond(cmd, fmt.Sprintf("Failed to load workspace: %s", err))
+		return
+	}
+
+	personas := tenant.templates.Personas()
+	current := tenant.personas.Get(cmd.ChannelID)
+
+	if cmd.Text == "" {
+		fe.Respond(cmd, fmt.Sprintf("This channel uses the *%s* persona. Available personas: %s.", current, strings.Join(personas, ", ")))
+		return
+	}
+
+	if !tenant.templates.HasPersona(cmd.Text) {
+		fe.Respond(cmd, fmt.Sprintf("Unknown persona: %s. Available personas: %s.", cmd.Text, strings.Join(personas, ", ")))
+		return
+	}
+
+	if err := tenant.personas.Set(cmd.ChannelID, cmd.Text); err != nil {
+		log.Error().Err(err).Msg("Failed to save persona")
+		fe.Respond(cmd, fmt.Sprintf("Failed to save persona: %s", err))
+		return
+	}
+
+	log.Info().Str("user_id", cmd.UserID).Str("channel_id", cmd.ChannelID).Str("persona", cmd.Text).Msg("Persona switched")
+
+	fe.Respond(cmd, fmt.Sprintf("Switched to the *%s* persona.", cmd.Text))
+	if current != cmd.Text && !fe.IsDM(cmd.ChannelID) {
+		if err := fe.PostMessage(cmd.TeamID, cmd.ChannelID, nil, fmt.Sprintf("<@%s> switched Scholar to the *%s* persona.", cmd.UserID, cmd.Text)); err != nil {
+			log.Error().Err(err).Msg("Failed to announce persona")
+		}
+	}
+}
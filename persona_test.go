@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mempirate/scholar/prompt"
+)
+
+func TestPersonaStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "personas.db")
+
+	personas, err := newPersonaStore(path, map[string]string{"C1": "protocol-engineering"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if persona := personas.Get("C1"); persona != "protocol-engineering" {
+		t.Errorf("expected the configured persona, got %s", persona)
+	}
+
+	if persona := personas.Get("C2"); persona != prompt.DefaultPersona {
+		t.Errorf("expected the default persona, got %s", persona)
+	}
+
+	if err := personas.Set("C1", "reading-group"); err != nil {
+		t.Fatal(err)
+	}
+
+	if persona := personas.Get("C1"); persona != "reading-group" {
+		t.Errorf("switch wasn't applied, got %s", persona)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/chat"
+)
+
+// stage is a step in processing a command.
+type stage int
+
+const (
+	stageFetching stage = iota
+	stageConverting
+	stageUploading
+	stageSummarizing
+	stageComparing
+	stageResearching
+	stageDone
+)
+
+func (s stage) String() string {
+	switch s {
+	case stageFetching:
+		return "Fetching content..."
+	case stageConverting:
+		return "Converting to markdown..."
+	case stageUploading:
+		return "Uploading to the library..."
+	case stageSummarizing:
+		return "Summarizing..."
+	case stageComparing:
+		return "Comparing..."
+	case stageResearching:
+		return "Researching..."
+	default:
+		return "Done."
+	}
+}
+
+// progressFunc is called when processing of a URL reaches a new stage.
+type progressFunc func(uri *url.URL, st stage)
+
+// commandReporter reports the progress and result of a slash command to the user who invoked it.
+//
+// A Slack response URL can only be used 5 times, so progress is only posted when the slowest URL
+// advances to a new stage: fetching, converting, uploading, summarizing and the final result. Details, like the
+// progress of long summaries, are only posted while the frontend allows more responses, keeping one for the result.
+type commandReporter struct {
+	log      zerolog.Logger
+	frontend chat.Frontend
+	cmd      chat.Command
+
+	mu       sync.Mutex
+	stages   map[string]stage
+	reported stage
+	// responses is the number of responses posted.
+	responses int
+}
+
+func newCommandReporter(log zerolog.Logger, frontend chat.Frontend, cmd chat.Command) *commandReporter {
+	stages := make(map[string]stage, len(cmd.URLs))
+	for _, uri := range cmd.URLs {
+		stages[uri.String()] = stageFetching
+	}
+
+	return &commandReporter{
+		log:      log,
+		frontend: frontend,
+		cmd:      cmd,
+		stages:   stages,
+		reported: -1,
+	}
+}
+
+// Progress records that the URL reached the given stage. It implements progressFunc.
+func (r *commandReporter) Progress(uri *url.URL, st stage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stages[uri.String()] = st
+
+	slowest := stageDone
+	for _, s := range r.stages {
+		slowest = min(slowest, s)
+	}
+
+	// The final result is posted with Done.
+	if slowest == stageDone || slowest <= r.reported {
+		return
+	}
+
+	r.reported = slowest
+	r.post(slowest.String())
+}
+
+// Stage reports a stage that applies to the command as a whole, like summarizing.
+func (r *commandReporter) Stage(st stage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if st <= r.reported {
+		return
+	}
+
+	r.reported = st
+	r.post(st.String())
+}
+
+// Detail reports the progress of the current stage, if the frontend allows more responses.
+func (r *commandReporter) Detail(text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit := r.frontend.MaxResponses(); limit > 0 && r.responses >= limit-1 {
+		return
+	}
+
+	r.post(text)
+}
+
+// Done posts the final result of the command.
+func (r *commandReporter) Done(text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reported = stageDone
+	r.post(text)
+}
+
+func (r *commandReporter) post(text string) {
+	r.responses++
+	if err := r.frontend.Respond(r.cmd, text); err != nil {
+		r.log.Error().Err(err).Msg("Failed to post command response")
+	}
+}
@@ -0,0 +1,156 @@
+package prompt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Comparison is a side by side comparison of documents along fixed dimensions, requested with /compare.
+type Comparison struct {
+	Overview          string           `json:"overview"`
+	Problem           []ComparisonCell `json:"problem"`
+	Assumptions       []ComparisonCell `json:"assumptions"`
+	Approach          []ComparisonCell `json:"approach"`
+	PerformanceClaims []ComparisonCell `json:"performance_claims"`
+	TradeOffs         []ComparisonCell `json:"trade_offs"`
+	// Conclusion sums up how the documents relate to each other.
+	Conclusion string `json:"conclusion"`
+}
+
+// ComparisonCell is what a document says about a dimension.
+type ComparisonCell struct {
+	// File is the name of the document.
+	File string `json:"file"`
+	Text string `json:"text"`
+}
+
+// ComparisonDimension is a row of a comparison.
+type ComparisonDimension struct {
+	Name  string
+	Cells []ComparisonCell
+}
+
+// Dimensions returns the dimensions of the comparison, in order.
+func (c *Comparison) Dimensions() []ComparisonDimension {
+	return []ComparisonDimension{
+		{"Problem", c.Problem},
+		{"Assumptions", c.Assumptions},
+		{"Approach", c.Approach},
+		{"Performance claims", c.PerformanceClaims},
+		{"Trade-offs", c.TradeOffs},
+	}
+}
+
+// ComparisonSchema returns the JSON schema of Comparison.
+func ComparisonSchema() map[string]any {
+	cells := func(description string) map[string]any {
+		return map[string]any{
+			"type":        "array",
+			"description": description + " One entry per file, in the order of the files.",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"file": map[string]any{"type": "string", "description": "The exact name of the file."},
+					"text": map[string]any{"type": "string", "description": "What the file says about this dimension, in one to three sentences."},
+				},
+				"required":             []string{"file", "text"},
+				"additionalProperties": false,
+			},
+		}
+	}
+
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"overview":           map[string]any{"type": "string", "description": "What the files are about, in two or three sentences."},
+			"problem":            cells("The problem each file addresses."),
+			"assumptions":        cells("The assumptions each file makes, e.g. its threat or network model."),
+			"approach":           cells("The approach or solution of each file."),
+			"performance_claims": cells("The performance claims of each file, with numbers where available."),
+			"trade_offs":         cells("The trade-offs and limitations of each file."),
+			"conclusion":         map[string]any{"type": "string", "description": "How the files relate, and when to prefer which."},
+		},
+		"required":             []string{"overview", "problem", "assumptions", "approach", "performance_claims", "trade_offs", "conclusion"},
+		"additionalProperties": false,
+	}
+}
+
+// ParseComparison parses and validates the response to a comparison prompt of the given files.
+func ParseComparison(response string, files []string) (*Comparison, error) {
+	var comparison Comparison
+	if err := decodeStrict(response, &comparison); err != nil {
+		return nil, err
+	}
+
+	if err := comparison.Validate(files); err != nil {
+		return nil, err
+	}
+
+	return &comparison, nil
+}
+
+// Validate checks that every dimension has an entry for each of the files, and no others.
+func (c *Comparison) Validate(files []string) error {
+	for _, dimension := range c.Dimensions() {
+		seen := make(map[string]bool, len(files))
+		for _, cell := range dimension.Cells {
+			if !slices.Contains(files, cell.File) {
+				return fmt.Errorf("%s: unknown file %q, expected one of %s", dimension.Name, cell.File, strings.Join(files, ", "))
+			}
+			seen[cell.File] = true
+		}
+
+		for _, file := range files {
+			if !seen[file] {
+				return fmt.Errorf("%s: missing file %q", dimension.Name, file)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Markdown renders the comparison in Slack's markdown, which has no tables: each dimension lists what each document
+// says about it, citing the documents by number. The documents are listed at the end, with their sources.
+func (c *Comparison) Markdown(docs []Document) string {
+	var b strings.Builder
+
+	index := make(map[string]int, len(docs))
+	for i, doc := range docs {
+		index[doc.File] = i + 1
+	}
+
+	title := func(file string) string {
+		if doc := docs[index[file]-1]; doc.Title != "" {
+			return doc.Title
+		}
+		return file
+	}
+
+	if c.Overview != "" {
+		fmt.Fprintf(&b, "%s\n", c.Overview)
+	}
+
+	for _, dimension := range c.Dimensions() {
+		fmt.Fprintf(&b, "\n*%s*\n", dimension.Name)
+		for _, cell := range dimension.Cells {
+			fmt.Fprintf(&b, "• *%s* [%d]: %s\n", title(cell.File), index[cell.File], cell.Text)
+		}
+	}
+
+	if c.Conclusion != "" {
+		fmt.Fprintf(&b, "\n*Conclusion*\n%s\n", c.Conclusion)
+	}
+
+	b.WriteString("\n---\n")
+	for i, doc := range docs {
+		fmt.Fprintf(&b, "[%d] %s", i+1, doc.File)
+		if doc.Source != "" {
+			fmt.Fprintf(&b, " (%s)", doc.Source)
+		}
+		b.WriteByte('\n')
+	}
+
+	return strings.TrimSpace(b.String())
+}
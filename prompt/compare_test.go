@@ -0,0 +1,37 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mempirate/scholar/document"
+)
+
+func TestParseComparison(t *testing.T) {
+	cells := `[{"file": "fastpay.md", "text": "A"}, {"file": "bitcoin.md", "text": "B"}]`
+	response := `{"overview": "Two payment systems.", "problem": ` + cells + `, "assumptions": ` + cells + `, "approach": ` + cells +
+		`, "performance_claims": ` + cells + `, "trade_offs": ` + cells + `, "conclusion": "Use FastPay for retail payments."}`
+	files := []string{"fastpay.md", "bitcoin.md"}
+
+	comparison, err := ParseComparison(response, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []Document{{File: "fastpay.md", Metadata: document.Metadata{Title: "FastPay", Source: "https://arxiv.org/abs/2003.11506"}}, {File: "bitcoin.md"}}
+	markdown := comparison.Markdown(docs)
+	for _, want := range []string{"*Performance claims*\n• *FastPay* [1]: A\n• *bitcoin.md* [2]: B", "*Conclusion*\nUse FastPay", "[1] fastpay.md (https://arxiv.org/abs/2003.11506)\n[2] bitcoin.md"} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("markdown doesn't contain %q:\n%s", want, markdown)
+		}
+	}
+
+	missing := strings.Replace(response, `"trade_offs": `+cells, `"trade_offs": [{"file": "fastpay.md", "text": "A"}]`, 1)
+	if _, err := ParseComparison(missing, files); err == nil || !strings.Contains(err.Error(), "Trade-offs") {
+		t.Errorf("expected an error for a missing file, got %v", err)
+	}
+
+	if _, err := ParseComparison(response, []string{"fastpay.md"}); err == nil {
+		t.Error("expected an error for an unknown file")
+	}
+}
@@ -1,47 +1,325 @@
 package prompt
 
-import "fmt"
-
-// TODO: Try other prompting techniques instead of just the regular "You are a ..."
-// 1. Tell it exactly where it's deployed (i.e. as a Slack bot, in a research channel, of this company, ...)
-// Interesting stuff in the Anthropic prompt engineering deep-dive: <https://www.youtube.com/watch?v=T9aRN5JkmL8>
-const ASSISTANT_PROMPT_INSTRUCTIONS = `You are a scholarly RAG research assistant. Always try to use your vector store to retrieve relevant information.
-Files can be of various types, such as PDFs, tweets, or web pages. Always try to use your vector store to retrieve relevant information.
-If you can't find the information, ask the user for more information, don't just hallucinate.
-In case of a tweet, be careful about retrieving the correct file, with the exact same name. If the tweet has any references, include those in the summary if relevant.
-Only use a single reference per unique file. When referring to text in a file, reference the exact text in the file (prefixed with an ">" character to indicate a quote).
-If the files referenced have YAML front matter, include some of the relevant links in the metadata for the user to do further research.`
-
-const SUMMARY_PROMPT = "Please provide a summary of this file: %s."
-
-const SUMMARY_PROMPT_INSTRUCTIONS = `You are a scholarly RAG research assistant, good at summarizing information in files that are in your vector store.
-Files can be of various types, such as PDFs, tweets, or web pages. Always try to use your vector store to retrieve relevant information.
-If you can't find the information, ask the user for more information, don't just hallucinate.
-In case of a tweet, be careful about retrieving the correct file, with the exact same name. If the tweet has any references, include those in the summary if relevant.
-In case of a PDF, always mention the title of the paper instead of the name. Only use a single reference per unique file.
-When referring to text in a file, reference the exact text in the file (prefixed with an ">" character to indicate a quote).
-If the files referenced have YAML front matter, include some of the relevant links in the metadata for the user do further research.`
-
-func CreateSummaryPrompt(filename string) string {
-	return fmt.Sprintf(SUMMARY_PROMPT, filename)
-}
-
-const MENTION_PROMPT_INSTRUCTIONS = `You are a scholarly RAG research assistant. Always try to use your vector store to retrieve relevant information.
-If you can't find the information, ask the user for more information, don't just hallucinate. You are called inside of a Slack thread,
-and you have to provide a response to a user's message. You can mention a user in a response by using the following schema: <@userId>
-(including the smaller / greater than signs). The userId field will be included in the messages.
-Always do this if it is relevant, or if you have to refer to messages sent by specific users. Spend time doing retrieval and understanding
-the context of the messages. If you can provide multiple relevant references to files / messages in the vector store, do so. But ALWAYS use only one reference per unique file.
-When referring to text in a file, reference the exact text in the file (prefixed with an ">" character to indicate a quote).
-If the files referenced have YAML front matter, include some of the relevant links in the metadata for the user to do further research.`
-
-const MENTION_PROMPT = `
-message: %s
-
-channelId: %s
-threadId: %s
-userId: %s`
-
-func CreateMentionPrompt(question, channel, thread, userID string) string {
-	return fmt.Sprintf(MENTION_PROMPT, question, channel, thread, userID)
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/mempirate/scholar/document"
+)
+
+// Templates are rendered with text/template. Every file in templates/ (except personas) defines the template
+// named after it, and the personas in templates/personas/ define the "persona" template used by the instructions.
+//
+//go:embed templates
+var builtin embed.FS
+
+// Template names.
+const (
+	// AssistantTemplate renders the instructions of the assistant, and of questions asked outside of chat.
+	AssistantTemplate = "assistant"
+	// SummaryInstructionsTemplate renders the instructions of summary runs.
+	SummaryInstructionsTemplate = "summary_instructions"
+	// SummaryTemplate renders the request for a summary of Data.Documents.
+	SummaryTemplate = "summary"
+	// MentionInstructionsTemplate renders the instructions of replies to mentions.
+	MentionInstructionsTemplate = "mention_instructions"
+	// MentionTemplate renders the message of a mention.
+	MentionTemplate = "mention"
+	// SectionInstructionsTemplate renders the instructions for summarizing Data.Section of a long document.
+	SectionInstructionsTemplate = "section_instructions"
+	// SectionTemplate renders the request for a summary of Data.Section.
+	SectionTemplate = "section"
+	// CombineTemplate renders the request for a summary of a long document from the summaries in Data.Sections.
+	// It is sent with the summary instructions.
+	CombineTemplate = "combine"
+	// CompareTemplate renders the request for a comparison of Data.Documents. It is sent with the summary instructions.
+	CompareTemplate = "compare"
+	// ResearchInstructionsTemplate renders the instructions of the steps of a research.
+	ResearchInstructionsTemplate = "research_instructions"
+	// ResearchPlanTemplate renders the request to break Data.Research.Question into sub-questions.
+	ResearchPlanTemplate = "research_plan"
+	// ResearchQuestionTemplate renders the request to answer Data.Research.SubQuestion from the library.
+	ResearchQuestionTemplate = "research_question"
+	// ResearchReportTemplate renders the request for a report on Data.Research.Question from its findings, which
+	// cite Data.Documents.
+	ResearchReportTemplate = "research_report"
+)
+
+// requiredTemplates must be defined for Scholar to work.
+var requiredTemplates = []string{
+	AssistantTemplate, SummaryInstructionsTemplate, SummaryTemplate, MentionInstructionsTemplate, MentionTemplate,
+	SectionInstructionsTemplate, SectionTemplate, CombineTemplate, CompareTemplate, ResearchInstructionsTemplate,
+	ResearchPlanTemplate, ResearchQuestionTemplate, ResearchReportTemplate,
+}
+
+// DefaultPersona is the persona of channels that don't have one.
+const DefaultPersona = "default"
+
+const (
+	TEMPLATE_EXT = ".tmpl"
+	PERSONAS_DIR = "personas"
+)
+
+// Data are the variables available to templates.
+type Data struct {
+	// Date is the current date (YYYY-MM-DD).
+	Date string
+	// Platform is the chat platform, e.g. "Slack". Empty outside of chat.
+	Platform string
+	// Persona is the name of the persona to render the template with. Empty is the default persona.
+	Persona  string
+	Channel  Channel
+	User     User
+	ThreadID string
+	// Message is the text of the user's message.
+	Message string
+	// Documents are the documents the prompt is about, e.g. the ones to summarize.
+	Documents []Document
+	// Summary are the options of the summary the prompt asks for, or the thread started with.
+	Summary SummaryOptions
+	// Section is the section of a long document to summarize.
+	Section Section
+	// Sections are the summaries of the sections of a long document, to combine into one summary.
+	Sections []Section
+	// Research is the state of the research the prompt is a step of.
+	Research Research
+}
+
+// Section is a numbered section of a long document, with either its text or its summary.
+type Section struct {
+	// Index is the number of the section, starting at 1, and Count the number of sections in the document.
+	Index   int
+	Count   int
+	Heading string
+	Text    string
+}
+
+// Channel is the conversation a prompt is rendered for.
+type Channel struct {
+	ID   string
+	Name string
+}
+
+// User is the profile of the user a prompt is rendered for.
+type User struct {
+	ID    string
+	Name  string
+	Title string
+}
+
+// Document is a document in the library, with its front matter.
+type Document struct {
+	File string
+	document.Metadata
+}
+
+var funcs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	// inc turns indexes into numbers, e.g. of cited sources.
+	"inc": func(i int) int { return i + 1 },
+}
+
+// Templates are the prompt templates, parsed once for every persona.
+type Templates struct {
+	personas map[string]*template.Template
+}
+
+// Load loads the built-in templates, overridden by the templates in dir if it isn't empty. A file in dir replaces
+// the built-in file with the same name, and new personas can be added in dir/personas. Every template is rendered
+// once with sample data, so invalid templates are reported here instead of when they're used.
+func Load(dir string) (*Templates, error) {
+	files, err := readTemplates(builtin, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("templates directory %s doesn't exist", dir)
+		}
+
+		overrides, err := readTemplates(os.DirFS(dir), ".")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read templates in %s", dir)
+		}
+
+		for name, content := range overrides {
+			files[name] = content
+		}
+	}
+
+	base := template.New("").Funcs(funcs).Option("missingkey=error")
+	for name, content := range files {
+		if strings.HasPrefix(name, PERSONAS_DIR+"/") {
+			continue
+		}
+
+		if _, err := base.New(strings.TrimSuffix(name, TEMPLATE_EXT)).Parse(content); err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", name, err)
+		}
+	}
+
+	for _, name := range requiredTemplates {
+		if base.Lookup(name) == nil {
+			return nil, fmt.Errorf("missing template %s%s", name, TEMPLATE_EXT)
+		}
+	}
+
+	t := &Templates{personas: make(map[string]*template.Template)}
+	for name, content := range files {
+		persona, ok := strings.CutPrefix(name, PERSONAS_DIR+"/")
+		if !ok {
+			continue
+		}
+		persona = strings.TrimSuffix(persona, TEMPLATE_EXT)
+
+		tmpl, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := tmpl.New("persona").Parse(content); err != nil {
+			return nil, fmt.Errorf("invalid persona %s: %w", name, err)
+		}
+
+		t.personas[persona] = tmpl
+	}
+
+	if _, ok := t.personas[DefaultPersona]; !ok {
+		return nil, fmt.Errorf("missing persona %s/%s%s", PERSONAS_DIR, DefaultPersona, TEMPLATE_EXT)
+	}
+
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// MustLoad is like Load, but panics if the templates are invalid. It is meant for the built-in templates.
+func MustLoad(dir string) *Templates {
+	t, err := Load(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+// readTemplates reads the .tmpl files in root and root/personas, keyed by their path relative to root.
+func readTemplates(fsys fs.FS, root string) (map[string]string, error) {
+	files := make(map[string]string)
+
+	for _, dir := range []string{root, path.Join(root, PERSONAS_DIR)} {
+		entries, err := fs.ReadDir(fsys, dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != TEMPLATE_EXT {
+				continue
+			}
+
+			content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			name, _ := strings.CutPrefix(path.Join(dir, entry.Name()), root+"/")
+			files[name] = string(content)
+		}
+	}
+
+	return files, nil
+}
+
+// sampleData exercises every variable, to validate templates. It is rendered with one and with several documents.
+var sampleData = Data{
+	Date:      "2025-01-01",
+	Platform:  "Slack",
+	Channel:   Channel{ID: "C012AB3CD", Name: "research"},
+	User:      User{ID: "U012AB3CD", Name: "Satoshi", Title: "Researcher"},
+	ThreadID:  "1735689600.000100",
+	Message:   "What is proof of work?",
+	Documents: []Document{{File: "a.md", Metadata: document.Metadata{Title: "A", Authors: []string{"Satoshi"}, Source: "https://example.com/a"}}, {File: "b.md"}},
+	Summary:   SummaryOptions{Style: StyleTLDR, Bullets: true, Language: "de", Focus: "security"},
+	Section:   Section{Index: 1, Count: 2, Heading: "1 Introduction", Text: "Proof of work is..."},
+	Sections:  []Section{{Index: 1, Count: 2, Heading: "1 Introduction", Text: "The introduction..."}, {Index: 2, Count: 2, Text: "The conclusion..."}},
+	Research: Research{
+		Question:     "How do payment channels scale?",
+		SubQuestion:  "What limits the throughput of payment channels?",
+		MaxQuestions: 4,
+		Findings:     []Finding{{Question: "What limits the throughput of payment channels?", Answer: "Liquidity...", Sources: []int{1}}, {Question: "Who uses them?", Answer: "Unknown."}},
+	},
+}
+
+func (t *Templates) validate() error {
+	for persona := range t.personas {
+		for _, name := range requiredTemplates {
+			for _, n := range []int{1, len(sampleData.Documents)} {
+				data := sampleData
+				data.Persona = persona
+				data.Documents = data.Documents[:n]
+
+				if _, err := t.Render(name, data); err != nil {
+					return fmt.Errorf("invalid template %s%s with persona %s: %w", name, TEMPLATE_EXT, persona, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Personas returns the names of the available personas, sorted.
+func (t *Templates) Personas() []string {
+	names := make([]string, 0, len(t.personas))
+	for name := range t.personas {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// HasPersona returns true if the persona exists.
+func (t *Templates) HasPersona(name string) bool {
+	_, ok := t.personas[name]
+	return ok
+}
+
+// Render renders the named template with the persona in data.
+func (t *Templates) Render(name string, data Data) (string, error) {
+	persona := data.Persona
+	if persona == "" {
+		persona = DefaultPersona
+	}
+
+	tmpl, ok := t.personas[persona]
+	if !ok {
+		return "", fmt.Errorf("unknown persona: %s", persona)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buf.String()), nil
 }
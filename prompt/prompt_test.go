@@ -0,0 +1,153 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mempirate/scholar/document"
+)
+
+func writeTemplate(t *testing.T, dir, name, content string) {
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuiltinTemplates(t *testing.T) {
+	templates, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	personas := templates.Personas()
+	for _, persona := range []string{DefaultPersona, "protocol-engineering", "reading-group"} {
+		if !templates.HasPersona(persona) {
+			t.Errorf("missing persona %s in %v", persona, personas)
+		}
+	}
+
+	data := Data{
+		Date:      "2025-06-01",
+		Platform:  "Slack",
+		Persona:   "reading-group",
+		Channel:   Channel{ID: "C1", Name: "papers"},
+		User:      User{ID: "U1", Name: "Ada", Title: "Engineer"},
+		Documents: []Document{{File: "bitcoin.md", Metadata: document.Metadata{Title: "Bitcoin", Authors: []string{"Satoshi Nakamoto"}}}},
+	}
+
+	instructions, err := templates.Render(SummaryInstructionsTemplate, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"reading group", "Today is 2025-06-01.", "#papers", "Ada (Engineer)"} {
+		if !strings.Contains(instructions, want) {
+			t.Errorf("instructions don't contain %q:\n%s", want, instructions)
+		}
+	}
+
+	summary, err := templates.Render(SummaryTemplate, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(summary, "Please provide a summary of this file: bitcoin.md.") || !strings.Contains(summary, `"Bitcoin" by Satoshi Nakamoto`) {
+		t.Errorf("unexpected summary prompt:\n%s", summary)
+	}
+
+	data.Documents = append(data.Documents, Document{File: "ethereum.md"})
+	if summary, _ = templates.Render(SummaryTemplate, data); !strings.Contains(summary, "these files: bitcoin.md, ethereum.md.") {
+		t.Errorf("unexpected multi-summary prompt:\n%s", summary)
+	}
+
+	data.Summary = SummaryOptions{Style: StyleELI5, Bullets: true, Focus: "incentives"}
+	if summary, _ = templates.Render(SummaryTemplate, data); !strings.Contains(summary, "like I'm five") || !strings.Contains(summary, "bulleted list") || !strings.Contains(summary, "Focus on incentives.") {
+		t.Errorf("summary options weren't rendered:\n%s", summary)
+	}
+
+	data.Summary = SummaryOptions{Structured: true}
+	if summary, _ = templates.Render(SummaryTemplate, data); !strings.HasPrefix(summary, "Please provide a structured summary of each of these files: bitcoin.md, ethereum.md.") {
+		t.Errorf("unexpected structured summary prompt:\n%s", summary)
+	}
+
+	data.Summary = SummaryOptions{Style: StyleELI5, Bullets: true, Focus: "incentives"}
+	if instructions, _ = templates.Render(MentionInstructionsTemplate, data); !strings.Contains(instructions, "Keep its style") {
+		t.Errorf("follow-up instructions don't keep the summary style:\n%s", instructions)
+	}
+
+	data.Documents = data.Documents[:1]
+	data.Section = Section{Index: 2, Count: 12, Heading: "3 Protocol", Text: "Authorities sign transfer orders."}
+	if section, _ := templates.Render(SectionTemplate, data); !strings.HasPrefix(section, `Please summarize section 2 of 12 ("3 Protocol") of "Bitcoin".`) || !strings.HasSuffix(section, "Authorities sign transfer orders.") {
+		t.Errorf("unexpected section prompt:\n%s", section)
+	}
+
+	data.Sections = []Section{{Index: 1, Heading: "1 Introduction", Text: "FastPay is a settlement system."}}
+	if combine, _ := templates.Render(CombineTemplate, data); !strings.Contains(combine, "[§1] 1 Introduction\nFastPay is a settlement system.") {
+		t.Errorf("unexpected combine prompt:\n%s", combine)
+	}
+
+	if _, err := templates.Render(AssistantTemplate, Data{Persona: "pirate"}); err == nil {
+		t.Error("expected an error for an unknown persona")
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "mention.tmpl", "{{.User.ID}} asks: {{.Message}}")
+	writeTemplate(t, dir, "personas/pirate.tmpl", "You are a pirate.")
+	writeTemplate(t, dir, "notes.txt", "{{ not a template")
+
+	templates, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mention, err := templates.Render(MentionTemplate, Data{Persona: "pirate", User: User{ID: "U1"}, Message: "Ahoy?"})
+	if err != nil || mention != "U1 asks: Ahoy?" {
+		t.Errorf("override wasn't used: %q (%v)", mention, err)
+	}
+
+	instructions, err := templates.Render(MentionInstructionsTemplate, Data{Persona: "pirate"})
+	if err != nil || !strings.HasPrefix(instructions, "You are a pirate.") {
+		t.Errorf("persona wasn't used: %q (%v)", instructions, err)
+	}
+
+	// Built-in personas are still available.
+	if !templates.HasPersona("reading-group") {
+		t.Error("built-in personas were dropped")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{name: "syntax", files: map[string]string{"summary.tmpl": "{{range .Documents}}"}},
+		{name: "unknown field", files: map[string]string{"mention.tmpl": "{{.Channel.Topic}}"}},
+		{name: "unknown template", files: map[string]string{"assistant.tmpl": `{{template "missing" .}}`}},
+		{name: "persona", files: map[string]string{"personas/broken.tmpl": "{{.Nope}}"}},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		for name, content := range test.files {
+			writeTemplate(t, dir, name, content)
+		}
+
+		if _, err := Load(dir); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
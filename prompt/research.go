@@ -0,0 +1,127 @@
+package prompt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Research is the state of a /research command, for the research templates.
+type Research struct {
+	// Question is the question of the user, and SubQuestion the step of the plan that is being answered.
+	Question    string
+	SubQuestion string
+	// MaxQuestions is the maximum number of sub-questions of the plan.
+	MaxQuestions int
+	// Findings are the answers to the sub-questions, which the report is written from. Their sources are numbered
+	// in the order of Data.Documents, starting at 1.
+	Findings []Finding
+}
+
+// Finding is the answer to a sub-question of a research.
+type Finding struct {
+	Question string
+	Answer   string
+	// Sources are the numbers of the documents the answer is based on.
+	Sources []int
+}
+
+// ResearchPlan is the response to the research plan prompt.
+type ResearchPlan struct {
+	Questions []string `json:"questions"`
+}
+
+// ResearchPlanSchema returns the JSON schema of ResearchPlan.
+func ResearchPlanSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"questions": map[string]any{
+				"type":        "array",
+				"description": "The sub-questions that together answer the question, in the order they should be researched.",
+				"items":       map[string]any{"type": "string"},
+			},
+		},
+		"required":             []string{"questions"},
+		"additionalProperties": false,
+	}
+}
+
+// ParseResearchPlan parses the response to a research plan prompt. Plans with more than max questions are cut short.
+func ParseResearchPlan(response string, max int) (*ResearchPlan, error) {
+	var plan ResearchPlan
+	if err := decodeStrict(response, &plan); err != nil {
+		return nil, err
+	}
+
+	questions := make([]string, 0, len(plan.Questions))
+	for _, question := range plan.Questions {
+		if question = strings.TrimSpace(question); question != "" {
+			questions = append(questions, question)
+		}
+	}
+
+	if len(questions) == 0 {
+		return nil, errors.New("the plan has no questions")
+	}
+
+	plan.Questions = questions[:min(max, len(questions))]
+	return &plan, nil
+}
+
+// ResearchAnswer is the response to a sub-question of a research, answered from the library.
+type ResearchAnswer struct {
+	Answer string `json:"answer"`
+	// Files are the names of the files the answer is based on.
+	Files []string `json:"files"`
+	// Complete is false if the library doesn't have the information to fully answer the question.
+	Complete bool `json:"complete"`
+	// Queries are web searches that would find the missing information. Set if the answer isn't complete.
+	Queries []string `json:"queries"`
+}
+
+// ResearchAnswerSchema returns the JSON schema of ResearchAnswer.
+func ResearchAnswerSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"answer":   map[string]any{"type": "string", "description": "The answer to the question, based only on the files."},
+			"files":    map[string]any{"type": "array", "description": "The exact names of the files the answer is based on.", "items": map[string]any{"type": "string"}},
+			"complete": map[string]any{"type": "boolean", "description": "Whether the files fully answer the question."},
+			"queries":  map[string]any{"type": "array", "description": "If the answer isn't complete, up to three web searches that would find the missing information.", "items": map[string]any{"type": "string"}},
+		},
+		"required":             []string{"answer", "files", "complete", "queries"},
+		"additionalProperties": false,
+	}
+}
+
+// ParseResearchAnswer parses the response to a research question prompt.
+func ParseResearchAnswer(response string) (*ResearchAnswer, error) {
+	var answer ResearchAnswer
+	if err := decodeStrict(response, &answer); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(answer.Answer) == "" {
+		return nil, errors.New("the answer is empty")
+	}
+
+	if answer.Complete {
+		answer.Queries = nil
+	}
+
+	return &answer, nil
+}
+
+// decodeStrict decodes a JSON response, rejecting unknown fields.
+func decodeStrict(response string, v any) error {
+	dec := json.NewDecoder(strings.NewReader(response))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return nil
+}
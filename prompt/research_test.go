@@ -0,0 +1,65 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mempirate/scholar/document"
+)
+
+func TestParseResearchPlan(t *testing.T) {
+	plan, err := ParseResearchPlan(`{"questions": ["What is FastPay?", " ", "How fast is it?", "Who runs it?"]}`, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Questions) != 2 || plan.Questions[1] != "How fast is it?" {
+		t.Errorf("expected the first two non-empty questions, got %q", plan.Questions)
+	}
+
+	if _, err := ParseResearchPlan(`{"questions": []}`, 2); err == nil {
+		t.Error("expected an error for an empty plan")
+	}
+
+	if _, err := ParseResearchPlan(`{"steps": ["What is FastPay?"]}`, 2); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestParseResearchAnswer(t *testing.T) {
+	answer, err := ParseResearchAnswer(`{"answer": "80,000 TPS.", "files": ["fastpay.md"], "complete": true, "queries": ["fastpay benchmark"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !answer.Complete || len(answer.Files) != 1 || answer.Queries != nil {
+		t.Errorf("unexpected answer %+v", answer)
+	}
+
+	if _, err := ParseResearchAnswer(`{"answer": "", "files": [], "complete": false, "queries": []}`); err == nil {
+		t.Error("expected an error for an empty answer")
+	}
+}
+
+func TestResearchReportTemplate(t *testing.T) {
+	templates := MustLoad("")
+
+	data := Data{
+		Documents: []Document{{File: "fastpay.md", Metadata: document.Metadata{Title: "FastPay", Source: "https://arxiv.org/abs/2003.11506"}}, {File: "bitcoin.md"}},
+		Research: Research{
+			Question: "How fast are payment systems?",
+			Findings: []Finding{{Question: "How fast is FastPay?", Answer: "80,000 TPS.", Sources: []int{1, 2}}},
+		},
+	}
+
+	report, err := templates.Render(ResearchReportTemplate, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"80,000 TPS. (sources: [1][2])", "[1] FastPay (https://arxiv.org/abs/2003.11506)\n[2] bitcoin.md"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report prompt doesn't contain %q:\n%s", want, report)
+		}
+	}
+}
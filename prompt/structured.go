@@ -0,0 +1,203 @@
+package prompt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// StructuredSummary is the summary of a document as a JSON object, so it can be validated, rendered consistently
+// and reused. It is requested with the --structured summary option.
+type StructuredSummary struct {
+	// File is the name of the summarized file.
+	File             string   `json:"file"`
+	Title            string   `json:"title"`
+	TLDR             string   `json:"tldr"`
+	KeyContributions []string `json:"key_contributions"`
+	Methodology      string   `json:"methodology"`
+	Results          []string `json:"results"`
+	Limitations      []string `json:"limitations"`
+	OpenQuestions    []string `json:"open_questions"`
+	RelatedWork      []string `json:"related_work"`
+	NotableQuotes    []Quote  `json:"notable_quotes"`
+}
+
+// Quote is a verbatim quote from a document.
+type Quote struct {
+	Text string `json:"text"`
+	// Context explains where the quote is from, or why it matters.
+	Context string `json:"context"`
+}
+
+// StructuredSummaries is the response to a structured summary prompt, with one summary per file.
+type StructuredSummaries struct {
+	Summaries []StructuredSummary `json:"summaries"`
+}
+
+// StructuredSummarySchema returns the JSON schema of StructuredSummaries. All fields are required, since strict
+// structured outputs don't support optional fields: unknown fields are empty strings or lists.
+func StructuredSummarySchema() map[string]any {
+	text := func(description string) map[string]any {
+		return map[string]any{"type": "string", "description": description}
+	}
+	list := func(description string) map[string]any {
+		return map[string]any{"type": "array", "description": description, "items": map[string]any{"type": "string"}}
+	}
+
+	summary := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"file":              text("The exact name of the summarized file."),
+			"title":             text("The title of the document."),
+			"tldr":              text("The main takeaway, in two or three sentences."),
+			"key_contributions": list("The key contributions of the document."),
+			"methodology":       text("How the results were obtained. Empty if not applicable."),
+			"results":           list("The main results, with numbers where available."),
+			"limitations":       list("The limitations of the approach or the results."),
+			"open_questions":    list("Questions the document leaves open."),
+			"related_work":      list("Related work referenced by the document."),
+			"notable_quotes": map[string]any{
+				"type":        "array",
+				"description": "Verbatim quotes from the document.",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"text":    text("The exact text of the quote."),
+						"context": text("Where the quote is from, or why it matters."),
+					},
+					"required":             []string{"text", "context"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required": []string{
+			"file", "title", "tldr", "key_contributions", "methodology", "results",
+			"limitations", "open_questions", "related_work", "notable_quotes",
+		},
+		"additionalProperties": false,
+	}
+
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"summaries": map[string]any{"type": "array", "items": summary},
+		},
+		"required":             []string{"summaries"},
+		"additionalProperties": false,
+	}
+}
+
+// ParseStructuredSummaries parses and validates the response to a structured summary prompt for the given files.
+func ParseStructuredSummaries(response string, files []string) ([]StructuredSummary, error) {
+	var parsed StructuredSummaries
+	if err := decodeStrict(response, &parsed); err != nil {
+		return nil, err
+	}
+
+	if len(parsed.Summaries) == 0 {
+		return nil, fmt.Errorf("no summaries")
+	}
+
+	for i, summary := range parsed.Summaries {
+		if err := summary.Validate(files); err != nil {
+			return nil, fmt.Errorf("summary %d: %w", i+1, err)
+		}
+	}
+
+	return parsed.Summaries, nil
+}
+
+// Validate checks that the summary is of one of the given files, and has a TL;DR and contributions.
+func (s *StructuredSummary) Validate(files []string) error {
+	if !slices.Contains(files, s.File) {
+		return fmt.Errorf("unknown file %q, expected one of %s", s.File, strings.Join(files, ", "))
+	}
+
+	if strings.TrimSpace(s.TLDR) == "" {
+		return fmt.Errorf("empty tldr")
+	}
+
+	if len(s.KeyContributions) == 0 {
+		return fmt.Errorf("no key contributions")
+	}
+
+	for _, quote := range s.NotableQuotes {
+		if strings.TrimSpace(quote.Text) == "" {
+			return fmt.Errorf("empty quote")
+		}
+	}
+
+	return nil
+}
+
+// Markdown renders the summary in Slack's markdown, which Discord renders as well. Empty sections are left out.
+func (s *StructuredSummary) Markdown() string {
+	var b strings.Builder
+
+	title := s.Title
+	if title == "" {
+		title = s.File
+	}
+	fmt.Fprintf(&b, "*%s*\n%s\n", title, s.TLDR)
+
+	list := func(heading string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+
+		fmt.Fprintf(&b, "\n*%s*\n", heading)
+		for _, item := range items {
+			fmt.Fprintf(&b, "• %s\n", item)
+		}
+	}
+
+	list("Key contributions", s.KeyContributions)
+	if s.Methodology != "" {
+		fmt.Fprintf(&b, "\n*Methodology*\n%s\n", s.Methodology)
+	}
+	list("Results", s.Results)
+	list("Limitations", s.Limitations)
+	list("Open questions", s.OpenQuestions)
+	list("Related work", s.RelatedWork)
+
+	if len(s.NotableQuotes) > 0 {
+		b.WriteString("\n*Notable quotes*\n")
+		for _, quote := range s.NotableQuotes {
+			fmt.Fprintf(&b, "> \"%s\"", quote.Text)
+			if quote.Context != "" {
+				fmt.Fprintf(&b, " — %s", quote.Context)
+			}
+			b.WriteByte('\n')
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// Text returns the summary as plain text, for searching.
+func (s *StructuredSummary) Text() string {
+	parts := []string{s.Title, s.TLDR, s.Methodology}
+	for _, items := range [][]string{s.KeyContributions, s.Results, s.Limitations, s.OpenQuestions, s.RelatedWork} {
+		parts = append(parts, items...)
+	}
+	for _, quote := range s.NotableQuotes {
+		parts = append(parts, quote.Text, quote.Context)
+	}
+
+	return strings.Join(slices.DeleteFunc(parts, func(part string) bool { return part == "" }), "\n")
+}
+
+// MarshalIndent returns the summary as indented JSON, as it is stored next to its document.
+func (s *StructuredSummary) MarshalIndent() ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
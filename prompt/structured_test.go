@@ -0,0 +1,67 @@
+package prompt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseStructuredSummaries(t *testing.T) {
+	response := `{"summaries": [{
+		"file": "bitcoin.md",
+		"title": "Bitcoin",
+		"tldr": "Electronic cash without a trusted third party.",
+		"key_contributions": ["Proof-of-work timestamps"],
+		"methodology": "",
+		"results": [],
+		"limitations": ["Requires an honest majority"],
+		"open_questions": [],
+		"related_work": [],
+		"notable_quotes": [{"text": "A purely peer-to-peer version of electronic cash", "context": "Abstract"}]
+	}]}`
+
+	summaries, err := ParseStructuredSummaries(response, []string{"bitcoin.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := summaries[0].Markdown()
+	for _, want := range []string{"*Bitcoin*\nElectronic cash", "*Limitations*\n• Requires an honest majority", `> "A purely peer-to-peer version of electronic cash" — Abstract`} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("markdown doesn't contain %q:\n%s", want, markdown)
+		}
+	}
+
+	// Empty sections are left out.
+	if strings.Contains(markdown, "Methodology") || strings.Contains(markdown, "Results") {
+		t.Errorf("markdown contains empty sections:\n%s", markdown)
+	}
+
+	invalid := map[string]string{
+		"json":         `{"summaries": [`,
+		"unknown file": strings.Replace(response, "bitcoin.md", "ethereum.md", 1),
+		"empty tldr":   strings.Replace(response, "Electronic cash without a trusted third party.", "", 1),
+		"extra field":  strings.Replace(response, `"title"`, `"score": 5, "title"`, 1),
+		"no summaries": `{"summaries": []}`,
+	}
+
+	for name, response := range invalid {
+		if _, err := ParseStructuredSummaries(response, []string{"bitcoin.md"}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestStructuredSummarySchema(t *testing.T) {
+	schema := StructuredSummarySchema()
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// Strict structured outputs require every property to be required.
+	summary := schema["properties"].(map[string]any)["summaries"].(map[string]any)["items"].(map[string]any)
+	properties := summary["properties"].(map[string]any)
+	if required := summary["required"].([]string); len(required) != len(properties) {
+		t.Errorf("%d properties, but %d required", len(properties), len(required))
+	}
+}
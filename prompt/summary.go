@@ -0,0 +1,94 @@
+package prompt
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type SummaryStyle = string
+
+const (
+	// StyleDefault is a regular summary.
+	StyleDefault SummaryStyle = ""
+	// StyleTLDR is a summary of two or three sentences.
+	StyleTLDR SummaryStyle = "tldr"
+	// StyleDetailed is a section by section summary.
+	StyleDetailed SummaryStyle = "detailed"
+	// StyleELI5 explains the document in simple words.
+	StyleELI5 SummaryStyle = "eli5"
+	// StyleTechnical keeps the terminology, algorithms and numbers of the document.
+	StyleTechnical SummaryStyle = "technical"
+)
+
+// SummaryStyles are the styles a summary can be written in, besides the default.
+var SummaryStyles = []SummaryStyle{StyleTLDR, StyleDetailed, StyleELI5, StyleTechnical}
+
+// MAX_FOCUS_LENGTH is the maximum length of a summary's focus topic.
+const MAX_FOCUS_LENGTH = 200
+
+// languageRegex matches language codes like "de" or "pt-BR".
+var languageRegex = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})?$`)
+
+// SummaryOptions customize a summary. The zero value is the default summary. They are rendered by the
+// "summary_options" template.
+type SummaryOptions struct {
+	Style SummaryStyle `json:"style,omitempty"`
+	// Bullets formats the summary as a list.
+	Bullets bool `json:"bullets,omitempty"`
+	// Language is the code of the language to write the summary in, e.g. "de". Empty is the language of the prompt.
+	Language string `json:"lang,omitempty"`
+	// Focus is a topic the summary should focus on.
+	Focus string `json:"focus,omitempty"`
+	// Structured requests a StructuredSummary of each document instead of free text.
+	Structured bool `json:"structured,omitempty"`
+}
+
+// IsZero returns true if the options are the defaults.
+func (o SummaryOptions) IsZero() bool {
+	return o == SummaryOptions{}
+}
+
+// Validate checks the style, language code and focus.
+func (o SummaryOptions) Validate() error {
+	if o.Style != StyleDefault && !slices.Contains(SummaryStyles, o.Style) {
+		return fmt.Errorf("unknown summary style: %s", o.Style)
+	}
+
+	if o.Language != "" && !languageRegex.MatchString(o.Language) {
+		return fmt.Errorf("invalid language code: %s", o.Language)
+	}
+
+	if o.Structured && o.Bullets {
+		return fmt.Errorf("structured summaries can't be formatted as bullets")
+	}
+
+	if len(o.Focus) > MAX_FOCUS_LENGTH {
+		return fmt.Errorf("focus must be at most %d characters", MAX_FOCUS_LENGTH)
+	}
+
+	return nil
+}
+
+// String describes the options, e.g. "structured, tldr, lang=de, focus: consensus". It is empty for the defaults.
+func (o SummaryOptions) String() string {
+	var parts []string
+	if o.Structured {
+		parts = append(parts, "structured")
+	}
+	if o.Style != StyleDefault {
+		parts = append(parts, o.Style)
+	}
+	if o.Bullets {
+		parts = append(parts, "bullets")
+	}
+	if o.Language != "" {
+		parts = append(parts, "lang="+o.Language)
+	}
+	if o.Focus != "" {
+		parts = append(parts, "focus: "+o.Focus)
+	}
+
+	return strings.Join(parts, ", ")
+}
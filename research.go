@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/config"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/search"
+	"github.com/mempirate/scholar/store"
+)
+
+// newSearchProvider returns the web search provider of /research, or nil if web search is disabled.
+func newSearchProvider(config config.ResearchConfig, firecrawlKey string) search.Provider {
+	if config.Search == search.ProviderFirecrawl && firecrawlKey != "" {
+		return search.NewFirecrawl(firecrawlKey, "")
+	}
+
+	return nil
+}
+
+// handleResearch researches the question of a /research command in a new thread, where it posts the steps of the
+// research and the report. The report is saved to the library, and new sources are added to it.
+func (a *app) handleResearch(ctx context.Context, fe chat.Frontend, cmd chat.Command) {
+	log := a.log.With().Str("frontend", fe.Name()).Logger()
+	reporter := newCommandReporter(log, fe, cmd)
+
+	// Research adds the sources it finds to the library.
+	if err := a.authorizer.Authorize(cmd.UserID, cmd.ChannelID, access.ActionIngest); err != nil {
+		log.Info().Str("user_id", cmd.UserID).Str("channel_id", cmd.ChannelID).Str("command", cmd.CommandType).Msg("Command denied")
+		reporter.Done(err.Error())
+		return
+	}
+
+	tenant, err := a.tenants.Get(ctx, cmd.TeamID)
+	if err != nil {
+		log.Error().Err(err).Str("team_id", cmd.TeamID).Msg("Failed to load workspace")
+		reporter.Done(fmt.Sprintf("Failed to load workspace: %s", err))
+		return
+	}
+
+	if err := checkModel(tenant.backend.UserModels(), cmd.Model); err != nil {
+		reporter.Done(err.Error())
+		return
+	}
+
+	scope := commandScope(fe, cmd)
+	ctx = withConversation(ctx, commandConversation(fe, cmd, scope))
+	ctx = tenant.withModel(ctx, backend.TaskResearch, cmd.Model)
+
+	channelID, threadID, err := startCommandThread(ctx, fe, tenant, cmd, scope, fmt.Sprintf("Researching: %s", cmd.Text))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start thread")
+		reporter.Done(err.Error())
+		return
+	}
+
+	reporter.Stage(stageResearching)
+
+	data := promptData(fe, tenant, cmd.TeamID, channelID, cmd.UserID)
+	data.ThreadID = threadID
+	data.Research.Question = cmd.Text
+	data.Research.MaxQuestions = tenant.researchConfig.MaxQuestions
+
+	r := newResearcher(log, tenant, scope, data, func(text string) {
+		if err := fe.PostMessage(cmd.TeamID, channelID, &threadID, text); err != nil {
+			log.Error().Err(err).Msg("Failed to post research step")
+		}
+	})
+
+	log.Info().Str("user_id", cmd.UserID).Str("thread_id", threadID).Str("question", cmd.Text).Msg("Researching")
+
+	name, err := r.Run(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Research failed")
+		reporter.Done(fmt.Sprintf("Research failed: %s", errorMessage(err)))
+		return
+	}
+
+	reporter.Done(fmt.Sprintf("Research done: %d new sources were added to the library, and the report was saved as %s.", r.added, name))
+}
+
+// researcher researches a question: it breaks the question into sub-questions, answers them from the library, and
+// searches the web for new sources where the library falls short, within the budget of the research settings.
+// Finally, it writes a report from the answers that cites their sources.
+type researcher struct {
+	log    zerolog.Logger
+	tenant *tenant
+	// scope is the library new sources and the report are added to.
+	scope store.Scope
+	// data are the template variables of the research, with its question and thread.
+	data prompt.Data
+	// post posts a step of the research in the thread.
+	post func(text string)
+
+	// searches and sources are what is left of the budget of web searches and new sources.
+	searches int
+	sources  int
+	// added is the number of sources added to the library.
+	added int
+	// seen are the URLs of search results that were already considered.
+	seen map[string]bool
+	// cited are the documents the answers are based on, numbered in the order they were first cited.
+	cited []prompt.Document
+}
+
+func newResearcher(log zerolog.Logger, tenant *tenant, scope store.Scope, data prompt.Data, post func(string)) *researcher {
+	return &researcher{
+		log:      log,
+		tenant:   tenant,
+		scope:    scope,
+		data:     data,
+		post:     post,
+		searches: tenant.researchConfig.MaxSearches,
+		sources:  tenant.researchConfig.MaxSources,
+		seen:     make(map[string]bool),
+	}
+}
+
+// Run researches the question, posts the report in the thread and saves it to the library. It returns the file name
+// of the report.
+func (r *researcher) Run(ctx context.Context) (string, error) {
+	questions, err := r.plan(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	r.post(formatPlan(questions))
+
+	findings := make([]prompt.Finding, 0, len(questions))
+	for i, question := range questions {
+		r.post(fmt.Sprintf("*%d/%d* %s\nSearching the library...", i+1, len(questions), question))
+
+		finding, err := r.answer(ctx, question)
+		if err != nil {
+			return "", err
+		}
+
+		findings = append(findings, finding)
+	}
+
+	r.post("Writing the report...")
+
+	report, err := r.report(ctx, findings)
+	if err != nil {
+		return "", err
+	}
+
+	// Follow-up questions in the thread are answered with the report in context.
+	if err := r.tenant.backend.Post(ctx, r.data.ThreadID, report); err != nil {
+		r.log.Warn().Err(err).Msg("Failed to post report to the assistant thread")
+	}
+
+	r.post(withModelFooter(ctx, report))
+
+	name := researchDocumentName(r.data.Research.Question, time.Now())
+	if err := r.save(ctx, name, report); err != nil {
+		return "", fmt.Errorf("failed to save report: %w", err)
+	}
+
+	return name, nil
+}
+
+// plan breaks the question into sub-questions.
+func (r *researcher) plan(ctx context.Context) ([]string, error) {
+	format := backend.ResponseFormat{
+		Name:        "research_plan",
+		Description: "The sub-questions of a research question.",
+		Schema:      prompt.ResearchPlanSchema(),
+	}
+
+	var plan *prompt.ResearchPlan
+	err := r.tenant.promptJSON(ctx, r.data.ThreadID, prompt.ResearchInstructionsTemplate, prompt.ResearchPlanTemplate, r.data, format, func(response string) (err error) {
+		plan, err = prompt.ParseResearchPlan(response, r.data.Research.MaxQuestions)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan research: %w", err)
+	}
+
+	return plan.Questions, nil
+}
+
+// answer answers a sub-question from the library. While the library falls short and the budget allows, it searches
+// the web for new sources, adds them to the library and asks again.
+func (r *researcher) answer(ctx context.Context, question string) (prompt.Finding, error) {
+	format := backend.ResponseFormat{
+		Name:        "research_answer",
+		Description: "The answer to a sub-question of a research, from the files.",
+		Schema:      prompt.ResearchAnswerSchema(),
+	}
+
+	data := r.data
+	data.Research.SubQuestion = question
+
+	for round := 0; ; round++ {
+		var answer *prompt.ResearchAnswer
+		err := r.tenant.promptJSON(ctx, data.ThreadID, prompt.ResearchInstructionsTemplate, prompt.ResearchQuestionTemplate, data, format, func(response string) (err error) {
+			answer, err = prompt.ParseResearchAnswer(response)
+			return err
+		})
+		if err != nil {
+			return prompt.Finding{}, fmt.Errorf("failed to answer %q: %w", question, err)
+		}
+
+		finding := prompt.Finding{Question: question, Answer: answer.Answer, Sources: r.cite(answer.Files)}
+
+		if answer.Complete {
+			return finding, nil
+		}
+
+		if round == r.tenant.researchConfig.MaxRounds || !r.canSearch() {
+			r.post("The library doesn't fully answer this. Moving on with a partial answer.")
+			return finding, nil
+		}
+
+		queries := answer.Queries
+		if len(queries) == 0 {
+			queries = []string{question}
+		}
+
+		r.post(fmt.Sprintf("The library doesn't fully answer this. Searching the web for: %s", strings.Join(queries, "; ")))
+
+		added := r.searchWeb(ctx, queries)
+		if len(added) == 0 {
+			r.post("No new sources found. Moving on with a partial answer.")
+			return finding, nil
+		}
+
+		r.post(formatAdded(added))
+		data.Documents = added
+	}
+}
+
+// canSearch returns true if web search is enabled, and the budget allows another search that adds sources.
+func (r *researcher) canSearch() bool {
+	return r.tenant.search != nil && r.searches > 0 && r.sources > 0
+}
+
+// searchWeb searches the web for the queries, and adds the results to the library while the budget allows. It
+// returns the documents that were added.
+func (r *researcher) searchWeb(ctx context.Context, queries []string) []prompt.Document {
+	var added []prompt.Document
+
+	for _, query := range queries {
+		if !r.canSearch() {
+			break
+		}
+
+		r.searches--
+		results, err := r.tenant.search.Search(ctx, query, r.tenant.researchConfig.ResultsPerSearch)
+		if err != nil {
+			r.log.Warn().Err(err).Str("query", query).Str("provider", r.tenant.search.Name()).Msg("Web search failed")
+			continue
+		}
+
+		for _, uri := range r.newResults(results) {
+			if r.sources == 0 {
+				break
+			}
+
+			doc, name, err := r.tenant.ingester.Ingest(ctx, uri, ingestOptions{Dedup: true, Scope: r.scope, UploadedBy: r.data.User.ID}, nil)
+			switch {
+			case err == nil:
+				r.sources--
+				r.added++
+				added = append(added, prompt.Document{File: name, Metadata: doc.Metadata})
+			case errors.Is(err, errDuplicate):
+				// The library already has it, so the assistant could have found it.
+			default:
+				r.log.Warn().Err(err).Str("url", uri.String()).Msg("Failed to add search result")
+			}
+		}
+	}
+
+	return added
+}
+
+// newResults returns the links of search results that weren't considered before, e.g. by an earlier search.
+func (r *researcher) newResults(results []search.Result) []*url.URL {
+	var urls []*url.URL
+	for _, result := range results {
+		uri, err := validateWebURL(result.URL)
+		if err != nil || r.seen[uri.String()] {
+			continue
+		}
+
+		r.seen[uri.String()] = true
+		urls = append(urls, uri)
+	}
+
+	return urls
+}
+
+// cite returns the numbers of the given files as sources of the report, numbering the files that weren't cited
+// before. Files that aren't in the library, e.g. because the assistant made them up, are dropped.
+func (r *researcher) cite(files []string) []int {
+	var numbers []int
+	for _, file := range files {
+		i := slices.IndexFunc(r.cited, func(doc prompt.Document) bool { return doc.File == file })
+		if i < 0 {
+			doc, err := readDocument(r.tenant.fileStore.Scoped(r.scope), file)
+			if err != nil && !r.scope.IsPublic() {
+				doc, err = readDocument(r.tenant.fileStore, file)
+			}
+			if err != nil {
+				r.log.Debug().Str("file", file).Msg("Dropping citation of a file that isn't in the library")
+				continue
+			}
+
+			r.cited = append(r.cited, prompt.Document{File: file, Metadata: doc.Metadata})
+			i = len(r.cited) - 1
+		}
+
+		if !slices.Contains(numbers, i+1) {
+			numbers = append(numbers, i+1)
+		}
+	}
+
+	return numbers
+}
+
+// report writes the report from the findings, and lists the cited sources at its end.
+func (r *researcher) report(ctx context.Context, findings []prompt.Finding) (string, error) {
+	data := r.data
+	data.Research.Findings = findings
+	data.Documents = r.cited
+
+	instructions, message, err := r.tenant.renderPrompt(prompt.ResearchInstructionsTemplate, prompt.ResearchReportTemplate, data)
+	if err != nil {
+		return "", err
+	}
+
+	report, err := r.tenant.backend.Complete(ctx, instructions, message)
+	if err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+
+	if references := researchReferences(r.cited); references != "" {
+		report += "\n\n" + references
+	}
+
+	return report, nil
+}
+
+// save stores the report in the library, derived from the documents it cites.
+func (r *researcher) save(ctx context.Context, name, report string) error {
+	var files, sources []string
+	for _, doc := range r.cited {
+		files = append(files, doc.File)
+		if doc.Source != "" {
+			sources = append(sources, doc.Source)
+		}
+	}
+
+	doc := &document.Document{
+		Content: []byte(report),
+		Metadata: document.Metadata{
+			Title:         "Research: " + r.data.Research.Question,
+			Type:          document.TypeReport,
+			ProcessedTime: time.Now().Format(time.RFC3339),
+			Links:         sources,
+			UploadedBy:    r.data.User.ID,
+			DerivedFrom:   files,
+		},
+	}
+	setScope(&doc.Metadata, r.scope)
+
+	return r.tenant.storeDocument(ctx, r.scope, name, doc)
+}
+
+// formatPlan lists the sub-questions of a research.
+func formatPlan(questions []string) string {
+	var b strings.Builder
+	b.WriteString("*Plan*")
+	for i, question := range questions {
+		fmt.Fprintf(&b, "\n%d. %s", i+1, question)
+	}
+
+	return b.String()
+}
+
+// formatAdded lists the sources added to the library by a web search.
+func formatAdded(docs []prompt.Document) string {
+	var b strings.Builder
+	b.WriteString("Added to the library:")
+	for _, doc := range docs {
+		title := doc.Title
+		if title == "" {
+			title = doc.File
+		}
+		fmt.Fprintf(&b, "\n• %s [%s]", title, doc.Source)
+	}
+
+	return b.String()
+}
+
+// researchReferences lists the sources of a report by number, with their links.
+func researchReferences(docs []prompt.Document) string {
+	if len(docs) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("---")
+	for i, doc := range docs {
+		title := doc.Title
+		if title == "" {
+			title = doc.File
+		}
+
+		fmt.Fprintf(&b, "\n[%d] %s", i+1, title)
+		if doc.Source != "" {
+			fmt.Fprintf(&b, " (%s)", doc.Source)
+		}
+	}
+
+	return b.String()
+}
+
+// MAX_RESEARCH_NAME_LENGTH is the maximum length of the question in the file name of a report.
+const MAX_RESEARCH_NAME_LENGTH = 60
+
+var nonAlphanumericRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// researchDocumentName returns the file name of the report of a question, e.g.
+// "research-how-do-payment-channels-scale-2025-01-01.md". Reports of the same question on the same day replace
+// each other.
+func researchDocumentName(question string, date time.Time) string {
+	slug := nonAlphanumericRegex.ReplaceAllString(strings.ToLower(question), "-")
+	if len(slug) > MAX_RESEARCH_NAME_LENGTH {
+		slug = slug[:MAX_RESEARCH_NAME_LENGTH]
+	}
+
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		return fmt.Sprintf("research-%s.md", date.Format(time.DateOnly))
+	}
+
+	return fmt.Sprintf("research-%s-%s.md", slug, date.Format(time.DateOnly))
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/config"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/search"
+	"github.com/mempirate/scholar/store"
+)
+
+func TestResearchCite(t *testing.T) {
+	fs := store.NewFileStore(t.TempDir())
+	channel := store.ChannelScope("C123")
+
+	for _, doc := range []struct {
+		scope store.Scope
+		doc   *document.Document
+	}{
+		{store.PublicScope, &document.Document{Content: []byte("# FastPay\n"), Metadata: document.Metadata{Source: "https://arxiv.org/abs/2003.11506"}}},
+		{channel, &document.Document{Content: []byte("# Bitcoin\n")}},
+	} {
+		name, markdown, err := doc.doc.ToMarkdown()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fs.Scoped(doc.scope).Store(name, strings.NewReader(string(markdown))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tenant := &tenant{fileStore: fs, researchConfig: config.Default().Research}
+	r := newResearcher(zerolog.Nop(), tenant, channel, prompt.Data{}, func(string) {})
+
+	if sources := r.cite([]string{"Bitcoin.md", "made-up.md", "FastPay.md", "Bitcoin.md"}); len(sources) != 2 || sources[0] != 1 || sources[1] != 2 {
+		t.Errorf("expected sources [1 2], got %v", sources)
+	}
+
+	if sources := r.cite([]string{"FastPay.md"}); len(sources) != 1 || sources[0] != 2 {
+		t.Errorf("expected FastPay to keep its number, got %v", sources)
+	}
+
+	references := researchReferences(r.cited)
+	if want := "---\n[1] Bitcoin\n[2] FastPay (https://arxiv.org/abs/2003.11506)"; references != want {
+		t.Errorf("expected references:\n%s\ngot:\n%s", want, references)
+	}
+}
+
+func TestResearchNewResults(t *testing.T) {
+	local := search.NewLocal([]search.Result{
+		{Title: "FastPay", URL: "https://arxiv.org/abs/2003.11506", Description: "Byzantine fault tolerant settlement"},
+		{Title: "Narwhal and Tusk", URL: "https://arxiv.org/abs/2105.11827", Description: "BFT consensus"},
+		{Title: "Local file", URL: "file:///etc/passwd", Description: "BFT"},
+	})
+
+	tenant := &tenant{researchConfig: config.Default().Research, search: local}
+	r := newResearcher(zerolog.Nop(), tenant, store.PublicScope, prompt.Data{}, func(string) {})
+
+	results, err := local.Search(context.Background(), "byzantine fault tolerant", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if urls := r.newResults(results); len(urls) != 1 || urls[0].String() != "https://arxiv.org/abs/2003.11506" {
+		t.Errorf("expected FastPay, got %v", urls)
+	}
+
+	// Results of earlier searches aren't considered again, and only web links are.
+	results, _ = local.Search(context.Background(), "BFT", 3)
+	if urls := r.newResults(results); len(urls) != 1 || urls[0].String() != "https://arxiv.org/abs/2105.11827" {
+		t.Errorf("expected Narwhal and Tusk, got %v", urls)
+	}
+
+	if !r.canSearch() {
+		t.Error("expected the budget to allow searching")
+	}
+
+	r.searches = 0
+	if r.canSearch() {
+		t.Error("expected the budget to be spent")
+	}
+}
+
+// fakeBackend answers prompts without the OpenAI API. Research answers are never complete: they cite the uploaded
+// files and a made-up one, and ask to search the next queries.
+type fakeBackend struct {
+	backend.ScholarBackend
+
+	queries  []string
+	uploaded []string
+	// report is the message the report was written from.
+	report string
+	// prompts and posts count the messages in threads that were and weren't answered.
+	prompts int
+	posts   int
+	// threadErr is returned when a thread is created.
+	threadErr error
+}
+
+func (b *fakeBackend) CreateThread(ctx context.Context, threadID string, scope store.Scope) error {
+	return b.threadErr
+}
+
+func (b *fakeBackend) Prompt(ctx context.Context, threadID, instructions, text string) (string, error) {
+	b.prompts++
+	return "A peer-to-peer electronic cash system.", nil
+}
+
+func (b *fakeBackend) UserModels() []string {
+	return nil
+}
+
+func (b *fakeBackend) TaskModel(task string) string {
+	return "gpt-4o"
+}
+
+func (b *fakeBackend) PromptJSON(ctx context.Context, threadID, instructions, text string, format backend.ResponseFormat) (string, error) {
+	if format.Name == "research_plan" {
+		return `{"questions":["How fast is FastPay?","How does Narwhal scale?"]}`, nil
+	}
+
+	n := min(3, len(b.queries))
+	answer := prompt.ResearchAnswer{Answer: "Partly.", Files: append(slices.Clone(b.uploaded), "made-up.md"), Queries: b.queries[:n]}
+	b.queries = b.queries[n:]
+
+	response, err := json.Marshal(answer)
+	return string(response), err
+}
+
+func (b *fakeBackend) Complete(ctx context.Context, instructions, text string) (string, error) {
+	b.report = text
+	return "Payment systems scale [1][2].", nil
+}
+
+func (b *fakeBackend) Post(ctx context.Context, threadID, text string) error {
+	b.posts++
+	return nil
+}
+
+func (b *fakeBackend) UploadFile(ctx context.Context, scope store.Scope, name string, content io.Reader) error {
+	b.uploaded = append(b.uploaded, name)
+	return nil
+}
+
+// fakeContent fetches pages without the network. Pages are titled after the path of their URL.
+type fakeContent struct {
+	fetched []string
+}
+
+func (c *fakeContent) HandleURL(uri *url.URL) (*document.Document, error) {
+	c.fetched = append(c.fetched, uri.String())
+
+	title := strings.TrimPrefix(uri.Path, "/")
+	return &document.Document{Content: []byte("# " + title + "\n"), Metadata: document.Metadata{Title: title, Source: uri.String()}}, nil
+}
+
+// countingSearch records the queries of a search provider.
+type countingSearch struct {
+	search.Provider
+	queries []string
+}
+
+func (s *countingSearch) Search(ctx context.Context, query string, limit int) ([]search.Result, error) {
+	s.queries = append(s.queries, query)
+	return s.Provider.Search(ctx, query, limit)
+}
+
+func TestResearchRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSearches int
+		maxSources  int
+		// queries are the searches made, and fetched the links that were added to the library.
+		queries []string
+		fetched []string
+	}{
+		{
+			name:        "source cap",
+			maxSearches: 10,
+			maxSources:  2,
+			queries:     []string{"alpha", "beta", "gamma"},
+			fetched:     []string{"https://example.com/alpha", "https://example.com/gamma"},
+		},
+		{
+			name:        "query budget",
+			maxSearches: 2,
+			maxSources:  10,
+			queries:     []string{"alpha", "beta"},
+			fetched:     []string{"https://example.com/alpha"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := store.NewFileStore(t.TempDir())
+
+			// Links that are already in the library aren't fetched again, and don't count as new sources.
+			existing := &document.Document{Content: []byte("# beta\n"), Metadata: document.Metadata{Title: "beta", Source: "https://example.com/beta"}}
+			existingName, markdown, err := existing.ToMarkdown()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := fs.Store(existingName, strings.NewReader(string(markdown))); err != nil {
+				t.Fatal(err)
+			}
+
+			var results []search.Result
+			for _, name := range []string{"alpha", "beta", "gamma", "delta", "epsilon"} {
+				results = append(results, search.Result{Title: name, URL: "https://example.com/" + name, Description: "Payments"})
+			}
+
+			researchConfig := config.Default().Research
+			researchConfig.MaxRounds = 5
+			researchConfig.MaxSearches = test.maxSearches
+			researchConfig.MaxSources = test.maxSources
+
+			fb := &fakeBackend{queries: []string{"alpha", "beta", "gamma", "delta", "epsilon"}}
+			fc := &fakeContent{}
+			searches := &countingSearch{Provider: search.NewLocal(results)}
+
+			tenant := &tenant{
+				fileStore:      fs,
+				backend:        fb,
+				templates:      config.Default().Prompts.Templates,
+				researchConfig: researchConfig,
+				search:         searches,
+				ingester:       &ingester{log: zerolog.Nop(), contentHandler: fc, fileStore: fs, backend: fb, concurrency: 1},
+			}
+
+			data := prompt.Data{ThreadID: "t1"}
+			data.Research.Question = "How do payment systems scale?"
+			data.Research.MaxQuestions = researchConfig.MaxQuestions
+
+			var posts []string
+			r := newResearcher(zerolog.Nop(), tenant, store.PublicScope, data, func(text string) { posts = append(posts, text) })
+
+			name, err := r.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(searches.queries, test.queries) {
+				t.Errorf("expected searches %v, got %v", test.queries, searches.queries)
+			}
+
+			if !slices.Equal(fc.fetched, test.fetched) || r.added != len(test.fetched) {
+				t.Errorf("expected %v to be added, got %v (%d)", test.fetched, fc.fetched, r.added)
+			}
+
+			// The report only cites the fetched sources, not the made-up file.
+			var references []string
+			for i, uri := range test.fetched {
+				title := strings.TrimPrefix(uri, "https://example.com/")
+				references = append(references, fmt.Sprintf("[%d] %s (%s)", i+1, title, uri))
+			}
+
+			want := "Payment systems scale [1][2].\n\n---\n" + strings.Join(references, "\n")
+			if report := posts[len(posts)-1]; report != want {
+				t.Errorf("expected report:\n%s\ngot:\n%s", want, report)
+			}
+
+			if strings.Contains(fb.report, "made-up.md") {
+				t.Error("the report was written from a made-up source")
+			}
+
+			if ok, err := fs.Contains(name); err != nil || !ok {
+				t.Errorf("the report %s wasn't saved (%v)", name, err)
+			}
+		})
+	}
+}
+
+func TestResearchDocumentName(t *testing.T) {
+	date := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		question string
+		expected string
+	}{
+		{"How do payment channels scale?", "research-how-do-payment-channels-scale-2025-01-01.md"},
+		{"Was ist Proof-of-Stake?", "research-was-ist-proof-of-stake-2025-01-01.md"},
+		{"???", "research-2025-01-01.md"},
+		{strings.Repeat("consensus ", 10), "research-consensus-consensus-consensus-consensus-consensus-consensus-2025-01-01.md"},
+	}
+
+	for _, test := range tests {
+		if name := researchDocumentName(test.question, date); name != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.question, test.expected, name)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package scrape
 
 import (
 	"net/url"
+	"slices"
 	"time"
 
 	"github.com/mempirate/scholar/document"
@@ -12,6 +13,38 @@ import (
 
 const FIRECRAWL_API = "https://api.firecrawl.dev"
 
+// FirecrawlConfig are the settings of Firecrawl scrapes.
+type FirecrawlConfig struct {
+	// Timeout is the time Firecrawl has to scrape a page.
+	Timeout time.Duration `yaml:"timeout"`
+	// Formats are the formats to scrape. The markdown format is required.
+	Formats []string `yaml:"formats"`
+	// ParsePDF converts PDFs to markdown.
+	ParsePDF bool `yaml:"parse_pdf"`
+}
+
+// DefaultFirecrawlConfig returns the default settings.
+func DefaultFirecrawlConfig() FirecrawlConfig {
+	return FirecrawlConfig{
+		Timeout:  90 * time.Second,
+		Formats:  []string{"markdown", "links"},
+		ParsePDF: true,
+	}
+}
+
+// Validate checks the settings.
+func (c *FirecrawlConfig) Validate() error {
+	if c.Timeout < time.Second {
+		return errors.New("timeout must be at least 1s")
+	}
+
+	if !slices.Contains(c.Formats, "markdown") {
+		return errors.New("formats must include markdown")
+	}
+
+	return nil
+}
+
 // FirecrawlScraper is a scraper that uses the Firecrawl API to scrape web pages.
 type FirecrawlScraper struct {
 	app *firecrawl.FirecrawlApp
@@ -19,18 +52,17 @@ type FirecrawlScraper struct {
 	params *firecrawl.ScrapeParams
 }
 
-func NewFirecrawlScraper(key string) (*FirecrawlScraper, error) {
+func NewFirecrawlScraper(key string, config FirecrawlConfig) (*FirecrawlScraper, error) {
 	app, err := firecrawl.NewFirecrawlApp(key, FIRECRAWL_API)
 	if err != nil {
 		return nil, err
 	}
 
-	scrapePDF := true
-	timeout := 90_000
+	timeout := int(config.Timeout.Milliseconds())
 
 	defaultParams := &firecrawl.ScrapeParams{
-		Formats:  []string{"markdown", "links"},
-		ParsePDF: &scrapePDF,
+		Formats:  config.Formats,
+		ParsePDF: &config.ParsePDF,
 		Timeout:  &timeout,
 	}
 
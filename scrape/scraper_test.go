@@ -7,7 +7,7 @@ import (
 )
 
 func TestScrapeArticle(t *testing.T) {
-	fc, err := NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"))
+	fc, err := NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"), DefaultFirecrawlConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -28,7 +28,7 @@ func TestScrapeArticle(t *testing.T) {
 }
 
 func TestScrapePDF(t *testing.T) {
-	fc, err := NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"))
+	fc, err := NewFirecrawlScraper(os.Getenv("FIRECRAWL_API_KEY"), DefaultFirecrawlConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
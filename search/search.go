@@ -0,0 +1,160 @@
+// Package search finds sources on the web, for research that goes beyond the library.
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Result is a web page found by a search.
+type Result struct {
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+}
+
+// Provider searches the web.
+type Provider interface {
+	// Name identifies the provider in logs, e.g. "firecrawl".
+	Name() string
+	// Search returns at most limit results for the query, best first.
+	Search(ctx context.Context, query string, limit int) ([]Result, error)
+}
+
+// Provider names, as configured.
+const (
+	ProviderFirecrawl = "firecrawl"
+	// ProviderNone disables web search.
+	ProviderNone = "none"
+)
+
+const FIRECRAWL_API = "https://api.firecrawl.dev"
+
+// FIRECRAWL_TIMEOUT is the time a Firecrawl search may take.
+const FIRECRAWL_TIMEOUT = 60 * time.Second
+
+// Firecrawl searches the web with Firecrawl's search endpoint, which the Firecrawl SDK doesn't support yet.
+// Ref: <https://docs.firecrawl.dev/api-reference/endpoint/search>
+type Firecrawl struct {
+	apiKey string
+	apiURL string
+	client *http.Client
+}
+
+// NewFirecrawl creates a Firecrawl search provider. apiURL defaults to FIRECRAWL_API.
+func NewFirecrawl(apiKey, apiURL string) *Firecrawl {
+	if apiURL == "" {
+		apiURL = FIRECRAWL_API
+	}
+
+	return &Firecrawl{
+		apiKey: apiKey,
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		client: &http.Client{Timeout: FIRECRAWL_TIMEOUT},
+	}
+}
+
+func (f *Firecrawl) Name() string {
+	return ProviderFirecrawl
+}
+
+// firecrawlResponse is the response of the search endpoint. Results are only scraped if asked to, which Scholar
+// doesn't: the documents it picks are ingested like any other link.
+type firecrawlResponse struct {
+	Success bool     `json:"success"`
+	Error   string   `json:"error"`
+	Data    []Result `json:"data"`
+}
+
+func (f *Firecrawl) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	body, err := json.Marshal(map[string]any{"query": query, "limit": limit})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.apiURL+"/v1/search", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+f.apiKey)
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search")
+	}
+	defer res.Body.Close()
+
+	var response firecrawlResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil && res.StatusCode == http.StatusOK {
+		return nil, errors.Wrap(err, "failed to decode search results")
+	}
+
+	if res.StatusCode != http.StatusOK || !response.Success {
+		return nil, fmt.Errorf("search failed with status %d: %s", res.StatusCode, response.Error)
+	}
+
+	if len(response.Data) > limit {
+		response.Data = response.Data[:limit]
+	}
+
+	return response.Data, nil
+}
+
+// Local searches a fixed set of results. It stands in for a web search in tests, and where Scholar can't reach
+// the web. Results are ranked by the number of query terms in their title and description.
+type Local struct {
+	results []Result
+}
+
+// NewLocal creates a provider that searches the given results.
+func NewLocal(results []Result) *Local {
+	return &Local{results: results}
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+func (l *Local) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	terms := strings.Fields(strings.ToLower(query))
+
+	type match struct {
+		result Result
+		score  int
+	}
+
+	var matches []match
+	for _, result := range l.results {
+		text := strings.ToLower(result.Title + " " + result.Description)
+
+		score := 0
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				score++
+			}
+		}
+
+		if score > 0 {
+			matches = append(matches, match{result, score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	results := make([]Result, 0, min(limit, len(matches)))
+	for _, m := range matches[:min(limit, len(matches))] {
+		results = append(results, m.result)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,72 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	local := NewLocal([]Result{
+		{Title: "Bitcoin", URL: "https://bitcoin.org/bitcoin.pdf", Description: "A peer-to-peer electronic cash system"},
+		{Title: "FastPay", URL: "https://arxiv.org/abs/2003.11506", Description: "High-performance byzantine fault tolerant settlement"},
+		{Title: "Narwhal and Tusk", URL: "https://arxiv.org/abs/2105.11827", Description: "A DAG-based mempool and efficient BFT consensus"},
+	})
+
+	results, err := local.Search(context.Background(), "Byzantine fault tolerant consensus", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results[0].Title != "FastPay" || results[1].Title != "Narwhal and Tusk" {
+		t.Errorf("expected FastPay and Narwhal and Tusk, got %+v", results)
+	}
+
+	if results, _ := local.Search(context.Background(), "consensus", 1); len(results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(results))
+	}
+
+	if results, _ := local.Search(context.Background(), "zero knowledge", 5); len(results) != 0 {
+		t.Errorf("expected no results, got %+v", results)
+	}
+}
+
+func TestFirecrawl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/search" || r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "error": "Unauthorized"})
+			return
+		}
+
+		var body struct {
+			Query string `json:"query"`
+			Limit int    `json:"limit"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		if body.Query != "narwhal" || body.Limit != 2 {
+			t.Errorf("unexpected request %+v", body)
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "data": []map[string]string{
+			{"title": "Narwhal and Tusk", "url": "https://arxiv.org/abs/2105.11827", "description": "A DAG-based mempool"},
+		}})
+	}))
+	defer server.Close()
+
+	results, err := NewFirecrawl("key", server.URL).Search(context.Background(), "narwhal", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].URL != "https://arxiv.org/abs/2105.11827" {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	if _, err := NewFirecrawl("invalid", server.URL).Search(context.Background(), "narwhal", 2); err == nil {
+		t.Error("expected error for unauthorized search")
+	}
+}
@@ -0,0 +1,150 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	EventsPath        = "/slack/events"
+	CommandsPath      = "/slack/commands"
+	InteractivityPath = "/slack/interactivity"
+)
+
+// MAX_BODY_SIZE limits the size of request bodies from Slack.
+const MAX_BODY_SIZE = 1 << 20
+
+// Handler returns an HTTP handler for the Events API, slash command and interactivity request URLs,
+// and the OAuth install flow if enabled. Every request from Slack is verified with the signing secret.
+// Ref: <https://api.slack.com/authentication/verifying-requests-from-slack>
+func (s *SlackHandler) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Without a signing secret (Socket Mode), only the install flow is served.
+	if s.signingSecret != "" {
+		mux.HandleFunc("POST "+EventsPath, s.verified(s.serveEvents))
+		mux.HandleFunc("POST "+CommandsPath, s.verified(s.serveCommand))
+		mux.HandleFunc("POST "+InteractivityPath, s.verified(s.serveInteraction))
+	}
+
+	if s.oauth != nil {
+		mux.HandleFunc("GET "+InstallPath, s.serveInstall)
+		mux.HandleFunc("GET "+OAuthCallbackPath, s.serveOAuthCallback)
+	}
+
+	return mux
+}
+
+// ServesHTTP returns true if Handler needs to be served: in HTTP mode, and for the OAuth install flow
+// (also in Socket Mode).
+func (s *SlackHandler) ServesHTTP() bool {
+	return s.signingSecret != "" || s.oauth != nil
+}
+
+// verified wraps a handler with request signature verification. The handler gets the verified body.
+// Requests with a timestamp older than 5 minutes are rejected to prevent replays.
+func (s *SlackHandler) verified(next func(w http.ResponseWriter, r *http.Request, body []byte)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		verifier, err := slack.NewSecretsVerifier(r.Header, s.signingSecret)
+		if err != nil {
+			s.log.Warn().Err(err).Str("path", r.URL.Path).Msg("Rejected unverifiable request")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, MAX_BODY_SIZE))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		verifier.Write(body)
+		if err := verifier.Ensure(); err != nil {
+			s.log.Warn().Err(err).Str("path", r.URL.Path).Msg("Rejected request with invalid signature")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		// Restore the body for form parsing
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r, body)
+	}
+}
+
+func (s *SlackHandler) serveEvents(w http.ResponseWriter, r *http.Request, body []byte) {
+	event, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		s.log.Warn().Err(err).Msg("Failed to parse event")
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	switch event.Type {
+	case slackevents.URLVerification:
+		var challenge slackevents.ChallengeResponse
+		if err := json.Unmarshal(body, &challenge); err != nil {
+			http.Error(w, "invalid challenge", http.StatusBadRequest)
+			return
+		}
+
+		s.log.Info().Msg("Responding to URL verification")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(challenge.Challenge))
+
+	case slackevents.CallbackEvent:
+		if err := s.handleEventsAPI(event); err != nil {
+			s.log.Error().Err(err).Msg("Failed to handle event, will be retried")
+			http.Error(w, "failed to handle event", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		s.log.Trace().Str("type", event.Type).Msg("Ignored event")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *SlackHandler) serveCommand(w http.ResponseWriter, r *http.Request, _ []byte) {
+	cmd, err := slack.SlashCommandParse(r)
+	if err != nil {
+		http.Error(w, "invalid command", http.StatusBadRequest)
+		return
+	}
+
+	response := s.handleCommand(cmd)
+	if response == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *SlackHandler) serveInteraction(w http.ResponseWriter, r *http.Request, _ []byte) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &callback); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.handleInteraction(callback); err != nil {
+		s.log.Error().Err(err).Msg("Failed to handle interaction")
+		http.Error(w, "failed to handle interaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,239 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/mempirate/scholar/cache"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+func newTestServer(t *testing.T, opts ...func(*SlackHandler)) (*SlackHandler, *httptest.Server) {
+	seen, err := cache.NewTTLCache(filepath.Join(t.TempDir(), "events.db"), SEEN_TTL, SEEN_MAX_ENTRIES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { seen.Close() })
+
+	s := &SlackHandler{
+		signingSecret:  testSigningSecret,
+		urlRegex:       regexp.MustCompile(URL_REGEX),
+		linkRegex:      regexp.MustCompile(SLACK_LINK_REGEX),
+		ingestReaction: DefaultIngestReaction,
+		seen:           seen,
+		workspaces:     map[string]*workspace{"T0001": {client: slack.New("xoxb-test")}},
+		commandCh:      make(chan Command, 4),
+		eventCh:        make(chan Event, 4),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+
+	return s, server
+}
+
+// post sends a request signed like Slack does: v0=HMAC-SHA256("v0:<timestamp>:<body>").
+func post(t *testing.T, uri, contentType, body string, timestamp time.Time, secret string) *http.Response {
+	ts := fmt.Sprint(timestamp.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func fixture(t *testing.T, name string) string {
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func TestHTTPVerification(t *testing.T) {
+	_, server := newTestServer(t)
+	body := fixture(t, "url_verification.json")
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		secret    string
+		status    int
+	}{
+		{name: "valid", timestamp: time.Now(), secret: testSigningSecret, status: http.StatusOK},
+		{name: "wrong secret", timestamp: time.Now(), secret: "wrong", status: http.StatusUnauthorized},
+		{name: "replayed", timestamp: time.Now().Add(-10 * time.Minute), secret: testSigningSecret, status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := post(t, server.URL+EventsPath, "application/json", body, test.timestamp, test.secret)
+			if resp.StatusCode != test.status {
+				t.Fatalf("unexpected status: %d", resp.StatusCode)
+			}
+
+			if test.status != http.StatusOK {
+				return
+			}
+
+			challenge, _ := io.ReadAll(resp.Body)
+			if string(challenge) != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+				t.Errorf("unexpected challenge: %s", challenge)
+			}
+		})
+	}
+}
+
+func TestHTTPEventCallback(t *testing.T) {
+	s, server := newTestServer(t)
+	body := fixture(t, "app_mention.json")
+
+	// Slack retries the same event when the ack is slow, it should only be handled once.
+	for i := 0; i < 2; i++ {
+		resp := post(t, server.URL+EventsPath, "application/json", body, time.Now(), testSigningSecret)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status: %d", resp.StatusCode)
+		}
+	}
+
+	if len(s.eventCh) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(s.eventCh))
+	}
+
+	event := <-s.eventCh
+	if event.Type != MentionEvent || event.TeamID != "T0001" || event.UserID != "U0001" || event.ThreadID != "1736000000.000100" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	if len(event.URLs) != 1 || event.URLs[0].String() != "https://bitcoin.org/bitcoin.pdf" {
+		t.Errorf("unexpected URLs: %v", event.URLs)
+	}
+}
+
+func TestHTTPSlashCommand(t *testing.T) {
+	s, server := newTestServer(t)
+
+	form := url.Values{
+		"command":      {UploadCommand},
+		"team_id":      {"T0001"},
+		"text":         {"https://example.com/a.pdf"},
+		"user_id":      {"U0001"},
+		"channel_id":   {"C0001"},
+		"trigger_id":   {"trigger-1"},
+		"response_url": {"https://hooks.slack.com/commands/1234/5678"},
+	}
+
+	resp := post(t, server.URL+CommandsPath, "application/x-www-form-urlencoded", form.Encode(), time.Now(), testSigningSecret)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var response map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response["response_type"] != "ephemeral" || response["text"] != ReplyWorking {
+		t.Errorf("unexpected response: %v", response)
+	}
+
+	cmd := <-s.commandCh
+	if cmd.CommandType != UploadCommand || cmd.ResponseURL != "https://hooks.slack.com/commands/1234/5678" || len(cmd.URLs) != 1 {
+		t.Errorf("unexpected command: %+v", cmd)
+	}
+
+	form.Set("text", "")
+	form.Set("trigger_id", "trigger-2")
+	resp = post(t, server.URL+CommandsPath, "application/x-www-form-urlencoded", form.Encode(), time.Now(), testSigningSecret)
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response["text"] != ReplyMissingURL {
+		t.Errorf("unexpected response: %v", response)
+	}
+}
+
+func TestHTTPInteraction(t *testing.T) {
+	s, server := newTestServer(t)
+
+	form := url.Values{"payload": {fixture(t, "message_action.json")}}
+
+	resp := post(t, server.URL+InteractivityPath, "application/x-www-form-urlencoded", form.Encode(), time.Now(), testSigningSecret)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	event := <-s.eventCh
+	if event.Type != ShortcutEvent || event.UserID != "U0002" || event.ThreadID != "1736000000.000200" || len(event.URLs) != 2 {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestHTTPEventQueueFull(t *testing.T) {
+	s, server := newTestServer(t, func(s *SlackHandler) { s.eventCh = make(chan Event) })
+	body := fixture(t, "app_mention.json")
+
+	// A full queue rejects the event instead of holding up the ack, so Slack delivers it again.
+	resp := post(t, server.URL+EventsPath, "application/json", body, time.Now(), testSigningSecret)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	// The rejected event isn't remembered, so the retry is handled.
+	s.eventCh = make(chan Event, 1)
+	resp = post(t, server.URL+EventsPath, "application/json", body, time.Now(), testSigningSecret)
+	if resp.StatusCode != http.StatusOK || len(s.eventCh) != 1 {
+		t.Fatalf("expected the retry to be handled, got status %d", resp.StatusCode)
+	}
+}
+
+func TestHTTPUnknownTeam(t *testing.T) {
+	s, server := newTestServer(t)
+	body := strings.Replace(fixture(t, "app_mention.json"), `"team_id": "T0001"`, `"team_id": "T0009"`, 1)
+
+	// Events of workspaces Scholar isn't installed in are acknowledged and dropped.
+	resp := post(t, server.URL+EventsPath, "application/json", body, time.Now(), testSigningSecret)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	if len(s.eventCh) != 0 {
+		t.Errorf("expected the event to be dropped, got %d", len(s.eventCh))
+	}
+}
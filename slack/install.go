@@ -0,0 +1,293 @@
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+
+	"github.com/mempirate/scholar/cache"
+)
+
+const (
+	InstallPath       = "/slack/install"
+	OAuthCallbackPath = "/slack/oauth/callback"
+)
+
+// AUTHORIZE_URL is where users are sent to approve the installation.
+const AUTHORIZE_URL = "https://slack.com/oauth/v2/authorize"
+
+// STATE_TTL is how long an install link is valid for.
+const STATE_TTL = 10 * time.Minute
+
+// STATE_COOKIE is the cookie that binds the OAuth state to the browser that started the installation.
+const STATE_COOKIE = "scholar_oauth_state"
+
+// BotScopes are the bot token scopes requested when installing Scholar.
+var BotScopes = []string{
+	"app_mentions:read",
+	"channels:history",
+	"groups:history",
+	"im:history",
+	"im:write",
+	"chat:write",
+	"commands",
+	"reactions:read",
+	"channels:read",
+	"groups:read",
+	"users:read",
+}
+
+// oauthExchange exchanges an OAuth code for a bot token. It is a variable so tests can replace it.
+var oauthExchange = func(ctx context.Context, clientID, clientSecret, code, redirectURL string) (*slack.OAuthV2Response, error) {
+	return slack.GetOAuthV2ResponseContext(ctx, http.DefaultClient, clientID, clientSecret, code, redirectURL)
+}
+
+// OAuthConfig configures the OAuth install flow, which allows Scholar to be installed in multiple workspaces.
+// Ref: <https://api.slack.com/authentication/oauth-v2>
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the public URL of the OAuth callback (ending in OAuthCallbackPath). It must be
+	// configured as a redirect URL of the Slack app.
+	RedirectURL string
+}
+
+// Installation is a workspace that installed Scholar with OAuth.
+type Installation struct {
+	TeamID      string    `json:"team_id"`
+	TeamName    string    `json:"team_name"`
+	BotToken    string    `json:"bot_token"`
+	BotUserID   string    `json:"bot_user_id"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// InstallationStore persists installations by team ID.
+type InstallationStore struct {
+	cache *cache.BoltCache
+}
+
+func NewInstallationStore(path string) (*InstallationStore, error) {
+	cache, err := cache.NewBoltCache(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InstallationStore{cache: cache}, nil
+}
+
+// Get returns the installation of the given team.
+func (s *InstallationStore) Get(teamID string) (*Installation, bool) {
+	value, ok := s.cache.Get(teamID)
+	if !ok {
+		return nil, false
+	}
+
+	var inst Installation
+	if err := json.Unmarshal([]byte(value), &inst); err != nil {
+		return nil, false
+	}
+
+	return &inst, true
+}
+
+// Put adds or replaces the installation of a team.
+func (s *InstallationStore) Put(inst *Installation) error {
+	value, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+
+	return s.cache.Put(inst.TeamID, string(value))
+}
+
+// List returns all installations.
+func (s *InstallationStore) List() ([]Installation, error) {
+	var installations []Installation
+	err := s.cache.ForEach(func(_, value string) error {
+		var inst Installation
+		if err := json.Unmarshal([]byte(value), &inst); err != nil {
+			return err
+		}
+
+		installations = append(installations, inst)
+		return nil
+	})
+
+	return installations, err
+}
+
+// Close closes the underlying database.
+func (s *InstallationStore) Close() error {
+	return s.cache.Close()
+}
+
+// EnableOAuth enables the OAuth install flow on the HTTP server, and loads existing installations
+// from dataDir. Must be called before Start.
+func (s *SlackHandler) EnableOAuth(cfg OAuthConfig, dataDir string) error {
+	installations, err := NewInstallationStore(path.Join(dataDir, "installations.db"))
+	if err != nil {
+		return errors.Wrap(err, "failed to open installation store")
+	}
+
+	existing, err := installations.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to load installations")
+	}
+
+	s.oauth = &cfg
+	s.installations = installations
+
+	for _, inst := range existing {
+		s.addWorkspace(&inst)
+	}
+
+	s.log.Info().Int("installations", len(existing)).Msg("OAuth installs enabled")
+
+	return nil
+}
+
+// addWorkspace registers the API client of an installed workspace.
+func (s *SlackHandler) addWorkspace(inst *Installation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.workspaces[inst.TeamID] = &workspace{
+		client:    slack.New(inst.BotToken),
+		botUserID: inst.BotUserID,
+	}
+}
+
+// serveInstall redirects the user to Slack to approve the installation. The nonce of the state is set in a cookie, so
+// only the browser that started the installation can complete it.
+func (s *SlackHandler) serveInstall(w http.ResponseWriter, r *http.Request) {
+	nonce, err := newNonce()
+	if err != nil {
+		s.log.Error().Err(err).Msg("Failed to create OAuth nonce")
+		http.Error(w, "Failed to start installation.", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     STATE_COOKIE,
+		Value:    nonce,
+		Path:     OAuthCallbackPath,
+		MaxAge:   int(STATE_TTL.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := url.Values{
+		"client_id":    {s.oauth.ClientID},
+		"scope":        {strings.Join(BotScopes, ",")},
+		"redirect_uri": {s.oauth.RedirectURL},
+		"state":        {s.newState(nonce, time.Now())},
+	}
+
+	http.Redirect(w, r, AUTHORIZE_URL+"?"+query.Encode(), http.StatusFound)
+}
+
+// serveOAuthCallback exchanges the code for a bot token and stores the installation.
+func (s *SlackHandler) serveOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if errMsg := query.Get("error"); errMsg != "" {
+		http.Error(w, "Installation cancelled: "+errMsg, http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := r.Cookie(STATE_COOKIE)
+	if err != nil || !s.validState(query.Get("state"), cookie.Value, time.Now()) {
+		http.Error(w, "Invalid or expired install link, please try again.", http.StatusBadRequest)
+		return
+	}
+
+	// The state can only be used once.
+	http.SetCookie(w, &http.Cookie{Name: STATE_COOKIE, Path: OAuthCallbackPath, MaxAge: -1, Secure: true, HttpOnly: true})
+
+	resp, err := oauthExchange(r.Context(), s.oauth.ClientID, s.oauth.ClientSecret, query.Get("code"), s.oauth.RedirectURL)
+	if err != nil {
+		s.log.Error().Err(err).Msg("Failed to exchange OAuth code")
+		http.Error(w, "Failed to complete installation.", http.StatusBadGateway)
+		return
+	}
+
+	inst := &Installation{
+		TeamID:      resp.Team.ID,
+		TeamName:    resp.Team.Name,
+		BotToken:    resp.AccessToken,
+		BotUserID:   resp.BotUserID,
+		InstalledAt: time.Now(),
+	}
+
+	if err := s.installations.Put(inst); err != nil {
+		s.log.Error().Err(err).Msg("Failed to store installation")
+		http.Error(w, "Failed to complete installation.", http.StatusInternalServerError)
+		return
+	}
+
+	s.addWorkspace(inst)
+
+	s.log.Info().Str("team_id", inst.TeamID).Str("team", inst.TeamName).Msg("Installed in workspace")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<p>Scholar was installed in %s. You can close this page.</p>", html.EscapeString(inst.TeamName))
+}
+
+// newNonce returns a random nonce for the OAuth state.
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
+
+// newState creates an OAuth state parameter that protects against CSRF: the nonce of the state cookie and the
+// timestamp, signed with the client secret.
+func (s *SlackHandler) newState(nonce string, now time.Time) string {
+	payload := nonce + "." + strconv.FormatInt(now.Unix(), 10)
+	return payload + "." + s.signState(payload)
+}
+
+// validState returns true if the state is signed, not expired, and carries the nonce of the state cookie.
+func (s *SlackHandler) validState(state, nonce string, now time.Time) bool {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(s.signState(parts[0]+"."+parts[1]))) {
+		return false
+	}
+
+	stateNonce, ts := parts[0], parts[1]
+	if nonce == "" || !hmac.Equal([]byte(stateNonce), []byte(nonce)) {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := now.Sub(time.Unix(unix, 0))
+	return age >= 0 && age < STATE_TTL
+}
+
+func (s *SlackHandler) signState(payload string) string {
+	mac := hmac.New(sha256.New, []byte(s.oauth.ClientSecret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
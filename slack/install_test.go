@@ -0,0 +1,136 @@
+package slack
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestOAuthInstall(t *testing.T) {
+	installations, err := NewInstallationStore(filepath.Join(t.TempDir(), "installations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { installations.Close() })
+
+	s, server := newTestServer(t, func(s *SlackHandler) {
+		s.oauth = &OAuthConfig{ClientID: "client-id", ClientSecret: "client-secret", RedirectURL: "https://scholar.example.com" + OAuthCallbackPath}
+		s.installations = installations
+	})
+
+	exchange := oauthExchange
+	t.Cleanup(func() { oauthExchange = exchange })
+	oauthExchange = func(_ context.Context, clientID, clientSecret, code, _ string) (*slack.OAuthV2Response, error) {
+		if clientID != "client-id" || clientSecret != "client-secret" || code != "the-code" {
+			t.Errorf("unexpected exchange: %s %s %s", clientID, clientSecret, code)
+		}
+
+		resp := &slack.OAuthV2Response{AccessToken: "xoxb-partner", BotUserID: "UBOT2"}
+		resp.Team.ID = "T0002"
+		resp.Team.Name = "Partner Lab"
+		return resp, nil
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := client.Get(server.URL + InstallPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound || !strings.HasPrefix(location.String(), AUTHORIZE_URL) {
+		t.Fatalf("unexpected redirect: %d %s", resp.StatusCode, location)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == STATE_COOKIE {
+			cookie = c
+		}
+	}
+
+	if cookie == nil || !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("unexpected state cookie: %+v", cookie)
+	}
+
+	// The test server is plain HTTP, so the secure cookie is sent by hand.
+	callback := func(state string, cookie *http.Cookie) int {
+		uri := server.URL + OAuthCallbackPath + "?" + url.Values{"code": {"the-code"}, "state": {state}}.Encode()
+		req, err := http.NewRequest(http.MethodGet, uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cookie != nil {
+			req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	state := location.Query().Get("state")
+
+	// A forged state is rejected
+	if status := callback("123.forged", cookie); status != http.StatusBadRequest {
+		t.Fatalf("expected forged state to be rejected, got %d", status)
+	}
+
+	// A valid state is rejected in a browser that didn't start the installation
+	if status := callback(state, nil); status != http.StatusBadRequest {
+		t.Fatalf("expected state without cookie to be rejected, got %d", status)
+	}
+
+	if status := callback(state, &http.Cookie{Name: STATE_COOKIE, Value: "other"}); status != http.StatusBadRequest {
+		t.Fatalf("expected state with another cookie to be rejected, got %d", status)
+	}
+
+	if status := callback(state, cookie); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	inst, ok := installations.Get("T0002")
+	if !ok || inst.BotToken != "xoxb-partner" || inst.TeamName != "Partner Lab" {
+		t.Errorf("unexpected installation: %+v", inst)
+	}
+
+	if !s.isSelf("T0002", "UBOT2") {
+		t.Errorf("expected workspace to be registered")
+	}
+}
+
+func TestOAuthState(t *testing.T) {
+	s := &SlackHandler{oauth: &OAuthConfig{ClientSecret: "secret"}}
+	now := time.Now()
+
+	state := s.newState("nonce", now)
+	if !s.validState(state, "nonce", now.Add(time.Minute)) {
+		t.Errorf("expected state to be valid")
+	}
+
+	if s.validState(state, "other", now) || s.validState(state, "", now) {
+		t.Errorf("expected state with another nonce to be invalid")
+	}
+
+	if s.validState(state, "nonce", now.Add(STATE_TTL+time.Second)) {
+		t.Errorf("expected state to be expired")
+	}
+
+	other := &SlackHandler{oauth: &OAuthConfig{ClientSecret: "other"}}
+	if other.validState(state, "nonce", now) {
+		t.Errorf("expected state signed with another secret to be invalid")
+	}
+}
@@ -1,91 +1,254 @@
 package slack
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
 
+	"github.com/mempirate/scholar/cache"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/document"
 	"github.com/mempirate/scholar/log"
+	"github.com/mempirate/scholar/prompt"
 )
 
-// https://stackoverflow.com/a/3809435 + Claude
-const URL_REGEX = `https?:\/\/(www\.)?[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}([-a-zA-Z0-9()@:%_\+.~#?&//=]*)`
+const URL_REGEX = chat.URL_REGEX
+
+// SLACK_LINK_REGEX matches Slack's link formatting: <url> or <url|label>.
+// Ref: <https://api.slack.com/reference/surfaces/formatting#links-in-retrieved-messages>
+const SLACK_LINK_REGEX = `<(https?://[^|>]+)(\|[^>]*)?>`
 
 const (
-	ReplyMissingURL     = "There doesn't seem to be a URL in your message."
-	ReplyInvalidURL     = "The URL you provided is invalid. Please provide a valid URL."
-	ReplyDownloadFailed = "Failed to download the PDF. Please try again later."
+	ReplyMissingURL      = chat.ReplyMissingURL
+	ReplyInvalidURL      = chat.ReplyInvalidURL
+	ReplyDownloadFailed  = "Failed to download the PDF. Please try again later."
+	ReplyWorking         = chat.ReplyWorking
+	ReplyBusy            = chat.ReplyBusy
+	ReplyUnknownCommand  = chat.ReplyUnknownCommand
+	ReplyCompareURLs     = chat.ReplyCompareURLs
+	ReplyMissingQuestion = chat.ReplyMissingQuestion
+	ReplyUnknownOption   = "Unknown option: %s. Supported options are --private and --channel."
+
+	ReplyUnknownModelOption   = "Unknown option: %s. Supported options are --private, --channel and --model=<name>."
+	ReplyUnknownSummaryOption = "Unknown option: %s. Supported options are --private, --channel, --model=<name>, " + chat.SummaryFlags + "."
 )
 
-type SlashCommand = string
+var (
+	errMissingURL  = errors.New(ReplyMissingURL)
+	errInvalidURL  = errors.New(ReplyInvalidURL)
+	errQueueFull   = errors.New("event queue full")
+	errUnknownTeam = errors.New("unknown workspace")
+)
+
+type SlashCommand = chat.CommandType
 
 const (
-	UploadCommand    SlashCommand = "/upload"
-	SummarizeCommand SlashCommand = "/summary"
+	UploadCommand    = chat.UploadCommand
+	SummarizeCommand = chat.SummarizeCommand
+	PersonaCommand   = chat.PersonaCommand
+	CompareCommand   = chat.CompareCommand
+	ResearchCommand  = chat.ResearchCommand
+	UsageCommand     = chat.UsageCommand
 )
 
+// RegenerateActionID is the action ID of the "Regenerate" button of summaries. Its value is the thread ID.
+const RegenerateActionID = "regenerate_summary"
+
+// MAX_SECTION_LENGTH is the maximum number of characters in the text of a section block.
+const MAX_SECTION_LENGTH = 3000
+
+// SaveShortcutCallbackID is the callback ID of the "Save to Scholar" message shortcut.
+const SaveShortcutCallbackID = "save_to_scholar"
+
+// DefaultIngestReaction is the emoji (without colons) that triggers ingestion of the links in a message.
+const DefaultIngestReaction = "books"
+
 // Command represents a processed command from Slack.
-type Command struct {
-	CommandType SlashCommand
-	UserID      string
-	ChannelID   string
-	URL         *url.URL
-}
+type Command = chat.Command
 
-type EventType = string
+type EventType = chat.EventType
 
 const (
-	MessageEvent EventType = "message"
-	MentionEvent EventType = "mention"
+	MessageEvent    = chat.MessageEvent
+	MentionEvent    = chat.MentionEvent
+	ReactionEvent   = chat.ReactionEvent
+	ShortcutEvent   = chat.ShortcutEvent
+	RegenerateEvent = chat.RegenerateEvent
 )
 
 // Event represents a processed event from Slack.
-type Event struct {
-	Type      EventType
-	UserID    string
-	ChannelID string
-	ThreadID  string
-	Text      string
-}
+type Event = chat.Event
 
+// SlackHandler is the Slack chat frontend.
 type SlackHandler struct {
-	log    zerolog.Logger
-	client *socketmode.Client
+	log zerolog.Logger
+	// client is used for Web API calls in the default workspace, which is the one the bot token belongs to.
+	client *slack.Client
+	// socket receives events with Socket Mode. If nil, events are received over HTTP.
+	socket *socketmode.Client
 
-	urlRegex *regexp.Regexp
+	// signingSecret verifies requests in HTTP mode.
+	signingSecret string
+
+	urlRegex  *regexp.Regexp
+	linkRegex *regexp.Regexp
 
 	commandCh chan Command
 	eventCh   chan Event
 
-	// TODO: limit this map
-	processingCache map[string]struct{}
+	// ingestReaction is the emoji name that triggers ingestion of a message's links.
+	ingestReaction string
+
+	// hasBotToken is false if the app is only installed with OAuth, in which case there's no default workspace.
+	hasBotToken bool
+	// defaultTeamID is the team ID of the default workspace.
+	defaultTeamID string
+
+	// workspaces maps team IDs to the API client and bot user of each workspace Scholar is installed in.
+	mu         sync.RWMutex
+	workspaces map[string]*workspace
+
+	// oauth is set if workspaces can install Scholar with OAuth.
+	oauth         *OAuthConfig
+	installations *InstallationStore
+
+	// seen contains the IDs of events and commands that have already been handled, so that
+	// deliveries retried by Slack are only processed once, even across restarts.
+	seen *cache.TTLCache
 }
 
-func NewSlackHandler(appToken, botToken string) *SlackHandler {
+// workspace is a Slack workspace (team) that Scholar is installed in.
+type workspace struct {
+	client *slack.Client
+	// botUserID is the user ID of the bot itself, used to ignore its own messages.
+	botUserID string
+}
+
+// SEEN_TTL is how long handled event IDs are remembered. Slack retries failed deliveries for up to an hour.
+// Ref: <https://api.slack.com/apis/events-api#retries>
+const SEEN_TTL = 2 * time.Hour
+
+// SEEN_MAX_ENTRIES bounds the number of remembered event IDs.
+const SEEN_MAX_ENTRIES = 10_000
+
+// Config are the settings of the Slack handler.
+type Config struct {
+	// IngestReaction is the emoji (without colons) that saves the links in a message when used as a reaction.
+	IngestReaction string `yaml:"ingest_reaction"`
+	// SeenTTL is how long handled event IDs are remembered, to ignore retried deliveries.
+	SeenTTL time.Duration `yaml:"seen_ttl"`
+	// SeenMaxEntries bounds the number of remembered event IDs.
+	SeenMaxEntries int `yaml:"seen_max_entries"`
+}
+
+// DefaultConfig returns the default settings.
+func DefaultConfig() Config {
+	return Config{
+		IngestReaction: DefaultIngestReaction,
+		SeenTTL:        SEEN_TTL,
+		SeenMaxEntries: SEEN_MAX_ENTRIES,
+	}
+}
+
+// Validate checks the settings.
+func (c *Config) Validate() error {
+	switch {
+	case c.IngestReaction == "" || strings.Contains(c.IngestReaction, ":"):
+		return errors.New("ingest_reaction must be an emoji name without colons")
+	case c.SeenTTL < time.Hour:
+		// Slack retries for up to an hour.
+		return errors.New("seen_ttl must be at least 1h")
+	case c.SeenMaxEntries < 1:
+		return errors.New("seen_max_entries must be at least 1")
+	}
+
+	return nil
+}
+
+// ignoredSubtypes are message subtypes that are not passed on as message events.
+var ignoredSubtypes = map[string]struct{}{
+	slack.MsgSubTypeMessageChanged: {},
+	slack.MsgSubTypeMessageDeleted: {},
+	slack.MsgSubTypeBotMessage:     {},
+}
+
+// NewSlackHandler creates a new Socket Mode handler. Reacting to a message with the configured ingest reaction
+// will ingest every link in that message. Handled event IDs are persisted in dataDir.
+func NewSlackHandler(appToken, botToken, dataDir string, config Config) *SlackHandler {
 	api := slack.New(botToken, slack.OptionAppLevelToken(appToken))
 
-	client := socketmode.New(api)
+	handler := newSlackHandler(api, dataDir, config)
+	handler.socket = socketmode.New(api)
+	handler.hasBotToken = botToken != ""
+
+	return handler
+}
+
+// NewHTTPSlackHandler creates a new handler that receives events, commands and interactions over HTTP
+// (the Events API, see Handler) instead of using Socket Mode. Requests are verified with the signing secret.
+func NewHTTPSlackHandler(botToken, signingSecret, dataDir string, config Config) *SlackHandler {
+	api := slack.New(botToken)
+
+	handler := newSlackHandler(api, dataDir, config)
+	handler.hasBotToken = botToken != ""
+	handler.signingSecret = signingSecret
+
+	return handler
+}
+
+func newSlackHandler(api *slack.Client, dataDir string, config Config) *SlackHandler {
+	log := log.NewLogger("slack")
+
+	seen, err := cache.NewTTLCache(path.Join(dataDir, "events.db"), config.SeenTTL, config.SeenMaxEntries)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create event cache")
+		return nil
+	}
 
 	return &SlackHandler{
-		log:             log.NewLogger("slack"),
-		client:          client,
-		urlRegex:        regexp.MustCompile(URL_REGEX),
-		processingCache: make(map[string]struct{}),
+		log:            log,
+		client:         api,
+		urlRegex:       regexp.MustCompile(URL_REGEX),
+		linkRegex:      regexp.MustCompile(SLACK_LINK_REGEX),
+		ingestReaction: config.IngestReaction,
+		seen:           seen,
+		workspaces:     make(map[string]*workspace),
 
 		commandCh: make(chan Command, 32),
 		eventCh:   make(chan Event, 32),
 	}
 }
 
+var _ chat.Frontend = (*SlackHandler)(nil)
+
+// Name implements chat.Frontend.
+func (s *SlackHandler) Name() string {
+	return "slack"
+}
+
+// Start starts receiving events from Slack. In Socket Mode, this connects to Slack and blocks. In HTTP mode,
+// requests are received by Handler, which must be served separately.
 func (s *SlackHandler) Start() {
-	go s.client.Run()
+	s.identify()
+
+	if s.socket == nil {
+		return
+	}
+
+	go s.socket.Run()
 
-	for evt := range s.client.Events {
+	for evt := range s.socket.Events {
 		switch evt.Type {
 		case socketmode.EventTypeConnecting:
 			s.log.Debug().Msg("Connecting to Slack with Socket Mode...")
@@ -100,14 +263,14 @@ func (s *SlackHandler) Start() {
 				continue
 			}
 
-			if err := s.onEvent(apiEvent); err != nil {
+			if err := s.handleEventsAPI(apiEvent); err != nil {
 				s.log.Error().Err(err).Msg("Failed to handle event, will be retried")
 				continue
 			}
 
 			s.log.Debug().Msg("Event handled successfully, sending ack")
 			// Acknowledge the event so it doesn't get retried
-			s.client.Ack(*evt.Request)
+			s.socket.Ack(*evt.Request)
 
 		case socketmode.EventTypeSlashCommand:
 			cmd, ok := evt.Data.(slack.SlashCommand)
@@ -116,12 +279,27 @@ func (s *SlackHandler) Start() {
 				continue
 			}
 
-			if err := s.onCommand(cmd); err != nil {
-				s.log.Error().Err(err).Msg("Failed to handle command, will be retried")
+			// Slash commands are always acknowledged immediately. Validation errors are part of the response
+			// so the user sees them instead of a timeout.
+			if response := s.handleCommand(cmd); response != nil {
+				s.socket.Ack(*evt.Request, response)
+			} else {
+				s.socket.Ack(*evt.Request)
+			}
+
+		case socketmode.EventTypeInteractive:
+			callback, ok := evt.Data.(slack.InteractionCallback)
+			if !ok {
+				s.log.Warn().Msg("Ignored event")
 				continue
 			}
 
-			s.client.Ack(*evt.Request)
+			if err := s.handleInteraction(callback); err != nil {
+				s.log.Error().Err(err).Msg("Failed to handle interaction, will be retried")
+				continue
+			}
+
+			s.socket.Ack(*evt.Request)
 
 		default:
 			s.log.Trace().Str("type", string(evt.Type)).Msg("Ignored event")
@@ -129,6 +307,174 @@ func (s *SlackHandler) Start() {
 	}
 }
 
+// identify looks up the workspace and bot user of the bot token, and registers it as the default workspace.
+func (s *SlackHandler) identify() {
+	if !s.hasBotToken {
+		s.log.Info().Msg("No bot token, only OAuth installations will be served")
+		return
+	}
+
+	auth, err := s.client.AuthTest()
+	if err != nil {
+		s.log.Error().Err(err).Msg("Failed to get bot identity, events of the default workspace will be dropped")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.defaultTeamID = auth.TeamID
+	s.workspaces[auth.TeamID] = &workspace{client: s.client, botUserID: auth.UserID}
+	s.log.Info().Str("team_id", auth.TeamID).Str("team", auth.Team).Msg("Identified default workspace")
+}
+
+// DefaultTeamID returns the team ID of the workspace the bot token belongs to. It is empty until the handler is started,
+// or if there's no bot token.
+func (s *SlackHandler) DefaultTeamID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.defaultTeamID
+}
+
+// api returns the Web API client for the given workspace, and false if Scholar isn't installed in it. Only an empty team
+// ID or the default workspace's falls back to the client of the bot token, so a workspace is never served with the token
+// of another one.
+func (s *SlackHandler) api(teamID string) (*slack.Client, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if ws, ok := s.workspaces[teamID]; ok {
+		return ws.client, true
+	}
+
+	if s.hasBotToken && (teamID == "" || teamID == s.defaultTeamID) {
+		return s.client, true
+	}
+
+	return nil, false
+}
+
+// teamAPI returns the Web API client for the given workspace, or an error if Scholar isn't installed in it.
+func (s *SlackHandler) teamAPI(teamID string) (*slack.Client, error) {
+	api, ok := s.api(teamID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownTeam, teamID)
+	}
+
+	return api, nil
+}
+
+// known returns true if Scholar is installed in the workspace. Events of other workspaces are dropped.
+func (s *SlackHandler) known(teamID string) bool {
+	_, ok := s.api(teamID)
+	return ok
+}
+
+// isSelf returns true if the user is Scholar's bot user in the given workspace.
+func (s *SlackHandler) isSelf(teamID, userID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ws, ok := s.workspaces[teamID]
+	return ok && ws.botUserID != "" && ws.botUserID == userID
+}
+
+// handleEventsAPI handles an Events API event, skipping events that have already been handled.
+// If this returns an error, the event should not be acknowledged to force a retry.
+func (s *SlackHandler) handleEventsAPI(event slackevents.EventsAPIEvent) error {
+	if !s.known(event.TeamID) {
+		s.log.Warn().Str("team_id", event.TeamID).Msg("Event of an unknown workspace, dropping")
+		return nil
+	}
+
+	keys := eventKeys(event)
+	if !s.claim(keys) {
+		s.log.Debug().Strs("keys", keys).Msg("Duplicate event, skipping")
+		return nil
+	}
+
+	if err := s.onEvent(event); err != nil {
+		s.release(keys)
+		return err
+	}
+
+	return nil
+}
+
+// handleCommand handles a slash command, and returns the payload to acknowledge it with.
+// Duplicate commands are acknowledged without a payload.
+func (s *SlackHandler) handleCommand(cmd slack.SlashCommand) map[string]string {
+	if !s.known(cmd.TeamID) {
+		s.log.Warn().Str("team_id", cmd.TeamID).Msg("Command of an unknown workspace, dropping")
+		return nil
+	}
+
+	key := "command:" + cmd.TriggerID
+	if !s.claim([]string{key}) {
+		s.log.Debug().Str("key", key).Msg("Duplicate command, skipping")
+		return nil
+	}
+
+	return ephemeral(s.onCommand(cmd))
+}
+
+// handleInteraction handles an interactivity payload, skipping interactions that have already been handled.
+// If this returns an error, the interaction should not be acknowledged.
+func (s *SlackHandler) handleInteraction(callback slack.InteractionCallback) error {
+	if !s.known(callback.Team.ID) {
+		s.log.Warn().Str("team_id", callback.Team.ID).Msg("Interaction of an unknown workspace, dropping")
+		return nil
+	}
+
+	key := "interaction:" + callback.TriggerID
+	if !s.claim([]string{key}) {
+		s.log.Debug().Str("key", key).Msg("Duplicate interaction, skipping")
+		return nil
+	}
+
+	if err := s.onInteraction(callback); err != nil {
+		s.release([]string{key})
+		return err
+	}
+
+	return nil
+}
+
+// eventKeys returns the deduplication keys for an event: the event ID, and the client message ID for messages.
+func eventKeys(event slackevents.EventsAPIEvent) []string {
+	var keys []string
+	if cb, ok := event.Data.(*slackevents.EventsAPICallbackEvent); ok && cb.EventID != "" {
+		keys = append(keys, "event:"+cb.EventID)
+	}
+
+	if msg, ok := event.InnerEvent.Data.(*slackevents.MessageEvent); ok && msg.ClientMsgID != "" {
+		keys = append(keys, "message:"+msg.ClientMsgID)
+	}
+
+	return keys
+}
+
+// claim marks the keys as seen, and returns false if any of them has already been seen. Keys are marked before the
+// event is handled, so a delivery retried while the first one is still being handled is skipped. If the keys can't be
+// marked, the event is handled anyway.
+func (s *SlackHandler) claim(keys []string) bool {
+	claimed, err := s.seen.Add(keys...)
+	if err != nil {
+		s.log.Error().Err(err).Strs("keys", keys).Msg("Failed to mark event as seen")
+		return true
+	}
+
+	return claimed
+}
+
+// release forgets the keys of an event that failed to be handled, so Slack's retry is handled.
+func (s *SlackHandler) release(keys []string) {
+	if err := s.seen.Remove(keys...); err != nil {
+		s.log.Error().Err(err).Strs("keys", keys).Msg("Failed to forget event")
+	}
+}
+
 // SubscribeCommands returns a channel that yields incoming commands.
 func (s *SlackHandler) SubscribeCommands() chan Command {
 	return s.commandCh
@@ -140,9 +486,18 @@ func (s *SlackHandler) SubscribeEvents() chan Event {
 }
 
 // StartUploadThread starts a new thread in the given channel with the given text, and returns the thread ID.
-func (s *SlackHandler) StartUploadThread(channelID, userID, text string) (string, error) {
+// Multi-line text (e.g. a list of uploads) gets the uploader on its own line.
+func (s *SlackHandler) StartUploadThread(teamID, channelID, userID, text string) (string, error) {
 	response := fmt.Sprintf("%s (uploaded by <@%s>)", text, userID)
-	_, threadID, err := s.client.PostMessage(channelID, slack.MsgOptionText(response, false))
+	if strings.Contains(text, "\n") {
+		response = fmt.Sprintf("Uploaded by <@%s>:\n%s", userID, text)
+	}
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		return "", err
+	}
+
+	_, threadID, err := api.PostMessage(channelID, slack.MsgOptionText(response, false))
 	if err != nil {
 		s.log.Err(err).Msg("Failed to post message")
 		return "", err
@@ -151,73 +506,360 @@ func (s *SlackHandler) StartUploadThread(channelID, userID, text string) (string
 	return threadID, nil
 }
 
-func (s *SlackHandler) PostEphemeral(channelID, userID, text string) error {
-	_, err := s.client.PostEphemeral(channelID, userID, slack.MsgOptionText(text, false))
-	return err
+// OpenDM returns the ID of the direct message channel between Scholar and the given user.
+func (s *SlackHandler) OpenDM(teamID, userID string) (string, error) {
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		return "", err
+	}
+
+	channel, _, _, err := api.OpenConversation(&slack.OpenConversationParameters{
+		Users:    []string{userID},
+		ReturnIM: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to open direct message with %s: %w", userID, err)
+	}
+
+	return channel.ID, nil
+}
+
+// IsDM returns true if the channel is a direct message with Scholar.
+func (s *SlackHandler) IsDM(channelID string) bool {
+	return strings.HasPrefix(channelID, "D")
+}
+
+// ChannelName returns the name of a channel. Direct messages don't have one.
+func (s *SlackHandler) ChannelName(teamID, channelID string) string {
+	if s.IsDM(channelID) {
+		return ""
+	}
+
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		s.log.Warn().Err(err).Str("channel", channelID).Msg("Failed to get channel info")
+		return ""
+	}
+
+	channel, err := api.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channelID})
+	if err != nil {
+		s.log.Warn().Err(err).Str("channel", channelID).Msg("Failed to get channel info")
+		return ""
+	}
+
+	return channel.Name
 }
 
-func (s *SlackHandler) ExtractURL(text string) (*url.URL, error) {
-	urlStr := s.urlRegex.FindString(text)
-	if urlStr == "" {
-		return nil, fmt.Errorf("no URL found in text: %s", text)
+// UserProfile returns the display name and title of a user.
+func (s *SlackHandler) UserProfile(teamID, userID string) chat.User {
+	profile := chat.User{ID: userID}
+
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		s.log.Warn().Err(err).Str("user", userID).Msg("Failed to get user info")
+		return profile
 	}
 
-	url, err := url.Parse(urlStr)
+	user, err := api.GetUserInfo(userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse URL: %w", err)
+		s.log.Warn().Err(err).Str("user", userID).Msg("Failed to get user info")
+		return profile
+	}
+
+	profile.Name = user.Profile.DisplayName
+	if profile.Name == "" {
+		profile.Name = user.RealName
 	}
+	profile.Title = user.Profile.Title
 
-	return url, nil
+	return profile
 }
 
-func (s *SlackHandler) PostMessage(channelID string, threadID *string, text string) error {
-	var err error
+// SearchMessages searches the recent history of a channel. The search API needs a user token, so the history is
+// fetched and matched locally instead.
+func (s *SlackHandler) SearchMessages(teamID, channelID, query string, limit int) ([]chat.Message, error) {
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := api.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Limit:     chat.SEARCH_HISTORY_LIMIT,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch history of channel %s: %w", channelID, err)
+	}
+
+	return chat.MatchMessages(chatMessages(history.Messages), query, limit), nil
+}
+
+// chatMessages converts Slack messages, skipping channel joins and other subtypes without user content.
+func chatMessages(msgs []slack.Message) []chat.Message {
+	messages := make([]chat.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.SubType != "" && msg.SubType != "thread_broadcast" {
+			continue
+		}
+
+		messages = append(messages, chat.Message{
+			ID:     msg.Timestamp,
+			UserID: msg.User,
+			Text:   msg.Text,
+			Time:   timestampTime(msg.Timestamp),
+		})
+	}
+
+	return messages
+}
+
+// timestampTime returns the time of a message timestamp, e.g. "1700000000.000100".
+func timestampTime(ts string) time.Time {
+	secs, micros, _ := strings.Cut(ts, ".")
+
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	usec, _ := strconv.ParseInt(micros, 10, 64)
+	return time.Unix(sec, usec*int64(time.Microsecond)).UTC()
+}
+
+func (s *SlackHandler) PostEphemeral(teamID, channelID, userID, text string) error {
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		return err
+	}
+
+	_, err = api.PostEphemeral(channelID, userID, slack.MsgOptionText(text, false))
+	return err
+}
+
+// MAX_RESPONSES is the number of times a response URL can be used.
+const MAX_RESPONSES = 5
+
+// MaxResponses is limited by the response URL of commands.
+func (s *SlackHandler) MaxResponses() int {
+	return MAX_RESPONSES
+}
+
+// Respond posts an ephemeral message to a command's response URL, replacing the previous response.
+// Commands without a response URL get an ephemeral message instead.
+func (s *SlackHandler) Respond(cmd Command, text string) error {
+	if cmd.ResponseURL == "" {
+		return s.PostEphemeral(cmd.TeamID, cmd.ChannelID, cmd.UserID, text)
+	}
+
+	return slack.PostWebhook(cmd.ResponseURL, &slack.WebhookMessage{
+		ResponseType:    slack.ResponseTypeEphemeral,
+		Text:            text,
+		ReplaceOriginal: true,
+	})
+}
+
+// ExtractURLs returns all valid URLs found in the text, in order of appearance and without duplicates.
+// Slack link formatting (<url|label>) is unwrapped first, so labels are never mistaken for links.
+func (s *SlackHandler) ExtractURLs(text string) []*url.URL {
+	return chat.ExtractURLs(s.linkRegex.ReplaceAllString(text, " $1 "))
+}
+
+func (s *SlackHandler) PostMessage(teamID, channelID string, threadID *string, text string) error {
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		return err
+	}
+
 	if threadID == nil {
-		_, _, err = s.client.PostMessage(channelID, slack.MsgOptionText(text, false))
+		_, _, err = api.PostMessage(channelID, slack.MsgOptionText(text, false))
 	} else {
-		_, _, err = s.client.PostMessage(channelID, slack.MsgOptionText(text, false), slack.MsgOptionTS(*threadID))
+		_, _, err = api.PostMessage(channelID, slack.MsgOptionText(text, false), slack.MsgOptionTS(*threadID))
 	}
 
 	return err
 }
 
-func (s *SlackHandler) onCommand(cmd slack.SlashCommand) error {
+// PostSummary posts a summary in a thread as blocks, followed by the footer and a "Regenerate" button.
+func (s *SlackHandler) PostSummary(teamID, channelID, threadID, text, footer string) error {
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		return err
+	}
+
+	var blocks []slack.Block
+	for _, chunk := range chat.SplitMessage(text, MAX_SECTION_LENGTH) {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, chunk, false, false), nil, nil))
+	}
+
+	if footer != "" {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, footer, false, false)))
+	}
+
+	button := slack.NewButtonBlockElement(RegenerateActionID, threadID, slack.NewTextBlockObject(slack.PlainTextType, "Regenerate", false, false))
+	blocks = append(blocks, slack.NewActionBlock("", button))
+
+	_, _, err = api.PostMessage(channelID, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(blocks...), slack.MsgOptionTS(threadID))
+	return err
+}
+
+// commandOptions are the options of a command's text.
+type commandOptions struct {
+	visibility document.Visibility
+	summary    prompt.SummaryOptions
+	model      string
+}
+
+// parseCommandOptions splits the options (e.g. --private) from a command's text and returns the visibility,
+// summary options and model they select together with the remaining text. Summary options are only accepted by
+// /summary, and --model by the commands that prompt the assistant.
+func parseCommandOptions(command, text string) (commandOptions, string, error) {
+	opts := commandOptions{visibility: document.VisibilityPublic}
+	prompts := command == SummarizeCommand || command == CompareCommand || command == ResearchCommand
+
+	args := chat.SplitArgs(text)
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			rest = append(rest, arg)
+			continue
+		}
+
+		switch arg {
+		case "--public":
+			opts.visibility = document.VisibilityPublic
+		case "--channel":
+			opts.visibility = document.VisibilityChannel
+		case "--private":
+			opts.visibility = document.VisibilityPrivate
+		default:
+			if prompts {
+				if ok, err := chat.ParseModelOption(arg, &opts.model); ok {
+					if err != nil {
+						return opts, "", err
+					}
+					continue
+				}
+			}
+
+			if command == SummarizeCommand {
+				if ok, err := chat.ParseSummaryOption(arg, &opts.summary); ok {
+					if err != nil {
+						return opts, "", err
+					}
+					continue
+				}
+
+				return opts, "", fmt.Errorf(ReplyUnknownSummaryOption, arg)
+			}
+
+			if prompts {
+				return opts, "", fmt.Errorf(ReplyUnknownModelOption, arg)
+			}
+
+			return opts, "", fmt.Errorf(ReplyUnknownOption, arg)
+		}
+	}
+
+	return opts, strings.Join(rest, " "), nil
+}
+
+// parseCommandURLs extracts the URLs from a command's text. Unlike ExtractURLs, it fails if the text
+// has no URLs or contains invalid ones.
+func (s *SlackHandler) parseCommandURLs(text string) ([]*url.URL, error) {
+	text = s.linkRegex.ReplaceAllString(text, " $1 ")
+	matches := s.urlRegex.FindAllString(text, -1)
+	if len(matches) == 0 {
+		if strings.TrimSpace(text) == "" {
+			return nil, errMissingURL
+		}
+
+		return nil, errInvalidURL
+	}
+
+	for _, match := range matches {
+		uri, err := url.Parse(match)
+		if err != nil || uri.Host == "" {
+			return nil, errInvalidURL
+		}
+	}
+
+	return s.ExtractURLs(text), nil
+}
+
+// onCommand validates a slash command and queues it for processing. It returns the text
+// to acknowledge the command with.
+func (s *SlackHandler) onCommand(cmd slack.SlashCommand) string {
+	command := Command{
+		CommandType: cmd.Command,
+		TeamID:      cmd.TeamID,
+		UserID:      cmd.UserID,
+		ChannelID:   cmd.ChannelID,
+		ResponseURL: cmd.ResponseURL,
+	}
+
 	switch cmd.Command {
-	case UploadCommand:
-		uri, err := s.ExtractURL(cmd.Text)
+	case UploadCommand, SummarizeCommand, CompareCommand:
+		opts, text, err := parseCommandOptions(cmd.Command, cmd.Text)
 		if err != nil {
-			return err
+			s.log.Debug().Str("text", cmd.Text).Err(err).Msg("Invalid command options")
+			return err.Error()
 		}
 
-		command := Command{
-			CommandType: UploadCommand,
-			UserID:      cmd.UserID,
-			ChannelID:   cmd.ChannelID,
-			URL:         uri,
+		urls, err := s.parseCommandURLs(text)
+		if err != nil {
+			s.log.Debug().Str("text", cmd.Text).Err(err).Msg("Invalid command")
+			return err.Error()
+		}
+
+		if cmd.Command == CompareCommand && len(urls) < 2 {
+			return ReplyCompareURLs
 		}
 
-		s.commandCh <- command
+		command.URLs = urls
+		command.Visibility = opts.visibility
+		command.Summary = opts.summary
+		command.Model = opts.model
+
+	case PersonaCommand:
+		command.Text = strings.TrimSpace(cmd.Text)
+
+	case UsageCommand:
 
-	case SummarizeCommand:
-		uri, err := s.ExtractURL(cmd.Text)
+	case ResearchCommand:
+		opts, text, err := parseCommandOptions(cmd.Command, cmd.Text)
 		if err != nil {
-			return err
+			s.log.Debug().Str("text", cmd.Text).Err(err).Msg("Invalid command options")
+			return err.Error()
 		}
 
-		command := Command{
-			CommandType: SummarizeCommand,
-			UserID:      cmd.UserID,
-			ChannelID:   cmd.ChannelID,
-			URL:         uri,
+		if text == "" {
+			return ReplyMissingQuestion
 		}
 
-		s.commandCh <- command
+		command.Text = text
+		command.Visibility = opts.visibility
+		command.Model = opts.model
 
 	default:
 		s.log.Debug().Str("command", cmd.Command).Msg("Ignoring unknown command")
+		return ReplyUnknownCommand
 	}
 
-	return nil
+	select {
+	case s.commandCh <- command:
+		return ReplyWorking
+	default:
+		s.log.Warn().Str("command", cmd.Command).Msg("Command queue full, rejecting command")
+		return ReplyBusy
+	}
+}
+
+// ephemeral creates a response payload that is only visible to the user that invoked the command.
+func ephemeral(text string) map[string]string {
+	return map[string]string{
+		"response_type": slack.ResponseTypeEphemeral,
+		"text":          text,
+	}
 }
 
 // onEvent handles an incoming event from Slack. If this returns an error, the event should not be acknowledged
@@ -227,9 +869,11 @@ func (s *SlackHandler) onEvent(event slackevents.EventsAPIEvent) error {
 		callbackEvent := event.InnerEvent
 		switch ev := callbackEvent.Data.(type) {
 		case *slackevents.MessageEvent:
-			return s.onMessage(ev)
+			return s.onMessage(event.TeamID, ev)
 		case *slackevents.AppMentionEvent:
-			return s.onAppMention(ev)
+			return s.onAppMention(event.TeamID, ev)
+		case *slackevents.ReactionAddedEvent:
+			return s.onReactionAdded(event.TeamID, ev)
 		default:
 			s.log.Debug().Str("type", callbackEvent.Type).Msg("Unhandled callback event")
 		}
@@ -239,26 +883,70 @@ func (s *SlackHandler) onEvent(event slackevents.EventsAPIEvent) error {
 	return nil
 }
 
-func (s *SlackHandler) onMessage(event *slackevents.MessageEvent) error {
+// queue queues an event for processing. The event queue is never waited for, so events are acknowledged in time:
+// if it is full, the event is rejected, and Slack delivers it again later.
+func (s *SlackHandler) queue(event Event) error {
+	select {
+	case s.eventCh <- event:
+		return nil
+	default:
+		s.log.Warn().Str("type", event.Type).Msg("Event queue full, rejecting event")
+		return errQueueFull
+	}
+}
+
+func (s *SlackHandler) onMessage(teamID string, event *slackevents.MessageEvent) error {
+	if _, ok := ignoredSubtypes[event.SubType]; ok {
+		s.log.Debug().Str("subtype", event.SubType).Msg("Ignoring message subtype")
+		return nil
+	}
+
+	if event.BotID != "" || s.isSelf(teamID, event.User) {
+		s.log.Debug().Str("user", event.User).Msg("Ignoring bot message")
+		return nil
+	}
+
 	s.log.Info().Str("thread_id", event.ThreadTimeStamp).Str("user", event.User).Msg("Received message event")
 
+	// Direct messages are addressed to Scholar, so they're handled like mentions. This is where
+	// users can ask about their private documents.
+	if event.ChannelType == slack.TYPE_IM {
+		threadID := event.TimeStamp
+		if event.ThreadTimeStamp != "" {
+			threadID = event.ThreadTimeStamp
+		}
+
+		return s.queue(Event{
+			Type:      MentionEvent,
+			TeamID:    teamID,
+			UserID:    event.User,
+			ChannelID: event.Channel,
+			ThreadID:  threadID,
+			Text:      event.Text,
+			URLs:      s.ExtractURLs(event.Text),
+		})
+	}
+
 	var threadID string
 	if event.ThreadTimeStamp != "" {
 		threadID = event.ThreadTimeStamp
 	}
 
-	s.eventCh <- Event{
+	return s.queue(Event{
 		Type:      MessageEvent,
+		TeamID:    teamID,
 		UserID:    event.User,
 		ChannelID: event.Channel,
 		ThreadID:  threadID,
 		Text:      event.Text,
-	}
-
-	return nil
+	})
 }
 
-func (s *SlackHandler) onAppMention(event *slackevents.AppMentionEvent) error {
+func (s *SlackHandler) onAppMention(teamID string, event *slackevents.AppMentionEvent) error {
+	if s.isSelf(teamID, event.User) {
+		return nil
+	}
+
 	s.log.Info().Str("thread_id", event.ThreadTimeStamp).Str("user", event.User).Msg("Received mention event")
 
 	threadID := event.TimeStamp
@@ -266,13 +954,122 @@ func (s *SlackHandler) onAppMention(event *slackevents.AppMentionEvent) error {
 		threadID = event.ThreadTimeStamp
 	}
 
-	s.eventCh <- Event{
+	return s.queue(Event{
 		Type:      MentionEvent,
+		TeamID:    teamID,
 		UserID:    event.User,
 		ChannelID: event.Channel,
 		ThreadID:  threadID,
 		Text:      event.Text,
+		URLs:      s.ExtractURLs(event.Text),
+	})
+}
+
+func (s *SlackHandler) onReactionAdded(teamID string, event *slackevents.ReactionAddedEvent) error {
+	if event.Reaction != s.ingestReaction || event.Item.Type != "message" {
+		return nil
+	}
+
+	s.log.Info().Str("reaction", event.Reaction).Str("user", event.User).Str("ts", event.Item.Timestamp).Msg("Received ingest reaction")
+
+	msg, err := s.getMessage(teamID, event.Item.Channel, event.Item.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	return s.queue(Event{
+		Type:      ReactionEvent,
+		TeamID:    teamID,
+		UserID:    event.User,
+		ChannelID: event.Item.Channel,
+		ThreadID:  threadOf(msg),
+		Text:      msg.Text,
+		URLs:      s.ExtractURLs(msg.Text),
+	})
+}
+
+// onInteraction handles interactivity payloads: the "Save to Scholar" message shortcut and the "Regenerate" button.
+func (s *SlackHandler) onInteraction(callback slack.InteractionCallback) error {
+	if callback.Type == slack.InteractionTypeBlockActions {
+		return s.onBlockActions(callback)
+	}
+
+	if callback.Type != slack.InteractionTypeMessageAction || callback.CallbackID != SaveShortcutCallbackID {
+		s.log.Debug().Str("type", string(callback.Type)).Str("callback_id", callback.CallbackID).Msg("Ignoring interaction")
+		return nil
+	}
+
+	s.log.Info().Str("user", callback.User.ID).Str("ts", callback.Message.Timestamp).Msg("Received save shortcut")
+
+	return s.queue(Event{
+		Type:      ShortcutEvent,
+		TeamID:    callback.Team.ID,
+		UserID:    callback.User.ID,
+		ChannelID: callback.Channel.ID,
+		ThreadID:  threadOf(&callback.Message),
+		Text:      callback.Message.Text,
+		URLs:      s.ExtractURLs(callback.Message.Text),
+	})
+}
+
+// onBlockActions handles clicks on the "Regenerate" button of summaries.
+func (s *SlackHandler) onBlockActions(callback slack.InteractionCallback) error {
+	for _, action := range callback.ActionCallback.BlockActions {
+		if action.ActionID != RegenerateActionID {
+			s.log.Debug().Str("action_id", action.ActionID).Msg("Ignoring block action")
+			continue
+		}
+
+		s.log.Info().Str("user", callback.User.ID).Str("thread_id", action.Value).Msg("Received regenerate action")
+
+		if err := s.queue(Event{
+			Type:      RegenerateEvent,
+			TeamID:    callback.Team.ID,
+			UserID:    callback.User.ID,
+			ChannelID: callback.Channel.ID,
+			ThreadID:  action.Value,
+		}); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// getMessage fetches a single message by its timestamp. This works for both top-level messages and thread replies.
+func (s *SlackHandler) getMessage(teamID, channelID, ts string) (*slack.Message, error) {
+	api, err := s.teamAPI(teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, _, _, err := api.GetConversationReplies(&slack.GetConversationRepliesParameters{
+		ChannelID: channelID,
+		Timestamp: ts,
+		Inclusive: true,
+		Latest:    ts,
+		Oldest:    ts,
+		Limit:     1,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message %s: %w", ts, err)
+	}
+
+	for _, msg := range msgs {
+		if msg.Timestamp == ts {
+			return &msg, nil
+		}
+	}
+
+	return nil, fmt.Errorf("message %s not found in channel %s", ts, channelID)
+}
+
+// threadOf returns the thread a reply to the message should go in.
+func threadOf(msg *slack.Message) string {
+	if msg.ThreadTimestamp != "" {
+		return msg.ThreadTimestamp
+	}
+
+	return msg.Timestamp
+}
@@ -1,8 +1,16 @@
 package slack
 
 import (
+	"errors"
 	"regexp"
 	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
 )
 
 func TestRegex(t *testing.T) {
@@ -20,3 +28,290 @@ func TestRegex(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractURLs(t *testing.T) {
+	s := &SlackHandler{urlRegex: regexp.MustCompile(URL_REGEX), linkRegex: regexp.MustCompile(SLACK_LINK_REGEX)}
+
+	urls := s.ExtractURLs("Check https://example.com/a.pdf and http://test.com/path_with_underscores, also no link here")
+	if len(urls) != 2 {
+		t.Fatalf("expected 2 URLs, got %d", len(urls))
+	}
+
+	if urls[0].String() != "https://example.com/a.pdf" {
+		t.Errorf("unexpected first URL: %s", urls[0])
+	}
+
+	if len(s.ExtractURLs("nothing to see")) != 0 {
+		t.Errorf("expected no URLs")
+	}
+}
+
+func TestExtractURLsSlackFormatting(t *testing.T) {
+	s := &SlackHandler{urlRegex: regexp.MustCompile(URL_REGEX), linkRegex: regexp.MustCompile(SLACK_LINK_REGEX)}
+
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{
+			name:     "plain",
+			text:     "<https://example.com/a.pdf>",
+			expected: []string{"https://example.com/a.pdf"},
+		},
+		{
+			name:     "label",
+			text:     "<https://example.com/a.pdf|example.com/a.pdf> and <https://test.com|https://other.com>",
+			expected: []string{"https://example.com/a.pdf", "https://test.com"},
+		},
+		{
+			name:     "duplicates",
+			text:     "<https://example.com> https://example.com <https://example.com|example>",
+			expected: []string{"https://example.com"},
+		},
+		{
+			name:     "multiple",
+			text:     "https://a.com/1 https://b.com/2 https://c.com/3",
+			expected: []string{"https://a.com/1", "https://b.com/2", "https://c.com/3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			urls := s.ExtractURLs(test.text)
+			if len(urls) != len(test.expected) {
+				t.Fatalf("expected %d URLs, got %v", len(test.expected), urls)
+			}
+
+			for i, uri := range urls {
+				if uri.String() != test.expected[i] {
+					t.Errorf("unexpected URL %d: %s", i, uri)
+				}
+			}
+		})
+	}
+}
+
+func TestOnCommand(t *testing.T) {
+	s := &SlackHandler{
+		urlRegex:  regexp.MustCompile(URL_REGEX),
+		linkRegex: regexp.MustCompile(SLACK_LINK_REGEX),
+		commandCh: make(chan Command, 1),
+	}
+
+	tests := []struct {
+		name     string
+		command  string
+		text     string
+		expected string
+	}{
+		{name: "missing", command: UploadCommand, text: " ", expected: ReplyMissingURL},
+		{name: "invalid", command: UploadCommand, text: "example dot com", expected: ReplyInvalidURL},
+		{name: "unknown", command: "/unknown", text: "https://example.com", expected: ReplyUnknownCommand},
+		{name: "compare one", command: CompareCommand, text: "https://example.com", expected: ReplyCompareURLs},
+		{name: "research without question", command: ResearchCommand, text: "--private", expected: ReplyMissingQuestion},
+		{name: "queued", command: SummarizeCommand, text: "https://example.com https://test.com", expected: ReplyWorking},
+		{name: "busy", command: UploadCommand, text: "https://example.com", expected: ReplyBusy},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply := s.onCommand(slack.SlashCommand{Command: test.command, Text: test.text, ResponseURL: "https://hooks.slack.com/x"})
+			if reply != test.expected {
+				t.Errorf("unexpected reply: %s", reply)
+			}
+		})
+	}
+
+	cmd := <-s.commandCh
+	if len(cmd.URLs) != 2 || cmd.ResponseURL != "https://hooks.slack.com/x" {
+		t.Errorf("unexpected command: %+v", cmd)
+	}
+
+	if reply := s.onCommand(slack.SlashCommand{Command: PersonaCommand, Text: " reading-group "}); reply != ReplyWorking {
+		t.Errorf("unexpected reply: %s", reply)
+	}
+
+	if cmd := <-s.commandCh; cmd.CommandType != PersonaCommand || cmd.Text != "reading-group" {
+		t.Errorf("unexpected command: %+v", cmd)
+	}
+
+	if reply := s.onCommand(slack.SlashCommand{Command: UsageCommand}); reply != ReplyWorking {
+		t.Errorf("unexpected reply: %s", reply)
+	}
+
+	if cmd := <-s.commandCh; cmd.CommandType != UsageCommand {
+		t.Errorf("unexpected command: %+v", cmd)
+	}
+}
+
+func TestOnMessageFiltering(t *testing.T) {
+	s := &SlackHandler{
+		workspaces: map[string]*workspace{"T1": {botUserID: "UBOT"}},
+		eventCh:    make(chan Event, 4),
+	}
+
+	messages := []*slackevents.MessageEvent{
+		{User: "U1", Text: "edited", SubType: slack.MsgSubTypeMessageChanged},
+		{User: "UBOT", Text: "own message"},
+		{BotID: "B1", Text: "other bot"},
+		{User: "U1", Text: "hello"},
+	}
+
+	for _, msg := range messages {
+		if err := s.onMessage("T1", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(s.eventCh) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(s.eventCh))
+	}
+
+	if ev := <-s.eventCh; ev.Text != "hello" || ev.TeamID != "T1" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestParseCommandOptions(t *testing.T) {
+	tests := []struct {
+		command    string
+		text       string
+		visibility document.Visibility
+		summary    prompt.SummaryOptions
+		model      string
+		rest       string
+		err        bool
+	}{
+		{text: "https://example.com", visibility: document.VisibilityPublic, rest: "https://example.com"},
+		{text: "--private https://example.com", visibility: document.VisibilityPrivate, rest: "https://example.com"},
+		{text: "https://example.com --channel", visibility: document.VisibilityChannel, rest: "https://example.com"},
+		{text: "--secret https://example.com", err: true},
+		{text: "--tldr https://example.com", err: true},
+		{
+			command:    SummarizeCommand,
+			text:       `--private --tldr --bullets --lang=pt-BR --focus=“consensus safety” https://example.com`,
+			visibility: document.VisibilityPrivate,
+			summary:    prompt.SummaryOptions{Style: prompt.StyleTLDR, Bullets: true, Language: "pt-BR", Focus: "consensus safety"},
+			rest:       "https://example.com",
+		},
+		{command: SummarizeCommand, text: "--tldr --eli5 https://example.com", err: true},
+		{command: SummarizeCommand, text: "--lang=english https://example.com", err: true},
+		{command: SummarizeCommand, text: "--focus https://example.com", err: true},
+		{
+			command:    SummarizeCommand,
+			text:       "--model=gpt-4o --bullets https://example.com",
+			visibility: document.VisibilityPublic,
+			summary:    prompt.SummaryOptions{Bullets: true},
+			model:      "gpt-4o",
+			rest:       "https://example.com",
+		},
+		{command: ResearchCommand, text: "--model=gpt-4o why?", visibility: document.VisibilityPublic, model: "gpt-4o", rest: "why?"},
+		{command: CompareCommand, text: "--model= https://a.com https://b.com", err: true},
+		{text: "--model=gpt-4o https://example.com", err: true},
+	}
+
+	for _, test := range tests {
+		if test.command == "" {
+			test.command = UploadCommand
+		}
+
+		opts, rest, err := parseCommandOptions(test.command, test.text)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error: %v", test.text, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if opts.visibility != test.visibility || opts.summary != test.summary || opts.model != test.model || rest != test.rest {
+			t.Errorf("%q: got %+v, %q", test.text, opts, rest)
+		}
+	}
+}
+
+func TestOnDirectMessage(t *testing.T) {
+	s := &SlackHandler{
+		urlRegex:  regexp.MustCompile(URL_REGEX),
+		linkRegex: regexp.MustCompile(SLACK_LINK_REGEX),
+		eventCh:   make(chan Event, 1),
+	}
+
+	msg := &slackevents.MessageEvent{User: "U1", Channel: "D1", ChannelType: slack.TYPE_IM, TimeStamp: "1.0", Text: "what about <https://example.com>?"}
+	if err := s.onMessage("T1", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := <-s.eventCh
+	if ev.Type != MentionEvent || ev.ThreadID != "1.0" || len(ev.URLs) != 1 {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestEventKeys(t *testing.T) {
+	event := slackevents.EventsAPIEvent{
+		Type: slackevents.CallbackEvent,
+		Data: &slackevents.EventsAPICallbackEvent{EventID: "Ev123"},
+		InnerEvent: slackevents.EventsAPIInnerEvent{
+			Data: &slackevents.MessageEvent{ClientMsgID: "abc"},
+		},
+	}
+
+	keys := eventKeys(event)
+	if len(keys) != 2 || keys[0] != "event:Ev123" || keys[1] != "message:abc" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestChatMessages(t *testing.T) {
+	msgs := []slack.Message{
+		{Msg: slack.Msg{Timestamp: "1700000000.000100", User: "U1", Text: "FastPay is out"}},
+		{Msg: slack.Msg{Timestamp: "1700000001.000200", User: "U2", Text: "<@U2> has joined the channel", SubType: "channel_join"}},
+	}
+
+	messages := chatMessages(msgs)
+	if len(messages) != 1 || messages[0].UserID != "U1" || messages[0].ID != "1700000000.000100" {
+		t.Fatalf("expected the message of U1, got %+v", messages)
+	}
+
+	if want := time.Unix(1700000000, 100000).UTC(); !messages[0].Time.Equal(want) {
+		t.Errorf("expected time %s, got %s", want, messages[0].Time)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	def, partner := slack.New("xoxb-default"), slack.New("xoxb-partner")
+	s := &SlackHandler{
+		client:        def,
+		hasBotToken:   true,
+		defaultTeamID: "T1",
+		workspaces:    map[string]*workspace{"T1": {client: def}, "T2": {client: partner}},
+	}
+
+	tests := []struct {
+		teamID string
+		client *slack.Client
+	}{
+		{teamID: "", client: def},
+		{teamID: "T1", client: def},
+		{teamID: "T2", client: partner},
+		{teamID: "T3"},
+	}
+
+	for _, test := range tests {
+		client, ok := s.api(test.teamID)
+		if client != test.client || ok != (test.client != nil) {
+			t.Errorf("%q: unexpected client %p, %t", test.teamID, client, ok)
+		}
+	}
+
+	// Without a bot token, only installed workspaces are served.
+	s.hasBotToken = false
+	if _, ok := s.api(""); ok {
+		t.Errorf("expected no client without a bot token")
+	}
+
+	if err := s.PostMessage("T3", "C1", nil, "hello"); !errors.Is(err, errUnknownTeam) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1,14 +1,18 @@
 package store
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/mempirate/scholar/document"
 )
 
 type LocalStore interface {
 	Path() string
-	// List returns a list of all files in the store.
+	// List returns a list of all documents in the store.
 	List() ([]string, error)
 
 	Contains(name string) (bool, error)
@@ -17,6 +21,14 @@ type LocalStore interface {
 
 	// Get returns a reader for the file with the given name. The caller is responsible for closing the reader!
 	Get(name string) (io.ReadCloser, error)
+
+	// Remove deletes the file with the given name, and its sidecars.
+	Remove(name string) error
+
+	// Scoped returns the store for the documents of the given scope.
+	Scoped(scope Scope) LocalStore
+	// Scopes returns the non-public scopes in the store.
+	Scopes() ([]Scope, error)
 }
 
 type FileStore struct {
@@ -32,12 +44,17 @@ func NewFileStore(dataDir string) *FileStore {
 func (fs *FileStore) List() ([]string, error) {
 	entries, err := os.ReadDir(fs.dataDir)
 	if err != nil {
+		// Scoped stores are created on the first upload
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
 		return nil, err
 	}
 
+	// Only documents are listed, not databases or other state kept in the data directory.
 	files := make([]string, 0)
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".md" {
 			files = append(files, entry.Name())
 		}
 	}
@@ -56,6 +73,10 @@ func (fs *FileStore) Contains(name string) (bool, error) {
 }
 
 func (fs *FileStore) Store(name string, content io.Reader) error {
+	if err := os.MkdirAll(fs.dataDir, os.ModePerm); err != nil {
+		return err
+	}
+
 	filePath := filepath.Join(fs.dataDir, name)
 
 	file, err := os.Create(filePath)
@@ -73,6 +94,124 @@ func (fs *FileStore) Get(name string) (io.ReadCloser, error) {
 	return os.Open(filePath)
 }
 
+func (fs *FileStore) Remove(name string) error {
+	if err := os.Remove(filepath.Join(fs.dataDir, name)); err != nil {
+		return err
+	}
+
+	sidecars, err := filepath.Glob(filepath.Join(fs.dataDir, SidecarName(name, "*")))
+	if err != nil {
+		return err
+	}
+
+	for _, sidecar := range sidecars {
+		if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (fs *FileStore) Path() string {
 	return fs.dataDir
 }
+
+// SidecarSummary is the kind of sidecar that holds the structured summary of a document.
+const SidecarSummary = "summary"
+
+// SidecarName returns the name of a document's sidecar of the given kind, e.g. "bitcoin.summary.json" for
+// "bitcoin.md". Sidecars are JSON files stored next to their document. They aren't listed as documents, and
+// they are removed together with their document.
+func SidecarName(name, kind string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + kind + ".json"
+}
+
+// SCOPES_DIR is the directory (in the data directory) that contains the documents of non-public scopes.
+const SCOPES_DIR = "scopes"
+
+// Scope is a library of documents with the same visibility: the public library, a channel's library,
+// or a user's private library.
+type Scope struct {
+	Visibility document.Visibility
+	// ID is the channel ID for channel scopes, and the user ID for private scopes.
+	ID string
+}
+
+// PublicScope is the scope of documents visible to the whole workspace.
+var PublicScope = Scope{Visibility: document.VisibilityPublic}
+
+// ChannelScope returns the scope of documents only visible in the given channel.
+func ChannelScope(channelID string) Scope {
+	return Scope{Visibility: document.VisibilityChannel, ID: channelID}
+}
+
+// PrivateScope returns the scope of documents only visible to the given user.
+func PrivateScope(userID string) Scope {
+	return Scope{Visibility: document.VisibilityPrivate, ID: userID}
+}
+
+// IsPublic returns true for the public scope.
+func (s Scope) IsPublic() bool {
+	return s.Visibility == "" || s.Visibility == document.VisibilityPublic
+}
+
+// Key returns a unique name for the scope, e.g. "channel-C012AB3CD". The public scope has an empty key.
+func (s Scope) Key() string {
+	if s.IsPublic() {
+		return ""
+	}
+
+	return s.Visibility + "-" + s.ID
+}
+
+// ParseScope parses a scope key created by Key.
+func ParseScope(key string) (Scope, error) {
+	if key == "" {
+		return PublicScope, nil
+	}
+
+	visibility, id, ok := strings.Cut(key, "-")
+	if !ok || id == "" || (visibility != document.VisibilityChannel && visibility != document.VisibilityPrivate) {
+		return Scope{}, fmt.Errorf("invalid scope: %s", key)
+	}
+
+	return Scope{Visibility: visibility, ID: id}, nil
+}
+
+// Scoped returns the store for the documents of the given scope. Public documents live in the root of the data
+// directory, other scopes in their own directory.
+func (fs *FileStore) Scoped(scope Scope) LocalStore {
+	if scope.IsPublic() {
+		return fs
+	}
+
+	return NewFileStore(filepath.Join(fs.dataDir, SCOPES_DIR, scope.Key()))
+}
+
+// Scopes returns the non-public scopes that have a directory in the store.
+func (fs *FileStore) Scopes() ([]Scope, error) {
+	entries, err := os.ReadDir(filepath.Join(fs.dataDir, SCOPES_DIR))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	scopes := make([]Scope, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		scope, err := ParseScope(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, nil
+}
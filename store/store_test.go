@@ -0,0 +1,89 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScopeKey(t *testing.T) {
+	for _, scope := range []Scope{PublicScope, ChannelScope("C123"), PrivateScope("U123")} {
+		parsed, err := ParseScope(scope.Key())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed.Key() != scope.Key() || parsed.IsPublic() != scope.IsPublic() {
+			t.Errorf("expected %+v, got %+v", scope, parsed)
+		}
+	}
+
+	if _, err := ParseScope("team-T123"); err == nil {
+		t.Error("expected error for unknown visibility")
+	}
+}
+
+func TestScopedStore(t *testing.T) {
+	fs := NewFileStore(t.TempDir())
+
+	if err := fs.Store("public.md", strings.NewReader("public")); err != nil {
+		t.Fatal(err)
+	}
+
+	private := fs.Scoped(PrivateScope("U123"))
+	if names, err := private.List(); err != nil || len(names) != 0 {
+		t.Fatalf("expected empty scope, got %v (%v)", names, err)
+	}
+
+	if err := private.Store("private.md", strings.NewReader("private")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scoped documents are not part of the public library.
+	names, err := fs.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 1 || names[0] != "public.md" {
+		t.Errorf("unexpected public documents: %v", names)
+	}
+
+	scopes, err := fs.Scopes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scopes) != 1 || scopes[0] != PrivateScope("U123") {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+}
+
+func TestSidecars(t *testing.T) {
+	fs := NewFileStore(t.TempDir())
+
+	if err := fs.Store("bitcoin.md", strings.NewReader("# Bitcoin")); err != nil {
+		t.Fatal(err)
+	}
+
+	sidecar := SidecarName("bitcoin.md", SidecarSummary)
+	if sidecar != "bitcoin.summary.json" {
+		t.Errorf("unexpected sidecar name: %s", sidecar)
+	}
+
+	if err := fs.Store(sidecar, strings.NewReader("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sidecars aren't documents.
+	if names, err := fs.List(); err != nil || len(names) != 1 {
+		t.Errorf("unexpected documents: %v (%v)", names, err)
+	}
+
+	if err := fs.Remove("bitcoin.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := fs.Contains(sidecar); err != nil || ok {
+		t.Errorf("sidecar wasn't removed with its document (%v)", err)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/cache"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/store"
+)
+
+// summaryRecord is what the summary of a thread was generated from, so it can be regenerated, and followed up on
+// in the same style.
+type summaryRecord struct {
+	Documents []prompt.Document     `json:"documents"`
+	Options   prompt.SummaryOptions `json:"options"`
+	// Scope is the library of the documents, where structured summaries are stored.
+	Scope store.Scope `json:"scope"`
+	// Model is the model the user selected, if any. Regenerated summaries keep it.
+	Model string `json:"model,omitempty"`
+}
+
+// summaryStore stores the summary record of each thread that starts with a summary.
+type summaryStore struct {
+	cache *cache.BoltCache
+}
+
+func newSummaryStore(path string) (*summaryStore, error) {
+	cache, err := cache.NewBoltCache(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &summaryStore{cache: cache}, nil
+}
+
+// Get returns the summary record of a thread.
+func (s *summaryStore) Get(threadID string) (*summaryRecord, bool) {
+	value, ok := s.cache.Get(threadID)
+	if !ok {
+		return nil, false
+	}
+
+	var record summaryRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, false
+	}
+
+	return &record, true
+}
+
+// Put records the summary of a thread.
+func (s *summaryStore) Put(threadID string, record *summaryRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.cache.Put(threadID, string(value))
+}
+
+// Close closes the database.
+func (s *summaryStore) Close() error {
+	return s.cache.Close()
+}
+
+// summaryFooter describes how a summary was generated and by which model, and when it was cached if it was reused.
+func summaryFooter(options prompt.SummaryOptions, model, cachedFrom string) string {
+	var parts []string
+	if cachedFrom != "" {
+		parts = append(parts, fmt.Sprintf("Cached from %s, regenerate?", cachedFrom))
+	}
+	if !options.IsZero() {
+		parts = append(parts, "Style: "+options.String())
+	}
+	if model != "" {
+		parts = append(parts, "Model: "+model)
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+// summarize summarizes the documents of the record in a thread, and records them so the summary can be regenerated.
+// Summaries are stored in the library, and reused for the same documents and options unless regenerate is set.
+// Summaries of long documents report their progress, if progress isn't nil.
+func (a *app) summarize(ctx context.Context, fe chat.Frontend, tenant *tenant, teamID, channelID, threadID, userID string, record *summaryRecord, progress func(string), regenerate bool) error {
+	data := promptData(fe, tenant, teamID, channelID, userID)
+	data.ThreadID = threadID
+	data.Documents = record.Documents
+	data.Summary = record.Options
+
+	summary, model, cachedFrom, err := tenant.cachedSummary(ctx, a.log, threadID, data, record, progress, regenerate)
+	if err != nil {
+		return err
+	}
+
+	if err := tenant.summaries.Put(threadID, record); err != nil {
+		return fmt.Errorf("failed to record summary: %w", err)
+	}
+
+	if err := fe.PostSummary(teamID, channelID, threadID, summary, summaryFooter(record.Options, model, cachedFrom)); err != nil {
+		return fmt.Errorf("failed to post summary: %w", err)
+	}
+
+	return nil
+}
+
+// cachedSummary returns the summary of the record's documents in the thread. The stored summary of the same documents
+// and options is reused unless regenerate is set, and posted to the thread so follow-up questions have it in context.
+// Otherwise a new summary is written and stored. It returns the summary, the model that wrote a new summary, and the
+// date of a reused one.
+func (t *tenant) cachedSummary(ctx context.Context, log zerolog.Logger, threadID string, data prompt.Data, record *summaryRecord, progress func(string), regenerate bool) (string, string, string, error) {
+	fileStore := t.fileStore.Scoped(record.Scope)
+
+	key, err := summaryKey(fileStore, data, record.Model)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to compute summary key, the summary won't be cached")
+	}
+	name := summaryDocumentName(record.Documents[0].File, key)
+
+	if key != "" && !regenerate {
+		if doc, err := readDocument(fileStore, name); err == nil {
+			summary := string(doc.Content)
+			log.Info().Str("name", name).Str("thread_id", threadID).Msg("Reusing cached summary")
+
+			// Follow-up questions in the thread are answered with the summary in context.
+			if err := t.backend.Post(ctx, threadID, fmt.Sprintf("%s:\n\n%s", doc.Metadata.Title, summary)); err != nil {
+				return "", "", "", fmt.Errorf("failed to post cached summary: %w", err)
+			}
+
+			return summary, "", cacheDate(doc.Metadata.ProcessedTime), nil
+		}
+	}
+
+	summary, model, err := t.summary(ctx, threadID, data, record, progress)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to prompt for summary: %w", err)
+	}
+
+	if key != "" {
+		if err := t.storeSummary(ctx, record.Scope, name, data, summary); err != nil {
+			log.Error().Err(err).Str("name", name).Msg("Failed to store summary")
+		}
+	}
+
+	return summary, model, "", nil
+}
+
+// summary prompts for a new summary of the record's documents, with the model the user selected or the model of the
+// summary's task. It returns the summary and the model that wrote it.
+func (t *tenant) summary(ctx context.Context, threadID string, data prompt.Data, record *summaryRecord, progress func(string)) (string, string, error) {
+	var doc *document.Document
+	if !record.Options.Structured {
+		doc = t.longDocument(data, record.Scope)
+	}
+
+	ctx = t.withModel(ctx, summaryTask(data.Documents, doc != nil), record.Model)
+
+	var summary string
+	var err error
+	switch {
+	case record.Options.Structured:
+		summary, err = t.structuredSummary(ctx, threadID, data, record.Scope)
+	case doc != nil:
+		summary, err = t.sectionSummary(ctx, threadID, data, doc, progress)
+	default:
+		summary, err = t.prompt(ctx, threadID, prompt.SummaryInstructionsTemplate, prompt.SummaryTemplate, data)
+	}
+
+	return summary, backend.UsedModel(ctx), err
+}
+
+// SUMMARY_KEY_LENGTH is the number of hex characters of a summary key.
+const SUMMARY_KEY_LENGTH = 16
+
+// summaryKey identifies a summary by the content of its documents, its options, the persona it's written with and the
+// model the user selected, if any. The front matter of the documents isn't part of the key, since it changes when a
+// document is ingested again.
+func summaryKey(fileStore store.LocalStore, data prompt.Data, model string) (string, error) {
+	options, err := json.Marshal(data.Summary)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", data.Persona, options)
+	// Summaries written with the model of their task keep the keys they had before models could be selected.
+	if model != "" {
+		fmt.Fprintf(h, "%s\n", model)
+	}
+	for _, d := range data.Documents {
+		doc, err := readDocument(fileStore, d.File)
+		if err != nil {
+			return "", err
+		}
+
+		sum := sha256.Sum256(doc.Content)
+		h.Write(sum[:])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:SUMMARY_KEY_LENGTH], nil
+}
+
+// summaryDocumentName returns the file name of the summary with the given key, e.g. "bitcoin.summary-0123456789abcdef.md"
+// for a summary of "bitcoin.md".
+func summaryDocumentName(file, key string) string {
+	return fmt.Sprintf("%s.summary-%s.md", strings.TrimSuffix(file, filepath.Ext(file)), key)
+}
+
+// cacheDate returns the date of a cached summary's processing time.
+func cacheDate(processedTime string) string {
+	t, err := time.Parse(time.RFC3339, processedTime)
+	if err != nil {
+		return processedTime
+	}
+
+	return t.Format(time.DateOnly)
+}
+
+// storeSummary stores a summary as a document in the scope's library, derived from the summarized documents, and
+// uploads it to the vector store. It replaces the previous summary with the same name.
+func (t *tenant) storeSummary(ctx context.Context, scope store.Scope, name string, data prompt.Data, summary string) error {
+	var titles, sources, files []string
+	for _, d := range data.Documents {
+		title := d.Title
+		if title == "" {
+			title = d.File
+		}
+
+		titles = append(titles, title)
+		files = append(files, d.File)
+		if d.Source != "" {
+			sources = append(sources, d.Source)
+		}
+	}
+
+	doc := &document.Document{
+		Content: []byte(summary),
+		Metadata: document.Metadata{
+			Title:         "Summary of " + strings.Join(titles, ", "),
+			Type:          document.TypeSummary,
+			ProcessedTime: time.Now().Format(time.RFC3339),
+			Links:         sources,
+			UploadedBy:    data.User.ID,
+			DerivedFrom:   files,
+		},
+	}
+	setScope(&doc.Metadata, scope)
+
+	return t.storeDocument(ctx, scope, name, doc)
+}
+
+// storeDocument stores a document generated by Scholar in the scope's library under the given name, and uploads it
+// to the vector store. It replaces the previous document with the same name.
+func (t *tenant) storeDocument(ctx context.Context, scope store.Scope, name string, doc *document.Document) error {
+	_, markdown, err := doc.ToMarkdown()
+	if err != nil {
+		return err
+	}
+
+	fileStore := t.fileStore.Scoped(scope)
+	if ok, err := fileStore.Contains(name); err == nil && ok {
+		if err := t.backend.DeleteFile(ctx, scope, name); err != nil {
+			return err
+		}
+	}
+
+	if err := fileStore.Store(name, bytes.NewReader(markdown)); err != nil {
+		return err
+	}
+
+	f, err := fileStore.Get(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return t.backend.UploadFile(ctx, scope, name, f)
+}
+
+// structuredSummary prompts for a structured summary of each document, and stores them next to their documents in
+// the scope's library. It returns the summaries rendered as markdown.
+func (t *tenant) structuredSummary(ctx context.Context, threadID string, data prompt.Data, scope store.Scope) (string, error) {
+	format := backend.ResponseFormat{
+		Name:        "structured_summaries",
+		Description: "A structured summary of each file.",
+		Schema:      prompt.StructuredSummarySchema(),
+	}
+
+	var summaries []prompt.StructuredSummary
+	err := t.promptJSON(ctx, threadID, prompt.SummaryInstructionsTemplate, prompt.SummaryTemplate, data, format, func(response string) (err error) {
+		summaries, err = prompt.ParseStructuredSummaries(response, documentFiles(data.Documents))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	fileStore := t.fileStore.Scoped(scope)
+	rendered := make([]string, len(summaries))
+	for i := range summaries {
+		if err := storeStructuredSummary(fileStore, &summaries[i]); err != nil {
+			return "", fmt.Errorf("failed to store structured summary: %w", err)
+		}
+
+		rendered[i] = summaries[i].Markdown()
+	}
+
+	return strings.Join(rendered, "\n\n"), nil
+}
+
+// longDocument returns the document to summarize if the summary is of a single document that is long enough to be
+// summarized section by section, or nil.
+func (t *tenant) longDocument(data prompt.Data, scope store.Scope) *document.Document {
+	if len(data.Documents) != 1 || t.summaryConfig.LongDocumentLength == 0 {
+		return nil
+	}
+
+	doc, err := readDocument(t.fileStore.Scoped(scope), data.Documents[0].File)
+	if err != nil || len(doc.Content) < t.summaryConfig.LongDocumentLength {
+		return nil
+	}
+
+	return doc
+}
+
+// sectionSummary summarizes a long document: each of its sections is summarized on its own, and the section
+// summaries are combined into one summary in the thread, which cites the sections it is based on.
+func (t *tenant) sectionSummary(ctx context.Context, threadID string, data prompt.Data, doc *document.Document, progress func(string)) (string, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	sections := doc.Sections(t.summaryConfig.SectionLength)
+
+	instructions, err := t.templates.Render(prompt.SectionInstructionsTemplate, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s prompt: %w", prompt.SectionInstructionsTemplate, err)
+	}
+
+	progress(fmt.Sprintf("Summarizing %d sections...", len(sections)))
+
+	summaries := make([]prompt.Section, len(sections))
+	var done atomic.Int32
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(t.summaryConfig.Concurrency)
+	for i, section := range sections {
+		g.Go(func() error {
+			summaries[i] = prompt.Section{Index: i + 1, Count: len(sections), Heading: section.Heading, Text: section.Text}
+
+			sectionData := data
+			sectionData.Section = summaries[i]
+			message, err := t.templates.Render(prompt.SectionTemplate, sectionData)
+			if err != nil {
+				return fmt.Errorf("failed to render %s prompt: %w", prompt.SectionTemplate, err)
+			}
+
+			if summaries[i].Text, err = t.backend.Complete(gctx, instructions, message); err != nil {
+				return fmt.Errorf("failed to summarize section %d: %w", i+1, err)
+			}
+
+			progress(fmt.Sprintf("Summarized %d of %d sections...", done.Add(1), len(sections)))
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return "", err
+	}
+
+	progress("Combining the section summaries...")
+
+	data.Sections = summaries
+	instructions, message, err := t.renderPrompt(prompt.SummaryInstructionsTemplate, prompt.CombineTemplate, data)
+	if err != nil {
+		return "", err
+	}
+
+	summary, err := t.backend.Prompt(ctx, threadID, instructions, message)
+	if err != nil {
+		return "", err
+	}
+
+	if legend := sectionLegend(summary, summaries); legend != "" {
+		summary += "\n\n" + legend
+	}
+
+	return summary, nil
+}
+
+// sectionCitationRegex matches the section citations of a combined summary, e.g. [§3].
+var sectionCitationRegex = regexp.MustCompile(`\[§(\d+)\]`)
+
+// sectionLegend lists the headings of the sections cited in the summary, in order.
+func sectionLegend(summary string, sections []prompt.Section) string {
+	cited := make(map[int]bool)
+	for _, match := range sectionCitationRegex.FindAllStringSubmatch(summary, -1) {
+		index, err := strconv.Atoi(match[1])
+		if err == nil && index >= 1 && index <= len(sections) {
+			cited[index] = true
+		}
+	}
+
+	if len(cited) == 0 {
+		return ""
+	}
+
+	lines := []string{"Sections:"}
+	for _, section := range sections {
+		if cited[section.Index] {
+			heading := section.Heading
+			if heading == "" {
+				heading = fmt.Sprintf("Section %d", section.Index)
+			}
+			lines = append(lines, fmt.Sprintf("[§%d] %s", section.Index, heading))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/store"
+)
+
+func TestSummaryStore(t *testing.T) {
+	summaries, err := newSummaryStore(filepath.Join(t.TempDir(), "summaries.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := &summaryRecord{
+		Documents: []prompt.Document{{File: "bitcoin.md", Metadata: document.Metadata{Title: "Bitcoin"}}},
+		Options:   prompt.SummaryOptions{Style: prompt.StyleTLDR, Language: "de"},
+		Scope:     store.ChannelScope("C1"),
+		Model:     "gpt-4o",
+	}
+
+	if err := summaries.Put("1.0", record); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := summaries.Get("1.0")
+	if !ok || got.Options != record.Options || got.Scope != record.Scope || got.Model != record.Model || got.Documents[0].Title != "Bitcoin" {
+		t.Errorf("unexpected record: %+v", got)
+	}
+
+	if _, ok := summaries.Get("2.0"); ok {
+		t.Error("unexpected record for another thread")
+	}
+
+	if footer := summaryFooter(record.Options, record.Model, ""); footer != "Style: tldr, lang=de · Model: gpt-4o" {
+		t.Errorf("unexpected footer: %s", footer)
+	}
+}
+
+func TestStructuredSummarySidecar(t *testing.T) {
+	fs := store.NewFileStore(t.TempDir())
+
+	doc := &document.Document{Content: []byte("# Bitcoin\n"), Metadata: document.Metadata{Source: "https://bitcoin.org/bitcoin.pdf"}}
+	name, markdown, err := doc.ToMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Store(name, strings.NewReader(string(markdown))); err != nil {
+		t.Fatal(err)
+	}
+
+	summary := &prompt.StructuredSummary{File: name, TLDR: "Electronic cash without a trusted third party.", KeyContributions: []string{"Proof-of-work timestamps"}}
+	if err := storeStructuredSummary(fs, summary); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readStructuredSummary(fs, name)
+	if err != nil || got.TLDR != summary.TLDR {
+		t.Fatalf("unexpected summary: %+v (%v)", got, err)
+	}
+
+	// The summary is searchable, even though the document doesn't contain its words.
+	docs, err := searchDocuments(fs, "trusted third party")
+	if err != nil || len(docs) != 1 {
+		t.Errorf("search didn't match the summary: %+v (%v)", docs, err)
+	}
+}
+
+func TestSectionLegend(t *testing.T) {
+	sections := []prompt.Section{{Index: 1, Heading: "1 Introduction"}, {Index: 2}, {Index: 3, Heading: "3 Protocol"}}
+
+	legend := sectionLegend("FastPay settles payments in one round trip [§3], without consensus [§1][§9].", sections)
+	if legend != "Sections:\n[§1] 1 Introduction\n[§3] 3 Protocol" {
+		t.Errorf("unexpected legend:\n%s", legend)
+	}
+
+	if legend := sectionLegend("No citations.", sections); legend != "" {
+		t.Errorf("unexpected legend:\n%s", legend)
+	}
+}
+
+func TestSummaryKey(t *testing.T) {
+	fs := store.NewFileStore(t.TempDir())
+
+	put := func(doc *document.Document) string {
+		name, markdown, err := doc.ToMarkdown()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fs.Store(name, strings.NewReader(string(markdown))); err != nil {
+			t.Fatal(err)
+		}
+
+		return name
+	}
+
+	name := put(&document.Document{Content: []byte("# Bitcoin\n"), Metadata: document.Metadata{ProcessedTime: "2025-01-01T00:00:00Z"}})
+	data := prompt.Data{Persona: prompt.DefaultPersona, Documents: []prompt.Document{{File: name}}}
+
+	key, err := summaryKey(fs, data, "")
+	if err != nil || len(key) != SUMMARY_KEY_LENGTH {
+		t.Fatalf("unexpected key %q (%v)", key, err)
+	}
+
+	// Ingesting the document again doesn't change its content.
+	put(&document.Document{Content: []byte("# Bitcoin\n"), Metadata: document.Metadata{ProcessedTime: "2025-02-01T00:00:00Z"}})
+	if again, _ := summaryKey(fs, data, ""); again != key {
+		t.Error("the key depends on the front matter")
+	}
+
+	tldr := data
+	tldr.Summary = prompt.SummaryOptions{Style: prompt.StyleTLDR}
+	persona := data
+	persona.Persona = "reading-group"
+	for _, other := range []prompt.Data{tldr, persona} {
+		if otherKey, _ := summaryKey(fs, other, ""); otherKey == key {
+			t.Errorf("same key for %+v", other)
+		}
+	}
+
+	if modelKey, _ := summaryKey(fs, data, "gpt-4o"); modelKey == key {
+		t.Error("same key for a selected model")
+	}
+
+	if _, err := summaryKey(fs, prompt.Data{Documents: []prompt.Document{{File: "missing.md"}}}, ""); err == nil {
+		t.Error("expected an error for a missing document")
+	}
+
+	if name := summaryDocumentName("Bitcoin.md", key); name != "Bitcoin.summary-"+key+".md" {
+		t.Errorf("unexpected summary name: %s", name)
+	}
+
+	if footer := summaryFooter(tldr.Summary, "", cacheDate("2025-02-01T10:00:00Z")); footer != "Cached from 2025-02-01, regenerate? · Style: tldr" {
+		t.Errorf("unexpected footer: %s", footer)
+	}
+}
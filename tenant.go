@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/openai/openai-go/option"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/config"
+	"github.com/mempirate/scholar/prompt"
+	"github.com/mempirate/scholar/search"
+	"github.com/mempirate/scholar/store"
+)
+
+// tenant is the isolated state of a single Slack workspace or Discord server: its documents, threads and vector store.
+type tenant struct {
+	teamID    string
+	fileStore *store.FileStore
+	backend   backend.ScholarBackend
+	ingester  *ingester
+	templates *prompt.Templates
+	personas  *personaStore
+	summaries *summaryStore
+	// threads are the owners of the conversations started over the API and MCP.
+	threads *threadOwnerStore
+	// summaryConfig are the settings of summaries of long documents.
+	summaryConfig config.SummaryConfig
+	// researchConfig are the settings of /research, and search its web search provider. search is nil if web
+	// search is disabled.
+	researchConfig config.ResearchConfig
+	search         search.Provider
+	// usage is the ledger of the workspace's usage of the API, and usageConfig its prices and budgets.
+	usage       *usageLedger
+	usageConfig config.UsageConfig
+}
+
+// tenants creates the tenant of each workspace on first use.
+//
+// The default workspace (the one SLACK_BOT_TOKEN belongs to) lives at the root of the data directory, so
+// single-workspace setups keep their existing library. Workspaces installed with OAuth get their own
+// directory under teams/, and their own assistant and vector store.
+type tenants struct {
+	log zerolog.Logger
+
+	apiKey         string
+	dataDir        string
+	config         *config.Config
+	contentHandler urlHandler
+	search         search.Provider
+
+	// defaultTeamID returns the team ID of the default workspace.
+	defaultTeamID func() string
+	// options are extra options of the OpenAI clients of the workspaces.
+	options []option.RequestOption
+
+	// tenants are the workspaces that are initialized or being initialized. The lock isn't held while a workspace is
+	// initialized, so a slow workspace doesn't hold up the others.
+	mu      sync.Mutex
+	tenants map[string]*tenantInit
+}
+
+// tenantInit is the initialization of a workspace's tenant. done is closed once it is initialized or failed to.
+type tenantInit struct {
+	done   chan struct{}
+	tenant *tenant
+	err    error
+}
+
+func newTenants(log zerolog.Logger, apiKey, dataDir string, config *config.Config, contentHandler urlHandler, search search.Provider, defaultTeamID func() string) *tenants {
+	return &tenants{
+		log:            log,
+		apiKey:         apiKey,
+		dataDir:        dataDir,
+		config:         config,
+		contentHandler: contentHandler,
+		search:         search,
+		defaultTeamID:  defaultTeamID,
+		tenants:        make(map[string]*tenantInit),
+	}
+}
+
+// normalize maps the default workspace to the empty team ID.
+func (t *tenants) normalize(teamID string) string {
+	if teamID == t.defaultTeamID() {
+		return ""
+	}
+
+	return teamID
+}
+
+// dir returns the data directory of the given workspace.
+func (t *tenants) dir(teamID string) string {
+	if teamID = t.normalize(teamID); teamID == "" {
+		return t.dataDir
+	}
+
+	return filepath.Join(t.dataDir, "teams", teamID)
+}
+
+// FileStore returns the local store of the given workspace, without initializing its backend.
+func (t *tenants) FileStore(teamID string) *store.FileStore {
+	return store.NewFileStore(t.dir(teamID))
+}
+
+// Get returns the tenant of the given workspace, initializing it if needed. An empty team ID refers to the default workspace.
+// Concurrent calls for a workspace share its initialization. Failed initializations are tried again on the next call.
+func (t *tenants) Get(ctx context.Context, teamID string) (*tenant, error) {
+	t.mu.Lock()
+	teamID = t.normalize(teamID)
+	init, ok := t.tenants[teamID]
+	if ok {
+		t.mu.Unlock()
+
+		select {
+		case <-init.done:
+			return init.tenant, init.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	init = &tenantInit{done: make(chan struct{})}
+	t.tenants[teamID] = init
+	t.mu.Unlock()
+
+	init.tenant, init.err = t.open(ctx, teamID)
+	if init.err != nil {
+		t.mu.Lock()
+		delete(t.tenants, teamID)
+		t.mu.Unlock()
+	}
+
+	close(init.done)
+	return init.tenant, init.err
+}
+
+// open initializes the tenant of a workspace. If it fails, the databases it opened are closed again, and the
+// workspace is initialized again on its next event.
+func (t *tenants) open(ctx context.Context, teamID string) (_ *tenant, err error) {
+	dir := t.dir(teamID)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "failed to create workspace data directory")
+	}
+
+	fileStore := store.NewFileStore(dir)
+	backend, err := backend.NewBackend(t.apiKey, t.config.OpenAI, fileStore, teamID, t.options...)
+	if err != nil {
+		return nil, err
+	}
+
+	tn := &tenant{
+		teamID:         teamID,
+		fileStore:      fileStore,
+		backend:        backend,
+		templates:      t.config.Prompts.Templates,
+		summaryConfig:  t.config.Summary,
+		researchConfig: t.config.Research,
+		search:         t.search,
+		usageConfig:    t.config.Usage,
+		ingester: &ingester{
+			log:            t.log,
+			contentHandler: t.contentHandler,
+			fileStore:      fileStore,
+			backend:        backend,
+			concurrency:    t.config.Ingest.Concurrency,
+		},
+	}
+
+	defer func() {
+		if err != nil {
+			tn.Close()
+		}
+	}()
+
+	// Tools are part of the assistant's settings, so they're registered before it is initialized.
+	if err := tn.registerTools(backend.Tools()); err != nil {
+		return nil, errors.Wrap(err, "failed to register tools")
+	}
+
+	if tn.usage, err = newUsageLedger(filepath.Join(dir, "usage.db")); err != nil {
+		return nil, errors.Wrap(err, "failed to open usage ledger")
+	}
+
+	backend.SetMeter(newUsageMeter(t.log, tn.usage, t.config.Usage))
+
+	if err := backend.Init(ctx); err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize backend for workspace %q", teamID)
+	}
+
+	if tn.personas, err = newPersonaStore(filepath.Join(dir, "personas.db"), t.config.Prompts.Channels); err != nil {
+		return nil, errors.Wrap(err, "failed to open persona store")
+	}
+
+	if tn.summaries, err = newSummaryStore(filepath.Join(dir, "summaries.db")); err != nil {
+		return nil, errors.Wrap(err, "failed to open summary store")
+	}
+
+	if tn.threads, err = newThreadOwnerStore(filepath.Join(dir, "thread_owners.db")); err != nil {
+		return nil, errors.Wrap(err, "failed to open thread owner store")
+	}
+
+	t.log.Info().Str("team_id", teamID).Str("dataDir", dir).Msg("Workspace initialized")
+	return tn, nil
+}
+
+// Close closes the databases of the tenant that are open.
+func (tn *tenant) Close() {
+	if tn.threads != nil {
+		tn.threads.Close()
+	}
+
+	if tn.summaries != nil {
+		tn.summaries.Close()
+	}
+
+	if tn.personas != nil {
+		tn.personas.Close()
+	}
+
+	if tn.usage != nil {
+		tn.usage.Close()
+	}
+
+	tn.backend.Close()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/option"
+	"github.com/rs/zerolog"
+
+	"github.com/mempirate/scholar/config"
+)
+
+func TestTenantsInit(t *testing.T) {
+	// The API holds requests until released, and rejects them.
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`))
+	}))
+	defer server.Close()
+
+	tenants := newTenants(zerolog.Nop(), "sk-test", t.TempDir(), config.Default(), nil, nil, func() string { return "" })
+	tenants.options = []option.RequestOption{option.WithBaseURL(server.URL)}
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i, teamID := range []string{"T1", "T1", "T2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = tenants.Get(ctx, teamID)
+		}()
+	}
+
+	// Workspaces are initialized concurrently, and concurrent calls for the same workspace share its initialization.
+	deadline := time.Now().Add(5 * time.Second)
+	for requests.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if requests.Load() != 2 {
+		t.Fatalf("expected 2 concurrent initializations, got %d", requests.Load())
+	}
+
+	// Waiting for a workspace stops when the context is done.
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := tenants.Get(waitCtx, "T1"); err != context.DeadlineExceeded {
+		t.Errorf("expected the wait to time out, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("expected initialization %d to fail", i)
+		}
+	}
+
+	// Failed initializations are retried, and the databases they opened were closed, so they can be opened again.
+	done := make(chan error)
+	go func() {
+		_, err := tenants.Get(ctx, "T1")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected initialization to fail again")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("initialization hung on databases left open")
+	}
+
+	if requests.Load() != 3 {
+		t.Errorf("expected the failed initialization to be retried, got %d requests", requests.Load())
+	}
+}
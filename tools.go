@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/store"
+)
+
+// MAX_TOOL_MESSAGES is the default number of messages returned by search_slack_messages.
+const MAX_TOOL_MESSAGES = 10
+
+// conversation is the chat conversation a run of the assistant answers. Tools act on behalf of its user, in its
+// library.
+type conversation struct {
+	// frontend is the chat platform of the conversation. Nil for API and MCP callers.
+	frontend  chat.Frontend
+	teamID    string
+	channelID string
+	userID    string
+	// command is what the conversation is about, e.g. "/summary" or "mention", for usage accounting.
+	command string
+	// scope is the library of the conversation, besides the public one.
+	scope store.Scope
+	// canIngest is true if the user is allowed to add documents to the library.
+	canIngest bool
+}
+
+// commandConversation returns the conversation of a command that was authorized to add documents.
+func commandConversation(fe chat.Frontend, cmd chat.Command, scope store.Scope) *conversation {
+	return &conversation{
+		frontend:  fe,
+		teamID:    cmd.TeamID,
+		channelID: cmd.ChannelID,
+		userID:    cmd.UserID,
+		command:   cmd.CommandType,
+		scope:     scope,
+		canIngest: true,
+	}
+}
+
+type conversationKey struct{}
+
+// withConversation returns a context for runs that answer the given conversation.
+func withConversation(ctx context.Context, conv *conversation) context.Context {
+	return context.WithValue(ctx, conversationKey{}, conv)
+}
+
+// conversationFrom returns the conversation of a run. Runs without a conversation (e.g. the CLI) only see the
+// public library, and can't ingest.
+func conversationFrom(ctx context.Context) *conversation {
+	if conv, ok := ctx.Value(conversationKey{}).(*conversation); ok {
+		return conv
+	}
+
+	return &conversation{scope: store.PublicScope}
+}
+
+// registerTools registers the function tools of the tenant's assistant.
+func (t *tenant) registerTools(tools *backend.Tools) error {
+	for _, tool := range []backend.Tool{
+		{
+			Name:        "ingest_url",
+			Description: "Add a web page, PDF or tweet to the library. Use it when the user asks to save a link. Once added, the document can be found with file search.",
+			Parameters:  objectSchema(map[string]any{"url": stringSchema("The http or https URL to add.")}, "url"),
+			Call:        t.ingestTool,
+		},
+		{
+			Name:        "list_documents",
+			Description: "List the documents of the library with their file name, title, source and type. Optionally only the documents that contain every word of the query.",
+			Parameters: objectSchema(map[string]any{
+				"query": stringSchema("Words to search for."),
+				"type":  stringSchema("Only documents of this type, e.g. pdf, article, tweet, summary or report."),
+			}),
+			Call: t.listDocumentsTool,
+		},
+		{
+			Name:        "get_document_metadata",
+			Description: "Get the metadata of a document: title, source, authors, type, publication and processing dates.",
+			Parameters:  objectSchema(map[string]any{"file": stringSchema("The file name of the document, as returned by list_documents.")}, "file"),
+			Call:        t.documentMetadataTool,
+		},
+		{
+			Name:        "search_slack_messages",
+			Description: "Search the recent messages of the current channel. Returns the messages that contain every word of the query, newest first.",
+			Parameters: objectSchema(map[string]any{
+				"query": stringSchema("Words to search for."),
+				"limit": map[string]any{"type": "integer", "description": fmt.Sprintf("Maximum number of messages to return. Defaults to %d.", MAX_TOOL_MESSAGES)},
+			}, "query"),
+			Call: searchMessagesTool,
+		},
+		{
+			Name:        "get_current_date",
+			Description: "Get today's date and weekday.",
+			Call:        currentDateTool,
+		},
+	} {
+		if err := tools.Register(tool); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *tenant) ingestTool(ctx context.Context, args json.RawMessage) (any, error) {
+	var p struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return nil, err
+	}
+
+	conv := conversationFrom(ctx)
+	if !conv.canIngest {
+		return nil, errors.New("the user is not allowed to add documents here")
+	}
+
+	if t.ingester.contentHandler == nil {
+		return nil, errors.New("ingestion is not configured")
+	}
+
+	uri, err := validateWebURL(p.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like links in mentions, the document is added to the conversation's library.
+	results := t.ingester.IngestAll(ctx, []*url.URL{uri}, ingestOptions{Dedup: true, Scope: conv.scope, UploadedBy: conv.userID}, nil)
+	return newIngestInfo(results[0]), nil
+}
+
+func (t *tenant) listDocumentsTool(ctx context.Context, args json.RawMessage) (any, error) {
+	var p struct {
+		Query string `json:"query"`
+		Type  string `json:"type"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return nil, err
+	}
+
+	docs := make([]documentInfo, 0)
+	for _, scope := range conversationScopes(ctx) {
+		fileStore := t.fileStore.Scoped(scope)
+
+		var found []documentInfo
+		var err error
+		if strings.TrimSpace(p.Query) == "" {
+			found, err = listDocuments(fileStore)
+		} else {
+			found, err = searchDocuments(fileStore, p.Query)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, doc := range found {
+			if p.Type == "" || strings.EqualFold(doc.Type, p.Type) {
+				docs = append(docs, doc)
+			}
+		}
+	}
+
+	return map[string]any{"total": len(docs), "documents": docs[:min(len(docs), MAX_SEARCH_RESULTS)]}, nil
+}
+
+func (t *tenant) documentMetadataTool(ctx context.Context, args json.RawMessage) (any, error) {
+	var p struct {
+		File string `json:"file"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return nil, err
+	}
+
+	if !validDocumentName(p.File) {
+		return nil, fmt.Errorf("invalid document name: %s", p.File)
+	}
+
+	// The conversation's own documents shadow public ones with the same name.
+	scopes := conversationScopes(ctx)
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if doc, err := readDocument(t.fileStore.Scoped(scopes[i]), p.File); err == nil {
+			return struct {
+				File string `json:"file"`
+				document.Metadata
+			}{p.File, doc.Metadata}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no such document: %s", p.File)
+}
+
+// conversationScopes returns the libraries the conversation of a run can see: the public library, and its own.
+func conversationScopes(ctx context.Context) []store.Scope {
+	scopes := []store.Scope{store.PublicScope}
+	if scope := conversationFrom(ctx).scope; !scope.IsPublic() {
+		scopes = append(scopes, scope)
+	}
+
+	return scopes
+}
+
+func searchMessagesTool(ctx context.Context, args json.RawMessage) (any, error) {
+	var p struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return nil, err
+	}
+
+	conv := conversationFrom(ctx)
+	if conv.frontend == nil {
+		return nil, errors.New("there are no chat messages outside of a chat conversation")
+	}
+
+	if p.Limit <= 0 {
+		p.Limit = MAX_TOOL_MESSAGES
+	}
+
+	messages, err := conv.frontend.SearchMessages(conv.teamID, conv.channelID, p.Query, min(p.Limit, chat.SEARCH_HISTORY_LIMIT))
+	if err != nil {
+		return nil, err
+	}
+
+	type messageInfo struct {
+		User string    `json:"user"`
+		Text string    `json:"text"`
+		Time time.Time `json:"time"`
+	}
+
+	infos := make([]messageInfo, len(messages))
+	for i, msg := range messages {
+		infos[i] = messageInfo{User: msg.UserID, Text: msg.Text, Time: msg.Time}
+	}
+
+	return map[string]any{"messages": infos}, nil
+}
+
+func currentDateTool(ctx context.Context, args json.RawMessage) (any, error) {
+	now := time.Now()
+	return map[string]string{"date": now.Format(time.DateOnly), "weekday": now.Weekday().String()}, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mempirate/scholar/document"
+	"github.com/mempirate/scholar/store"
+)
+
+func TestLibraryTools(t *testing.T) {
+	fs := store.NewFileStore(t.TempDir())
+	channel := store.ChannelScope("C123")
+
+	for _, doc := range []struct {
+		scope store.Scope
+		doc   *document.Document
+	}{
+		{store.PublicScope, &document.Document{Content: []byte("# FastPay\n\nByzantine settlement.\n"), Metadata: document.Metadata{Source: "https://arxiv.org/abs/2003.11506", Type: document.TypePDF}}},
+		{channel, &document.Document{Content: []byte("# Bitcoin\n\nPeer-to-peer electronic cash.\n"), Metadata: document.Metadata{Title: "Bitcoin", Type: document.TypeArticle}}},
+	} {
+		name, markdown, err := doc.doc.ToMarkdown()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fs.Scoped(doc.scope).Store(name, strings.NewReader(string(markdown))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tenant := &tenant{fileStore: fs}
+	public := context.Background()
+	inChannel := withConversation(public, &conversation{channelID: "C123", scope: channel})
+
+	count := func(ctx context.Context, args string) int {
+		out, err := tenant.listDocumentsTool(ctx, json.RawMessage(args))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return out.(map[string]any)["total"].(int)
+	}
+
+	if n := count(public, `{}`); n != 1 {
+		t.Errorf("expected the public document only, got %d", n)
+	}
+
+	if n := count(inChannel, `{}`); n != 2 {
+		t.Errorf("expected the public and channel documents, got %d", n)
+	}
+
+	if n := count(inChannel, `{"query": "electronic cash"}`); n != 1 {
+		t.Errorf("expected 1 search result, got %d", n)
+	}
+
+	if n := count(inChannel, `{"type": "PDF"}`); n != 1 {
+		t.Errorf("expected 1 pdf, got %d", n)
+	}
+
+	out, err := tenant.documentMetadataTool(inChannel, json.RawMessage(`{"file": "Bitcoin.md"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, _ := json.Marshal(out)
+	if !strings.Contains(string(encoded), `"file":"Bitcoin.md"`) || !strings.Contains(string(encoded), `"title":"Bitcoin"`) {
+		t.Errorf("unexpected metadata %s", encoded)
+	}
+
+	for _, file := range []string{"Bitcoin.md", "../config.yaml", "made-up.md"} {
+		if _, err := tenant.documentMetadataTool(public, json.RawMessage(`{"file": "`+file+`"}`)); err == nil {
+			t.Errorf("expected an error for %s outside of the channel", file)
+		}
+	}
+
+	if _, err := tenant.ingestTool(public, json.RawMessage(`{"url": "https://example.com"}`)); err == nil {
+		t.Error("expected ingestion to be denied without a conversation")
+	}
+
+	if _, err := searchMessagesTool(public, json.RawMessage(`{"query": "fastpay"}`)); err == nil {
+		t.Error("expected message search to fail without a chat conversation")
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/mempirate/scholar/access"
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/chat"
+	"github.com/mempirate/scholar/config"
+	"github.com/mempirate/scholar/util"
+)
+
+const (
+	// USAGE_BUCKET_NAME is the bucket of the usage ledger.
+	USAGE_BUCKET_NAME = "usage"
+	// USAGE_DAILY_BUCKET_NAME is the bucket of the tokens used per day by each user and channel, which budgets are
+	// checked against.
+	USAGE_DAILY_BUCKET_NAME = "usage_daily"
+	// USAGE_REPORT_DAYS is the number of days covered by /usage.
+	USAGE_REPORT_DAYS = 30
+	// USAGE_REPORT_TOP is the number of users, channels and commands listed by /usage.
+	USAGE_REPORT_TOP = 5
+)
+
+// usageEntry is what a single request to the API used, and on whose behalf.
+type usageEntry struct {
+	Time time.Time `json:"time"`
+	// UserID, ChannelID and Command are empty for requests outside of conversations, like syncing the library.
+	UserID           string `json:"userId,omitempty"`
+	ChannelID        string `json:"channelId,omitempty"`
+	Command          string `json:"command,omitempty"`
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int64  `json:"promptTokens,omitempty"`
+	CompletionTokens int64  `json:"completionTokens,omitempty"`
+	StorageBytes     int64  `json:"storageBytes,omitempty"`
+}
+
+// Kinds of daily rollups.
+const (
+	usageByUser    = "user"
+	usageByChannel = "channel"
+)
+
+// usageLedger is the persistent record of a workspace's usage. Entries are keyed by time, so the entries of a day
+// are next to each other. The tokens of each day are also rolled up by user and channel, so checking a budget is a
+// single lookup. Only the last USAGE_REPORT_DAYS days are kept.
+type usageLedger struct {
+	db *bolt.DB
+	// pruned is the day the ledger was last pruned. It is only accessed in write transactions.
+	pruned time.Time
+}
+
+func newUsageLedger(path string) (*usageLedger, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		entries, err := tx.CreateBucketIfNotExists([]byte(USAGE_BUCKET_NAME))
+		if err != nil {
+			return err
+		}
+
+		if tx.Bucket([]byte(USAGE_DAILY_BUCKET_NAME)) != nil {
+			return nil
+		}
+
+		// Ledgers from before the rollups get them from their entries.
+		daily, err := tx.CreateBucket([]byte(USAGE_DAILY_BUCKET_NAME))
+		if err != nil {
+			return err
+		}
+
+		return entries.ForEach(func(k, v []byte) error {
+			var entry usageEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return errors.Wrapf(err, "invalid usage entry %s", k)
+			}
+
+			return rollUp(daily, entry)
+		})
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to create usage bucket")
+	}
+
+	ledger := &usageLedger{db: db}
+	if err := ledger.Prune(time.Now()); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to prune usage")
+	}
+
+	return ledger, nil
+}
+
+// Close closes the database.
+func (l *usageLedger) Close() error {
+	return l.db.Close()
+}
+
+// usageKey returns the key of an entry. The sequence number keeps entries of the same instant apart.
+func usageKey(t time.Time, seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s/%016x", t.UTC().Format("2006-01-02T15:04:05.000000000"), seq))
+}
+
+// dailyKey returns the key of the tokens used on the day of t by the user or the channel with the given ID.
+func dailyKey(t time.Time, kind, id string) []byte {
+	return []byte(startOfDay(t).Format("2006-01-02") + "/" + kind + "/" + id)
+}
+
+// rollUp adds the tokens of an entry to the daily rollups of its user and channel.
+func rollUp(daily *bolt.Bucket, entry usageEntry) error {
+	tokens := entry.PromptTokens + entry.CompletionTokens
+	if tokens == 0 {
+		return nil
+	}
+
+	for kind, id := range map[string]string{usageByUser: entry.UserID, usageByChannel: entry.ChannelID} {
+		if id == "" {
+			continue
+		}
+
+		key := dailyKey(entry.Time, kind, id)
+		total := make([]byte, 8)
+		binary.BigEndian.PutUint64(total, uint64(dailyTokens(daily, key)+tokens))
+		if err := daily.Put(key, total); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dailyTokens returns the tokens of a daily rollup.
+func dailyTokens(daily *bolt.Bucket, key []byte) int64 {
+	value := daily.Get(key)
+	if len(value) != 8 {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(value))
+}
+
+// Add records an entry. The first entry of a day prunes the entries that are too old to be reported.
+func (l *usageLedger) Add(entry usageEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return l.db.Update(func(tx *bolt.Tx) error {
+		if startOfDay(entry.Time).After(l.pruned) {
+			if err := l.prune(tx, entry.Time); err != nil {
+				return err
+			}
+		}
+
+		bucket := tx.Bucket([]byte(USAGE_BUCKET_NAME))
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		if err := bucket.Put(usageKey(entry.Time, seq), value); err != nil {
+			return err
+		}
+
+		return rollUp(tx.Bucket([]byte(USAGE_DAILY_BUCKET_NAME)), entry)
+	})
+}
+
+// Tokens returns the tokens used on the day of t by the user or the channel with the given ID.
+func (l *usageLedger) Tokens(t time.Time, kind, id string) (int64, error) {
+	var tokens int64
+	err := l.db.View(func(tx *bolt.Tx) error {
+		tokens = dailyTokens(tx.Bucket([]byte(USAGE_DAILY_BUCKET_NAME)), dailyKey(t, kind, id))
+		return nil
+	})
+
+	return tokens, err
+}
+
+// usagePeriodStart returns the start of the USAGE_REPORT_DAYS days up to now.
+func usagePeriodStart(now time.Time) time.Time {
+	return startOfDay(now).AddDate(0, 0, -USAGE_REPORT_DAYS+1)
+}
+
+// Prune deletes the entries and rollups of the days before the USAGE_REPORT_DAYS days up to now.
+func (l *usageLedger) Prune(now time.Time) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		return l.prune(tx, now)
+	})
+}
+
+func (l *usageLedger) prune(tx *bolt.Tx, now time.Time) error {
+	start := usagePeriodStart(now)
+
+	for name, end := range map[string][]byte{
+		USAGE_BUCKET_NAME:       usageKey(start, 0),
+		USAGE_DAILY_BUCKET_NAME: []byte(start.Format("2006-01-02")),
+	} {
+		bucket := tx.Bucket([]byte(name))
+
+		// Keys are collected first, deleting while iterating would skip some.
+		var old [][]byte
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = cursor.Next() {
+			old = append(old, bytes.Clone(k))
+		}
+
+		for _, k := range old {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	l.pruned = startOfDay(now)
+	return nil
+}
+
+// Since returns the entries recorded since the given time, oldest first.
+func (l *usageLedger) Since(since time.Time) ([]usageEntry, error) {
+	var entries []usageEntry
+	err := l.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(USAGE_BUCKET_NAME)).Cursor()
+		for k, v := cursor.Seek(usageKey(since, 0)); k != nil; k, v = cursor.Next() {
+			var entry usageEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return errors.Wrapf(err, "invalid usage entry %s", k)
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// usageTotals adds up the usage of entries.
+type usageTotals struct {
+	PromptTokens     int64
+	CompletionTokens int64
+	StorageBytes     int64
+	// Cost is the estimated cost in dollars, of the models with a price.
+	Cost float64
+}
+
+func (t *usageTotals) Tokens() int64 {
+	return t.PromptTokens + t.CompletionTokens
+}
+
+func (t *usageTotals) add(entry usageEntry, prices map[string]config.Price) {
+	t.PromptTokens += entry.PromptTokens
+	t.CompletionTokens += entry.CompletionTokens
+	t.StorageBytes += entry.StorageBytes
+
+	if price, ok := modelPrice(prices, entry.Model); ok {
+		t.Cost += (float64(entry.PromptTokens)*price.Prompt + float64(entry.CompletionTokens)*price.Completion) / 1_000_000
+	}
+}
+
+// modelPrice returns the price of a model. Dated versions of a model (e.g. gpt-4o-2024-08-06) have its price.
+func modelPrice(prices map[string]config.Price, model string) (config.Price, bool) {
+	if price, ok := prices[model]; ok {
+		return price, true
+	}
+
+	// The longest matching name wins, so gpt-4o-mini-<date> isn't priced as gpt-4o.
+	best := ""
+	for name := range prices {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best = name
+		}
+	}
+
+	price, ok := prices[best]
+	return price, ok && best != ""
+}
+
+// usageBy adds up the usage of entries by the key of each entry, e.g. by user.
+func usageBy(entries []usageEntry, prices map[string]config.Price, key func(usageEntry) string) map[string]*usageTotals {
+	totals := make(map[string]*usageTotals)
+	for _, entry := range entries {
+		k := key(entry)
+		if totals[k] == nil {
+			totals[k] = &usageTotals{}
+		}
+		totals[k].add(entry, prices)
+	}
+
+	return totals
+}
+
+// startOfDay returns midnight UTC of the day of t, when budgets reset.
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// errBudgetExceeded is returned when a user or a channel has spent its daily budget.
+var errBudgetExceeded = errors.New("daily budget exceeded")
+
+// usageMeter records the usage of a workspace's backend in its ledger, attributed to the conversation of each
+// request, and enforces the daily budgets.
+type usageMeter struct {
+	log    zerolog.Logger
+	ledger *usageLedger
+	config config.UsageConfig
+	now    func() time.Time
+}
+
+func newUsageMeter(log zerolog.Logger, ledger *usageLedger, config config.UsageConfig) *usageMeter {
+	return &usageMeter{log: log, ledger: ledger, config: config, now: time.Now}
+}
+
+// Model returns the model to use on behalf of the conversation of the context. Once the user or the channel has spent
+// its daily budget, the request is refused, or downgraded to a cheaper model.
+func (m *usageMeter) Model(ctx context.Context, model string) (string, error) {
+	if m.config.UserBudget == 0 && m.config.ChannelBudget == 0 {
+		return model, nil
+	}
+
+	conv := conversationFrom(ctx)
+
+	exceeded, err := m.exceeded(conv.userID, conv.channelID)
+	if err != nil {
+		// Usage that can't be read doesn't block the assistant.
+		m.log.Error().Err(err).Msg("Failed to read usage")
+		return model, nil
+	}
+
+	if exceeded == "" {
+		return model, nil
+	}
+
+	if m.config.Exceeded == config.BudgetDowngrade {
+		m.log.Info().Str("user_id", conv.userID).Str("channel_id", conv.channelID).Str("model", m.config.DowngradeModel).Msg("Budget exceeded, downgrading model")
+		return m.config.DowngradeModel, nil
+	}
+
+	m.log.Info().Str("user_id", conv.userID).Str("channel_id", conv.channelID).Msg("Budget exceeded, refusing")
+	return "", fmt.Errorf("%w: %s. It resets at midnight UTC", errBudgetExceeded, exceeded)
+}
+
+// exceeded describes the budget the user or the channel has spent today, or returns an empty string if neither has.
+func (m *usageMeter) exceeded(userID, channelID string) (string, error) {
+	now := m.now()
+
+	if m.config.UserBudget > 0 && userID != "" {
+		used, err := m.ledger.Tokens(now, usageByUser, userID)
+		if err != nil {
+			return "", err
+		}
+
+		if used >= int64(m.config.UserBudget) {
+			return fmt.Sprintf("you have used your %s tokens for today", formatTokens(int64(m.config.UserBudget))), nil
+		}
+	}
+
+	if m.config.ChannelBudget > 0 && channelID != "" {
+		used, err := m.ledger.Tokens(now, usageByChannel, channelID)
+		if err != nil {
+			return "", err
+		}
+
+		if used >= int64(m.config.ChannelBudget) {
+			return fmt.Sprintf("this channel has used its %s tokens for today", formatTokens(int64(m.config.ChannelBudget))), nil
+		}
+	}
+
+	return "", nil
+}
+
+// Record adds the usage to the ledger, attributed to the conversation of the context.
+func (m *usageMeter) Record(ctx context.Context, usage backend.Usage) {
+	conv := conversationFrom(ctx)
+
+	entry := usageEntry{
+		Time:             m.now(),
+		UserID:           conv.userID,
+		ChannelID:        conv.channelID,
+		Command:          conv.command,
+		Model:            usage.Model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		StorageBytes:     usage.StorageBytes,
+	}
+
+	if err := m.ledger.Add(entry); err != nil {
+		m.log.Error().Err(err).Msg("Failed to record usage")
+	}
+}
+
+// formatTokens formats a number of tokens, e.g. 950, 12.3k or 1M.
+func formatTokens(n int64) string {
+	short := func(v float64, unit string) string {
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", v), ".0") + unit
+	}
+
+	switch {
+	case n >= 1_000_000:
+		return short(float64(n)/1_000_000, "M")
+	case n >= 1_000:
+		return short(float64(n)/1_000, "k")
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// formatTotals formats usage, e.g. "12.3k tokens (~$0.01)".
+func formatTotals(t *usageTotals) string {
+	s := fmt.Sprintf("%s tokens (~$%.2f)", formatTokens(t.Tokens()), t.Cost)
+	if t.StorageBytes > 0 {
+		s += fmt.Sprintf(", %s uploaded", util.FormatBytes(t.StorageBytes))
+	}
+
+	return s
+}
+
+// formatTop formats the largest usages, e.g. "<@U1> 12.3k, <@U2> 1.0k". label formats the keys.
+func formatTop(totals map[string]*usageTotals, label func(string) string) string {
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		if totals[key].Tokens() > 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]].Tokens() != totals[keys[j]].Tokens() {
+			return totals[keys[i]].Tokens() > totals[keys[j]].Tokens()
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, 0, USAGE_REPORT_TOP)
+	for _, key := range keys[:min(len(keys), USAGE_REPORT_TOP)] {
+		parts = append(parts, fmt.Sprintf("%s %s", label(key), formatTokens(totals[key].Tokens())))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// usageReport describes the usage of a workspace for /usage: today's usage of the user and the channel against their
+// budgets, and the usage of the last USAGE_REPORT_DAYS days by command, user and channel.
+func usageReport(entries []usageEntry, cfg config.UsageConfig, userID, channelID string, now time.Time) string {
+	today := startOfDay(now)
+
+	var todays []usageEntry
+	for _, entry := range entries {
+		if !entry.Time.Before(today) {
+			todays = append(todays, entry)
+		}
+	}
+
+	budget := func(used *usageTotals, budget int) string {
+		if used == nil {
+			used = &usageTotals{}
+		}
+		if budget == 0 {
+			return formatTokens(used.Tokens()) + " tokens"
+		}
+		return fmt.Sprintf("%s of %s tokens", formatTokens(used.Tokens()), formatTokens(int64(budget)))
+	}
+
+	byUser := usageBy(todays, cfg.Prices, func(e usageEntry) string { return e.UserID })
+	byChannel := usageBy(todays, cfg.Prices, func(e usageEntry) string { return e.ChannelID })
+	total := usageBy(todays, cfg.Prices, func(usageEntry) string { return "" })[""]
+	if total == nil {
+		total = &usageTotals{}
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "*Today* (UTC): %s\n", formatTotals(total))
+	fmt.Fprintf(&report, "You: %s. This channel: %s.\n", budget(byUser[userID], cfg.UserBudget), budget(byChannel[channelID], cfg.ChannelBudget))
+
+	period := usageBy(entries, cfg.Prices, func(usageEntry) string { return "" })[""]
+	if period == nil {
+		return report.String()
+	}
+
+	fmt.Fprintf(&report, "\n*Last %d days*: %s\n", USAGE_REPORT_DAYS, formatTotals(period))
+
+	label := func(unattributed string, format func(string) string) func(string) string {
+		return func(key string) string {
+			if key == "" {
+				return unattributed
+			}
+			return format(key)
+		}
+	}
+
+	for _, breakdown := range []struct {
+		name  string
+		key   func(usageEntry) string
+		label func(string) string
+	}{
+		{"By command", func(e usageEntry) string { return e.Command }, label("other", func(k string) string { return k })},
+		{"Top users", func(e usageEntry) string { return e.UserID }, label("other", func(k string) string { return "<@" + k + ">" })},
+		{"Top channels", func(e usageEntry) string { return e.ChannelID }, label("other", func(k string) string { return "<#" + k + ">" })},
+	} {
+		if top := formatTop(usageBy(entries, cfg.Prices, breakdown.key), breakdown.label); top != "" {
+			fmt.Fprintf(&report, "%s: %s\n", breakdown.name, top)
+		}
+	}
+
+	return report.String()
+}
+
+// handleUsage reports the usage of the workspace to the user who invoked /usage.
+func (a *app) handleUsage(ctx context.Context, fe chat.Frontend, cmd chat.Command) {
+	log := a.log.With().Str("frontend", fe.Name()).Logger()
+
+	if err := a.authorizer.Authorize(cmd.UserID, cmd.ChannelID, access.ActionAsk); err != nil {
+		log.Info().Str("user_id", cmd.UserID).Str("channel_id", cmd.ChannelID).Str("command", cmd.CommandType).Msg("Command denied")
+		fe.Respond(cmd, err.Error())
+		return
+	}
+
+	tenant, err := a.tenants.Get(ctx, cmd.TeamID)
+	if err != nil {
+		log.Error().Err(err).Str("team_id", cmd.TeamID).Msg("Failed to load workspace")
+		fe.Respond(cmd, fmt.Sprintf("Failed to load workspace: %s", err))
+		return
+	}
+
+	now := time.Now()
+	entries, err := tenant.usage.Since(usagePeriodStart(now))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read usage")
+		fe.Respond(cmd, fmt.Sprintf("Failed to read usage: %s", err))
+		return
+	}
+
+	fe.Respond(cmd, usageReport(entries, tenant.usageConfig, cmd.UserID, cmd.ChannelID, now))
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/mempirate/scholar/backend"
+	"github.com/mempirate/scholar/config"
+)
+
+func newTestLedger(t *testing.T) *usageLedger {
+	ledger, err := newUsageLedger(filepath.Join(t.TempDir(), "usage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+
+	return ledger
+}
+
+func TestUsageLedger(t *testing.T) {
+	ledger := newTestLedger(t)
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	for _, entry := range []usageEntry{
+		{Time: now.AddDate(0, 0, -1), UserID: "U1", PromptTokens: 100},
+		{Time: now, UserID: "U1", PromptTokens: 10},
+		{Time: now, UserID: "U2", CompletionTokens: 20},
+	} {
+		if err := ledger.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ledger.Since(startOfDay(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].UserID != "U1" || entries[1].UserID != "U2" {
+		t.Errorf("expected today's entries in order, got %+v", entries)
+	}
+}
+
+func TestUsageLedgerRollups(t *testing.T) {
+	ledger := newTestLedger(t)
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	for _, entry := range []usageEntry{
+		{Time: now.AddDate(0, 0, -1), UserID: "U1", ChannelID: "C1", PromptTokens: 100},
+		{Time: now, UserID: "U1", ChannelID: "C1", PromptTokens: 10, CompletionTokens: 5},
+		{Time: now.Add(time.Hour), UserID: "U1", ChannelID: "C2", CompletionTokens: 20},
+		{Time: now, StorageBytes: 1000},
+	} {
+		if err := ledger.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		kind, id string
+		tokens   int64
+	}{
+		{usageByUser, "U1", 35},
+		{usageByChannel, "C1", 15},
+		{usageByChannel, "C2", 20},
+		{usageByUser, "U2", 0},
+	} {
+		if tokens, err := ledger.Tokens(now, test.kind, test.id); err != nil || tokens != test.tokens {
+			t.Errorf("%s %s: expected %d tokens, got %d, %v", test.kind, test.id, test.tokens, tokens, err)
+		}
+	}
+}
+
+func TestUsageLedgerMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.db")
+	ledger, err := newUsageLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if err := ledger.Add(usageEntry{Time: now, UserID: "U1", PromptTokens: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A ledger from before the rollups only has entries.
+	if err := ledger.db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket([]byte(USAGE_DAILY_BUCKET_NAME)) }); err != nil {
+		t.Fatal(err)
+	}
+	ledger.Close()
+
+	ledger, err = newUsageLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+
+	if tokens, err := ledger.Tokens(now, usageByUser, "U1"); err != nil || tokens != 10 {
+		t.Errorf("expected the rollups to be rebuilt, got %d tokens, %v", tokens, err)
+	}
+}
+
+func TestUsageLedgerPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.db")
+	ledger, err := newUsageLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	old := usagePeriodStart(now).Add(-time.Minute)
+
+	for _, entry := range []usageEntry{
+		{Time: old, UserID: "U1", PromptTokens: 100},
+		{Time: now, UserID: "U1", PromptTokens: 10},
+	} {
+		if err := ledger.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ledger.Close()
+
+	// Reopening the ledger prunes the days that are no longer reported.
+	ledger, err = newUsageLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+
+	entries, err := ledger.Since(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].PromptTokens != 10 {
+		t.Errorf("expected the old entry to be pruned, got %+v", entries)
+	}
+
+	if tokens, _ := ledger.Tokens(old, usageByUser, "U1"); tokens != 0 {
+		t.Errorf("expected the old rollup to be pruned, got %d tokens", tokens)
+	}
+
+	if tokens, _ := ledger.Tokens(now, usageByUser, "U1"); tokens != 10 {
+		t.Errorf("expected today's rollup to be kept, got %d tokens", tokens)
+	}
+
+	// The first entry of a later day prunes too.
+	later := now.AddDate(0, 0, USAGE_REPORT_DAYS)
+	if err := ledger.Add(usageEntry{Time: later, UserID: "U1", PromptTokens: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, _ := ledger.Since(time.Time{}); len(entries) != 1 || entries[0].PromptTokens != 1 {
+		t.Errorf("expected the entries before the later day to be pruned, got %+v", entries)
+	}
+}
+
+func TestUsageMeter(t *testing.T) {
+	ledger := newTestLedger(t)
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	cfg := config.Default().Usage
+	cfg.UserBudget = 1000
+
+	meter := newUsageMeter(zerolog.Nop(), ledger, cfg)
+	meter.now = func() time.Time { return now }
+
+	ctx := withConversation(context.Background(), &conversation{userID: "U1", channelID: "C1", command: "mention"})
+	other := withConversation(context.Background(), &conversation{userID: "U2", channelID: "C1"})
+
+	meter.Record(ctx, backend.Usage{Model: "gpt-4o", PromptTokens: 900, CompletionTokens: 50})
+	if model, err := meter.Model(ctx, "gpt-4o"); err != nil || model != "gpt-4o" {
+		t.Errorf("expected gpt-4o within the budget, got %q, %v", model, err)
+	}
+
+	meter.Record(ctx, backend.Usage{Model: "gpt-4o", CompletionTokens: 50})
+	if _, err := meter.Model(ctx, "gpt-4o"); !errors.Is(err, errBudgetExceeded) {
+		t.Errorf("expected the budget to be exceeded, got %v", err)
+	}
+
+	if _, err := meter.Model(other, "gpt-4o"); err != nil {
+		t.Errorf("expected other users to keep their budget, got %v", err)
+	}
+
+	meter.config.Exceeded = config.BudgetDowngrade
+	if model, err := meter.Model(ctx, "gpt-4o"); err != nil || model != cfg.DowngradeModel {
+		t.Errorf("expected a downgrade to %s, got %q, %v", cfg.DowngradeModel, model, err)
+	}
+
+	// Budgets reset at midnight UTC.
+	meter.now = func() time.Time { return now.AddDate(0, 0, 1) }
+	if model, err := meter.Model(ctx, "gpt-4o"); err != nil || model != "gpt-4o" {
+		t.Errorf("expected the budget to reset, got %q, %v", model, err)
+	}
+
+	entries, _ := ledger.Since(startOfDay(now))
+	if len(entries) != 2 || entries[0].Command != "mention" || entries[0].ChannelID != "C1" {
+		t.Errorf("expected attributed entries, got %+v", entries)
+	}
+}
+
+func TestModelPrice(t *testing.T) {
+	prices := config.Default().Usage.Prices
+
+	for model, expected := range map[string]float64{
+		"gpt-4o":                 2.50,
+		"gpt-4o-2024-08-06":      2.50,
+		"gpt-4o-mini-2024-07-18": 0.15,
+	} {
+		if price, ok := modelPrice(prices, model); !ok || price.Prompt != expected {
+			t.Errorf("%s: expected a prompt price of %.2f, got %+v", model, expected, price)
+		}
+	}
+
+	if _, ok := modelPrice(prices, "o1"); ok {
+		t.Error("expected no price for o1")
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	cfg := config.Default().Usage
+	cfg.UserBudget = 100_000
+
+	entries := []usageEntry{
+		{Time: now.AddDate(0, 0, -3), UserID: "U2", ChannelID: "C2", Command: "/research", Model: "gpt-4o", PromptTokens: 1_000_000},
+		{Time: now, UserID: "U1", ChannelID: "C1", Command: "mention", Model: "gpt-4o-mini", PromptTokens: 12_000, CompletionTokens: 300},
+		{Time: now, StorageBytes: 2048},
+	}
+
+	report := usageReport(entries, cfg, "U1", "C1", now)
+	for _, want := range []string{
+		"*Today* (UTC): 12.3k tokens (~$0.00), 2.0KiB uploaded",
+		"You: 12.3k of 100k tokens. This channel: 12.3k tokens.",
+		"*Last 30 days*: 1M tokens (~$2.50)",
+		"By command: /research 1M, mention 12.3k",
+		"Top users: <@U2> 1M, <@U1> 12.3k",
+		"Top channels: <#C2> 1M, <#C1> 12.3k",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("expected %q in:\n%s", want, report)
+		}
+	}
+}